@@ -0,0 +1,269 @@
+package adherence
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	"github.com/tespo/satya/v2/types"
+)
+
+//
+// DefaultWindow is how far either side of a reminder
+// a usage still counts as taken on time
+//
+const DefaultWindow = time.Hour
+
+//
+// lateLimit is the longest a dose can be taken after its
+// reminder and still count as taken late. A dose is also
+// no longer late once the next dose's window opens
+//
+const lateLimit = 12 * time.Hour
+
+//
+// dateFormat is the layout of the dates in a report
+//
+const dateFormat = "2006-01-02"
+
+//
+// Options are the range and rules a report is built with.
+// From and To are inclusive days in Location and reminder
+// minutes are read as minutes past midnight in Location
+//
+type Options struct {
+	From     time.Time
+	To       time.Time
+	Location *time.Location
+	Window   time.Duration
+	Now      time.Time
+}
+
+//
+// Day is the tally of doses scheduled on one day. Pending
+// doses are still inside their window and not counted
+// towards the adherence percentage
+//
+type Day struct {
+	Date      string   `json:"date"`
+	Scheduled int      `json:"scheduled"`
+	Taken     int      `json:"taken"`
+	Late      int      `json:"late"`
+	Missed    int      `json:"missed"`
+	Pending   int      `json:"pending"`
+	Adherence *float64 `json:"adherence"`
+}
+
+//
+// Summary totals the days of a range. Streaks count days where
+// every due dose was taken, skipping days with nothing due
+//
+type Summary struct {
+	Scheduled     int      `json:"scheduled"`
+	Taken         int      `json:"taken"`
+	Late          int      `json:"late"`
+	Missed        int      `json:"missed"`
+	Pending       int      `json:"pending"`
+	Adherence     *float64 `json:"adherence"`
+	CurrentStreak int      `json:"current_streak"`
+	LongestStreak int      `json:"longest_streak"`
+	Days          []Day    `json:"days"`
+}
+
+//
+// RegimenReport is the summary of a single regimen
+//
+type RegimenReport struct {
+	RegimenID uuid.UUID `json:"regimen_id"`
+	Summary
+}
+
+//
+// Report is the adherence over a range for a set of
+// regimens, in total and per regimen
+//
+type Report struct {
+	From     string `json:"from"`
+	To       string `json:"to"`
+	Timezone string `json:"timezone"`
+	Summary
+	Regimens []RegimenReport `json:"regimens"`
+}
+
+//
+// UsageRange returns the span usages need to be loaded
+// for to build a report with the options
+//
+func UsageRange(options Options) (time.Time, time.Time) {
+	return options.From.Add(-options.Window), options.To.AddDate(0, 0, 1).Add(lateLimit)
+}
+
+//
+// Build compares the reminders of each regimen with the usages
+// recorded for it. Each usage can only be matched with one dose
+//
+func Build(regimens types.Regimens, reminders types.Reminders, usages types.Usages, options Options) Report {
+	if options.Window <= 0 {
+		options.Window = DefaultWindow
+	}
+	report := Report{
+		From:     options.From.Format(dateFormat),
+		To:       options.To.Format(dateFormat),
+		Timezone: options.Location.String(),
+		Regimens: []RegimenReport{},
+	}
+	total := emptyDays(options)
+	for _, regimen := range regimens {
+		days := emptyDays(options)
+		doses := schedule(regimen.ID, reminders, options)
+		tally(days, doses, regimenUsages(regimen.ID, usages), options)
+		for i := range days {
+			add(&total[i], days[i])
+		}
+		report.Regimens = append(report.Regimens, RegimenReport{
+			RegimenID: regimen.ID,
+			Summary:   summarize(days),
+		})
+	}
+	report.Summary = summarize(total)
+	return report
+}
+
+func emptyDays(options Options) []Day {
+	days := []Day{}
+	for day := options.From; !day.After(options.To); day = day.AddDate(0, 0, 1) {
+		days = append(days, Day{Date: day.Format(dateFormat)})
+	}
+	return days
+}
+
+type dose struct {
+	day int
+	at  time.Time
+}
+
+//
+// schedule lists the doses of a regimen in order, only counting
+// a reminder for the times it existed. time.Date normalizes
+// reminders falling into a daylight saving gap
+//
+func schedule(regimenID uuid.UUID, reminders types.Reminders, options Options) []dose {
+	doses := []dose{}
+	for _, reminder := range reminders {
+		if reminder.RegimenID != regimenID {
+			continue
+		}
+		day := 0
+		for date := options.From; !date.After(options.To); date = date.AddDate(0, 0, 1) {
+			at := time.Date(date.Year(), date.Month(), date.Day(), int(reminder.Minute/60), int(reminder.Minute%60), 0, 0, options.Location)
+			if !at.Before(reminder.CreatedAt) && (reminder.DeletedAt == nil || at.Before(*reminder.DeletedAt)) {
+				doses = append(doses, dose{day: day, at: at})
+			}
+			day++
+		}
+	}
+	sort.Slice(doses, func(i, j int) bool { return doses[i].at.Before(doses[j].at) })
+	return doses
+}
+
+func regimenUsages(regimenID uuid.UUID, usages types.Usages) []time.Time {
+	times := []time.Time{}
+	for _, usage := range usages {
+		if usage.RegimenID == regimenID && usage.DeletedAt == nil {
+			times = append(times, usage.CreatedAt)
+		}
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+	return times
+}
+
+//
+// tally matches usages to doses in order. A usage before a dose's
+// window is an extra dispense and is skipped
+//
+func tally(days []Day, doses []dose, usages []time.Time, options Options) {
+	next := 0
+	for i, d := range doses {
+		start := d.at.Add(-options.Window)
+		onTime := d.at.Add(options.Window)
+		end := d.at.Add(lateLimit)
+		if i+1 < len(doses) {
+			if nextStart := doses[i+1].at.Add(-options.Window); nextStart.Before(end) {
+				end = nextStart
+			}
+		}
+		if end.Before(onTime) {
+			end = onTime
+		}
+		for next < len(usages) && usages[next].Before(start) {
+			next++
+		}
+		day := &days[d.day]
+		day.Scheduled++
+		switch {
+		case next < len(usages) && usages[next].Before(end):
+			if usages[next].After(onTime) {
+				day.Late++
+			} else {
+				day.Taken++
+			}
+			next++
+		case end.After(options.Now):
+			day.Pending++
+		default:
+			day.Missed++
+		}
+	}
+	for i := range days {
+		days[i].Adherence = percentage(days[i].Taken, days[i].Late, days[i].Missed)
+	}
+}
+
+func add(total *Day, day Day) {
+	total.Scheduled += day.Scheduled
+	total.Taken += day.Taken
+	total.Late += day.Late
+	total.Missed += day.Missed
+	total.Pending += day.Pending
+	total.Adherence = percentage(total.Taken, total.Late, total.Missed)
+}
+
+func summarize(days []Day) Summary {
+	summary := Summary{Days: days}
+	streak := 0
+	for _, day := range days {
+		summary.Scheduled += day.Scheduled
+		summary.Taken += day.Taken
+		summary.Late += day.Late
+		summary.Missed += day.Missed
+		summary.Pending += day.Pending
+		if day.Missed > 0 {
+			streak = 0
+			continue
+		}
+		if day.Taken+day.Late == 0 {
+			continue
+		}
+		streak++
+		if streak > summary.LongestStreak {
+			summary.LongestStreak = streak
+		}
+	}
+	summary.CurrentStreak = streak
+	summary.Adherence = percentage(summary.Taken, summary.Late, summary.Missed)
+	return summary
+}
+
+//
+// percentage is the share of due doses that were taken,
+// on time or late, or nil when no dose was due
+//
+func percentage(taken, late, missed int) *float64 {
+	due := taken + late + missed
+	if due == 0 {
+		return nil
+	}
+	value := math.Round(float64(taken+late)/float64(due)*1000) / 10
+	return &value
+}
@@ -1,13 +1,30 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/getsentry/sentry-go"
 	"github.com/subosito/gotenv"
+	"github.com/tespo/buddha/auth"
+	"github.com/tespo/buddha/db"
+	"github.com/tespo/buddha/handlers"
+	"github.com/tespo/buddha/health"
+	"github.com/tespo/buddha/metrics"
+	"github.com/tespo/buddha/models"
+	"github.com/tespo/buddha/presence"
 	"github.com/tespo/buddha/router"
+	"github.com/tespo/buddha/scheduler"
+	"github.com/tespo/buddha/shutdown"
+	"github.com/tespo/buddha/tracing"
+	"github.com/tespo/buddha/util"
+	"github.com/tespo/buddha/webhook"
 )
 
 func init() {
@@ -30,12 +47,151 @@ func main() {
 		port = "5000"
 	}
 
-	r := router.CreateRouter()
+	tracingConfig := tracing.ConfigFromEnv()
+	exporter, err := tracing.ExporterFromConfig(tracingConfig)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if exporter != nil {
+		provider := tracing.NewProvider(exporter, tracingConfig)
+		shutdown.Register("tracer", provider)
+		tracing.SetProvider(provider)
+	}
+
+	conn, err := db.Connect(db.ConfigFromEnv())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer conn.Close()
+	metrics.RegisterDBStats(conn.DB())
+	tracing.RegisterGorm(conn)
+	if err := models.AutoMigrate(conn); err != nil {
+		log.Fatal(err)
+	}
+	handlers.SetDB(conn)
+
+	webhooks := webhook.New(conn, webhook.ConfigFromEnv())
+	webhooks.Start()
+	shutdown.Register("webhook dispatcher", webhooks)
+	handlers.SetWebhooks(webhooks)
+
+	if os.Getenv("REMINDER_SCHEDULER") != "off" {
+		config, err := scheduler.ConfigFromEnv()
+		if err != nil {
+			log.Fatal(err)
+		}
+		notifier, err := scheduler.NotifierFromEnv()
+		if err != nil {
+			log.Fatal(err)
+		}
+		reminders := scheduler.New(conn, notifier, config)
+		reminders.Start()
+		shutdown.Register("reminder scheduler", reminders)
+	}
+
+	if os.Getenv("REQUEST_LOG") == "off" {
+		util.SetRequestLog(nil)
+	}
+
+	if os.Getenv("OFFLINE_DETECTOR") != "off" {
+		detector := presence.New(conn, presence.ConfigFromEnv())
+		detector.Start()
+		shutdown.Register("offline detector", detector)
+	}
+
+	var verifier *util.TokenVerifier
+	if auth.NeedsTokenVerifier() {
+		verifier, err = util.NewTokenVerifier(util.TokenConfigFromEnv())
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	authenticator, err := auth.NewAuthenticatorFromEnv(verifier)
+	if err != nil {
+		log.Fatal(err)
+	}
 
+	voiceVerifiers, err := auth.VoiceVerifiersFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	readiness := health.New(health.ConfigFromEnv())
+	readiness.Add("mysql", health.SQL(conn.DB()))
+	if provider := os.Getenv("AUTH_PROVIDER"); provider == "" || provider == "vijnana" {
+		readiness.AddOptional("vijnana", health.HTTP(vijnanaHealthURL()))
+	}
+	readiness.Add("aws", health.AWS("TESPO_EMAIL", "INVITATION_TEMPLATE_NAME"))
+
+	r := router.CreateRouter(authenticator, voiceVerifiers, readiness)
+
+	config := shutdown.ConfigFromEnv()
 	srv := &http.Server{
-		Handler: r,
-		Addr:    ":" + port,
+		Handler:           r,
+		Addr:              ":" + port,
+		ReadHeaderTimeout: config.ReadHeaderTimeout,
+		ReadTimeout:       config.ReadTimeout,
+		WriteTimeout:      config.WriteTimeout,
+		IdleTimeout:       config.IdleTimeout,
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- srv.ListenAndServe()
+	}()
+	var metricsSrv *http.Server
+	if addr := os.Getenv("METRICS_ADDR"); addr != "" {
+		metricsSrv = &http.Server{
+			Handler:           router.MetricsRouter(),
+			Addr:              addr,
+			ReadHeaderTimeout: config.ReadHeaderTimeout,
+			ReadTimeout:       config.ReadTimeout,
+			WriteTimeout:      config.WriteTimeout,
+			IdleTimeout:       config.IdleTimeout,
+		}
+		go func() {
+			if err := metricsSrv.ListenAndServe(); err != http.ErrServerClosed {
+				log.Println("metrics server:", err)
+			}
+		}()
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	select {
+	case err := <-serveErr:
+		log.Println(err)
+	case received := <-signals:
+		log.Printf("received %v, draining for up to %v", received, config.Timeout)
 	}
 
-	log.Fatal(srv.ListenAndServe())
+	ctx, cancel := context.WithTimeout(context.Background(), config.Timeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Println("server shutdown:", err)
+	}
+	if metricsSrv != nil {
+		if err := metricsSrv.Shutdown(ctx); err != nil {
+			log.Println("metrics server shutdown:", err)
+		}
+	}
+	if err := shutdown.Default.Shutdown(ctx); err != nil {
+		log.Println(err)
+	}
+	sentry.Flush(2 * time.Second)
+}
+
+//
+// vijnanaHealthURL is the url readiness probes vijnana at,
+// VIJNANA_URL followed by VIJNANA_HEALTH_PATH
+//
+func vijnanaHealthURL() string {
+	base := os.Getenv("VIJNANA_URL")
+	if base == "" {
+		return ""
+	}
+	path := os.Getenv("VIJNANA_HEALTH_PATH")
+	if path == "" {
+		path = "/healthz"
+	}
+	return strings.TrimSuffix(base, "/") + path
 }
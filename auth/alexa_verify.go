@@ -0,0 +1,234 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// alexaCertHost is the only host alexa certificates are served from
+	alexaCertHost = "s3.amazonaws.com"
+	// alexaCertPath is the path alexa certificates are served under
+	alexaCertPath = "/echo.api/"
+	// alexaCertName is the name the signing certificate must be issued for
+	alexaCertName = "echo-api.amazon.com"
+	// alexaTimestampTolerance is how old a request may be
+	alexaTimestampTolerance = 150 * time.Second
+)
+
+//
+// AlexaVerifier checks the signature alexa puts on each request
+// against the certificate chain it links to, and that the
+// request was sent recently
+//
+type AlexaVerifier struct {
+	store *CertificateStore
+	roots *x509.CertPool
+}
+
+//
+// NewAlexaVerifier returns a verifier that loads certificate
+// chains from the store. Chains must lead to one of the
+// roots, which are the system roots when nil
+//
+func NewAlexaVerifier(store *CertificateStore, roots *x509.CertPool) *AlexaVerifier {
+	return &AlexaVerifier{store: store, roots: roots}
+}
+
+//
+// Verify checks the certificate chain, the signature of the
+// body and the request timestamp. Smart home directives carry
+// no timestamp, so only their signature is checked
+//
+func (v *AlexaVerifier) Verify(r *http.Request, body []byte) error {
+	certURL := r.Header.Get("SignatureCertChainUrl")
+	if err := validateAlexaCertURL(certURL); err != nil {
+		return err
+	}
+	chain, err := v.store.Chain(certURL)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	intermediates := x509.NewCertPool()
+	for _, cert := range chain[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err = chain[0].Verify(x509.VerifyOptions{
+		DNSName:       alexaCertName,
+		Roots:         v.roots,
+		Intermediates: intermediates,
+		CurrentTime:   now,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		return fmt.Errorf("Invalid alexa certificate: %v", err)
+	}
+	key, ok := chain[0].PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return errors.New("Alexa certificate does not have an RSA key")
+	}
+	if err := verifyAlexaSignature(r.Header, key, body); err != nil {
+		return err
+	}
+	var request struct {
+		Directive json.RawMessage `json:"directive"`
+		Request   struct {
+			Timestamp time.Time `json:"timestamp"`
+		} `json:"request"`
+	}
+	if err := json.Unmarshal(body, &request); err != nil {
+		return errors.New("Alexa request is not valid json")
+	}
+	if len(request.Directive) > 0 {
+		return nil
+	}
+	if request.Request.Timestamp.IsZero() {
+		return errors.New("Alexa request has no timestamp")
+	}
+	if age := now.Sub(request.Request.Timestamp); age > alexaTimestampTolerance || age < -alexaTimestampTolerance {
+		return errors.New("Alexa request timestamp is out of range")
+	}
+	return nil
+}
+
+//
+// verifyAlexaSignature checks the SHA-256 signature when
+// the request has one and the SHA-1 signature otherwise
+//
+func verifyAlexaSignature(header http.Header, key *rsa.PublicKey, body []byte) error {
+	hash, signature := crypto.SHA256, header.Get("Signature-256")
+	if signature == "" {
+		hash, signature = crypto.SHA1, header.Get("Signature")
+	}
+	decoded, err := base64.StdEncoding.DecodeString(signature)
+	if signature == "" || err != nil {
+		return errors.New("Alexa request has no valid signature")
+	}
+	var digest []byte
+	if hash == crypto.SHA256 {
+		sum := sha256.Sum256(body)
+		digest = sum[:]
+	} else {
+		sum := sha1.Sum(body)
+		digest = sum[:]
+	}
+	if err := rsa.VerifyPKCS1v15(key, hash, digest, decoded); err != nil {
+		return errors.New("Alexa request signature does not match")
+	}
+	return nil
+}
+
+//
+// validateAlexaCertURL checks the certificate chain url
+// points to amazon's certificate location
+//
+func validateAlexaCertURL(certURL string) error {
+	parsed, err := url.Parse(certURL)
+	if certURL == "" || err != nil {
+		return errors.New("Alexa request has no valid certificate url")
+	}
+	if !strings.EqualFold(parsed.Scheme, "https") ||
+		!strings.EqualFold(parsed.Hostname(), alexaCertHost) ||
+		(parsed.Port() != "" && parsed.Port() != "443") ||
+		!strings.HasPrefix(path.Clean(parsed.Path), alexaCertPath) {
+		return fmt.Errorf("Alexa certificate url %v is not allowed", certURL)
+	}
+	return nil
+}
+
+//
+// CertificateStore loads certificate chains by url and caches
+// them until the first certificate in the chain expires
+//
+type CertificateStore struct {
+	load   func(certURL string) ([]byte, error)
+	mutex  sync.Mutex
+	chains map[string][]*x509.Certificate
+}
+
+//
+// NewCertificateStore returns a store downloading chains
+//
+func NewCertificateStore(timeout time.Duration) *CertificateStore {
+	client := &http.Client{Timeout: timeout}
+	return &CertificateStore{
+		chains: map[string][]*x509.Certificate{},
+		load: func(certURL string) ([]byte, error) {
+			resp, err := client.Get(certURL)
+			if err != nil {
+				return nil, err
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				return nil, fmt.Errorf("Cannot fetch certificate chain: %v", resp.Status)
+			}
+			return ioutil.ReadAll(resp.Body)
+		},
+	}
+}
+
+//
+// NewFixtureCertificateStore returns a store answering every url
+// with the chain in the local file. It is meant for testing
+//
+func NewFixtureCertificateStore(file string) *CertificateStore {
+	return &CertificateStore{
+		chains: map[string][]*x509.Certificate{},
+		load: func(certURL string) ([]byte, error) {
+			return ioutil.ReadFile(file)
+		},
+	}
+}
+
+//
+// Chain returns the certificates at the url, the signing
+// certificate first
+//
+func (s *CertificateStore) Chain(certURL string) ([]*x509.Certificate, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if chain, ok := s.chains[certURL]; ok && time.Now().Before(chain[0].NotAfter) {
+		return chain, nil
+	}
+	data, err := s.load(certURL)
+	if err != nil {
+		return nil, err
+	}
+	chain := []*x509.Certificate{}
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, cert)
+	}
+	if len(chain) == 0 {
+		return nil, errors.New("Certificate chain is empty")
+	}
+	s.chains[certURL] = chain
+	return chain, nil
+}
@@ -2,9 +2,7 @@ package auth
 
 import (
 	"encoding/json"
-	"errors"
 	"net/http"
-	"os"
 	"regexp"
 	"strings"
 
@@ -17,30 +15,14 @@ import (
 // ExplicitRouterAuthenticationWrapper wraps all developer
 // handlers validating tokens and roles/permissions on endpoints
 //
-func ExplicitRouterAuthenticationWrapper(route, method string, next http.HandlerFunc) http.HandlerFunc {
+func ExplicitRouterAuthenticationWrapper(authenticator Authenticator, route, method string, next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
-		client := &http.Client{}
-		req, _ := http.NewRequest("GET", os.Getenv("VIJNANA_URL")+"/validate-token-permissions", nil)
-		req.Header = r.Header
-		req.Header.Set("Route", route)
-		req.Header.Set("Method", method)
-		resp, err := client.Do(req)
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			if err = json.NewEncoder(w).Encode(map[string]string{"Error": err.Error()}); err != nil {
-				panic(err)
-			}
-			return
-		}
-		if resp.StatusCode != 200 {
-			w.WriteHeader(http.StatusUnauthorized)
-			if err = json.NewEncoder(w).Encode(map[string]string{"Error": "Unauthorized"}); err != nil {
-				panic(err)
-			}
+		tokenString := bearerToken(r)
+		if err := authenticator.Authorize(r.Header, tokenString, route, method); err != nil {
+			authenticationError(w, err)
 			return
 		}
-		tokenString := strings.Split(r.Header.Get("Authorization"), "Bearer ")[1]
 		context.Set(r, "token", tokenString)
 		next(w, r)
 	}
@@ -50,27 +32,11 @@ func ExplicitRouterAuthenticationWrapper(route, method string, next http.Handler
 // LambdaRouterAuthenticationWrapper wraps all developer
 // handlers validating tokens and roles/permissions on endpoints
 //
-func LambdaRouterAuthenticationWrapper(route, method string, next http.HandlerFunc) http.HandlerFunc {
+func LambdaRouterAuthenticationWrapper(authenticator Authenticator, route, method string, next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
-		client := &http.Client{}
-		req, _ := http.NewRequest("GET", os.Getenv("VIJNANA_URL")+"/validate-token-permissions", nil)
-		req.Header = r.Header
-		req.Header.Set("Route", route)
-		req.Header.Set("Method", method)
-		resp, err := client.Do(req)
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			if err = json.NewEncoder(w).Encode(map[string]string{"Error": err.Error()}); err != nil {
-				panic(err)
-			}
-			return
-		}
-		if resp.StatusCode != 200 {
-			w.WriteHeader(http.StatusUnauthorized)
-			if err = json.NewEncoder(w).Encode(map[string]string{"Error": "Unauthorized"}); err != nil {
-				panic(err)
-			}
+		if err := authenticator.Authorize(r.Header, bearerToken(r), route, method); err != nil {
+			authenticationError(w, err)
 			return
 		}
 		next(w, r)
@@ -81,73 +47,56 @@ func LambdaRouterAuthenticationWrapper(route, method string, next http.HandlerFu
 // ImplicitRouterAuthenticationWrapper validates tokens and
 // adds context to the handlers for the user data
 //
-func ImplicitRouterAuthenticationWrapper(requiredScope string, next http.HandlerFunc) http.HandlerFunc {
+func ImplicitRouterAuthenticationWrapper(authenticator Authenticator, requiredScope string, next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
-		client := &http.Client{}
-		req, _ := http.NewRequest("GET", os.Getenv("VIJNANA_URL")+"/validate-token", nil)
-		req.Header = r.Header
-		resp, err := client.Do(req)
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			if err = json.NewEncoder(w).Encode(map[string]string{"Error": err.Error()}); err != nil {
-				panic(err)
-			}
-			return
-		}
-		if resp.StatusCode != 200 {
-			w.WriteHeader(http.StatusUnauthorized)
-			if err = json.NewEncoder(w).Encode(map[string]string{"Error": "Unauthorized"}); err != nil {
-				panic(err)
-			}
-			return
-		}
-		tokenString := strings.Split(r.Header.Get("Authorization"), "Bearer ")[1]
-		claims, err := util.ParseToken(tokenString)
+		tokenString := bearerToken(r)
+		claims, err := authenticator.Authenticate(r.Header, tokenString)
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			if err = json.NewEncoder(w).Encode(map[string]string{"Error": err.Error()}); err != nil {
-				panic(err)
-			}
+			authenticationError(w, err)
 			return
 		}
 		scopePermissions, ok := claims["scope_permissions"].([]interface{})
 		if !ok {
-			util.ErrorResponder(w, http.StatusUnauthorized, errors.New("Unauthorized"))
+			util.ErrorResponder(w, http.StatusUnauthorized, ErrUnauthorized)
 			return
 		}
 		scopedFields, ok := claims["scoped_fields"].([]interface{})
 		if !ok {
-			util.ErrorResponder(w, http.StatusUnauthorized, errors.New("Unauthorized"))
+			util.ErrorResponder(w, http.StatusUnauthorized, ErrUnauthorized)
 			return
 		}
-		for count, scope := range scopePermissions {
-			if checkPattern(scope.(string), requiredScope) {
+		permitted := false
+		for _, scope := range scopePermissions {
+			if scope, ok := scope.(string); ok && checkPattern(scope, requiredScope) {
+				permitted = true
 				break
 			}
-			if count == len(scopePermissions)-1 {
-				util.ErrorResponder(w, http.StatusUnauthorized, errors.New("Unauthorized"))
-				return
-			}
+		}
+		if !permitted {
+			util.ErrorResponder(w, http.StatusUnauthorized, ErrUnauthorized)
+			return
 		}
 		userID, ok := claims["user_id"].(string)
 		if !ok {
-			util.ErrorResponder(w, http.StatusUnauthorized, errors.New("Unauthorized"))
+			util.ErrorResponder(w, http.StatusUnauthorized, ErrUnauthorized)
 			return
 		}
 		accountID, ok := claims["account_id"].(string)
 		if !ok {
-			util.ErrorResponder(w, http.StatusUnauthorized, errors.New("Unauthorized"))
+			util.ErrorResponder(w, http.StatusUnauthorized, ErrUnauthorized)
 			return
 		}
 		owner, ok := claims["owner"].(bool)
 		if !ok {
-			util.ErrorResponder(w, http.StatusUnauthorized, errors.New("Unauthorized"))
+			util.ErrorResponder(w, http.StatusUnauthorized, ErrUnauthorized)
 			return
 		}
-		scopedFieldSlice := make([]string, len(scopedFields))
-		for i, v := range scopedFields {
-			scopedFieldSlice[i] = v.(string)
+		scopedFieldSlice := make([]string, 0, len(scopedFields))
+		for _, v := range scopedFields {
+			if field, ok := v.(string); ok {
+				scopedFieldSlice = append(scopedFieldSlice, field)
+			}
 		}
 		context.Set(r, "user_id", userID)
 		context.Set(r, "account_id", accountID)
@@ -158,6 +107,25 @@ func ImplicitRouterAuthenticationWrapper(requiredScope string, next http.Handler
 	}
 }
 
+//
+// authenticationError responds with 401 for rejected tokens
+// and 500 when the authenticator itself failed
+//
+func authenticationError(w http.ResponseWriter, err error) {
+	if err == ErrUnauthorized {
+		w.WriteHeader(http.StatusUnauthorized)
+	} else {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+	if err = json.NewEncoder(w).Encode(map[string]string{"Error": err.Error()}); err != nil {
+		panic(err)
+	}
+}
+
+func bearerToken(r *http.Request) string {
+	return strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+}
+
 func checkPattern(pattern, match string) bool {
 	var validator *regexp.Regexp
 	if pattern == "*" {
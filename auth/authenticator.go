@@ -0,0 +1,101 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/tespo/buddha/util"
+)
+
+//
+// ErrUnauthorized is returned by authenticators when
+// a token is not valid for the request
+//
+var ErrUnauthorized = errors.New("Unauthorized")
+
+//
+// Authenticator validates the tokens the route wrappers receive.
+// Any other error than ErrUnauthorized is treated as the
+// authenticator itself failing
+//
+type Authenticator interface {
+	// Authorize checks the token may call the route with the method
+	Authorize(header http.Header, token, route, method string) error
+	// Authenticate validates the token and returns its claims
+	Authenticate(header http.Header, token string) (map[string]interface{}, error)
+}
+
+//
+// NeedsTokenVerifier reports whether the authenticator named by
+// AUTH_PROVIDER reads token claims with a token verifier
+//
+func NeedsTokenVerifier() bool {
+	provider := os.Getenv("AUTH_PROVIDER")
+	return provider == "" || provider == "vijnana" || provider == "jwt"
+}
+
+//
+// NewAuthenticatorFromEnv builds the authenticator named by
+// AUTH_PROVIDER, defaulting to vijnana. The vijnana and jwt
+// providers read token claims with the verifier
+//
+func NewAuthenticatorFromEnv(verifier *util.TokenVerifier) (Authenticator, error) {
+	if NeedsTokenVerifier() && verifier == nil {
+		return nil, errors.New("No token verifier for the auth provider")
+	}
+	switch provider := os.Getenv("AUTH_PROVIDER"); provider {
+	case "", "vijnana":
+		return NewVijnanaAuthenticator(
+			verifier,
+			envDuration("VIJNANA_TIMEOUT", 5*time.Second),
+			envDuration("VIJNANA_CACHE_TTL", time.Minute),
+			envDuration("VIJNANA_NEGATIVE_CACHE_TTL", 10*time.Second),
+		), nil
+	case "jwt":
+		return NewJWTAuthenticator(verifier), nil
+	case "static":
+		return NewStaticAuthenticatorFromFile(os.Getenv("AUTH_STATIC_TOKENS_FILE"))
+	default:
+		return nil, fmt.Errorf("Unknown auth provider %v", provider)
+	}
+}
+
+//
+// authorizeClaims checks the permissions claim of a token
+// against a route. Each permission is an object with a slug
+// pattern and the actions allowed on it
+//
+func authorizeClaims(claims map[string]interface{}, route, method string) error {
+	permissions, ok := claims["permissions"].([]interface{})
+	if !ok {
+		return ErrUnauthorized
+	}
+	for _, permission := range permissions {
+		permission, ok := permission.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		slug, _ := permission["slug"].(string)
+		if slug == "" || (slug != route && !checkPattern(slug, route)) {
+			continue
+		}
+		actions, _ := permission["actions"].([]interface{})
+		for _, action := range actions {
+			if action == "*" || action == method {
+				return nil
+			}
+		}
+	}
+	return ErrUnauthorized
+}
+
+func envDuration(key string, fallback time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
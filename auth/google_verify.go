@@ -0,0 +1,83 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/tespo/buddha/util"
+)
+
+//
+// googleJWKSURL serves the keys google signs requests with
+//
+const googleJWKSURL = "https://www.googleapis.com/oauth2/v3/certs"
+
+//
+// GoogleVerifier checks the jwt google signs each request
+// with was issued by google for the project
+//
+type GoogleVerifier struct {
+	verifier *util.TokenVerifier
+}
+
+//
+// NewGoogleVerifier returns a verifier for the project that
+// loads google's keys from the JWKS url or file
+//
+func NewGoogleVerifier(projectID, jwks string) (*GoogleVerifier, error) {
+	verifier, err := util.NewTokenVerifier(util.TokenConfig{
+		JWKSURL:     jwks,
+		JWKSRefresh: time.Hour,
+		Issuer:      "https://accounts.google.com",
+		Audience:    projectID,
+		Leeway:      30 * time.Second,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &GoogleVerifier{verifier: verifier}, nil
+}
+
+//
+// Verify checks the Google-Assistant-Signature header of
+// conversational requests. Smart home intents are not signed,
+// so they rely on the bearer token alone
+//
+func (v *GoogleVerifier) Verify(r *http.Request, body []byte) error {
+	if googleSmartHomeRequest(body) {
+		return nil
+	}
+	token := r.Header.Get("Google-Assistant-Signature")
+	if token == "" {
+		return errors.New("Google request is not signed")
+	}
+	if _, err := v.verifier.Parse(token); err != nil {
+		return errors.New("Google request signature is not valid")
+	}
+	return nil
+}
+
+//
+// googleSmartHomeRequest reports whether every input of the
+// body is an action.devices intent: SYNC, QUERY, EXECUTE
+// or DISCONNECT
+//
+func googleSmartHomeRequest(body []byte) bool {
+	var request struct {
+		Inputs []struct {
+			Intent string `json:"intent"`
+		} `json:"inputs"`
+	}
+	if err := json.Unmarshal(body, &request); err != nil || len(request.Inputs) == 0 {
+		return false
+	}
+	for _, input := range request.Inputs {
+		if !strings.HasPrefix(input.Intent, "action.devices.") {
+			return false
+		}
+	}
+	return true
+}
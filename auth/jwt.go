@@ -0,0 +1,44 @@
+package auth
+
+import (
+	"net/http"
+
+	"github.com/tespo/buddha/util"
+)
+
+//
+// JWTAuthenticator verifies tokens locally without calling
+// vijnana. Route permissions come from the permissions claim
+//
+type JWTAuthenticator struct {
+	verifier *util.TokenVerifier
+}
+
+//
+// NewJWTAuthenticator returns an authenticator for the verifier
+//
+func NewJWTAuthenticator(verifier *util.TokenVerifier) *JWTAuthenticator {
+	return &JWTAuthenticator{verifier: verifier}
+}
+
+//
+// Authorize verifies the token and checks its permissions claim
+//
+func (a *JWTAuthenticator) Authorize(header http.Header, token, route, method string) error {
+	claims, err := a.Authenticate(header, token)
+	if err != nil {
+		return err
+	}
+	return authorizeClaims(claims, route, method)
+}
+
+//
+// Authenticate verifies the token signature and claims
+//
+func (a *JWTAuthenticator) Authenticate(header http.Header, token string) (map[string]interface{}, error) {
+	claims, err := a.verifier.Parse(token)
+	if err != nil {
+		return nil, ErrUnauthorized
+	}
+	return claims, nil
+}
@@ -0,0 +1,66 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+)
+
+//
+// StaticAuthenticator accepts a fixed set of tokens with
+// preconfigured claims. It is meant for local development
+// and tests and must not be used in production
+//
+type StaticAuthenticator struct {
+	tokens map[string]map[string]interface{}
+}
+
+//
+// NewStaticAuthenticator returns an authenticator for the
+// given tokens and the claims each one carries
+//
+func NewStaticAuthenticator(tokens map[string]map[string]interface{}) *StaticAuthenticator {
+	return &StaticAuthenticator{tokens: tokens}
+}
+
+//
+// NewStaticAuthenticatorFromFile loads the tokens from a json
+// file mapping each token to its claims
+//
+func NewStaticAuthenticatorFromFile(path string) (*StaticAuthenticator, error) {
+	if path == "" {
+		return nil, errors.New("AUTH_STATIC_TOKENS_FILE is required for the static auth provider")
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	tokens := map[string]map[string]interface{}{}
+	if err := json.Unmarshal(data, &tokens); err != nil {
+		return nil, err
+	}
+	return NewStaticAuthenticator(tokens), nil
+}
+
+//
+// Authorize checks the permissions claim configured for the token
+//
+func (a *StaticAuthenticator) Authorize(header http.Header, token, route, method string) error {
+	claims, err := a.Authenticate(header, token)
+	if err != nil {
+		return err
+	}
+	return authorizeClaims(claims, route, method)
+}
+
+//
+// Authenticate returns the claims configured for the token
+//
+func (a *StaticAuthenticator) Authenticate(header http.Header, token string) (map[string]interface{}, error) {
+	claims, ok := a.tokens[token]
+	if !ok {
+		return nil, ErrUnauthorized
+	}
+	return claims, nil
+}
@@ -0,0 +1,210 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/tespo/buddha/metrics"
+	"github.com/tespo/buddha/tracing"
+	"github.com/tespo/buddha/util"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+//
+// maxCachedValidations bounds the validation cache before
+// expired entries are swept out
+//
+const maxCachedValidations = 10000
+
+//
+// VijnanaAuthenticator validates tokens against vijnana, caching
+// the results per token, route and method. Concurrent lookups
+// for the same key share a single call to vijnana. The claims
+// of tokens vijnana accepts are read with the verifier
+//
+type VijnanaAuthenticator struct {
+	verifier    *util.TokenVerifier
+	client      *http.Client
+	ttl         time.Duration
+	negativeTTL time.Duration
+	mutex       sync.Mutex
+	entries     map[string]validation
+	calls       map[string]*validationCall
+}
+
+type validation struct {
+	valid     bool
+	expiresAt time.Time
+}
+
+type validationCall struct {
+	done  chan struct{}
+	valid bool
+	err   error
+}
+
+//
+// NewVijnanaAuthenticator returns a vijnana authenticator using the
+// verifier, the client timeout and the cache lifetimes for accepted
+// and rejected tokens
+//
+func NewVijnanaAuthenticator(verifier *util.TokenVerifier, timeout, ttl, negativeTTL time.Duration) *VijnanaAuthenticator {
+	return &VijnanaAuthenticator{
+		verifier:    verifier,
+		client:      &http.Client{Timeout: timeout},
+		ttl:         ttl,
+		negativeTTL: negativeTTL,
+		entries:     map[string]validation{},
+		calls:       map[string]*validationCall{},
+	}
+}
+
+//
+// Authorize checks with vijnana that the token holds
+// the permission for the route and method
+//
+func (v *VijnanaAuthenticator) Authorize(header http.Header, token, route, method string) error {
+	valid, err := v.validate(header, "/validate-token-permissions", token, route, method)
+	if err != nil {
+		return err
+	}
+	if !valid {
+		return ErrUnauthorized
+	}
+	return nil
+}
+
+//
+// Authenticate checks with vijnana that the token is
+// valid and returns its verified claims
+//
+func (v *VijnanaAuthenticator) Authenticate(header http.Header, token string) (map[string]interface{}, error) {
+	valid, err := v.validate(header, "/validate-token", token, "", "")
+	if err != nil {
+		return nil, err
+	}
+	if !valid {
+		return nil, ErrUnauthorized
+	}
+	return v.verifier.Parse(token)
+}
+
+//
+// validate returns whether vijnana accepts the token for the
+// route and method, answering from the cache when it can.
+// Only an answer of valid or rejected is cached, so a vijnana
+// error is retried by the next request.
+// The span of the check is a child of the header's traceparent
+//
+func (v *VijnanaAuthenticator) validate(header http.Header, endpoint, token, route, method string) (bool, error) {
+	ctx, span := tracing.Tracer().Start(tracing.Extract(context.Background(), header), "vijnana "+endpoint,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("vijnana.route", route),
+			attribute.String("vijnana.method", method),
+		),
+	)
+	defer span.End()
+	key := endpoint + "|" + route + "|" + method + "|" + token
+	v.mutex.Lock()
+	if entry, ok := v.entries[key]; ok && time.Now().Before(entry.expiresAt) {
+		v.mutex.Unlock()
+		span.SetAttributes(attribute.String("vijnana.cache", "hit"))
+		return entry.valid, nil
+	}
+	if call, ok := v.calls[key]; ok {
+		v.mutex.Unlock()
+		span.SetAttributes(attribute.String("vijnana.cache", "shared"))
+		<-call.done
+		tracing.SetError(span, call.err)
+		return call.valid, call.err
+	}
+	call := &validationCall{done: make(chan struct{})}
+	v.calls[key] = call
+	v.mutex.Unlock()
+
+	span.SetAttributes(attribute.String("vijnana.cache", "miss"))
+	call.valid, call.err = v.request(ctx, span, header, endpoint, route, method)
+	tracing.SetError(span, call.err)
+
+	v.mutex.Lock()
+	delete(v.calls, key)
+	if call.err == nil {
+		v.store(key, token, call.valid)
+	}
+	v.mutex.Unlock()
+	close(call.done)
+	return call.valid, call.err
+}
+
+func (v *VijnanaAuthenticator) request(ctx context.Context, span trace.Span, header http.Header, endpoint, route, method string) (bool, error) {
+	req, err := http.NewRequest("GET", os.Getenv("VIJNANA_URL")+endpoint, nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header = cloneHeader(header)
+	if route != "" {
+		req.Header.Set("Route", route)
+		req.Header.Set("Method", method)
+	}
+	tracing.Inject(ctx, req.Header)
+	start := time.Now()
+	resp, err := v.client.Do(req)
+	metrics.VijnanaDuration.WithLabelValues(endpoint).Observe(time.Since(start).Seconds())
+	if err != nil {
+		metrics.VijnanaRequests.WithLabelValues(endpoint, "error").Inc()
+		return false, err
+	}
+	resp.Body.Close()
+	span.SetAttributes(attribute.Int("http.status_code", resp.StatusCode))
+	switch resp.StatusCode {
+	case http.StatusOK:
+		metrics.VijnanaRequests.WithLabelValues(endpoint, "valid").Inc()
+		return true, nil
+	case http.StatusUnauthorized, http.StatusForbidden:
+		metrics.VijnanaRequests.WithLabelValues(endpoint, "invalid").Inc()
+		return false, nil
+	default:
+		metrics.VijnanaRequests.WithLabelValues(endpoint, "error").Inc()
+		return false, fmt.Errorf("vijnana answered %v", resp.StatusCode)
+	}
+}
+
+func (v *VijnanaAuthenticator) store(key, token string, valid bool) {
+	ttl := v.negativeTTL
+	if valid {
+		ttl = v.ttl
+	}
+	expiresAt := time.Now().Add(ttl)
+	if exp, ok := util.TokenExpiry(token); ok && exp.Before(expiresAt) {
+		expiresAt = exp
+	}
+	if !time.Now().Before(expiresAt) {
+		return
+	}
+	if len(v.entries) >= maxCachedValidations {
+		now := time.Now()
+		for cachedKey, entry := range v.entries {
+			if !now.Before(entry.expiresAt) {
+				delete(v.entries, cachedKey)
+			}
+		}
+		if len(v.entries) >= maxCachedValidations {
+			return
+		}
+	}
+	v.entries[key] = validation{valid: valid, expiresAt: expiresAt}
+}
+
+func cloneHeader(header http.Header) http.Header {
+	clone := make(http.Header, len(header))
+	for key, values := range header {
+		clone[key] = append([]string(nil), values...)
+	}
+	return clone
+}
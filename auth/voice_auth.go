@@ -2,53 +2,44 @@ package auth
 
 import (
 	"bytes"
-	"encoding/json"
 	"errors"
 	"io/ioutil"
 	"net/http"
-	"os"
-	"reflect"
-	"strings"
 
 	"github.com/gorilla/context"
 	"github.com/tespo/buddha/util"
-	"github.com/tespo/satya/v2/types"
+	"github.com/tespo/buddha/voice"
 )
 
 //
 // AuthenticateVoiceRequest handles parsing and validating
-// a jwt token from different voice command services
+// a jwt token from different voice command services, once
+// the verifier has checked the request comes from the service.
+// The provider's codec knows where the token is
 //
-func AuthenticateVoiceRequest(provider string, next http.HandlerFunc) http.HandlerFunc {
+func AuthenticateVoiceRequest(authenticator Authenticator, verifier VoiceVerifier, provider string, next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		client := &http.Client{}
-		req, _ := http.NewRequest("GET", os.Getenv("VIJNANA_URL")+"/validate-token", nil)
-		req.Header = r.Header
-		if provider == "alexa" {
-			req.Header.Set("Authorization", "Bearer "+getAlexaToken(r))
+		codec, ok := voice.Codecs[provider]
+		if verifier == nil || !ok {
+			util.ErrorResponder(w, http.StatusUnauthorized, errors.New("Requests from "+provider+" cannot be verified"))
+			return
 		}
-		resp, err := client.Do(req)
+		data, err := ioutil.ReadAll(r.Body)
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			if err = json.NewEncoder(w).Encode(map[string]string{"Error": err.Error()}); err != nil {
-				panic(err)
-			}
+			util.ErrorResponder(w, http.StatusBadRequest, err)
 			return
 		}
-		if resp.StatusCode != 200 {
-			w.WriteHeader(http.StatusUnauthorized)
-			if err = json.NewEncoder(w).Encode(map[string]string{"Error": "Unauthorized"}); err != nil {
-				panic(err)
-			}
+		r.Body = ioutil.NopCloser(bytes.NewReader(data))
+		if err := verifier.Verify(r, data); err != nil {
+			util.ErrorResponder(w, http.StatusUnauthorized, err)
 			return
 		}
-		tokenString := strings.Split(r.Header.Get("Authorization"), "Bearer ")[1]
-		claims, err := util.ParseToken(tokenString)
+		tokenString := codec.Token(r, data)
+		header := cloneHeader(r.Header)
+		header.Set("Authorization", "Bearer "+tokenString)
+		claims, err := authenticator.Authenticate(header, tokenString)
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			if err = json.NewEncoder(w).Encode(map[string]string{"Error": err.Error()}); err != nil {
-				panic(err)
-			}
+			authenticationError(w, err)
 			return
 		}
 		userID, ok := claims["user_id"].(string)
@@ -60,23 +51,3 @@ func AuthenticateVoiceRequest(provider string, next http.HandlerFunc) http.Handl
 		next(w, r)
 	}
 }
-
-func getAlexaToken(r *http.Request) string {
-	data, err := ioutil.ReadAll(r.Body)
-	if err != nil {
-		return ""
-	}
-	var d map[string]interface{}
-	if err := json.Unmarshal(data, &d); err != nil {
-		return ""
-	}
-	alexaRequest := types.AlexaRequest{}
-	if err := json.Unmarshal(data, &alexaRequest); err != nil {
-		return ""
-	}
-	r.Body = ioutil.NopCloser(bytes.NewReader(data))
-	if !reflect.DeepEqual(alexaRequest.Directive.Endpoint, types.AlexaDiscoverResponseEventPayloadEndpoint{}) {
-		return alexaRequest.Directive.Endpoint.Scope.Token
-	}
-	return alexaRequest.Directive.Payload.Scope.Token
-}
@@ -0,0 +1,85 @@
+package auth
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"time"
+)
+
+//
+// VoiceVerifier checks a voice command request really
+// comes from the assistant it claims to come from
+//
+type VoiceVerifier interface {
+	Verify(r *http.Request, body []byte) error
+}
+
+//
+// SkipVoiceVerification accepts every request. It is
+// used when VOICE_VERIFICATION is off
+//
+var SkipVoiceVerification VoiceVerifier = skipVoiceVerification{}
+
+type skipVoiceVerification struct{}
+
+func (skipVoiceVerification) Verify(r *http.Request, body []byte) error {
+	return nil
+}
+
+//
+// VoiceVerifiersFromEnv builds the verifier of each voice
+// provider. VOICE_VERIFICATION is on by default, test
+// verifies against the local fixture certificates and
+// off turns verification off. Webhook requests carry the
+// user's own token, so there is no platform to verify
+//
+func VoiceVerifiersFromEnv() (map[string]VoiceVerifier, error) {
+	mode := os.Getenv("VOICE_VERIFICATION")
+	if mode == "off" {
+		return map[string]VoiceVerifier{
+			"alexa":   SkipVoiceVerification,
+			"google":  SkipVoiceVerification,
+			"webhook": SkipVoiceVerification,
+		}, nil
+	}
+	projectID := os.Getenv("GOOGLE_PROJECT_ID")
+	if projectID == "" {
+		return nil, errors.New("GOOGLE_PROJECT_ID is required to verify google requests")
+	}
+	switch mode {
+	case "", "on":
+		google, err := NewGoogleVerifier(projectID, googleJWKSURL)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]VoiceVerifier{
+			"alexa":   NewAlexaVerifier(NewCertificateStore(10*time.Second), nil),
+			"google":  google,
+			"webhook": SkipVoiceVerification,
+		}, nil
+	case "test":
+		roots := x509.NewCertPool()
+		data, err := ioutil.ReadFile(os.Getenv("ALEXA_TEST_ROOT_CERT_FILE"))
+		if err != nil {
+			return nil, err
+		}
+		if !roots.AppendCertsFromPEM(data) {
+			return nil, errors.New("ALEXA_TEST_ROOT_CERT_FILE has no certificates")
+		}
+		google, err := NewGoogleVerifier(projectID, os.Getenv("GOOGLE_TEST_JWKS_FILE"))
+		if err != nil {
+			return nil, err
+		}
+		return map[string]VoiceVerifier{
+			"alexa":   NewAlexaVerifier(NewFixtureCertificateStore(os.Getenv("ALEXA_TEST_CERT_CHAIN_FILE")), roots),
+			"google":  google,
+			"webhook": SkipVoiceVerification,
+		}, nil
+	default:
+		return nil, fmt.Errorf("Unknown voice verification mode %v", mode)
+	}
+}
@@ -2,18 +2,88 @@ package db
 
 import (
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/jinzhu/gorm"
 	_ "github.com/jinzhu/gorm/dialects/mysql" // Importing this for gorm to designate the db driver
 )
 
 //
-// Open database connection specific to the environment
+// Config holds the settings for the shared
+// database connection pool
 //
-func Open() (*gorm.DB, error) {
-	db, err := gorm.Open("mysql", os.Getenv("DB_USER")+"@"+os.Getenv("DB_HOST")+"/"+os.Getenv("DB_NAME")+"?charset=utf8&parseTime=True&loc=Local")
+type Config struct {
+	DSN             string
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+}
+
+//
+// ConfigFromEnv builds the pool config specific to the environment
+//
+func ConfigFromEnv() Config {
+	return Config{
+		DSN:             os.Getenv("DB_USER") + "@" + os.Getenv("DB_HOST") + "/" + os.Getenv("DB_NAME") + "?charset=utf8&parseTime=True&loc=Local",
+		MaxOpenConns:    envInt("DB_MAX_OPEN_CONNS", 25),
+		MaxIdleConns:    envInt("DB_MAX_IDLE_CONNS", 25),
+		ConnMaxLifetime: envDuration("DB_CONN_MAX_LIFETIME", 5*time.Minute),
+	}
+}
+
+//
+// Connect opens the long lived connection pool. It is meant
+// to be called once on startup and shared by every request.
+// gorm pings the database on open so a bad config fails here
+//
+func Connect(config Config) (*gorm.DB, error) {
+	db, err := gorm.Open("mysql", config.DSN)
 	if err != nil {
 		return nil, err
 	}
+	db.DB().SetMaxOpenConns(config.MaxOpenConns)
+	db.DB().SetMaxIdleConns(config.MaxIdleConns)
+	db.DB().SetConnMaxLifetime(config.ConnMaxLifetime)
 	return db, nil
 }
+
+func envInt(key string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+func envDuration(key string, fallback time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+//
+// Transaction runs fn in a database transaction. The transaction
+// is committed when fn returns nil and rolled back when it
+// returns an error or panics, in which case the panic is
+// raised again after the rollback
+//
+func Transaction(conn *gorm.DB, fn func(tx *gorm.DB) error) error {
+	tx := conn.Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+			panic(r)
+		}
+	}()
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
@@ -0,0 +1,256 @@
+package firmware
+
+import (
+	"encoding/json"
+	"hash/fnv"
+	"sort"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	uuid "github.com/satori/go.uuid"
+	"github.com/tespo/buddha/models"
+	"github.com/tespo/satya/v2/types"
+)
+
+//
+// Parts of a dispenser that run firmware
+//
+const (
+	PartPCB        = "pcb"
+	PartWifi       = "wifi"
+	PartController = "controller"
+)
+
+//
+// DefaultCohort is the cohort every dispenser is in
+//
+const DefaultCohort = "default"
+
+//
+// Parts lists every part that runs firmware
+//
+var Parts = []string{PartPCB, PartWifi, PartController}
+
+//
+// Versions are the firmware versions of a dispenser by part
+//
+type Versions map[string]string
+
+//
+// FromPayload reads the versions a dispenser reported
+// in a lambda message. Parts left empty are skipped
+//
+func FromPayload(dispenser types.PayloadDispenser) Versions {
+	versions := Versions{}
+	for part, version := range map[string]string{
+		PartPCB:        dispenser.PcbFirmwareVersion,
+		PartWifi:       dispenser.WifiFirmwareVersion,
+		PartController: dispenser.ControllerFirmwareVersion,
+	} {
+		if version != "" {
+			versions[part] = version
+		}
+	}
+	return versions
+}
+
+//
+// Meta sets the versions in the dispenser's meta, as
+// {"pcb": {"version": "1.2.0"}, ...}, keeping the rest of it
+//
+func Meta(meta json.RawMessage, versions Versions) (json.RawMessage, error) {
+	fields := map[string]json.RawMessage{}
+	if len(meta) > 0 && json.Unmarshal(meta, &fields) != nil {
+		fields = map[string]json.RawMessage{}
+	}
+	for part, version := range versions {
+		data, err := json.Marshal(map[string]string{"version": version})
+		if err != nil {
+			return nil, err
+		}
+		fields[part] = data
+	}
+	return json.Marshal(fields)
+}
+
+//
+// Record adds the reported versions to the dispenser's firmware
+// history. A version reported again is seen again, and a new
+// version becomes the part's current one
+//
+func Record(db *gorm.DB, dispenserID uuid.UUID, versions Versions, at time.Time) error {
+	parts := make([]string, 0, len(versions))
+	for part := range versions {
+		parts = append(parts, part)
+	}
+	sort.Strings(parts)
+	for _, part := range parts {
+		current := models.FirmwareVersion{}
+		err := current.GetOneByQuery(db, "dispenser_id = ? AND part = ? AND current = ?", dispenserID, part, true)
+		if err != nil && err.Error() != "record not found" {
+			return err
+		}
+		if err == nil {
+			if current.Version == versions[part] {
+				current.LastSeenAt = at
+				if err := current.Update(db); err != nil {
+					return err
+				}
+				continue
+			}
+			current.Current = false
+			if err := current.Update(db); err != nil {
+				return err
+			}
+		}
+		version := models.FirmwareVersion{
+			DispenserID: dispenserID,
+			Part:        part,
+			Version:     versions[part],
+			Current:     true,
+			FirstSeenAt: at,
+			LastSeenAt:  at,
+		}
+		if err := version.Create(db); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//
+// Current gets the versions the dispenser runs now
+//
+func Current(db *gorm.DB, dispenserID uuid.UUID) (Versions, error) {
+	current := models.FirmwareVersions{}
+	if err := current.GetByQuery(db, "dispenser_id = ? AND current = ?", dispenserID, true); err != nil {
+		return nil, err
+	}
+	versions := Versions{}
+	for _, version := range current {
+		versions[version.Part] = version.Version
+	}
+	return versions, nil
+}
+
+//
+// Cohorts gets the cohorts the dispenser is in: the default
+// cohort and every cohort it was added to
+//
+func Cohorts(db *gorm.DB, dispenserID uuid.UUID) (map[string]bool, error) {
+	members := models.FirmwareCohortMembers{}
+	if err := members.GetByQuery(db, "dispenser_id = ?", dispenserID); err != nil {
+		return nil, err
+	}
+	cohorts := map[string]bool{DefaultCohort: true}
+	for _, member := range members {
+		cohorts[member.Cohort] = true
+	}
+	return cohorts, nil
+}
+
+//
+// VersionCount is how many dispensers run a version
+//
+type VersionCount struct {
+	Version    string  `json:"version"`
+	Dispensers int     `json:"dispensers"`
+	Percentage float64 `json:"percentage"`
+}
+
+//
+// PartBreakdown is how the versions of a part are spread
+// across the dispensers that reported it, most common first
+//
+type PartBreakdown struct {
+	Dispensers int            `json:"dispensers"`
+	Versions   []VersionCount `json:"versions"`
+}
+
+//
+// Fleet breaks down the current versions of every part
+//
+func Fleet(db *gorm.DB) (map[string]PartBreakdown, error) {
+	rows := []struct {
+		Part       string
+		Version    string
+		Dispensers int
+	}{}
+	err := db.Model(&models.FirmwareVersion{}).
+		Select("part, version, count(*) as dispensers").
+		Where("current = ?", true).
+		Group("part, version").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	fleet := map[string]PartBreakdown{}
+	for _, part := range Parts {
+		fleet[part] = PartBreakdown{Versions: []VersionCount{}}
+	}
+	for _, row := range rows {
+		breakdown := fleet[row.Part]
+		breakdown.Dispensers += row.Dispensers
+		breakdown.Versions = append(breakdown.Versions, VersionCount{Version: row.Version, Dispensers: row.Dispensers})
+		fleet[row.Part] = breakdown
+	}
+	for part, breakdown := range fleet {
+		for i := range breakdown.Versions {
+			breakdown.Versions[i].Percentage = 100 * float64(breakdown.Versions[i].Dispensers) / float64(breakdown.Dispensers)
+		}
+		sort.Slice(breakdown.Versions, func(i, j int) bool {
+			if breakdown.Versions[i].Dispensers != breakdown.Versions[j].Dispensers {
+				return breakdown.Versions[i].Dispensers > breakdown.Versions[j].Dispensers
+			}
+			return breakdown.Versions[i].Version < breakdown.Versions[j].Version
+		})
+		fleet[part] = breakdown
+	}
+	return fleet, nil
+}
+
+//
+// Bucket places the dispenser in one of 100 buckets of the
+// cohort. A dispenser keeps its bucket, so raising a rollout's
+// percentage only adds dispensers to it
+//
+func Bucket(cohort, serial string) uint {
+	hash := fnv.New32a()
+	hash.Write([]byte(cohort + ":" + serial))
+	return uint(hash.Sum32() % 100)
+}
+
+//
+// Update is a version a dispenser should update a part to
+//
+type Update struct {
+	Part      string    `json:"part"`
+	Version   string    `json:"version"`
+	RolloutID uuid.UUID `json:"rollout_id"`
+}
+
+//
+// Updates picks the updates for a dispenser from the active
+// rollouts, newest first. Only rollouts of the dispenser's
+// cohorts are offered, and for each part the newest rollout the
+// dispenser is in wins. A part already on that rollout's target
+// needs no update
+//
+func Updates(rollouts models.FirmwareRollouts, serial string, cohorts map[string]bool, current Versions) []Update {
+	updates := []Update{}
+	decided := map[string]bool{}
+	for _, rollout := range rollouts {
+		if !cohorts[rollout.Cohort] {
+			continue
+		}
+		if decided[rollout.Part] || Bucket(rollout.Cohort, serial) >= rollout.Percentage {
+			continue
+		}
+		decided[rollout.Part] = true
+		if current[rollout.Part] == rollout.TargetVersion {
+			continue
+		}
+		updates = append(updates, Update{Part: rollout.Part, Version: rollout.TargetVersion, RolloutID: rollout.ID})
+	}
+	return updates
+}
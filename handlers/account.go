@@ -11,7 +11,6 @@ import (
 
 	"github.com/gorilla/context"
 	"github.com/gorilla/mux"
-	"github.com/tespo/buddha/db"
 	"github.com/tespo/buddha/util"
 	"github.com/tespo/satya/v2/scoping"
 	"github.com/tespo/satya/v2/types"
@@ -33,11 +32,7 @@ func GetAccount(w http.ResponseWriter, r *http.Request) {
 		util.ErrorResponder(w, http.StatusBadRequest, errors.New("No scopes"))
 		return
 	}
-	db, err := db.Open()
-	if err != nil {
-		panic(err)
-	}
-	defer db.Close()
+	db := requestDB(r)
 	account := types.Account{}
 	if err := account.GetByID(db, uuid.FromStringOrNil(accountID.(string))); err != nil {
 		util.ErrorResponder(w, http.StatusInternalServerError, err)
@@ -74,11 +69,7 @@ func PutAccount(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	db, err := db.Open()
-	if err != nil {
-		panic(err)
-	}
-	defer db.Close()
+	db := requestDB(r)
 	account.ID = uuid.FromStringOrNil(accountID.(string))
 	if err := account.Update(db); err != nil {
 		util.ErrorResponder(w, http.StatusInternalServerError, err)
@@ -102,11 +93,7 @@ func GetAccountByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	db, err := db.Open()
-	if err != nil {
-		panic(err)
-	}
-	defer db.Close()
+	db := requestDB(r)
 
 	var account types.Account
 	if err := account.GetByID(db, uuid.FromStringOrNil(id)); err != nil {
@@ -114,7 +101,7 @@ func GetAccountByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err = json.NewEncoder(w).Encode(account); err != nil {
+	if err := json.NewEncoder(w).Encode(account); err != nil {
 		panic(err)
 	}
 }
@@ -125,13 +112,7 @@ func GetAccountByID(w http.ResponseWriter, r *http.Request) {
 func GetAccounts(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	db, err := db.Open()
-	if err != nil {
-		panic(err)
-	}
-	defer db.Close()
-
-	db = util.SetDBPagination(db, r)
+	db := util.SetDBPagination(requestDB(r), r)
 
 	var accounts types.Accounts
 	if err := accounts.Get(db); err != nil {
@@ -156,18 +137,14 @@ func PostAccount(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	db, err := db.Open()
-	if err != nil {
-		panic(err)
-	}
-	defer db.Close()
+	db := requestDB(r)
 
 	if err := account.Create(db); err != nil {
 		util.ErrorResponder(w, http.StatusInternalServerError, err)
 		return
 	}
 
-	if err = json.NewEncoder(w).Encode(account); err != nil {
+	if err := json.NewEncoder(w).Encode(account); err != nil {
 		panic(err)
 	}
 }
@@ -196,18 +173,14 @@ func PutAccountByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	db, err := db.Open()
-	if err != nil {
-		panic(err)
-	}
-	defer db.Close()
+	db := requestDB(r)
 
 	if err := account.Update(db); err != nil {
 		util.ErrorResponder(w, http.StatusInternalServerError, err)
 		return
 	}
 
-	if err = json.NewEncoder(w).Encode(account); err != nil {
+	if err := json.NewEncoder(w).Encode(account); err != nil {
 		panic(err)
 	}
 }
@@ -224,11 +197,7 @@ func DeleteAccountByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	db, err := db.Open()
-	if err != nil {
-		panic(err)
-	}
-	defer db.Close()
+	db := requestDB(r)
 
 	var account types.Account
 	if err := account.Delete(db, uuid.FromStringOrNil(id)); err != nil {
@@ -236,7 +205,7 @@ func DeleteAccountByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err = json.NewEncoder(w).Encode(account); err != nil {
+	if err := json.NewEncoder(w).Encode(account); err != nil {
 		panic(err)
 	}
 }
@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/context"
+	"github.com/jinzhu/gorm"
+	uuid "github.com/satori/go.uuid"
+	"github.com/tespo/buddha/adherence"
+	"github.com/tespo/buddha/util"
+	"github.com/tespo/satya/v2/types"
+)
+
+//
+// maxAdherenceDays is the longest range a report can cover
+//
+const maxAdherenceDays = 366
+
+//
+// GetUserAdherence is the GET method for the adherence
+// of the user's regimens
+//
+func GetUserAdherence(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	userID, ok := context.GetOk(r, "user_id")
+	if !ok {
+		util.ErrorResponder(w, http.StatusInternalServerError, errors.New("Cannot process token claims"))
+		return
+	}
+	options, err := adherenceOptions(r)
+	if err != nil {
+		util.ErrorResponder(w, http.StatusBadRequest, err)
+		return
+	}
+	db := requestDB(r)
+
+	regimens := types.Regimens{}
+	if err := regimens.GetByQuery(db, "user_id = ?", uuid.FromStringOrNil(userID.(string))); err != nil {
+		util.ErrorResponder(w, http.StatusInternalServerError, err)
+		return
+	}
+	report, err := adherenceReport(db, regimens, options)
+	if err != nil {
+		util.ErrorResponder(w, http.StatusInternalServerError, err)
+		return
+	}
+	util.JSONResponder(w, report)
+}
+
+//
+// GetAccountAdherence is the GET method for the adherence
+// of every regimen on the account
+//
+func GetAccountAdherence(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	accountID, ok := context.GetOk(r, "account_id")
+	if !ok {
+		util.ErrorResponder(w, http.StatusInternalServerError, errors.New("Cannot process token claims"))
+		return
+	}
+	options, err := adherenceOptions(r)
+	if err != nil {
+		util.ErrorResponder(w, http.StatusBadRequest, err)
+		return
+	}
+	db := requestDB(r)
+
+	regimens := types.Regimens{}
+	if err := regimens.GetAccountRegimens(db, uuid.FromStringOrNil(accountID.(string))); err != nil {
+		util.ErrorResponder(w, http.StatusInternalServerError, err)
+		return
+	}
+	report, err := adherenceReport(db, regimens, options)
+	if err != nil {
+		util.ErrorResponder(w, http.StatusInternalServerError, err)
+		return
+	}
+	util.JSONResponder(w, report)
+}
+
+//
+// adherenceReport loads the reminders and usages of the regimens
+// and builds the report. Deleted reminders are loaded too so
+// doses count for the time the reminder existed
+//
+func adherenceReport(db *gorm.DB, regimens types.Regimens, options adherence.Options) (adherence.Report, error) {
+	reminders := types.Reminders{}
+	usages := types.Usages{}
+	if len(regimens) > 0 {
+		regimenIDs := make([]uuid.UUID, len(regimens))
+		for i, regimen := range regimens {
+			regimenIDs[i] = regimen.ID
+		}
+		if err := reminders.GetByQuery(db.Unscoped(), "regimen_id IN (?)", regimenIDs); err != nil {
+			return adherence.Report{}, err
+		}
+		start, end := adherence.UsageRange(options)
+		if err := usages.GetByQuery(db, "regimen_id IN (?) AND created_at BETWEEN ? AND ?", regimenIDs, start, end); err != nil {
+			return adherence.Report{}, err
+		}
+	}
+	return adherence.Build(regimens, reminders, usages, options), nil
+}
+
+//
+// adherenceOptions reads the report range from the from and to
+// dates, the tz timezone and the window in minutes. It defaults
+// to the last 30 days in UTC
+//
+func adherenceOptions(r *http.Request) (adherence.Options, error) {
+	query := r.URL.Query()
+	options := adherence.Options{
+		Location: time.UTC,
+		Window:   adherence.DefaultWindow,
+		Now:      time.Now(),
+	}
+	if tz := query.Get("tz"); tz != "" {
+		location, err := time.LoadLocation(tz)
+		if err != nil {
+			return options, errors.New("Invalid timezone " + tz)
+		}
+		options.Location = location
+	}
+	if window := query.Get("window"); window != "" {
+		minutes, err := strconv.Atoi(window)
+		if err != nil || minutes <= 0 {
+			return options, errors.New("window must be a positive number of minutes")
+		}
+		options.Window = time.Duration(minutes) * time.Minute
+	}
+	now := options.Now.In(options.Location)
+	options.To = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, options.Location)
+	if to := query.Get("to"); to != "" {
+		date, err := time.ParseInLocation("2006-01-02", to, options.Location)
+		if err != nil {
+			return options, errors.New("to must be a date formatted as YYYY-MM-DD")
+		}
+		options.To = date
+	}
+	options.From = options.To.AddDate(0, 0, -29)
+	if from := query.Get("from"); from != "" {
+		date, err := time.ParseInLocation("2006-01-02", from, options.Location)
+		if err != nil {
+			return options, errors.New("from must be a date formatted as YYYY-MM-DD")
+		}
+		options.From = date
+	}
+	if options.To.Before(options.From) {
+		return options, errors.New("from must not be after to")
+	}
+	if options.From.AddDate(0, 0, maxAdherenceDays).Before(options.To) {
+		return options, errors.New("range cannot be longer than " + strconv.Itoa(maxAdherenceDays) + " days")
+	}
+	return options, nil
+}
@@ -9,7 +9,6 @@ import (
 
 	"github.com/gorilla/mux"
 	uuid "github.com/satori/go.uuid"
-	"github.com/tespo/buddha/db"
 	"github.com/tespo/buddha/util"
 	"github.com/tespo/satya/v2/types"
 )
@@ -20,12 +19,7 @@ import (
 func GetBarcodes(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	db, err := db.Open()
-	if err != nil {
-		panic(err)
-	}
-	defer db.Close()
-	db = util.SetDBPagination(db, r)
+	db := util.SetDBPagination(requestDB(r), r)
 
 	var barcodes types.Barcodes
 	if err := barcodes.Get(db); err != nil {
@@ -48,11 +42,7 @@ func GetBarcodesByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	db, err := db.Open()
-	if err != nil {
-		panic(err)
-	}
-	defer db.Close()
+	db := requestDB(r)
 
 	var barcode types.Barcode
 	if err := barcode.GetByID(db, uuid.FromStringOrNil(id)); err != nil {
@@ -60,7 +50,7 @@ func GetBarcodesByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err = json.NewEncoder(w).Encode(barcode); err != nil {
+	if err := json.NewEncoder(w).Encode(barcode); err != nil {
 		panic(err)
 	}
 }
@@ -79,18 +69,14 @@ func PostBarcodes(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	db, err := db.Open()
-	if err != nil {
-		panic(err)
-	}
-	defer db.Close()
+	db := requestDB(r)
 
 	if err := barcode.Create(db); err != nil {
 		util.ErrorResponder(w, http.StatusInternalServerError, err)
 		return
 	}
 
-	if err = json.NewEncoder(w).Encode(barcode); err != nil {
+	if err := json.NewEncoder(w).Encode(barcode); err != nil {
 		panic(err)
 	}
 }
@@ -119,18 +105,14 @@ func PutBarcodesByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	db, err := db.Open()
-	if err != nil {
-		panic(err)
-	}
-	defer db.Close()
+	db := requestDB(r)
 
 	if err := barcode.Update(db); err != nil {
 		util.ErrorResponder(w, http.StatusInternalServerError, err)
 		return
 	}
 
-	if err = json.NewEncoder(w).Encode(barcode); err != nil {
+	if err := json.NewEncoder(w).Encode(barcode); err != nil {
 		panic(err)
 	}
 }
@@ -155,11 +137,7 @@ func PutBarcodesByCode(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	db, err := db.Open()
-	if err != nil {
-		panic(err)
-	}
-	defer db.Close()
+	db := requestDB(r)
 
 	existingBarcode := types.Barcode{}
 	if err := existingBarcode.GetOneByQuery(db, "code = ?", code); err != nil {
@@ -174,7 +152,7 @@ func PutBarcodesByCode(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err = json.NewEncoder(w).Encode(barcode); err != nil {
+	if err := json.NewEncoder(w).Encode(barcode); err != nil {
 		panic(err)
 	}
 }
@@ -191,11 +169,7 @@ func DeleteBarcodesByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	db, err := db.Open()
-	if err != nil {
-		panic(err)
-	}
-	defer db.Close()
+	db := requestDB(r)
 
 	var barcode types.Barcode
 	if err := barcode.Delete(db, uuid.FromStringOrNil(id)); err != nil {
@@ -203,7 +177,7 @@ func DeleteBarcodesByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err = json.NewEncoder(w).Encode(barcode); err != nil {
+	if err := json.NewEncoder(w).Encode(barcode); err != nil {
 		panic(err)
 	}
 }
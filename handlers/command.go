@@ -0,0 +1,287 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gorilla/context"
+	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
+	uuid "github.com/satori/go.uuid"
+	"github.com/tespo/buddha/models"
+	"github.com/tespo/buddha/tracing"
+	"github.com/tespo/buddha/util"
+	"github.com/tespo/satya/v2/types"
+)
+
+//
+// commandLambdas are the lambda functions that
+// send each kind of command to a dispenser
+//
+var commandLambdas = map[string]string{
+	models.CommandDispense: "DispenserDispense",
+	models.CommandLocate:   "DispenserLocate",
+	models.CommandReboot:   "DispenserReboot",
+}
+
+//
+// commandPayload is the lambda payload of a command,
+// which the dispenser reports back with
+//
+type commandPayload struct {
+	types.Payload
+	Command struct {
+		ID   uuid.UUID `json:"id"`
+		Kind string    `json:"kind"`
+	} `json:"command"`
+}
+
+//
+// commandStatusMessage is a dispenser reporting on a command
+//
+type commandStatusMessage struct {
+	Payload struct {
+		Command struct {
+			ID     uuid.UUID `json:"id"`
+			Status string    `json:"status"`
+			Error  string    `json:"error"`
+		} `json:"command"`
+	} `json:"payload"`
+}
+
+//
+// PostDispenserCommand is the POST method to send a command
+// to one of the account's dispensers. The command is sent once,
+// before responding, and is answered failed with a 502 when
+// the lambda cannot be invoked
+//
+func PostDispenserCommand(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	accountID, ok := context.GetOk(r, "account_id")
+	if !ok {
+		util.ErrorResponder(w, http.StatusInternalServerError, errors.New("Cannot process token claims"))
+		return
+	}
+	var body struct {
+		Kind string `json:"kind"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		util.ErrorResponder(w, http.StatusBadRequest, err)
+		return
+	}
+	if !models.ValidCommandKind(body.Kind) {
+		util.ErrorResponder(w, http.StatusBadRequest, errors.New("kind must be dispense, locate or reboot"))
+		return
+	}
+	db := requestDB(r)
+	dispenser, err := accountDispenser(db, uuid.FromStringOrNil(accountID.(string)), mux.Vars(r)["dispenser_id"])
+	if err != nil {
+		dispenserErrorResponder(w, err)
+		return
+	}
+	var userID *uuid.UUID
+	if id, ok := context.GetOk(r, "user_id"); ok {
+		parsed := uuid.FromStringOrNil(id.(string))
+		userID = &parsed
+	}
+	command, err := sendDeviceCommand(db, util.RequestID(r), dispenser, uuid.FromStringOrNil(accountID.(string)), userID, body.Kind, "api")
+	if err != nil && command.ID == uuid.Nil {
+		util.ErrorResponder(w, http.StatusInternalServerError, err)
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusBadGateway)
+	}
+	util.JSONResponder(w, command)
+}
+
+//
+// GetDispenserCommands is the GET method for the commands
+// sent to one of the account's dispensers, newest first
+//
+func GetDispenserCommands(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	accountID, ok := context.GetOk(r, "account_id")
+	if !ok {
+		util.ErrorResponder(w, http.StatusInternalServerError, errors.New("Cannot process token claims"))
+		return
+	}
+	db := requestDB(r)
+	dispenser, err := accountDispenser(db, uuid.FromStringOrNil(accountID.(string)), mux.Vars(r)["dispenser_id"])
+	if err != nil {
+		dispenserErrorResponder(w, err)
+		return
+	}
+	commands := models.DeviceCommands{}
+	if err := commands.GetByQuery(util.SetDBPagination(db, r).Order("created_at desc"), "dispenser_id = ? AND account_id = ?", dispenser.ID, uuid.FromStringOrNil(accountID.(string))); err != nil {
+		util.ErrorResponder(w, http.StatusInternalServerError, err)
+		return
+	}
+	for i := range commands {
+		expireDeviceCommand(db, util.RequestID(r), &commands[i])
+	}
+	util.PaginationResponder(w, r, commands)
+}
+
+//
+// GetAccountCommandByID is the GET method for one of
+// the commands sent to the account's dispensers
+//
+func GetAccountCommandByID(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	accountID, ok := context.GetOk(r, "account_id")
+	if !ok {
+		util.ErrorResponder(w, http.StatusInternalServerError, errors.New("Cannot process token claims"))
+		return
+	}
+	db := requestDB(r)
+	command := models.DeviceCommand{}
+	if err := command.GetOneByQuery(db, "id = ? AND account_id = ?", uuid.FromStringOrNil(mux.Vars(r)["command_id"]), uuid.FromStringOrNil(accountID.(string))); err != nil {
+		if err.Error() == "record not found" {
+			util.ErrorResponder(w, http.StatusNotFound, errors.New("command cannot be found"))
+			return
+		}
+		util.ErrorResponder(w, http.StatusInternalServerError, err)
+		return
+	}
+	expireDeviceCommand(db, util.RequestID(r), &command)
+	util.JSONResponder(w, command)
+}
+
+//
+// DeviceCommandStatus handles the lambda message of a
+// dispenser acknowledging, completing or failing a command
+//
+func DeviceCommandStatus(w http.ResponseWriter, r *http.Request) {
+	message := commandStatusMessage{}
+	if err := json.NewDecoder(r.Body).Decode(&message); err != nil {
+		util.ErrorResponder(w, http.StatusBadRequest, err)
+		return
+	}
+	db := requestDB(r)
+	status := message.Payload.Command
+	command := models.DeviceCommand{}
+	if err := command.GetOneByQuery(db, "id = ?", status.ID); err != nil {
+		if err.Error() == "record not found" {
+			util.ErrorResponder(w, http.StatusNotFound, errors.New("command cannot be found"))
+			return
+		}
+		util.ErrorResponder(w, http.StatusInternalServerError, err)
+		return
+	}
+	now := time.Now()
+	var changed bool
+	switch status.Status {
+	case models.CommandAcknowledged, models.CommandCompleted:
+		changed = command.Advance(status.Status, now)
+	case models.CommandFailed:
+		changed = command.Fail(status.Error, now)
+	default:
+		util.ErrorResponder(w, http.StatusBadRequest, errors.New("status must be acknowledged, completed or failed"))
+		return
+	}
+	if changed {
+		if err := command.Update(db); err != nil {
+			util.ErrorResponder(w, http.StatusInternalServerError, err)
+			return
+		}
+	}
+	util.JSONResponder(w, command)
+}
+
+//
+// sendDeviceCommand queues the command and sends it to the
+// dispenser. A command that could not be sent is returned
+// failed along with the error
+//
+func sendDeviceCommand(db *gorm.DB, requestID string, dispenser types.Dispenser, accountID uuid.UUID, userID *uuid.UUID, kind, source string) (models.DeviceCommand, error) {
+	command := models.DeviceCommand{
+		DispenserID: dispenser.ID,
+		AccountID:   accountID,
+		UserID:      userID,
+		Kind:        kind,
+		Status:      models.CommandQueued,
+		Source:      source,
+	}
+	if err := command.Create(db); err != nil {
+		return models.DeviceCommand{}, err
+	}
+	payload := commandPayload{
+		Payload: types.Payload{
+			Customer: types.PayloadCustomer{
+				ID: accountID.String(),
+			},
+			Dispenser: types.PayloadDispenser{
+				Serial: dispenser.Serial,
+				Name:   dispenser.Name,
+			},
+		},
+	}
+	payload.Command.ID = command.ID
+	payload.Command.Kind = kind
+	_, err := util.InvokeLambda(tracing.Context(db), requestID, commandLambdas[kind], payload)
+	if err != nil {
+		util.CaptureException(requestID, err)
+		command.Fail(err.Error(), time.Now())
+	} else {
+		command.Advance(models.CommandSent, time.Now())
+	}
+	if updateErr := command.Update(db); updateErr != nil {
+		util.CaptureException(requestID, updateErr)
+	}
+	return command, err
+}
+
+//
+// completeDispenseCommand marks the dispense command that caused
+// the usage completed. It is the command the dispenser reported,
+// or else the oldest open dispense sent to the dispenser
+//
+func completeDispenseCommand(db *gorm.DB, dispenserID uuid.UUID, commandID uuid.UUID, usage types.Usage) error {
+	command := models.DeviceCommand{}
+	var err error
+	if commandID != uuid.Nil {
+		err = command.GetOneByQuery(db, "id = ? AND dispenser_id = ?", commandID, dispenserID)
+	} else {
+		since := time.Now().Add(-deviceCommandTimeout())
+		err = command.GetOneByQuery(db.Order("created_at asc"), "dispenser_id = ? AND kind = ? AND status IN (?) AND created_at >= ?",
+			dispenserID, models.CommandDispense, []string{models.CommandSent, models.CommandAcknowledged}, since)
+	}
+	if err != nil {
+		if err.Error() == "record not found" {
+			return nil
+		}
+		return err
+	}
+	if !command.Advance(models.CommandCompleted, time.Now()) {
+		return nil
+	}
+	command.UsageID = &usage.ID
+	return command.Update(db)
+}
+
+//
+// expireDeviceCommand fails a command the dispenser has not
+// finished within DEVICE_COMMAND_TIMEOUT of it being sent
+//
+func expireDeviceCommand(db *gorm.DB, requestID string, command *models.DeviceCommand) {
+	if !command.Open() || time.Since(command.CreatedAt) < deviceCommandTimeout() {
+		return
+	}
+	if command.Fail("Dispenser did not finish the command in time", time.Now()) {
+		if err := command.Update(db); err != nil {
+			util.CaptureException(requestID, err)
+		}
+	}
+}
+
+func deviceCommandTimeout() time.Duration {
+	timeout, err := time.ParseDuration(os.Getenv("DEVICE_COMMAND_TIMEOUT"))
+	if err != nil || timeout <= 0 {
+		return 5 * time.Minute
+	}
+	return timeout
+}
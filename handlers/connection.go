@@ -11,7 +11,6 @@ import (
 	"github.com/gorilla/context"
 	"github.com/gorilla/mux"
 	uuid "github.com/satori/go.uuid"
-	"github.com/tespo/buddha/db"
 	"github.com/tespo/buddha/util"
 	"github.com/tespo/satya/v2/scoping"
 	"github.com/tespo/satya/v2/types"
@@ -23,13 +22,7 @@ import (
 func GetConnections(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	db, err := db.Open()
-	if err != nil {
-		panic(err)
-	}
-	defer db.Close()
-
-	db = util.SetDBPagination(db, r)
+	db := util.SetDBPagination(requestDB(r), r)
 
 	var connections types.Connections
 	if err := connections.Get(db); err != nil {
@@ -52,11 +45,7 @@ func GetConnectionsByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	db, err := db.Open()
-	if err != nil {
-		panic(err)
-	}
-	defer db.Close()
+	db := requestDB(r)
 
 	var connection types.Connection
 	if err := connection.GetByID(db, uuid.FromStringOrNil(id)); err != nil {
@@ -64,7 +53,7 @@ func GetConnectionsByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err = json.NewEncoder(w).Encode(connection); err != nil {
+	if err := json.NewEncoder(w).Encode(connection); err != nil {
 		panic(err)
 	}
 }
@@ -83,18 +72,14 @@ func PostConnections(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	db, err := db.Open()
-	if err != nil {
-		panic(err)
-	}
-	defer db.Close()
+	db := requestDB(r)
 
 	if err := connection.Create(db); err != nil {
 		util.ErrorResponder(w, http.StatusInternalServerError, err)
 		return
 	}
 
-	if err = json.NewEncoder(w).Encode(connection); err != nil {
+	if err := json.NewEncoder(w).Encode(connection); err != nil {
 		panic(err)
 	}
 }
@@ -123,18 +108,14 @@ func PutConnectionsByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	db, err := db.Open()
-	if err != nil {
-		panic(err)
-	}
-	defer db.Close()
+	db := requestDB(r)
 
 	if err := connection.Update(db); err != nil {
 		util.ErrorResponder(w, http.StatusInternalServerError, err)
 		return
 	}
 
-	if err = json.NewEncoder(w).Encode(connection); err != nil {
+	if err := json.NewEncoder(w).Encode(connection); err != nil {
 		panic(err)
 	}
 }
@@ -151,11 +132,7 @@ func DeleteConnectionsByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	db, err := db.Open()
-	if err != nil {
-		panic(err)
-	}
-	defer db.Close()
+	db := requestDB(r)
 
 	var connection types.Connection
 
@@ -171,7 +148,7 @@ func DeleteConnectionsByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err = json.NewEncoder(w).Encode(connection); err != nil {
+	if err := json.NewEncoder(w).Encode(connection); err != nil {
 		panic(err)
 	}
 }
@@ -185,11 +162,7 @@ func GetAccountConnections(w http.ResponseWriter, r *http.Request) {
 		util.ErrorResponder(w, http.StatusInternalServerError, errors.New("Cannot process token claims"))
 		return
 	}
-	db, err := db.Open()
-	if err != nil {
-		panic(err)
-	}
-	defer db.Close()
+	db := requestDB(r)
 	account := types.Account{
 		ID: uuid.FromStringOrNil(accountID.(string)),
 	}
@@ -221,11 +194,7 @@ func GetAccountConnectionByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	db, err := db.Open()
-	if err != nil {
-		panic(err)
-	}
-	defer db.Close()
+	db := requestDB(r)
 	account := types.Account{
 		ID: uuid.FromStringOrNil(accountID.(string)),
 	}
@@ -270,11 +239,7 @@ func PutAccountConnectionsByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	db, err := db.Open()
-	if err != nil {
-		panic(err)
-	}
-	defer db.Close()
+	db := requestDB(r)
 	account := types.Account{
 		ID: uuid.FromStringOrNil(accountID.(string)),
 	}
@@ -301,11 +266,7 @@ func DeleteAccountConnectionsByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	db, err := db.Open()
-	if err != nil {
-		panic(err)
-	}
-	defer db.Close()
+	db := requestDB(r)
 	account := types.Account{
 		ID: uuid.FromStringOrNil(accountID.(string)),
 	}
@@ -6,11 +6,12 @@ import (
 	"encoding/json"
 	"errors"
 	"net/http"
+	"strings"
 
 	"github.com/gorilla/context"
 	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
 	uuid "github.com/satori/go.uuid"
-	"github.com/tespo/buddha/db"
 	"github.com/tespo/buddha/util"
 	"github.com/tespo/satya/v2/scoping"
 	"github.com/tespo/satya/v2/types"
@@ -25,12 +26,7 @@ func GetAccountDispensers(w http.ResponseWriter, r *http.Request) {
 		util.ErrorResponder(w, http.StatusInternalServerError, errors.New("Cannot process token claims"))
 		return
 	}
-	db, err := db.Open()
-	if err != nil {
-		panic(err)
-	}
-	defer db.Close()
-	db = util.SetDBPagination(db, r)
+	db := util.SetDBPagination(requestDB(r), r)
 
 	connections := types.Connections{}
 
@@ -64,17 +60,13 @@ func GetDispenserByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	db, err := db.Open()
+	db := requestDB(r)
+	dispenser, err := accountDispenser(db, uuid.FromStringOrNil(accountID.(string)), dispenserID)
 	if err != nil {
-		panic(err)
-	}
-	defer db.Close()
-	connection := types.Connection{}
-	if err := connection.GetAccountDispenserByID(db, uuid.FromStringOrNil(accountID.(string)), uuid.FromStringOrNil(dispenserID)); err != nil {
-		util.ErrorResponder(w, http.StatusInternalServerError, err)
+		dispenserErrorResponder(w, err)
 		return
 	}
-	util.JSONResponder(w, scoping.FilterByScopes(scopedFields.([]string), connection.Dispensers[0]))
+	util.JSONResponder(w, scoping.FilterByScopes(scopedFields.([]string), dispenser))
 
 }
 
@@ -87,6 +79,11 @@ func PutDispenserByID(w http.ResponseWriter, r *http.Request) {
 		util.ErrorResponder(w, http.StatusBadRequest, errors.New("No Dispenser ID supplied"))
 		return
 	}
+	accountID, ok := context.GetOk(r, "account_id")
+	if !ok {
+		util.ErrorResponder(w, http.StatusInternalServerError, errors.New("Cannot process token claims"))
+		return
+	}
 
 	var dispenser types.Dispenser
 	decoder := json.NewDecoder(r.Body)
@@ -96,16 +93,17 @@ func PutDispenserByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if dispenser.ID.String() != dispenserID {
+	db := requestDB(r)
+	current, err := accountDispenser(db, uuid.FromStringOrNil(accountID.(string)), dispenserID)
+	if err != nil {
+		dispenserErrorResponder(w, err)
+		return
+	}
+	if dispenser.ID != current.ID {
 		util.ErrorResponder(w, http.StatusBadRequest, errors.New("Cannot update dispenser ID"))
 		return
 	}
 
-	db, err := db.Open()
-	if err != nil {
-		panic(err)
-	}
-	defer db.Close()
 	if err := dispenser.Update(db); err != nil {
 		util.ErrorResponder(w, http.StatusInternalServerError, err)
 		return
@@ -117,7 +115,10 @@ func PutDispenserByID(w http.ResponseWriter, r *http.Request) {
 }
 
 //
-// DeleteDispenser is the DELETE method for a dispenser
+// DeleteDispenser is the DELETE method for one of an account's
+// dispensers, chosen by the dispenser_id path variable or the
+// dispenser query param. Either may be an ID or a name and can
+// be left out when the account only has one dispenser
 //
 func DeleteDispenser(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
@@ -127,20 +128,17 @@ func DeleteDispenser(w http.ResponseWriter, r *http.Request) {
 		util.ErrorResponder(w, http.StatusInternalServerError, errors.New("Cannot process token claims"))
 		return
 	}
-
-	db, err := db.Open()
-	if err != nil {
-		panic(err)
+	selector := mux.Vars(r)["dispenser_id"]
+	if selector == "" {
+		selector = r.URL.Query().Get("dispenser")
 	}
-	defer db.Close()
-	connections := types.Connections{}
-	dispensers, err := connections.GetAccountDispensers(db, uuid.FromStringOrNil(accountID.(string)))
+
+	db := requestDB(r)
+	dispenser, err := accountDispenser(db, uuid.FromStringOrNil(accountID.(string)), selector)
 	if err != nil {
-		util.ErrorResponder(w, http.StatusInternalServerError, err)
+		dispenserErrorResponder(w, err)
 		return
 	}
-	var dispenser types.Dispenser
-	dispenser.GetByID(db, dispensers[0].ID)
 	if err := dispenser.Delete(db, dispenser.ID); err != nil {
 		util.ErrorResponder(w, http.StatusInternalServerError, err)
 		return
@@ -149,6 +147,71 @@ func DeleteDispenser(w http.ResponseWriter, r *http.Request) {
 	util.JSONResponder(w, map[string]string{"status": "success"})
 }
 
+var (
+	errDispenserNotFound  = errors.New("dispenser cannot be found")
+	errDispenserAmbiguous = errors.New("account has several dispensers, choose one by ID or name")
+)
+
+//
+// accountDispenser finds one of the account's dispensers by its
+// ID, name or serial. Names are matched ignoring case. An empty
+// selector picks the only dispenser of the account
+//
+func accountDispenser(db *gorm.DB, accountID uuid.UUID, selector string) (types.Dispenser, error) {
+	connections := types.Connections{}
+	dispensers, err := connections.GetAccountDispensers(db, accountID)
+	if err != nil {
+		return types.Dispenser{}, err
+	}
+	return selectDispenser(dispensers, selector)
+}
+
+func selectDispenser(dispensers types.Dispensers, selector string) (types.Dispenser, error) {
+	selector = strings.TrimSpace(selector)
+	if selector == "" {
+		switch len(dispensers) {
+		case 0:
+			return types.Dispenser{}, errDispenserNotFound
+		case 1:
+			return dispensers[0], nil
+		default:
+			return types.Dispenser{}, errDispenserAmbiguous
+		}
+	}
+	if id := uuid.FromStringOrNil(selector); id != uuid.Nil {
+		for _, dispenser := range dispensers {
+			if dispenser.ID == id {
+				return dispenser, nil
+			}
+		}
+	}
+	matches := []types.Dispenser{}
+	for _, dispenser := range dispensers {
+		if strings.EqualFold(dispenser.Name, selector) || dispenser.Serial == selector {
+			matches = append(matches, dispenser)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return types.Dispenser{}, errDispenserNotFound
+	case 1:
+		return matches[0], nil
+	default:
+		return types.Dispenser{}, errDispenserAmbiguous
+	}
+}
+
+func dispenserErrorResponder(w http.ResponseWriter, err error) {
+	switch err {
+	case errDispenserNotFound:
+		util.ErrorResponder(w, http.StatusNotFound, err)
+	case errDispenserAmbiguous:
+		util.ErrorResponder(w, http.StatusBadRequest, err)
+	default:
+		util.ErrorResponder(w, http.StatusInternalServerError, err)
+	}
+}
+
 // Dev routes
 
 //
@@ -157,11 +220,7 @@ func DeleteDispenser(w http.ResponseWriter, r *http.Request) {
 func GetDispensers(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	db, err := db.Open()
-	if err != nil {
-		panic(err)
-	}
-	defer db.Close()
+	db := requestDB(r)
 
 	var dispensers types.Dispensers
 	if err := dispensers.Get(db); err != nil {
@@ -184,11 +243,7 @@ func GetDispensersByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	db, err := db.Open()
-	if err != nil {
-		panic(err)
-	}
-	defer db.Close()
+	db := requestDB(r)
 
 	var dispenser types.Dispenser
 	if err := dispenser.GetByID(db, uuid.FromStringOrNil(id)); err != nil {
@@ -196,7 +251,7 @@ func GetDispensersByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err = json.NewEncoder(w).Encode(dispenser); err != nil {
+	if err := json.NewEncoder(w).Encode(dispenser); err != nil {
 		panic(err)
 	}
 }
@@ -215,18 +270,14 @@ func PostDispensers(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	db, err := db.Open()
-	if err != nil {
-		panic(err)
-	}
-	defer db.Close()
+	db := requestDB(r)
 
 	if err := dispenser.Create(db); err != nil {
 		util.ErrorResponder(w, http.StatusInternalServerError, err)
 		return
 	}
 
-	if err = json.NewEncoder(w).Encode(dispenser); err != nil {
+	if err := json.NewEncoder(w).Encode(dispenser); err != nil {
 		panic(err)
 	}
 }
@@ -255,18 +306,14 @@ func PutDispensersByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	db, err := db.Open()
-	if err != nil {
-		panic(err)
-	}
-	defer db.Close()
+	db := requestDB(r)
 
 	if err := dispenser.Update(db); err != nil {
 		util.ErrorResponder(w, http.StatusInternalServerError, err)
 		return
 	}
 
-	if err = json.NewEncoder(w).Encode(dispenser); err != nil {
+	if err := json.NewEncoder(w).Encode(dispenser); err != nil {
 		panic(err)
 	}
 }
@@ -283,11 +330,7 @@ func DeleteDispensersByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	db, err := db.Open()
-	if err != nil {
-		panic(err)
-	}
-	defer db.Close()
+	db := requestDB(r)
 
 	var dispenser types.Dispenser
 	if err := dispenser.Delete(db, uuid.FromStringOrNil(id)); err != nil {
@@ -295,7 +338,7 @@ func DeleteDispensersByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err = json.NewEncoder(w).Encode(dispenser); err != nil {
+	if err := json.NewEncoder(w).Encode(dispenser); err != nil {
 		panic(err)
 	}
 }
@@ -0,0 +1,355 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/context"
+	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
+	uuid "github.com/satori/go.uuid"
+	"github.com/tespo/buddha/firmware"
+	"github.com/tespo/buddha/models"
+	"github.com/tespo/buddha/util"
+	"github.com/tespo/satya/v2/types"
+)
+
+//
+// recordDispenserFirmware records the firmware versions a
+// dispenser reported and sets them in its meta. Callers run it in
+// the transaction of their own writes, so the history and the
+// meta are saved together or not at all
+//
+func recordDispenserFirmware(tx *gorm.DB, dispenser *types.Dispenser, reported types.PayloadDispenser) error {
+	versions := firmware.FromPayload(reported)
+	if err := firmware.Record(tx, dispenser.ID, versions, time.Now()); err != nil {
+		return err
+	}
+	meta, err := firmware.Meta(dispenser.Meta, versions)
+	if err != nil {
+		return err
+	}
+	dispenser.Meta = meta
+	return dispenser.Update(tx)
+}
+
+//
+// DispenserFirmware handles the lambda message of a dispenser
+// reporting its firmware and asking which updates to install
+//
+func DispenserFirmware(w http.ResponseWriter, r *http.Request) {
+	lambdaMessage := types.LambdaMessage{}
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&lambdaMessage); err != nil {
+		util.ErrorResponder(w, http.StatusInternalServerError, err)
+		return
+	}
+	db := requestDB(r)
+	dispenser := types.Dispenser{}
+	if err := dispenser.GetOneByQuery(db, "serial = ?", lambdaMessage.Payload.Dispenser.Serial); err != nil {
+		if err.Error() == "record not found" {
+			util.ErrorResponder(w, http.StatusNotFound, errors.New("dispenser cannot be found"))
+			return
+		}
+		util.ErrorResponder(w, http.StatusInternalServerError, err)
+		return
+	}
+	err := transaction(db, func(tx *gorm.DB) error {
+		return recordDispenserFirmware(tx, &dispenser, lambdaMessage.Payload.Dispenser)
+	})
+	if err != nil {
+		util.ErrorResponder(w, http.StatusInternalServerError, err)
+		return
+	}
+	current, err := firmware.Current(db, dispenser.ID)
+	if err != nil {
+		util.ErrorResponder(w, http.StatusInternalServerError, err)
+		return
+	}
+	cohorts, err := firmware.Cohorts(db, dispenser.ID)
+	if err != nil {
+		util.ErrorResponder(w, http.StatusInternalServerError, err)
+		return
+	}
+	rollouts := models.FirmwareRollouts{}
+	if err := rollouts.GetByQuery(db.Order("created_at desc"), "active = ?", true); err != nil {
+		util.ErrorResponder(w, http.StatusInternalServerError, err)
+		return
+	}
+	util.JSONResponder(w, map[string]interface{}{
+		"versions": current,
+		"updates":  firmware.Updates(rollouts, dispenser.Serial, cohorts, current),
+	})
+}
+
+//
+// GetDispenserFirmware is the GET method for the firmware
+// history of one of the account's dispensers, newest first
+//
+func GetDispenserFirmware(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	accountID, ok := context.GetOk(r, "account_id")
+	if !ok {
+		util.ErrorResponder(w, http.StatusInternalServerError, errors.New("Cannot process token claims"))
+		return
+	}
+	db := requestDB(r)
+	dispenser, err := accountDispenser(db, uuid.FromStringOrNil(accountID.(string)), mux.Vars(r)["dispenser_id"])
+	if err != nil {
+		dispenserErrorResponder(w, err)
+		return
+	}
+	query := util.SetDBPagination(db, r).Order("first_seen_at desc")
+	if part := r.URL.Query().Get("part"); part != "" {
+		query = query.Where("part = ?", part)
+	}
+	versions := models.FirmwareVersions{}
+	if err := versions.GetByQuery(query, "dispenser_id = ?", dispenser.ID); err != nil {
+		util.ErrorResponder(w, http.StatusInternalServerError, err)
+		return
+	}
+	util.PaginationResponder(w, r, versions)
+}
+
+// Dev routes
+
+//
+// GetFirmwareFleet is the GET method for how the firmware
+// versions of each part are spread across the fleet
+//
+func GetFirmwareFleet(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	fleet, err := firmware.Fleet(requestDB(r))
+	if err != nil {
+		util.ErrorResponder(w, http.StatusInternalServerError, err)
+		return
+	}
+	util.JSONResponder(w, fleet)
+}
+
+//
+// GetFirmwareRollouts is the GET method for the firmware rollouts
+//
+func GetFirmwareRollouts(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	db := requestDB(r)
+	rollouts := models.FirmwareRollouts{}
+	if err := util.SetDBPagination(db, r).Order("created_at desc").Find(&rollouts).Error; err != nil {
+		util.ErrorResponder(w, http.StatusInternalServerError, err)
+		return
+	}
+	util.PaginationResponder(w, r, rollouts)
+}
+
+//
+// PostFirmwareRollout is the POST method for a firmware rollout
+//
+func PostFirmwareRollout(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	rollout := models.FirmwareRollout{Active: true}
+	if err := json.NewDecoder(r.Body).Decode(&rollout); err != nil {
+		util.ErrorResponder(w, http.StatusBadRequest, err)
+		return
+	}
+	rollout.ID = uuid.Nil
+	if rollout.Cohort == "" {
+		rollout.Cohort = "default"
+	}
+	if err := validateRollout(rollout); err != nil {
+		util.ErrorResponder(w, http.StatusBadRequest, err)
+		return
+	}
+	db := requestDB(r)
+	if err := rollout.Create(db); err != nil {
+		util.ErrorResponder(w, http.StatusInternalServerError, err)
+		return
+	}
+	util.JSONResponder(w, rollout)
+}
+
+//
+// PutFirmwareRolloutByID is the PUT method for a firmware rollout,
+// used to raise its percentage or pause it
+//
+func PutFirmwareRolloutByID(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	db := requestDB(r)
+	rollout, err := findRollout(db, mux.Vars(r)["rollout_id"])
+	if err != nil {
+		rolloutErrorResponder(w, err)
+		return
+	}
+	id := rollout.ID
+	if err := json.NewDecoder(r.Body).Decode(&rollout); err != nil {
+		util.ErrorResponder(w, http.StatusBadRequest, err)
+		return
+	}
+	if rollout.ID != id {
+		util.ErrorResponder(w, http.StatusBadRequest, errors.New("Cannot update rollout ID"))
+		return
+	}
+	if rollout.Cohort == "" {
+		rollout.Cohort = "default"
+	}
+	if err := validateRollout(rollout); err != nil {
+		util.ErrorResponder(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := rollout.Update(db); err != nil {
+		util.ErrorResponder(w, http.StatusInternalServerError, err)
+		return
+	}
+	util.JSONResponder(w, rollout)
+}
+
+//
+// DeleteFirmwareRolloutByID is the DELETE method for a firmware rollout
+//
+func DeleteFirmwareRolloutByID(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	db := requestDB(r)
+	rollout, err := findRollout(db, mux.Vars(r)["rollout_id"])
+	if err != nil {
+		rolloutErrorResponder(w, err)
+		return
+	}
+	if err := rollout.Delete(db); err != nil {
+		util.ErrorResponder(w, http.StatusInternalServerError, err)
+		return
+	}
+	util.JSONResponder(w, map[string]string{"status": "success"})
+}
+
+//
+// GetFirmwareCohortDispensers is the GET method for the
+// dispensers added to a cohort
+//
+func GetFirmwareCohortDispensers(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	db := requestDB(r)
+	members := models.FirmwareCohortMembers{}
+	if err := members.GetByQuery(util.SetDBPagination(db, r).Order("created_at desc"), "cohort = ?", mux.Vars(r)["cohort"]); err != nil {
+		util.ErrorResponder(w, http.StatusInternalServerError, err)
+		return
+	}
+	util.PaginationResponder(w, r, members)
+}
+
+//
+// PutFirmwareCohortDispenser is the PUT method that adds the
+// dispenser with the serial to a cohort. Adding it again is a no-op
+//
+func PutFirmwareCohortDispenser(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	vars := mux.Vars(r)
+	if vars["cohort"] == firmware.DefaultCohort {
+		util.ErrorResponder(w, http.StatusBadRequest, errors.New("every dispenser is in the default cohort"))
+		return
+	}
+	db := requestDB(r)
+	dispenser, err := cohortDispenser(db, vars["serial"])
+	if err != nil {
+		rolloutErrorResponder(w, err)
+		return
+	}
+	member := models.FirmwareCohortMember{}
+	err = member.GetOneByQuery(db, "cohort = ? AND dispenser_id = ?", vars["cohort"], dispenser.ID)
+	if err == nil {
+		util.JSONResponder(w, member)
+		return
+	}
+	if err.Error() != "record not found" {
+		util.ErrorResponder(w, http.StatusInternalServerError, err)
+		return
+	}
+	member = models.FirmwareCohortMember{Cohort: vars["cohort"], DispenserID: dispenser.ID}
+	if err := member.Create(db); err != nil {
+		util.ErrorResponder(w, http.StatusInternalServerError, err)
+		return
+	}
+	util.JSONResponder(w, member)
+}
+
+//
+// DeleteFirmwareCohortDispenser is the DELETE method that takes
+// the dispenser with the serial out of a cohort
+//
+func DeleteFirmwareCohortDispenser(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	vars := mux.Vars(r)
+	db := requestDB(r)
+	dispenser, err := cohortDispenser(db, vars["serial"])
+	if err != nil {
+		rolloutErrorResponder(w, err)
+		return
+	}
+	member := models.FirmwareCohortMember{}
+	if err := member.GetOneByQuery(db, "cohort = ? AND dispenser_id = ?", vars["cohort"], dispenser.ID); err != nil {
+		if err.Error() == "record not found" {
+			util.ErrorResponder(w, http.StatusNotFound, errors.New("dispenser is not in the cohort"))
+			return
+		}
+		util.ErrorResponder(w, http.StatusInternalServerError, err)
+		return
+	}
+	if err := member.Delete(db); err != nil {
+		util.ErrorResponder(w, http.StatusInternalServerError, err)
+		return
+	}
+	util.JSONResponder(w, map[string]string{"status": "success"})
+}
+
+var errCohortDispenserNotFound = errors.New("dispenser cannot be found")
+
+func cohortDispenser(db *gorm.DB, serial string) (types.Dispenser, error) {
+	dispenser := types.Dispenser{}
+	if err := dispenser.GetOneByQuery(db, "serial = ?", serial); err != nil {
+		if err.Error() == "record not found" {
+			return dispenser, errCohortDispenserNotFound
+		}
+		return dispenser, err
+	}
+	return dispenser, nil
+}
+
+var errRolloutNotFound = errors.New("rollout cannot be found")
+
+func findRollout(db *gorm.DB, id string) (models.FirmwareRollout, error) {
+	rollout := models.FirmwareRollout{}
+	if err := rollout.GetOneByQuery(db, "id = ?", uuid.FromStringOrNil(id)); err != nil {
+		if err.Error() == "record not found" {
+			return rollout, errRolloutNotFound
+		}
+		return rollout, err
+	}
+	return rollout, nil
+}
+
+func rolloutErrorResponder(w http.ResponseWriter, err error) {
+	if err == errRolloutNotFound || err == errCohortDispenserNotFound {
+		util.ErrorResponder(w, http.StatusNotFound, err)
+		return
+	}
+	util.ErrorResponder(w, http.StatusInternalServerError, err)
+}
+
+func validateRollout(rollout models.FirmwareRollout) error {
+	known := false
+	for _, part := range firmware.Parts {
+		if rollout.Part == part {
+			known = true
+		}
+	}
+	if !known {
+		return errors.New("part must be pcb, wifi or controller")
+	}
+	if rollout.TargetVersion == "" {
+		return errors.New("target_version is required")
+	}
+	if rollout.Percentage > 100 {
+		return errors.New("percentage must be between 0 and 100")
+	}
+	return nil
+}
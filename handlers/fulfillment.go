@@ -3,228 +3,349 @@ package handlers
 import (
 	"encoding/json"
 	"errors"
-	"fmt"
+	"io/ioutil"
 	"net/http"
 	"time"
 
-	uuid "github.com/satori/go.uuid"
-
 	"github.com/gorilla/context"
-	"github.com/tespo/buddha/db"
+	"github.com/jinzhu/gorm"
+	uuid "github.com/satori/go.uuid"
+	"github.com/tespo/buddha/firmware"
+	"github.com/tespo/buddha/models"
+	"github.com/tespo/buddha/tracing"
 	"github.com/tespo/buddha/util"
+	"github.com/tespo/buddha/voice"
 	"github.com/tespo/satya/v2/types"
 )
 
 //
-// GoogleFulfillment handles the voice commands webhook calls
-//
-func GoogleFulfillment(w http.ResponseWriter, r *http.Request) {
-	request := types.GoogleHomeRequest{}
-	decoder := json.NewDecoder(r.Body)
-	if err := decoder.Decode(&request); err != nil {
-		util.ErrorResponder(w, http.StatusBadRequest, err)
-		return
-	}
-	response := types.GoogleHomeResponse{}
-	switch request.Inputs[0].Intent {
-	case "action.devices.EXECUTE":
-		go googleDispensePod(r, request)
-	case "Dispense":
-		// err = dispensePod(r, request)
-	case "action.devices.SYNC":
-		response.Payload = types.ResponsePayload{
-			AgentUserID: "test",
-			Devices: []types.GoogleHomeDevice{
-				{
-					ID:     "tespo dispenser",
-					Type:   "action.devices.types.MICROWAVE",
-					Traits: []string{"action.devices.traits.StartStop"},
-					Name: types.GoogleHomeDeviceName{
-						DefaultNames: []string{"Tespo Connect Dispenser", "Vitamin Dispenser"},
-						Name:         "Dispenser",
-						Nicknames:    []string{"Kitchen Dispenser"},
-					},
-					WillReportState: false,
-					Attributes: types.GoogleHomeDeviceAttributes{
-						Pausable: false,
-						AvailableZones: []string{
-							"Kitchen",
-							"Bathroom",
-						},
-					},
-				},
-			},
-		}
-	}
-	response.RequestID = request.RequestID
-	util.JSONResponder(w, response)
-}
+// voiceIntentHandlers answers the intents of every voice assistant
+//
+var voiceIntentHandlers = voice.NewRegistry(map[string]voice.Handler{
+	voice.IntentDiscover:          discoverVoiceDevices,
+	voice.IntentQuery:             queryVoiceDevices,
+	voice.IntentExecute:           executeVoiceCommands,
+	voice.IntentDisconnect:        disconnectVoiceUser,
+	voice.IntentServingsRemaining: voiceQuestion(servingsRemainingIntent),
+	voice.IntentTakenToday:        voiceQuestion(takenTodayIntent),
+	voice.IntentNextReminder:      voiceQuestion(nextReminderIntent),
+})
 
-func googleDispensePod(r *http.Request, request types.GoogleHomeRequest) error {
-	id, ok := context.GetOk(r, "user_id")
-	if !ok {
-		return errors.New("Cannot process token claims")
+//
+// VoiceFulfillment handles the voice commands webhook calls of the
+// provider. Its codec turns the request into a common intent and
+// the answer back into the provider's response
+//
+func VoiceFulfillment(provider string) http.HandlerFunc {
+	codec := voice.Codecs[provider]
+	return func(w http.ResponseWriter, r *http.Request) {
+		data, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			util.ErrorResponder(w, http.StatusBadRequest, err)
+			return
+		}
+		request, err := codec.Decode(data)
+		if err != nil {
+			util.ErrorResponder(w, http.StatusBadRequest, err)
+			return
+		}
+		request.Provider = provider
+		request.RequestID = util.RequestID(r)
+		request.Context = util.RequestContext(r)
+		userID, ok := context.GetOk(r, "user_id")
+		if !ok {
+			util.ErrorResponder(w, http.StatusInternalServerError, errors.New("Cannot process token claims"))
+			return
+		}
+		user := types.User{}
+		if err := user.GetByID(requestDB(r), uuid.FromStringOrNil(userID.(string))); err != nil {
+			util.ErrorResponder(w, http.StatusNotFound, err)
+			return
+		}
+		response, err := dispatchVoiceIntent(requestDB(r), user, request)
+		if err != nil {
+			if encoded := codec.EncodeError(request, err); encoded != nil {
+				if err != voice.ErrUnknownIntent && err != voice.ErrNotLinked {
+					util.CaptureException(util.RequestID(r), err)
+				}
+				util.JSONResponder(w, encoded)
+				return
+			}
+			if err == voice.ErrUnknownIntent {
+				util.ErrorResponder(w, http.StatusBadRequest, errors.New("Unknown intent "+request.Intent))
+				return
+			}
+			if err == voice.ErrNotLinked {
+				util.ErrorResponder(w, http.StatusUnauthorized, err)
+				return
+			}
+			util.ErrorResponder(w, http.StatusInternalServerError, err)
+			return
+		}
+		util.JSONResponder(w, codec.Encode(request, response))
 	}
-	user := types.User{
-		ID: uuid.FromStringOrNil(id.(string)),
+}
+
+//
+// dispatchVoiceIntent answers the request unless the user unlinked
+// the provider. Discovery links the user again, and disconnecting
+// is always answered
+//
+func dispatchVoiceIntent(db *gorm.DB, user types.User, request voice.Request) (voice.Response, error) {
+	if request.Intent != voice.IntentDiscover && request.Intent != voice.IntentDisconnect {
+		link := models.VoiceLink{}
+		err := link.GetOneByQuery(db, "provider = ? AND user_id = ?", request.Provider, user.ID)
+		if err != nil && err.Error() != "record not found" {
+			return voice.Response{}, err
+		}
+		if err == nil && !link.Linked() {
+			return voice.Response{}, voice.ErrNotLinked
+		}
 	}
+	return voiceIntentHandlers.Dispatch(user, request)
+}
+
+//
+// discoverVoiceDevices lists the dispensers connected to the
+// user's account. The device id is the dispenser ID so commands
+// reach the right dispenser. Discovery links the user to the
+// provider
+//
+func discoverVoiceDevices(user types.User, request voice.Request) (voice.Response, error) {
+	db := voiceDB(request)
 	connections := types.Connections{}
-	db, err := db.Open()
+	dispensers, err := connections.GetAccountDispensers(db, user.AccountID)
 	if err != nil {
-		panic(err)
+		return voice.Response{}, err
 	}
-	defer db.Close()
-	if err := user.GetByID(db, user.ID); err != nil {
-		return err
+	if err := linkVoiceUser(db, request.Provider, user); err != nil {
+		return voice.Response{}, err
+	}
+	devices := make([]voice.Device, len(dispensers))
+	for i, dispenser := range dispensers {
+		name := dispenser.Name
+		if name == "" {
+			name = "Dispenser"
+		}
+		devices[i] = voice.Device{
+			ID:       dispenser.ID.String(),
+			Name:     name,
+			Serial:   dispenser.Serial,
+			Firmware: dispenserFirmwareVersion(dispenser, firmware.PartController),
+		}
 	}
+	return voice.Response{UserID: user.ID.String(), Devices: devices}, nil
+}
+
+//
+// queryVoiceDevices reports each device as online while its
+// dispenser is connected to the account, along with the servings
+// remaining in the pod last inserted into it. Every dispenser
+// is reported when no device is given
+//
+func queryVoiceDevices(user types.User, request voice.Request) (voice.Response, error) {
+	db := voiceDB(request)
+	connections := types.Connections{}
 	dispensers, err := connections.GetAccountDispensers(db, user.AccountID)
 	if err != nil {
-		return err
+		return voice.Response{}, err
 	}
-	dispenser := dispensers[0]
-	payload := types.Payload{
-		Customer: types.PayloadCustomer{
-			ID: user.AccountID.String(),
-		},
-		Dispenser: types.PayloadDispenser{
-			Serial: dispenser.Serial,
-			Name:   dispenser.Name,
-		},
-	}
-	_, err = util.TriggerLambda(payload, "DispenserDispense")
-	if err != nil {
-		return err
+	deviceIDs := request.Devices
+	if len(deviceIDs) == 0 {
+		for _, dispenser := range dispensers {
+			deviceIDs = append(deviceIDs, dispenser.ID.String())
+		}
+	}
+	response := voice.Response{}
+	for _, deviceID := range deviceIDs {
+		dispenser, err := voiceDispenser(db, request.RequestID, user.AccountID, dispensers, deviceID)
+		if err != nil {
+			response.States = append(response.States, voice.DeviceState{ID: deviceID, Err: err})
+			continue
+		}
+		servings, err := dispenserServingsRemaining(db, dispenser)
+		if err != nil {
+			return voice.Response{}, err
+		}
+		response.States = append(response.States, voice.DeviceState{
+			ID:                deviceID,
+			Online:            true,
+			ServingsRemaining: servings,
+		})
 	}
-	return nil
+	return response, nil
 }
 
 //
-// AlexaFulfillment will handle the different intent
-// requests that come in from Alexa
+// executeVoiceCommands runs each command and reports the result
+// for every device it targets. Dispensing waits for the dispenser
+// to be asked, stopping has nothing to do
 //
-func AlexaFulfillment(w http.ResponseWriter, r *http.Request) {
-	var request types.AlexaRequest
-	decoder := json.NewDecoder(r.Body)
-	if err := decoder.Decode(&request); err != nil {
-		util.ErrorResponder(w, http.StatusBadRequest, err)
-		return
+func executeVoiceCommands(user types.User, request voice.Request) (voice.Response, error) {
+	db := voiceDB(request)
+	connections := types.Connections{}
+	dispensers, err := connections.GetAccountDispensers(db, user.AccountID)
+	if err != nil {
+		return voice.Response{}, err
 	}
-	var response types.AlexaResponse
-	switch request.Directive.Header.Name {
-	case "Discover":
-		response = alexaDiscoverResponse(r, request)
-	case "TurnOn":
-		response = *alexaDispensePod(r, request)
+	response := voice.Response{}
+	for _, command := range request.Commands {
+		for _, deviceID := range command.Devices {
+			if command.Name != voice.CommandDispense && command.Name != voice.CommandStop {
+				response.States = append(response.States, voice.DeviceState{ID: deviceID, Err: voice.ErrNotSupported})
+				continue
+			}
+			dispenser, err := voiceDispenser(db, request.RequestID, user.AccountID, dispensers, deviceID)
+			if err != nil {
+				response.States = append(response.States, voice.DeviceState{ID: deviceID, Err: err})
+				continue
+			}
+			dispense := command.Name == voice.CommandDispense
+			if dispense {
+				userID := user.ID
+				if _, err := sendDeviceCommand(db, request.RequestID, dispenser, user.AccountID, &userID, models.CommandDispense, request.Provider); err != nil {
+					response.States = append(response.States, voice.DeviceState{ID: deviceID, Online: true, Err: voice.ErrTransient})
+					continue
+				}
+			}
+			response.States = append(response.States, voice.DeviceState{ID: deviceID, Online: true, On: dispense})
+		}
 	}
-	util.JSONResponder(w, &response)
+	return response, nil
+}
+
+//
+// voiceDB is the connection pool with the span of the
+// voice request set as the parent of its queries
+//
+func voiceDB(request voice.Request) *gorm.DB {
+	return tracing.WithContext(database, request.Context)
 }
 
-func alexaDiscoverResponse(r *http.Request, request types.AlexaRequest) types.AlexaResponse {
-	response := types.AlexaResponse{}
-	response.Event = types.AlexaDiscoverResponseEvent{
-		Header: types.AlexaHeader{
-			MessageID:      request.Directive.Header.MessageID,
-			Namespace:      "Alexa.Discovery",
-			Name:           "Discover.Response",
-			PayloadVersion: "3",
-		},
-		Payload: types.AlexaDiscoverResponseEventPayload{
-			Endpoints: []types.AlexaDiscoverResponseEventPayloadEndpoint{
-				{
-					EndpointID:        "dispense321",
-					FriendlyName:      "Dispenser",
-					Description:       "Tespo Connect Dispenser",
-					ManufacturerName:  "Tespo",
-					DisplayCategories: []string{"OTHER"},
-					Cookie:            map[string]interface{}{},
-					Capabilities: []types.AlexaEndpointCapabilities{
-						{
-							Type:      "AlexaInterface",
-							Interface: "Alexa.PowerController",
-							Version:   "3",
-							Properties: types.AlexaCapabilityProperites{
-								ProactivelyReported: true,
-								Retrievable:         true,
-								Supported: []map[string]string{
-									{
-										"name": "powerState",
-									},
-								},
-							},
-						},
-					},
-					AdditionalAttributes: types.AlexaAdditionalAttributes{
-						Manufacturer:     "Tepspo",
-						Model:            "Model 2",
-						SerialNumber:     "123",
-						FirmwareVersion:  "1",
-						SoftwareVersion:  "1",
-						CustomIdentifier: "1",
-					},
-				},
-			},
-		},
-	}
-	data, _ := json.Marshal(response)
-	fmt.Printf("%s", data)
-	return response
+//
+// disconnectVoiceUser unlinks the user from the provider
+//
+func disconnectVoiceUser(user types.User, request voice.Request) (voice.Response, error) {
+	return voice.Response{}, unlinkVoiceUser(voiceDB(request), request.Provider, user)
 }
 
-func alexaDispensePod(r *http.Request, request types.AlexaRequest) *types.AlexaResponse {
-	id, ok := context.GetOk(r, "user_id")
-	if !ok {
-		return nil
+//
+// legacyVoiceDeviceIDs are the fixed device ids voice assistants
+// were given before dispensers were listed individually. They
+// address the account's only dispenser
+//
+var legacyVoiceDeviceIDs = map[string]bool{
+	"tespo dispenser": true,
+	"dispense321":     true,
+}
+
+//
+// voiceDispenser picks the dispenser a voice assistant addressed by
+// its device id out of the account's connected dispensers. A
+// dispenser the account was connected to before is offline
+//
+func voiceDispenser(db *gorm.DB, requestID string, accountID uuid.UUID, dispensers types.Dispensers, deviceID string) (types.Dispenser, error) {
+	if legacyVoiceDeviceIDs[deviceID] {
+		deviceID = ""
 	}
-	user := types.User{
-		ID: uuid.FromStringOrNil(id.(string)),
+	dispenser, err := selectDispenser(dispensers, deviceID)
+	switch err {
+	case nil:
+		return dispenser, nil
+	case errDispenserAmbiguous:
+		return dispenser, voice.ErrDeviceNotFound
 	}
-	connections := types.Connections{}
-	db, err := db.Open()
-	if err != nil {
-		panic(err)
+	id := uuid.FromStringOrNil(deviceID)
+	if id == uuid.Nil {
+		return dispenser, voice.ErrDeviceNotFound
+	}
+	connection := types.Connection{}
+	if err := connection.GetOneByQuery(db.Unscoped(), "dispenser_id = ? AND account_id = ?", id, accountID); err != nil {
+		if err.Error() != "record not found" {
+			util.CaptureException(requestID, err)
+			return dispenser, voice.ErrTransient
+		}
+		return dispenser, voice.ErrDeviceNotFound
+	}
+	return dispenser, voice.ErrDeviceOffline
+}
+
+//
+// dispenserServingsRemaining is the servings last reported for the
+// regimen of the pod most recently inserted into the dispenser,
+// or nil when no pod was inserted
+//
+func dispenserServingsRemaining(db *gorm.DB, dispenser types.Dispenser) (*uint, error) {
+	insertion := types.Insertion{}
+	//GORM apparently inverts ordering... thus asc == desc and desc == asc
+	if err := insertion.GetByQuery(db.Order("created_at asc"), "dispenser_id = ?", dispenser.ID); err != nil {
+		if err.Error() == "record not found" {
+			return nil, nil
+		}
+		return nil, err
 	}
-	defer db.Close()
-	if err := user.GetByID(db, user.ID); err != nil {
+	regimen := types.Regimen{}
+	if err := regimen.GetByID(db, insertion.RegimenID); err != nil {
+		if err.Error() == "record not found" {
+			return nil, nil
+		}
+		return nil, err
+	}
+	servings := regimen.LastReportedServingsRemaining
+	return &servings, nil
+}
+
+//
+// linkVoiceUser records the user as linked to the provider
+//
+func linkVoiceUser(db *gorm.DB, provider string, user types.User) error {
+	link := models.VoiceLink{}
+	err := link.GetOneByQuery(db, "provider = ? AND user_id = ?", provider, user.ID)
+	if err != nil && err.Error() != "record not found" {
+		return err
+	}
+	if link.Linked() {
 		return nil
 	}
-	dispensers, err := connections.GetAccountDispensers(db, user.AccountID)
+	link.Provider = provider
+	link.UserID = user.ID
+	link.AccountID = user.AccountID
+	link.LinkedAt = time.Now()
+	link.UnlinkedAt = nil
 	if err != nil {
+		return link.Create(db)
+	}
+	return link.Update(db)
+}
+
+//
+// unlinkVoiceUser records the user as no longer linked
+// to the provider
+//
+func unlinkVoiceUser(db *gorm.DB, provider string, user types.User) error {
+	link := models.VoiceLink{}
+	if err := link.GetOneByQuery(db, "provider = ? AND user_id = ?", provider, user.ID); err != nil {
+		if err.Error() == "record not found" {
+			return nil
+		}
+		return err
+	}
+	if !link.Linked() {
 		return nil
 	}
-	dispenser := dispensers[0]
-	response := types.AlexaResponse{
-		Context: types.AlexaContext{
-			Properties: []types.AlexaHeader{
-				{
-					Namespace:    "Alexa.ToggleController",
-					Name:         "toggleState",
-					Value:        "ON",
-					TimeOfSample: time.Now(),
-				},
-			},
-		},
-		Event: types.AlexaDiscoverResponseEvent{
-			Header: types.AlexaHeader{
-				Namespace:      "Alexa",
-				Name:           "Response",
-				PayloadVersion: "3",
-				MessageID:      request.Directive.Header.MessageID,
-			},
-			Payload: types.AlexaDiscoverResponseEventPayload{},
-		},
-	}
-	payload := types.Payload{
-		Customer: types.PayloadCustomer{
-			ID: user.AccountID.String(),
-		},
-		Dispenser: types.PayloadDispenser{
-			Serial: dispenser.Serial,
-			Name:   dispenser.Name,
-		},
-	}
-	_, err = util.TriggerLambda(payload, "DispenserDispense")
-	if err != nil {
-		panic(err)
+	now := time.Now()
+	link.UnlinkedAt = &now
+	return link.Update(db)
+}
+
+//
+// dispenserFirmwareVersion reads the version of a part
+// of the dispenser from its meta
+//
+func dispenserFirmwareVersion(dispenser types.Dispenser, part string) string {
+	meta := map[string]map[string]string{}
+	if len(dispenser.Meta) == 0 || json.Unmarshal(dispenser.Meta, &meta) != nil {
+		return ""
 	}
-	return &response
+	return meta[part]["version"]
 }
@@ -0,0 +1,40 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/jinzhu/gorm"
+	"github.com/tespo/buddha/db"
+	"github.com/tespo/buddha/tracing"
+	"github.com/tespo/buddha/util"
+)
+
+//
+// database is the shared connection pool
+// every handler queries through
+//
+var database *gorm.DB
+
+//
+// SetDB injects the connection pool built
+// on startup into the handlers
+//
+func SetDB(db *gorm.DB) {
+	database = db
+}
+
+//
+// requestDB is the connection pool with the request's
+// span set as the parent of the spans of its queries
+//
+func requestDB(r *http.Request) *gorm.DB {
+	return tracing.WithContext(database, util.RequestContext(r))
+}
+
+//
+// transaction runs the writes of a multi-step
+// handler in a single database transaction
+//
+func transaction(conn *gorm.DB, fn func(tx *gorm.DB) error) error {
+	return db.Transaction(conn, fn)
+}
@@ -0,0 +1,30 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/tespo/buddha/health"
+	"github.com/tespo/buddha/util"
+)
+
+//
+// Liveness answers while the process can serve requests at all
+//
+func Liveness(w http.ResponseWriter, r *http.Request) {
+	util.JSONResponder(w, map[string]string{"status": health.StatusOK})
+}
+
+//
+// Readiness runs the checks of the dependencies, answering
+// 503 when a required one fails so the instance gets no traffic
+//
+func Readiness(checker *health.Checker) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report := checker.Run(r.Context())
+		w.Header().Set("Content-Type", "application/json")
+		if report.Status == health.StatusError {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		util.JSONResponder(w, report)
+	})
+}
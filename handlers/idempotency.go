@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/tespo/buddha/models"
+	"github.com/tespo/buddha/util"
+)
+
+//
+// lambdaEventTimeout is how long an event can stay in flight
+// before a re-delivery may take it over, in case the instance
+// processing it died
+//
+const lambdaEventTimeout = time.Minute
+
+//
+// lambdaEventKey holds the fields of a lambda message
+// an idempotency key can be built from
+//
+type lambdaEventKey struct {
+	MessageID string `json:"message_id"`
+	Payload   struct {
+		Sequence  *uint64 `json:"sequence"`
+		Dispenser struct {
+			Serial string `json:"serial"`
+		} `json:"dispenser"`
+	} `json:"payload"`
+}
+
+//
+// LambdaEventHandler handles a lambda message. The event is
+// nil when the message carries no idempotency key
+//
+type LambdaEventHandler func(w http.ResponseWriter, r *http.Request, event *models.LambdaEvent)
+
+//
+// IdempotentLambdaEvent makes a lambda handler safe to call again
+// with the same event. The key comes from the Idempotency-Key header,
+// the message_id of the message or the dispenser serial plus the
+// payload sequence number. A handler with writes completes the event
+// in their transaction with completeLambdaEvent, so the key is kept
+// exactly when the writes are. Otherwise the first successful response
+// is stored. The stored response is returned as is for every
+// re-delivery of the event. Events without a key are processed every time
+//
+func IdempotentLambdaEvent(route string, next LambdaEventHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		data, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			util.ErrorResponder(w, http.StatusBadRequest, err)
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(data))
+		key := idempotencyKey(r.Header, data)
+		if key == "" {
+			next(w, r, nil)
+			return
+		}
+		key = route + "|" + key
+		db := requestDB(r)
+
+		event := models.LambdaEvent{Key: key, Route: route}
+		if err := event.Create(db); err != nil {
+			existing := models.LambdaEvent{}
+			if err := existing.GetOneByQuery(db, "`key` = ?", key); err != nil {
+				util.ErrorResponder(w, http.StatusInternalServerError, err)
+				return
+			}
+			if existing.CompletedAt != nil {
+				replayLambdaEvent(w, existing)
+				return
+			}
+			if time.Since(existing.CreatedAt) < lambdaEventTimeout {
+				w.Header().Set("Retry-After", strconv.Itoa(int(lambdaEventTimeout.Seconds())))
+				util.ErrorResponder(w, http.StatusConflict, errors.New("event is already being processed"))
+				return
+			}
+			if err := existing.Delete(db); err != nil {
+				util.ErrorResponder(w, http.StatusInternalServerError, err)
+				return
+			}
+			if err := event.Create(db); err != nil {
+				util.ErrorResponder(w, http.StatusConflict, errors.New("event is already being processed"))
+				return
+			}
+		}
+
+		recorder := &responseRecorder{ResponseWriter: w, statusCode: http.StatusOK}
+		// The key is released unless the event was completed, even
+		// when the handler panics after committing its writes
+		defer event.DeleteUnfinished(db)
+		next(recorder, r, &event)
+		if event.CompletedAt != nil || recorder.statusCode < 200 || recorder.statusCode >= 300 {
+			return
+		}
+		event.Complete(db, recorder.statusCode, recorder.body.Bytes())
+	}
+}
+
+//
+// completeLambdaEvent completes the event in the transaction of the
+// handler's writes with the successful response the handler sends
+// once they are committed
+//
+func completeLambdaEvent(tx *gorm.DB, event *models.LambdaEvent, response interface{}) error {
+	if event == nil {
+		return nil
+	}
+	data, err := json.Marshal(response)
+	if err != nil {
+		return err
+	}
+	// JSONResponder ends the body with a newline
+	return event.Complete(tx, http.StatusOK, append(data, '\n'))
+}
+
+//
+// idempotencyKey returns the key identifying the event,
+// or an empty string when the event carries none
+//
+func idempotencyKey(header http.Header, data []byte) string {
+	if key := header.Get("Idempotency-Key"); key != "" {
+		return key
+	}
+	message := lambdaEventKey{}
+	if err := json.Unmarshal(data, &message); err != nil {
+		return ""
+	}
+	if message.MessageID != "" {
+		return message.MessageID
+	}
+	if message.Payload.Sequence != nil && message.Payload.Dispenser.Serial != "" {
+		return fmt.Sprintf("%v:%v", message.Payload.Dispenser.Serial, *message.Payload.Sequence)
+	}
+	return ""
+}
+
+func replayLambdaEvent(w http.ResponseWriter, event models.LambdaEvent) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(event.StatusCode)
+	w.Write(event.Response)
+}
+
+//
+// responseRecorder passes the response through
+// while keeping a copy of its status and body
+//
+type responseRecorder struct {
+	http.ResponseWriter
+	statusCode  int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (r *responseRecorder) WriteHeader(statusCode int) {
+	if r.wroteHeader {
+		return
+	}
+	r.wroteHeader = true
+	r.statusCode = statusCode
+	r.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (r *responseRecorder) Write(data []byte) (int, error) {
+	if !r.wroteHeader {
+		r.WriteHeader(http.StatusOK)
+	}
+	r.body.Write(data)
+	return r.ResponseWriter.Write(data)
+}
@@ -9,7 +9,6 @@ import (
 
 	"github.com/gorilla/mux"
 	uuid "github.com/satori/go.uuid"
-	"github.com/tespo/buddha/db"
 	"github.com/tespo/buddha/util"
 	"github.com/tespo/satya/v2/types"
 )
@@ -20,12 +19,7 @@ import (
 func GetInsertions(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	db, err := db.Open()
-	if err != nil {
-		panic(err)
-	}
-	defer db.Close()
-	db = util.SetDBPagination(db, r)
+	db := util.SetDBPagination(requestDB(r), r)
 
 	var insertions types.Insertions
 	if err := insertions.Get(db); err != nil {
@@ -48,11 +42,7 @@ func GetInsertionByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	db, err := db.Open()
-	if err != nil {
-		panic(err)
-	}
-	defer db.Close()
+	db := requestDB(r)
 
 	var insertion types.Insertion
 	if err := insertion.GetByID(db, uuid.FromStringOrNil(id)); err != nil {
@@ -60,7 +50,7 @@ func GetInsertionByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err = json.NewEncoder(w).Encode(insertion); err != nil {
+	if err := json.NewEncoder(w).Encode(insertion); err != nil {
 		panic(err)
 	}
 }
@@ -79,18 +69,14 @@ func PostInsertion(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	db, err := db.Open()
-	if err != nil {
-		panic(err)
-	}
-	defer db.Close()
+	db := requestDB(r)
 
 	if err := insertion.Create(db); err != nil {
 		util.ErrorResponder(w, http.StatusInternalServerError, err)
 		return
 	}
 
-	if err = json.NewEncoder(w).Encode(insertion); err != nil {
+	if err := json.NewEncoder(w).Encode(insertion); err != nil {
 		panic(err)
 	}
 }
@@ -119,18 +105,14 @@ func PutInsertionByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	db, err := db.Open()
-	if err != nil {
-		panic(err)
-	}
-	defer db.Close()
+	db := requestDB(r)
 
 	if err := insertion.Update(db); err != nil {
 		util.ErrorResponder(w, http.StatusInternalServerError, err)
 		return
 	}
 
-	if err = json.NewEncoder(w).Encode(insertion); err != nil {
+	if err := json.NewEncoder(w).Encode(insertion); err != nil {
 		panic(err)
 	}
 }
@@ -147,11 +129,7 @@ func DeleteInsertionsByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	db, err := db.Open()
-	if err != nil {
-		panic(err)
-	}
-	defer db.Close()
+	db := requestDB(r)
 
 	var insertion types.Insertion
 	if err := insertion.Delete(db, uuid.FromStringOrNil(id)); err != nil {
@@ -159,7 +137,7 @@ func DeleteInsertionsByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err = json.NewEncoder(w).Encode(insertion); err != nil {
+	if err := json.NewEncoder(w).Encode(insertion); err != nil {
 		panic(err)
 	}
 }
@@ -9,9 +9,10 @@ import (
 
 	"github.com/gorilla/context"
 	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
 	uuid "github.com/satori/go.uuid"
-	"github.com/tespo/buddha/db"
 	"github.com/tespo/buddha/util"
+	"github.com/tespo/buddha/webhook"
 	"github.com/tespo/satya/v2/scoping"
 	"github.com/tespo/satya/v2/types"
 )
@@ -35,11 +36,7 @@ func GetInvitationByID(w http.ResponseWriter, r *http.Request) {
 		util.ErrorResponder(w, http.StatusBadRequest, errors.New("No scopes"))
 		return
 	}
-	db, err := db.Open()
-	if err != nil {
-		panic(err)
-	}
-	defer db.Close()
+	db := requestDB(r)
 	invitation := types.Invitation{}
 	if err := invitation.GetOneByQuery(db, "id = ? and account_id = ?", uuid.FromStringOrNil(id), accountID.(uuid.UUID)); err != nil {
 		util.ErrorResponder(w, http.StatusNotFound, err)
@@ -62,11 +59,7 @@ func GetInvitations(w http.ResponseWriter, r *http.Request) {
 		util.ErrorResponder(w, http.StatusBadRequest, errors.New("No scopes"))
 		return
 	}
-	db, err := db.Open()
-	if err != nil {
-		panic(err)
-	}
-	defer db.Close()
+	db := requestDB(r)
 	invitations := types.Invitations{}
 	if err := invitations.GetByQuery(db, "account_id = ?", uuid.FromStringOrNil(accountID.(string))); err != nil {
 		util.ErrorResponder(w, http.StatusNotFound, err)
@@ -103,11 +96,7 @@ func PostInvitation(w http.ResponseWriter, r *http.Request) {
 	invitation.AccountID = uuid.FromStringOrNil(accountID.(string))
 	invitation.ExpiresAt = time.Now().Add(48 * time.Hour)
 	invitation.Code = strings.Replace(uuid.NewV4().String(), "-", "", -1)
-	db, err := db.Open()
-	if err != nil {
-		panic(err)
-	}
-	defer db.Close()
+	db := requestDB(r)
 	account := types.Account{}
 	if err := account.GetByID(db, uuid.FromStringOrNil(accountID.(string))); err != nil {
 		util.ErrorResponder(w, http.StatusNotFound, err)
@@ -131,7 +120,7 @@ func PostInvitation(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	if err := util.SendInviteEmail(strings.ToLower(invitation.Email), account.Name, invitation.Code); err != nil {
+	if err := util.SendInviteEmail(util.RequestContext(r), strings.ToLower(invitation.Email), account.Name, invitation.Code); err != nil {
 		util.ErrorResponder(w, http.StatusInternalServerError, err)
 		panic(err)
 	}
@@ -153,11 +142,7 @@ func DeleteInvitation(w http.ResponseWriter, r *http.Request) {
 		util.ErrorResponder(w, http.StatusInternalServerError, errors.New("Cannot process token claims"))
 		return
 	}
-	db, err := db.Open()
-	if err != nil {
-		panic(err)
-	}
-	defer db.Close()
+	db := requestDB(r)
 	invitation := types.Invitation{
 		ID:        uuid.FromStringOrNil(id),
 		AccountID: uuid.FromStringOrNil(accountID.(string)),
@@ -193,11 +178,7 @@ func AcceptInvitation(w http.ResponseWriter, r *http.Request) {
 		util.ErrorResponder(w, http.StatusInternalServerError, errors.New("Cannot process token claims"))
 		return
 	}
-	db, err := db.Open()
-	if err != nil {
-		panic(err)
-	}
-	defer db.Close()
+	db := requestDB(r)
 	invitation := types.Invitation{}
 	if err := invitation.GetByID(db, uuid.FromStringOrNil(id)); err != nil {
 		util.ErrorResponder(w, http.StatusInternalServerError, err)
@@ -216,103 +197,89 @@ func AcceptInvitation(w http.ResponseWriter, r *http.Request) {
 		util.ErrorResponder(w, http.StatusBadRequest, errors.New("user cannot accept this invitation"))
 		return
 	}
-	now := time.Now()
-	if owner.(bool) {
-		accountWithUsers := types.Account{
-			ID: uuid.FromStringOrNil(accountID.(string)),
-		}
-		if err := accountWithUsers.GetUsers(db); err != nil {
-			util.ErrorResponder(w, http.StatusInternalServerError, err)
-			return
-		}
-		for _, forUser := range accountWithUsers.Users {
-			forUser.AccountID = invitation.AccountID
-			accountUser := &forUser
-			if err := accountUser.Update(db); err != nil {
-				util.ErrorResponder(w, http.StatusInternalServerError, err)
-				return
+	deleteCause := []byte("{\"delete_cause\":\"user " + userID.(string) + " accepted invitation\"}")
+	err := transaction(db, func(tx *gorm.DB) error {
+		now := time.Now()
+		if owner.(bool) {
+			accountWithUsers := types.Account{
+				ID: uuid.FromStringOrNil(accountID.(string)),
 			}
-			if err := accountUser.GetUserWithAllData(db); err != nil {
-				util.ErrorResponder(w, http.StatusInternalServerError, err)
-				return
+			if err := accountWithUsers.GetUsers(tx); err != nil {
+				return err
 			}
-			now := time.Now()
-			// Delete old user data
-			for _, regimen := range accountUser.Regimens {
-				for _, reminder := range regimen.Reminders {
-					reminder.Meta = []byte("{\"delete_cause\":\"user " + userID.(string) + " accepted invitation\"}")
-					reminder.DeletedAt = &now
-					if err := reminder.Update(db); err != nil {
-						util.ErrorResponder(w, http.StatusInternalServerError, err)
-						return
-					}
+			for _, forUser := range accountWithUsers.Users {
+				forUser.AccountID = invitation.AccountID
+				accountUser := &forUser
+				if err := accountUser.Update(tx); err != nil {
+					return err
 				}
-				for _, usage := range regimen.Usages {
-					usage.Meta = []byte("{\"delete_cause\":\"user " + userID.(string) + " accepted invitation\"}")
-					usage.DeletedAt = &now
-					if err := usage.Update(db); err != nil {
-						util.ErrorResponder(w, http.StatusInternalServerError, err)
-						return
-					}
+				if err := accountUser.GetUserWithAllData(tx); err != nil {
+					return err
 				}
-				regimen.Meta = []byte("{\"delete_cause\":\"user " + userID.(string) + " accepted invitation\"}")
-				regimen.DeletedAt = &now
-				if err := regimen.Update(db); err != nil {
-					util.ErrorResponder(w, http.StatusInternalServerError, err)
-					return
+				if err := deleteUserData(tx, accountUser, deleteCause, now); err != nil {
+					return err
 				}
 			}
+			accountWithOutUsers := types.Account{}
+			if err := accountWithOutUsers.GetByID(tx, uuid.FromStringOrNil(accountID.(string))); err != nil {
+				return err
+			}
+			accountWithOutUsers.Meta = deleteCause
+			accountWithOutUsers.DeletedAt = &now
+			return accountWithOutUsers.Update(tx)
 		}
-		accountWithOutUsers := types.Account{}
-		if err := accountWithOutUsers.GetByID(db, uuid.FromStringOrNil(accountID.(string))); err != nil {
-			util.ErrorResponder(w, http.StatusInternalServerError, err)
-			return
+
+		acceptUser.AccountID = invitation.AccountID
+		if err := acceptUser.Update(tx); err != nil {
+			return err
 		}
-		accountWithOutUsers.Meta = []byte("{\"delete_cause\":\"user " + userID.(string) + " accepted invitation\"}")
-		accountWithOutUsers.DeletedAt = &now
-		if err := accountWithOutUsers.Update(db); err != nil {
-			util.ErrorResponder(w, http.StatusInternalServerError, err)
-			return
+		if err := deleteUserData(tx, &acceptUser, deleteCause, now); err != nil {
+			return err
 		}
-		util.JSONResponder(w, map[string]string{"status": "success"})
-		return
-	}
-
-	acceptUser.AccountID = invitation.AccountID
-	if err := acceptUser.Update(db); err != nil {
+		if err := invitation.Delete(tx, invitation.ID); err != nil {
+			return errors.New("could not delete invitation: " + err.Error())
+		}
+		return nil
+	})
+	if err != nil {
 		util.ErrorResponder(w, http.StatusInternalServerError, err)
 		return
 	}
-	// Delete old user data
-	for _, regimen := range acceptUser.Regimens {
+	emitWebhook(util.RequestID(r), invitation.AccountID, webhook.EventInvitationAccepted, map[string]interface{}{
+		"invitation_id": invitation.ID,
+		"user_id":       acceptUser.ID,
+		"email":         invitation.Email,
+		"owner":         owner,
+	})
+
+	util.JSONResponder(w, map[string]string{"status": "success"})
+}
+
+//
+// deleteUserData soft deletes the regimens of a user moving
+// accounts, along with their reminders and usages
+//
+func deleteUserData(tx *gorm.DB, user *types.User, deleteCause []byte, now time.Time) error {
+	for _, regimen := range user.Regimens {
 		for _, reminder := range regimen.Reminders {
-			reminder.Meta = []byte("{\"delete_cause\":\"user " + userID.(string) + " accepted invitation\"}")
+			reminder.Meta = deleteCause
 			reminder.DeletedAt = &now
-			if err := reminder.Update(db); err != nil {
-				util.ErrorResponder(w, http.StatusInternalServerError, err)
-				return
+			if err := reminder.Update(tx); err != nil {
+				return err
 			}
 		}
 		for _, usage := range regimen.Usages {
-			usage.Meta = []byte("{\"delete_cause\":\"user " + userID.(string) + " accepted invitation\"}")
+			usage.Meta = deleteCause
 			usage.DeletedAt = &now
-			if err := usage.Update(db); err != nil {
-				util.ErrorResponder(w, http.StatusInternalServerError, err)
-				return
+			if err := usage.Update(tx); err != nil {
+				return err
 			}
 		}
-		regimen.Meta = []byte("{\"delete_cause\":\"user " + userID.(string) + " accepted invitation\"}")
+		regimen.Meta = deleteCause
 		regimen.DeletedAt = &now
-		if err := regimen.Update(db); err != nil {
-			util.ErrorResponder(w, http.StatusInternalServerError, err)
-			return
+		if err := regimen.Update(tx); err != nil {
+			return err
 		}
 	}
-
-	if err := invitation.Delete(db, invitation.ID); err != nil {
-		util.ErrorResponder(w, http.StatusInternalServerError, errors.New("invite accepted but could not delete invitation: "+err.Error()))
-		return
-	}
-
-	util.JSONResponder(w, map[string]string{"status": "success"})
+	return nil
 }
@@ -4,37 +4,50 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"time"
 
+	"github.com/jinzhu/gorm"
 	uuid "github.com/satori/go.uuid"
-	"github.com/tespo/buddha/db"
+	"github.com/tespo/buddha/metrics"
+	"github.com/tespo/buddha/models"
+	"github.com/tespo/buddha/presence"
 	"github.com/tespo/buddha/util"
+	"github.com/tespo/buddha/webhook"
 	"github.com/tespo/satya/v2/types"
 )
 
+//
+// lambdaSuccess is the response to a processed lambda message
+//
+var lambdaSuccess = map[string]string{"status": "success"}
+
 //
 // DispenserDispensed handles the lambda message
 // for Dispenser Dispensed
 //
-func DispenserDispensed(w http.ResponseWriter, r *http.Request) {
+func DispenserDispensed(w http.ResponseWriter, r *http.Request, event *models.LambdaEvent) {
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		util.ErrorResponder(w, http.StatusInternalServerError, err)
+		return
+	}
 	lambdaMessage := types.LambdaMessage{}
-	decoder := json.NewDecoder(r.Body)
-	if err := decoder.Decode(&lambdaMessage); err != nil {
+	if err := json.Unmarshal(data, &lambdaMessage); err != nil {
 		util.ErrorResponder(w, http.StatusInternalServerError, err)
 		return
 	}
+	// Dispensers running a dispense command name it in the payload
+	commandMessage := commandStatusMessage{}
+	json.Unmarshal(data, &commandMessage)
 	barcode := types.Barcode{
 		Code: lambdaMessage.Payload.Pod.Barcode,
 	}
 	pod := types.Pod{}
 	regimen := types.Regimen{}
 	insertion := types.Insertion{}
-	db, err := db.Open()
-	if err != nil {
-		panic(err)
-	}
-	defer db.Close()
+	db := requestDB(r)
 	connections := types.Connections{}
 	dispensers, err := connections.GetAccountDispensers(db, uuid.FromStringOrNil(lambdaMessage.Payload.Customer.ID))
 	if err != nil {
@@ -97,48 +110,48 @@ func DispenserDispensed(w http.ResponseWriter, r *http.Request) {
 		Flags:       uint(lambdaMessage.Payload.Pod.Flags),
 	}
 
-	if err := newUsage.Create(db); err != nil {
+	previousServings := regimen.LastReportedServingsRemaining
+	err = transaction(db, func(tx *gorm.DB) error {
+		if err := newUsage.Create(tx); err != nil {
+			return err
+		}
+		regimen.LastReportedServingsRemaining = uint(lambdaMessage.Payload.Pod.ServingsRemaining)
+		if err := regimen.Update(tx); err != nil {
+			return err
+		}
+		if err := recordDispenserFirmware(tx, &dispenser, lambdaMessage.Payload.Dispenser); err != nil {
+			return err
+		}
+		return completeLambdaEvent(tx, event, lambdaSuccess)
+	})
+	if err != nil {
 		util.ErrorResponder(w, http.StatusInternalServerError, err)
 		return
 	}
+	metrics.Dispenses.Inc()
 
-	regimen.LastReportedServingsRemaining = uint(lambdaMessage.Payload.Pod.ServingsRemaining)
-	if err := regimen.Update(db); err != nil {
-		util.ErrorResponder(w, http.StatusInternalServerError, err)
-		return
+	checkLowSupply(db, util.RequestID(r), regimen, previousServings)
+	if err := completeDispenseCommand(db, dispenser.ID, commandMessage.Payload.Command.ID, newUsage); err != nil {
+		util.CaptureException(util.RequestID(r), err)
 	}
-	meta := map[string]map[string]string{
-		"pcb":        map[string]string{"version": lambdaMessage.Payload.Dispenser.ControllerFirmwareVersion},
-		"wifi":       map[string]string{"version": lambdaMessage.Payload.Dispenser.WifiFirmwareVersion},
-		"controller": map[string]string{"version": lambdaMessage.Payload.Dispenser.PcbFirmwareVersion},
-	}
-	metaBytes, err := json.Marshal(meta)
-	if err != nil {
-		util.ErrorResponder(w, http.StatusInternalServerError, err)
-		return
-	}
-	dispenser.Meta = metaBytes
-	dispenser.Update(db)
+	emitWebhook(util.RequestID(r), uuid.FromStringOrNil(lambdaMessage.Payload.Customer.ID), webhook.EventUsageCreated, newUsage)
+	dispenserSeen(db, util.RequestID(r), dispenser.ID, uuid.FromStringOrNil(lambdaMessage.Payload.Customer.ID))
 
-	util.JSONResponder(w, map[string]string{"status": "success"})
+	util.JSONResponder(w, lambdaSuccess)
 }
 
 //
 // PodInserted handles the lambda message
 // for Pod Inserted
 //
-func PodInserted(w http.ResponseWriter, r *http.Request) {
+func PodInserted(w http.ResponseWriter, r *http.Request, event *models.LambdaEvent) {
 	lambdaMessage := types.LambdaMessage{}
 	decoder := json.NewDecoder(r.Body)
 	if err := decoder.Decode(&lambdaMessage); err != nil {
 		util.ErrorResponder(w, http.StatusInternalServerError, err)
 		return
 	}
-	db, err := db.Open()
-	if err != nil {
-		panic(err)
-	}
-	defer db.Close()
+	db := requestDB(r)
 
 	pod := types.Pod{}
 	insertion := types.Insertion{}
@@ -201,51 +214,49 @@ func PodInserted(w http.ResponseWriter, r *http.Request) {
 			newRegimen = true
 		}
 	}
-	if lambdaMessage.Payload.Pod.Barcode == "" || newRegimen {
-		regimen = types.Regimen{
-			PodID:                         &pod.ID,
-			AccountID:                     account.ID,
-			UserID:                        &userID,
-			LastReportedServingsRemaining: uint(lambdaMessage.Payload.Pod.ServingsRemaining),
+	err = transaction(db, func(tx *gorm.DB) error {
+		if lambdaMessage.Payload.Pod.Barcode == "" || newRegimen {
+			regimen = types.Regimen{
+				PodID:                         &pod.ID,
+				AccountID:                     account.ID,
+				UserID:                        &userID,
+				LastReportedServingsRemaining: uint(lambdaMessage.Payload.Pod.ServingsRemaining),
+			}
+			if err := regimen.Create(tx); err != nil {
+				return err
+			}
+		} else {
+			regimen.LastReportedServingsRemaining = uint(lambdaMessage.Payload.Pod.ServingsRemaining)
+			if err := regimen.Update(tx); err != nil {
+				return err
+			}
 		}
-		if err := regimen.Create(db); err != nil {
-			util.ErrorResponder(w, http.StatusInternalServerError, err)
-			return
+
+		insertion = types.Insertion{
+			RegimenID:   regimen.ID,
+			DispenserID: dispenser.ID,
+			BarcodeID:   &barcode.ID,
+			Flags:       uint(lambdaMessage.Payload.Pod.Flags),
+			LabelTall:   barcode.LabelTall,
+			LabelWide:   barcode.LabelWide,
 		}
-	} else {
-		regimen.LastReportedServingsRemaining = uint(lambdaMessage.Payload.Pod.ServingsRemaining)
-		if err := regimen.Update(db); err != nil {
-			util.ErrorResponder(w, http.StatusInternalServerError, err)
-			return
+		if err := insertion.Create(tx); err != nil {
+			return err
 		}
-	}
-
-	insertion = types.Insertion{
-		RegimenID:   regimen.ID,
-		DispenserID: dispenser.ID,
-		BarcodeID:   &barcode.ID,
-		Flags:       uint(lambdaMessage.Payload.Pod.Flags),
-		LabelTall:   barcode.LabelTall,
-		LabelWide:   barcode.LabelWide,
-	}
-	if err := insertion.Create(db); err != nil {
-		util.ErrorResponder(w, http.StatusInternalServerError, err)
-		return
-	}
-	meta := map[string]map[string]string{
-		"pcb":        map[string]string{"version": lambdaMessage.Payload.Dispenser.ControllerFirmwareVersion},
-		"wifi":       map[string]string{"version": lambdaMessage.Payload.Dispenser.WifiFirmwareVersion},
-		"controller": map[string]string{"version": lambdaMessage.Payload.Dispenser.PcbFirmwareVersion},
-	}
-	metaBytes, err := json.Marshal(meta)
+		if err := recordDispenserFirmware(tx, &dispenser, lambdaMessage.Payload.Dispenser); err != nil {
+			return err
+		}
+		return completeLambdaEvent(tx, event, lambdaSuccess)
+	})
 	if err != nil {
 		util.ErrorResponder(w, http.StatusInternalServerError, err)
 		return
 	}
-	dispenser.Meta = metaBytes
-	dispenser.Update(db)
+	metrics.Insertions.Inc()
+	emitWebhook(util.RequestID(r), account.ID, webhook.EventPodInserted, insertion)
+	dispenserSeen(db, util.RequestID(r), dispenser.ID, account.ID)
 
-	util.JSONResponder(w, map[string]string{"status": "success"})
+	util.JSONResponder(w, lambdaSuccess)
 }
 
 //
@@ -259,41 +270,19 @@ func DispenserConnected(w http.ResponseWriter, r *http.Request) {
 		util.ErrorResponder(w, http.StatusInternalServerError, err)
 		return
 	}
-	db, err := db.Open()
-	if err != nil {
-		panic(err)
-	}
-	defer db.Close()
-	account := types.Account{
-		ID: uuid.FromStringOrNil(lambdaMessage.Payload.Customer.ID),
-	}
-	if err := account.GetConnections(db); err != nil {
-		if err.Error() != "record not found" {
-			util.ErrorResponder(w, http.StatusInternalServerError, err)
-			return
-		}
-	}
-	if len(account.Connections) > 0 {
-		util.ErrorResponder(w, http.StatusBadRequest, errors.New("account already has connection"))
-		return
-	}
+	db := requestDB(r)
 	dispenser := types.Dispenser{}
 	if err := dispenser.GetOneByQuery(db, "serial = ?", lambdaMessage.Payload.Dispenser.Serial); err != nil {
 		util.ErrorResponder(w, http.StatusInternalServerError, err)
 		return
 	}
-	meta := map[string]map[string]string{
-		"pcb":        map[string]string{"version": lambdaMessage.Payload.Dispenser.ControllerFirmwareVersion},
-		"wifi":       map[string]string{"version": lambdaMessage.Payload.Dispenser.WifiFirmwareVersion},
-		"controller": map[string]string{"version": lambdaMessage.Payload.Dispenser.PcbFirmwareVersion},
-	}
-	metaBytes, err := json.Marshal(meta)
+	err := transaction(db, func(tx *gorm.DB) error {
+		return recordDispenserFirmware(tx, &dispenser, lambdaMessage.Payload.Dispenser)
+	})
 	if err != nil {
 		util.ErrorResponder(w, http.StatusInternalServerError, err)
 		return
 	}
-	dispenser.Meta = metaBytes
-	dispenser.Update(db)
 	connection := types.Connection{}
 	if err := connection.GetOneByQuery(db, "dispenser_id = ?", dispenser.ID); err != nil {
 		if err.Error() != "record not found" {
@@ -307,6 +296,8 @@ func DispenserConnected(w http.ResponseWriter, r *http.Request) {
 			util.ErrorResponder(w, http.StatusInternalServerError, err)
 			return
 		}
+		emitWebhook(util.RequestID(r), connection.AccountID, webhook.EventDispenserConnected, connection)
+		dispenserSeen(db, util.RequestID(r), dispenser.ID, connection.AccountID)
 		util.JSONResponder(w, map[string]string{"status": "Success"})
 		return
 	}
@@ -325,11 +316,7 @@ func DispenserDisconnected(w http.ResponseWriter, r *http.Request) {
 		util.ErrorResponder(w, http.StatusInternalServerError, err)
 		return
 	}
-	db, err := db.Open()
-	if err != nil {
-		panic(err)
-	}
-	defer db.Close()
+	db := requestDB(r)
 	account := types.Account{
 		ID: uuid.FromStringOrNil(lambdaMessage.Payload.Customer.ID),
 	}
@@ -345,17 +332,26 @@ func DispenserDisconnected(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	connection := types.Connection{}
-	if err := connection.GetOneByQuery(db, "dispenser_id = ?", dispenser.ID); err != nil {
+	if err := connection.GetOneByQuery(db, "dispenser_id = ? AND account_id = ?", dispenser.ID, account.ID); err != nil {
 		util.ErrorResponder(w, http.StatusInternalServerError, err)
 		return
 	}
-	now := time.Now()
-	connection.DisconnectedAt = &now
-	connection.Update(db)
-	if err := connection.Delete(db, connection.ID); err != nil {
+	err := transaction(db, func(tx *gorm.DB) error {
+		now := time.Now()
+		connection.DisconnectedAt = &now
+		if err := connection.Update(tx); err != nil {
+			return err
+		}
+		return connection.Delete(tx, connection.ID)
+	})
+	if err != nil {
 		util.ErrorResponder(w, http.StatusInternalServerError, err)
 		return
 	}
+	emitWebhook(util.RequestID(r), account.ID, webhook.EventDispenserDisconnected, connection)
+	if err := presence.End(db, dispenser.ID, *connection.DisconnectedAt, models.SessionDisconnected); err != nil {
+		util.CaptureException(util.RequestID(r), err)
+	}
 	util.JSONResponder(w, map[string]string{"status": "success"})
 	return
 }
\ No newline at end of file
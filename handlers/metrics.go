@@ -0,0 +1,30 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/tespo/buddha/util"
+)
+
+//
+// Metrics serves the registry to Prometheus. When METRICS_TOKEN
+// is set, scrapes must send it as a bearer token
+//
+func Metrics(registry *prometheus.Registry) http.Handler {
+	serve := promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := os.Getenv("METRICS_TOKEN")
+		given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if token != "" && subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			util.ErrorResponder(w, http.StatusUnauthorized, errors.New("Unauthorized"))
+			return
+		}
+		serve.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/context"
+	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
+	uuid "github.com/satori/go.uuid"
+	"github.com/tespo/buddha/models"
+	"github.com/tespo/buddha/presence"
+	"github.com/tespo/buddha/util"
+	"github.com/tespo/satya/v2/types"
+)
+
+//
+// connectionSession is a session with how long it lasted
+//
+type connectionSession struct {
+	models.DispenserSession
+	DurationSeconds int64 `json:"duration_seconds"`
+}
+
+//
+// DispenserHeartbeat handles the lambda message a dispenser
+// sends periodically while it is online. The dispenser must
+// be connected to the account the message names
+//
+func DispenserHeartbeat(w http.ResponseWriter, r *http.Request) {
+	lambdaMessage := types.LambdaMessage{}
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&lambdaMessage); err != nil {
+		util.ErrorResponder(w, http.StatusInternalServerError, err)
+		return
+	}
+	db := requestDB(r)
+	dispenser := types.Dispenser{}
+	if err := dispenser.GetOneByQuery(db, "serial = ?", lambdaMessage.Payload.Dispenser.Serial); err != nil {
+		if err.Error() == "record not found" {
+			util.ErrorResponder(w, http.StatusNotFound, errors.New("dispenser cannot be found"))
+			return
+		}
+		util.ErrorResponder(w, http.StatusInternalServerError, err)
+		return
+	}
+	accountID := uuid.FromStringOrNil(lambdaMessage.Payload.Customer.ID)
+	connection := types.Connection{}
+	if err := connection.GetOneByQuery(db, "dispenser_id = ? AND account_id = ?", dispenser.ID, accountID); err != nil {
+		if err.Error() == "record not found" {
+			util.ErrorResponder(w, http.StatusForbidden, errors.New("dispenser is not connected to the account"))
+			return
+		}
+		util.ErrorResponder(w, http.StatusInternalServerError, err)
+		return
+	}
+	session, err := presence.Seen(db, dispenser.ID, accountID, time.Now())
+	if err != nil {
+		util.ErrorResponder(w, http.StatusInternalServerError, err)
+		return
+	}
+	util.JSONResponder(w, session)
+}
+
+//
+// GetDispenserStatus is the GET method for whether one of
+// the account's dispensers is online and when it was last seen
+//
+func GetDispenserStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	accountID, ok := context.GetOk(r, "account_id")
+	if !ok {
+		util.ErrorResponder(w, http.StatusInternalServerError, errors.New("Cannot process token claims"))
+		return
+	}
+	db := requestDB(r)
+	dispenser, err := accountDispenser(db, uuid.FromStringOrNil(accountID.(string)), mux.Vars(r)["dispenser_id"])
+	if err != nil {
+		dispenserErrorResponder(w, err)
+		return
+	}
+	status, err := presence.StatusOf(db, dispenser.ID, presence.ConfigFromEnv(), time.Now())
+	if err != nil {
+		util.ErrorResponder(w, http.StatusInternalServerError, err)
+		return
+	}
+	util.JSONResponder(w, status)
+}
+
+//
+// GetDispenserConnectionHistory is the GET method for the online
+// sessions of a dispenser on the account, newest first. Dispensers
+// the account was connected to before can be given by ID
+//
+func GetDispenserConnectionHistory(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	accountID, ok := context.GetOk(r, "account_id")
+	if !ok {
+		util.ErrorResponder(w, http.StatusInternalServerError, errors.New("Cannot process token claims"))
+		return
+	}
+	db := requestDB(r)
+	selector := mux.Vars(r)["dispenser_id"]
+	dispenserID := uuid.Nil
+	dispenser, err := accountDispenser(db, uuid.FromStringOrNil(accountID.(string)), selector)
+	switch {
+	case err == nil:
+		dispenserID = dispenser.ID
+	case err == errDispenserNotFound && uuid.FromStringOrNil(selector) != uuid.Nil:
+		dispenserID = uuid.FromStringOrNil(selector)
+	default:
+		dispenserErrorResponder(w, err)
+		return
+	}
+	sessions := models.DispenserSessions{}
+	if err := sessions.GetByQuery(util.SetDBPagination(db, r).Order("started_at desc"), "dispenser_id = ? AND account_id = ?", dispenserID, uuid.FromStringOrNil(accountID.(string))); err != nil {
+		util.ErrorResponder(w, http.StatusInternalServerError, err)
+		return
+	}
+	now := time.Now()
+	history := []connectionSession{}
+	for _, session := range sessions {
+		history = append(history, connectionSession{
+			DispenserSession: session,
+			DurationSeconds:  int64(session.Duration(now) / time.Second),
+		})
+	}
+	util.PaginationResponder(w, r, history)
+}
+
+//
+// dispenserSeen starts or extends the session of a dispenser
+// that reported in through another lambda message
+//
+func dispenserSeen(db *gorm.DB, requestID string, dispenserID, accountID uuid.UUID) {
+	if _, err := presence.Seen(db, dispenserID, accountID, time.Now()); err != nil {
+		util.CaptureException(requestID, err)
+	}
+}
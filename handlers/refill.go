@@ -0,0 +1,181 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/gorilla/context"
+	"github.com/jinzhu/gorm"
+	uuid "github.com/satori/go.uuid"
+	"github.com/tespo/buddha/refill"
+	"github.com/tespo/buddha/shutdown"
+	"github.com/tespo/buddha/tracing"
+	"github.com/tespo/buddha/util"
+	"github.com/tespo/buddha/webhook"
+	"github.com/tespo/satya/v2/scoping"
+	"github.com/tespo/satya/v2/types"
+)
+
+//
+// forecastRegimen is a regimen response along
+// with the forecast of when it runs out
+//
+type forecastRegimen struct {
+	types.Regimen
+	Forecast *refill.Forecast `json:"forecast,omitempty"`
+}
+
+//
+// lowSupplyEvent is sent when a regimen's
+// forecast drops below the low supply threshold
+//
+type lowSupplyEvent struct {
+	Event      string          `json:"event"`
+	AccountID  uuid.UUID       `json:"account_id"`
+	UserID     *uuid.UUID      `json:"user_id"`
+	RegimenID  uuid.UUID       `json:"regimen_id"`
+	PodID      *uuid.UUID      `json:"pod_id"`
+	Forecast   refill.Forecast `json:"forecast"`
+	OccurredAt time.Time       `json:"occurred_at"`
+}
+
+//
+// GetAccountRefills is the GET method for the refill forecasts of
+// an account's regimens, soonest to run out first
+//
+func GetAccountRefills(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	accountID, ok := context.GetOk(r, "account_id")
+	if !ok {
+		util.ErrorResponder(w, http.StatusInternalServerError, errors.New("Cannot process token claims"))
+		return
+	}
+	db := requestDB(r)
+
+	regimens := types.Regimens{}
+	if err := regimens.GetAccountRegimens(db, uuid.FromStringOrNil(accountID.(string))); err != nil {
+		util.ErrorResponder(w, http.StatusInternalServerError, err)
+		return
+	}
+	forecasts, err := regimenForecasts(db, regimens...)
+	if err != nil {
+		util.ErrorResponder(w, http.StatusInternalServerError, err)
+		return
+	}
+	refills := make([]refill.Forecast, 0, len(forecasts))
+	for _, regimen := range regimens {
+		refills = append(refills, forecasts[regimen.ID])
+	}
+	sort.SliceStable(refills, func(i, j int) bool {
+		if refills[j].DaysRemaining == nil {
+			return refills[i].DaysRemaining != nil
+		}
+		return refills[i].DaysRemaining != nil && *refills[i].DaysRemaining < *refills[j].DaysRemaining
+	})
+	util.JSONResponder(w, refills)
+}
+
+//
+// regimenForecasts predicts the run-out of each regimen
+// from its usages inside the lookback
+//
+func regimenForecasts(db *gorm.DB, regimens ...types.Regimen) (map[uuid.UUID]refill.Forecast, error) {
+	config := refill.ConfigFromEnv()
+	now := time.Now()
+	forecasts := map[uuid.UUID]refill.Forecast{}
+	if len(regimens) == 0 {
+		return forecasts, nil
+	}
+	regimenIDs := make([]uuid.UUID, len(regimens))
+	for i, regimen := range regimens {
+		regimenIDs[i] = regimen.ID
+	}
+	usages := types.Usages{}
+	if err := usages.GetByQuery(db, "regimen_id IN (?) AND created_at >= ?", regimenIDs, config.Since(now)); err != nil {
+		return nil, err
+	}
+	for _, regimen := range regimens {
+		forecasts[regimen.ID] = refill.Predict(regimen, usages, config, now)
+	}
+	return forecasts, nil
+}
+
+//
+// withForecasts scopes each regimen response and adds its forecast.
+// The forecast gives away the servings remaining, so it is left
+// out when the scopes hide them
+//
+func withForecasts(db *gorm.DB, scopedFields []string, regimens ...types.Regimen) ([]forecastRegimen, error) {
+	responses := make([]forecastRegimen, len(regimens))
+	for i, regimen := range regimens {
+		responses[i] = forecastRegimen{
+			Regimen: scoping.FilterByScopes(scopedFields, regimen).(types.Regimen),
+		}
+	}
+	if !servingsInScope(scopedFields) {
+		return responses, nil
+	}
+	forecasts, err := regimenForecasts(db, regimens...)
+	if err != nil {
+		return nil, err
+	}
+	for i, regimen := range regimens {
+		forecast := forecasts[regimen.ID]
+		responses[i].Forecast = &forecast
+	}
+	return responses, nil
+}
+
+//
+// servingsInScope reports whether the scopes let the
+// servings remaining of a regimen through
+//
+func servingsInScope(scopedFields []string) bool {
+	probe := types.Regimen{LastReportedServingsRemaining: 1}
+	return scoping.FilterByScopes(scopedFields, probe).(types.Regimen).LastReportedServingsRemaining != 0
+}
+
+//
+// checkLowSupply sends a low supply event when the servings
+// reported for the regimen moved its forecast below the
+// threshold. The event is sent to the lambda function named
+// by LOW_SUPPLY_LAMBDA without holding up the response, and
+// shutdown waits for it to be sent
+//
+func checkLowSupply(db *gorm.DB, requestID string, regimen types.Regimen, previousServings uint) {
+	config := refill.ConfigFromEnv()
+	now := time.Now()
+	usages := types.Usages{}
+	if err := usages.GetByQuery(db, "regimen_id = ? AND created_at >= ?", regimen.ID, config.Since(now)); err != nil {
+		util.CaptureException(requestID, err)
+		return
+	}
+	forecast, crossed := refill.Crossed(regimen, previousServings, usages, config, now)
+	if !crossed {
+		return
+	}
+	event := lowSupplyEvent{
+		Event:      "regimen.low_supply",
+		AccountID:  regimen.AccountID,
+		UserID:     regimen.UserID,
+		RegimenID:  regimen.ID,
+		PodID:      regimen.PodID,
+		Forecast:   forecast,
+		OccurredAt: now,
+	}
+	emitWebhook(requestID, regimen.AccountID, webhook.EventLowSupply, event)
+	name := os.Getenv("LOW_SUPPLY_LAMBDA")
+	if name == "" {
+		log.Printf("regimen %v is low on supply, set LOW_SUPPLY_LAMBDA to send the event", regimen.ID)
+		return
+	}
+	shutdown.Go("low supply lambda", func() {
+		if _, err := util.InvokeLambda(tracing.Context(db), requestID, name, event); err != nil {
+			util.CaptureException(requestID, err)
+		}
+	})
+}
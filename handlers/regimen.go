@@ -9,8 +9,8 @@ import (
 
 	"github.com/gorilla/context"
 	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
 	uuid "github.com/satori/go.uuid"
-	"github.com/tespo/buddha/db"
 	"github.com/tespo/buddha/util"
 	"github.com/tespo/satya/v2/scoping"
 	"github.com/tespo/satya/v2/types"
@@ -32,11 +32,7 @@ func GetAccountRegimens(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	db, err := db.Open()
-	if err != nil {
-		panic(err)
-	}
-	defer db.Close()
+	db := requestDB(r)
 
 	regimens := types.Regimens{}
 
@@ -45,11 +41,13 @@ func GetAccountRegimens(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	for i, regimen := range regimens {
-		regimens[i] = scoping.FilterByScopes(scopedFields.([]string), regimen).(types.Regimen)
+	responses, err := withForecasts(db, scopedFields.([]string), regimens...)
+	if err != nil {
+		util.ErrorResponder(w, http.StatusInternalServerError, err)
+		return
 	}
 
-	util.PaginationResponder(w, r, regimens)
+	util.PaginationResponder(w, r, responses)
 }
 
 //
@@ -67,11 +65,7 @@ func GetUserRegimens(w http.ResponseWriter, r *http.Request) {
 		util.ErrorResponder(w, http.StatusBadRequest, errors.New("No scopes"))
 		return
 	}
-	db, err := db.Open()
-	if err != nil {
-		panic(err)
-	}
-	defer db.Close()
+	db := requestDB(r)
 
 	user := types.User{
 		ID: uuid.FromStringOrNil(userID.(string)),
@@ -80,10 +74,12 @@ func GetUserRegimens(w http.ResponseWriter, r *http.Request) {
 		util.ErrorResponder(w, http.StatusInternalServerError, err)
 		return
 	}
-	for i, regimen := range user.Regimens {
-		user.Regimens[i] = scoping.FilterByScopes(scopedFields.([]string), regimen).(types.Regimen)
+	responses, err := withForecasts(db, scopedFields.([]string), user.Regimens...)
+	if err != nil {
+		util.ErrorResponder(w, http.StatusInternalServerError, err)
+		return
 	}
-	util.PaginationResponder(w, r, user.Regimens)
+	util.PaginationResponder(w, r, responses)
 }
 
 //
@@ -107,19 +103,20 @@ func GetAccountRegimensByID(w http.ResponseWriter, r *http.Request) {
 		util.ErrorResponder(w, http.StatusBadRequest, errors.New("No scopes"))
 		return
 	}
-	db, err := db.Open()
-	if err != nil {
-		panic(err)
-	}
-	defer db.Close()
+	db := requestDB(r)
 
 	var regimen types.Regimen
 	if err := regimen.GetAccountRegimenByID(db, uuid.FromStringOrNil(regimenID), uuid.FromStringOrNil(accountID.(string))); err != nil {
 		util.ErrorResponder(w, http.StatusInternalServerError, err)
 		return
 	}
+	responses, err := withForecasts(db, scopedFields.([]string), regimen)
+	if err != nil {
+		util.ErrorResponder(w, http.StatusInternalServerError, err)
+		return
+	}
 
-	util.JSONResponder(w, scoping.FilterByScopes(scopedFields.([]string), regimen))
+	util.JSONResponder(w, responses[0])
 }
 
 //
@@ -143,19 +140,20 @@ func GetUserRegimensByID(w http.ResponseWriter, r *http.Request) {
 		util.ErrorResponder(w, http.StatusBadRequest, errors.New("No scopes"))
 		return
 	}
-	db, err := db.Open()
-	if err != nil {
-		panic(err)
-	}
-	defer db.Close()
+	db := requestDB(r)
 
 	var regimen types.Regimen
 	if err := regimen.GetUserRegimenByID(db, uuid.FromStringOrNil(regimenID), uuid.FromStringOrNil(userID.(string))); err != nil {
 		util.ErrorResponder(w, http.StatusInternalServerError, err)
 		return
 	}
+	responses, err := withForecasts(db, scopedFields.([]string), regimen)
+	if err != nil {
+		util.ErrorResponder(w, http.StatusInternalServerError, err)
+		return
+	}
 
-	util.JSONResponder(w, scoping.FilterByScopes(scopedFields.([]string), regimen))
+	util.JSONResponder(w, responses[0])
 }
 
 //
@@ -191,11 +189,7 @@ func PutAccountRegimensByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	db, err := db.Open()
-	if err != nil {
-		panic(err)
-	}
-	defer db.Close()
+	db := requestDB(r)
 
 	currentRegimen := types.Regimen{}
 	if err := currentRegimen.GetByID(db, uuid.FromStringOrNil(regimenID)); err != nil {
@@ -223,19 +217,23 @@ func PutAccountRegimensByID(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		util.JSONResponder(w, scoping.FilterByScopes(scopedFields.([]string), regimen))
-	}
-
-	if err := db.Model(&existingRegimen).Association("Usages").Append(currentRegimen.Usages).Error; err != nil {
-		util.ErrorResponder(w, http.StatusInternalServerError, err)
-		return
-	}
-	existingRegimen.LastReportedServingsRemaining = currentRegimen.LastReportedServingsRemaining
-	if err := existingRegimen.Update(db); err != nil {
-		util.ErrorResponder(w, http.StatusInternalServerError, err)
 		return
 	}
-	deleteRegimen := types.Regimen{}
-	if err := deleteRegimen.Delete(db, uuid.FromStringOrNil(regimenID)); err != nil {
+
+	// The pod already has a regimen on the account, so this
+	// regimen is merged into it and removed
+	err = transaction(db, func(tx *gorm.DB) error {
+		if err := tx.Model(&existingRegimen).Association("Usages").Append(currentRegimen.Usages).Error; err != nil {
+			return err
+		}
+		existingRegimen.LastReportedServingsRemaining = currentRegimen.LastReportedServingsRemaining
+		if err := existingRegimen.Update(tx); err != nil {
+			return err
+		}
+		deleteRegimen := types.Regimen{}
+		return deleteRegimen.Delete(tx, uuid.FromStringOrNil(regimenID))
+	})
+	if err != nil {
 		util.ErrorResponder(w, http.StatusInternalServerError, err)
 		return
 	}
@@ -259,11 +257,7 @@ func DeleteAccountRegimenByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	db, err := db.Open()
-	if err != nil {
-		panic(err)
-	}
-	defer db.Close()
+	db := requestDB(r)
 
 	var regimen types.Regimen
 	if err := regimen.DeleteAccountRegimenByID(db, uuid.FromStringOrNil(regimenID), uuid.FromStringOrNil(accountID.(string))); err != nil {
@@ -278,12 +272,7 @@ func DeleteAccountRegimenByID(w http.ResponseWriter, r *http.Request) {
 // GetRegimen is the GET method for a regimen
 //
 func GetRegimen(w http.ResponseWriter, r *http.Request) {
-	db, err := db.Open()
-	if err != nil {
-		panic(err)
-	}
-	defer db.Close()
-	db = util.SetDBPagination(db, r)
+	db := util.SetDBPagination(requestDB(r), r)
 
 	var regimens types.Regimens
 	if err := regimens.Get(db); err != nil {
@@ -305,11 +294,7 @@ func GetRegimenByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	db, err := db.Open()
-	if err != nil {
-		panic(err)
-	}
-	defer db.Close()
+	db := requestDB(r)
 
 	var regimen types.Regimen
 	if err := regimen.GetByID(db, uuid.FromStringOrNil(regimenID)); err != nil {
@@ -317,7 +302,7 @@ func GetRegimenByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err = json.NewEncoder(w).Encode(regimen); err != nil {
+	if err := json.NewEncoder(w).Encode(regimen); err != nil {
 		panic(err)
 	}
 }
@@ -345,18 +330,14 @@ func PutRegimenByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	db, err := db.Open()
-	if err != nil {
-		panic(err)
-	}
-	defer db.Close()
+	db := requestDB(r)
 
 	if err := regimen.Update(db); err != nil {
 		util.ErrorResponder(w, http.StatusInternalServerError, err)
 		return
 	}
 
-	if err = json.NewEncoder(w).Encode(regimen); err != nil {
+	if err := json.NewEncoder(w).Encode(regimen); err != nil {
 		panic(err)
 	}
 }
@@ -373,11 +354,7 @@ func DeleteRegimenByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	db, err := db.Open()
-	if err != nil {
-		panic(err)
-	}
-	defer db.Close()
+	db := requestDB(r)
 
 	var regimen types.Regimen
 	if err := regimen.Delete(db, uuid.FromStringOrNil(id)); err != nil {
@@ -385,7 +362,7 @@ func DeleteRegimenByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err = json.NewEncoder(w).Encode(regimen); err != nil {
+	if err := json.NewEncoder(w).Encode(regimen); err != nil {
 		panic(err)
 	}
 
@@ -11,7 +11,6 @@ import (
 	"github.com/gorilla/context"
 	"github.com/gorilla/mux"
 	uuid "github.com/satori/go.uuid"
-	"github.com/tespo/buddha/db"
 	"github.com/tespo/buddha/util"
 	"github.com/tespo/satya/v2/scoping"
 	"github.com/tespo/satya/v2/types"
@@ -37,11 +36,7 @@ func GetUserRemindersByRegimenID(w http.ResponseWriter, r *http.Request) {
 		util.ErrorResponder(w, http.StatusBadRequest, errors.New("No scopes"))
 		return
 	}
-	db, err := db.Open()
-	if err != nil {
-		panic(err)
-	}
-	defer db.Close()
+	db := requestDB(r)
 	userUUID := uuid.FromStringOrNil(userID.(string))
 	regimen := types.Regimen{
 		ID:     uuid.FromStringOrNil(regimenID),
@@ -72,11 +67,7 @@ func GetReminders(w http.ResponseWriter, r *http.Request) {
 		util.ErrorResponder(w, http.StatusBadRequest, errors.New("No scopes"))
 		return
 	}
-	db, err := db.Open()
-	if err != nil {
-		panic(err)
-	}
-	defer db.Close()
+	db := requestDB(r)
 	userUUID := uuid.FromStringOrNil(userID.(string))
 
 	reminders := types.Reminders{}
@@ -124,11 +115,7 @@ func PostReminder(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	db, err := db.Open()
-	if err != nil {
-		panic(err)
-	}
-	defer db.Close()
+	db := requestDB(r)
 	userUUID := uuid.FromStringOrNil(userID.(string))
 	regimen := types.Regimen{
 		ID:        uuid.FromStringOrNil(regimenID),
@@ -141,7 +128,7 @@ func PostReminder(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err = json.NewEncoder(w).Encode(regimen.Reminders); err != nil {
+	if err := json.NewEncoder(w).Encode(regimen.Reminders); err != nil {
 		panic(err)
 	}
 }
@@ -183,11 +170,7 @@ func PutReminderByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	db, err := db.Open()
-	if err != nil {
-		panic(err)
-	}
-	defer db.Close()
+	db := requestDB(r)
 
 	userUUID := uuid.FromStringOrNil(userID.(string))
 	regimen := types.Regimen{
@@ -201,7 +184,7 @@ func PutReminderByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err = json.NewEncoder(w).Encode(regimen.Reminders); err != nil {
+	if err := json.NewEncoder(w).Encode(regimen.Reminders); err != nil {
 		panic(err)
 	}
 }
@@ -230,11 +213,7 @@ func DeleteReminderByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	db, err := db.Open()
-	if err != nil {
-		panic(err)
-	}
-	defer db.Close()
+	db := requestDB(r)
 
 	userUUID := uuid.FromStringOrNil(userID.(string))
 
@@ -256,7 +235,7 @@ func DeleteReminderByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err = json.NewEncoder(w).Encode(regimen.Reminders); err != nil {
+	if err := json.NewEncoder(w).Encode(regimen.Reminders); err != nil {
 		panic(err)
 	}
 }
@@ -9,7 +9,6 @@ import (
 
 	"github.com/gorilla/mux"
 	uuid "github.com/satori/go.uuid"
-	"github.com/tespo/buddha/db"
 	"github.com/tespo/buddha/util"
 	"github.com/tespo/satya/v2/types"
 )
@@ -20,12 +19,7 @@ import (
 func GetRoles(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	db, err := db.Open()
-	if err != nil {
-		panic(err)
-	}
-	defer db.Close()
-	db = util.SetDBPagination(db, r)
+	db := util.SetDBPagination(requestDB(r), r)
 
 	var roles types.Roles
 	if err := roles.Get(db); err != nil {
@@ -48,11 +42,7 @@ func GetRolesByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	db, err := db.Open()
-	if err != nil {
-		panic(err)
-	}
-	defer db.Close()
+	db := requestDB(r)
 
 	var role types.Role
 	if err := role.GetByID(db, uuid.FromStringOrNil(id)); err != nil {
@@ -60,7 +50,7 @@ func GetRolesByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err = json.NewEncoder(w).Encode(role); err != nil {
+	if err := json.NewEncoder(w).Encode(role); err != nil {
 		panic(err)
 	}
 }
@@ -79,18 +69,14 @@ func PostRoles(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	db, err := db.Open()
-	if err != nil {
-		panic(err)
-	}
-	defer db.Close()
+	db := requestDB(r)
 
 	if err := role.Create(db); err != nil {
 		util.ErrorResponder(w, http.StatusInternalServerError, err)
 		return
 	}
 
-	if err = json.NewEncoder(w).Encode(role); err != nil {
+	if err := json.NewEncoder(w).Encode(role); err != nil {
 		panic(err)
 	}
 }
@@ -119,18 +105,14 @@ func PutRolesByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	db, err := db.Open()
-	if err != nil {
-		panic(err)
-	}
-	defer db.Close()
+	db := requestDB(r)
 
 	if err := role.Update(db); err != nil {
 		util.ErrorResponder(w, http.StatusInternalServerError, err)
 		return
 	}
 
-	if err = json.NewEncoder(w).Encode(role); err != nil {
+	if err := json.NewEncoder(w).Encode(role); err != nil {
 		panic(err)
 	}
 }
@@ -147,11 +129,7 @@ func DeleteRolesByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	db, err := db.Open()
-	if err != nil {
-		panic(err)
-	}
-	defer db.Close()
+	db := requestDB(r)
 
 	var role types.Role
 	if err := role.Delete(db, uuid.FromStringOrNil(id)); err != nil {
@@ -159,7 +137,7 @@ func DeleteRolesByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err = json.NewEncoder(w).Encode(role); err != nil {
+	if err := json.NewEncoder(w).Encode(role); err != nil {
 		panic(err)
 	}
 }
@@ -177,11 +155,7 @@ func AddPermissionToRoleByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	db, err := db.Open()
-	if err != nil {
-		panic(err)
-	}
-	defer db.Close()
+	db := requestDB(r)
 
 	var role types.Role
 	if err := role.GetByID(db, uuid.FromStringOrNil(roleID)); err != nil {
@@ -194,7 +168,7 @@ func AddPermissionToRoleByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err = json.NewEncoder(w).Encode(role); err != nil {
+	if err := json.NewEncoder(w).Encode(role); err != nil {
 		panic(err)
 	}
 }
@@ -209,11 +183,7 @@ func DeletePermissionFromRole(w http.ResponseWriter, r *http.Request) {
 		util.ErrorResponder(w, http.StatusBadRequest, errors.New("No ID supplied"))
 		return
 	}
-	db, err := db.Open()
-	if err != nil {
-		panic(err)
-	}
-	defer db.Close()
+	db := requestDB(r)
 
 	permission := types.Permission{}
 	if err := permission.GetByID(db, uuid.FromStringOrNil(permissionID)); err != nil {
@@ -244,11 +214,7 @@ func GetRoleWithPermissions(w http.ResponseWriter, r *http.Request) {
 		util.ErrorResponder(w, http.StatusBadRequest, errors.New("No ID supplied"))
 		return
 	}
-	db, err := db.Open()
-	if err != nil {
-		panic(err)
-	}
-	defer db.Close()
+	db := requestDB(r)
 
 	var role types.Role
 	if err := role.GetByID(db.Preload("Permissions"), uuid.FromStringOrNil(roleID)); err != nil {
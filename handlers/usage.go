@@ -12,7 +12,6 @@ import (
 	"github.com/gorilla/context"
 	"github.com/gorilla/mux"
 	uuid "github.com/satori/go.uuid"
-	"github.com/tespo/buddha/db"
 	"github.com/tespo/buddha/util"
 	"github.com/tespo/satya/v2/types"
 )
@@ -33,11 +32,7 @@ func GetUserUsages(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	db, err := db.Open()
-	if err != nil {
-		panic(err)
-	}
-	defer db.Close()
+	db := requestDB(r)
 
 	var usages types.Usages
 	if err := usages.GetByQuery(db, "user_id = ?", uuid.FromStringOrNil(userID.(string))); err != nil {
@@ -73,11 +68,7 @@ func GetUserUsageByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	db, err := db.Open()
-	if err != nil {
-		panic(err)
-	}
-	defer db.Close()
+	db := requestDB(r)
 
 	var usages types.Usages
 	if err := usages.GetByQuery(db, "id = ? AND user_id = ?", uuid.FromStringOrNil(id), uuid.FromStringOrNil(userID.(string))); err != nil {
@@ -89,7 +80,7 @@ func GetUserUsageByID(w http.ResponseWriter, r *http.Request) {
 		usages[i] = scoping.FilterByScopes(scopedFields.([]string), usage).(types.Usage)
 	}
 
-	if err = json.NewEncoder(w).Encode(usages); err != nil {
+	if err := json.NewEncoder(w).Encode(usages); err != nil {
 		panic(err)
 	}
 }
@@ -124,11 +115,7 @@ func PutUserUsageByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	db, err := db.Open()
-	if err != nil {
-		panic(err)
-	}
-	defer db.Close()
+	db := requestDB(r)
 
 	var usages types.Usages
 	if err := usages.GetByQuery(db, "id = ? AND user_id = ?", uuid.FromStringOrNil(id), uuid.FromStringOrNil(userID.(string))); err != nil {
@@ -145,7 +132,7 @@ func PutUserUsageByID(w http.ResponseWriter, r *http.Request) {
 		usages[i] = scoping.FilterByScopes(scopedFields.([]string), usage).(types.Usage)
 	}
 
-	if err = json.NewEncoder(w).Encode(usages); err != nil {
+	if err := json.NewEncoder(w).Encode(usages); err != nil {
 		panic(err)
 	}
 }
@@ -166,11 +153,7 @@ func GetAccountUsages(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	db, err := db.Open()
-	if err != nil {
-		panic(err)
-	}
-	defer db.Close()
+	db := requestDB(r)
 
 	var usages types.Usages
 	if err := usages.GetByQuery(db, "account_id = ?", uuid.FromStringOrNil(userID.(string))); err != nil {
@@ -202,11 +185,7 @@ func GetAccountUsageByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	db, err := db.Open()
-	if err != nil {
-		panic(err)
-	}
-	defer db.Close()
+	db := requestDB(r)
 
 	var usage types.Usage
 	if err := usage.GetByQuery(db, "id = ?", uuid.FromStringOrNil(id)); err != nil {
@@ -242,11 +221,7 @@ func PutAccountUsageByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	db, err := db.Open()
-	if err != nil {
-		panic(err)
-	}
-	defer db.Close()
+	db := requestDB(r)
 
 	var usage types.Usage
 	if err := usage.GetByID(db, uuid.FromStringOrNil(id)); err != nil {
@@ -273,12 +248,7 @@ func PutAccountUsageByID(w http.ResponseWriter, r *http.Request) {
 func GetUsages(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	db, err := db.Open()
-	if err != nil {
-		panic(err)
-	}
-	defer db.Close()
-	db = util.SetDBPagination(db, r)
+	db := util.SetDBPagination(requestDB(r), r)
 
 	var usages types.Usages
 	if err := usages.Get(db); err != nil {
@@ -301,11 +271,7 @@ func GetUsagesByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	db, err := db.Open()
-	if err != nil {
-		panic(err)
-	}
-	defer db.Close()
+	db := requestDB(r)
 
 	var usage types.Usage
 	if err := usage.GetByID(db, uuid.FromStringOrNil(id)); err != nil {
@@ -313,7 +279,7 @@ func GetUsagesByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err = json.NewEncoder(w).Encode(usage); err != nil {
+	if err := json.NewEncoder(w).Encode(usage); err != nil {
 		panic(err)
 	}
 }
@@ -332,18 +298,14 @@ func PostUsages(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	db, err := db.Open()
-	if err != nil {
-		panic(err)
-	}
-	defer db.Close()
+	db := requestDB(r)
 
 	if err := usage.Create(db); err != nil {
 		util.ErrorResponder(w, http.StatusInternalServerError, err)
 		return
 	}
 
-	if err = json.NewEncoder(w).Encode(usage); err != nil {
+	if err := json.NewEncoder(w).Encode(usage); err != nil {
 		panic(err)
 	}
 }
@@ -372,18 +334,14 @@ func PutUsagesByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	db, err := db.Open()
-	if err != nil {
-		panic(err)
-	}
-	defer db.Close()
+	db := requestDB(r)
 
 	if err := usage.Update(db); err != nil {
 		util.ErrorResponder(w, http.StatusInternalServerError, err)
 		return
 	}
 
-	if err = json.NewEncoder(w).Encode(usage); err != nil {
+	if err := json.NewEncoder(w).Encode(usage); err != nil {
 		panic(err)
 	}
 }
@@ -400,11 +358,7 @@ func DeleteUsagesByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	db, err := db.Open()
-	if err != nil {
-		panic(err)
-	}
-	defer db.Close()
+	db := requestDB(r)
 
 	var usage types.Usage
 	if err := usage.Delete(db, uuid.FromStringOrNil(id)); err != nil {
@@ -412,7 +366,7 @@ func DeleteUsagesByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err = json.NewEncoder(w).Encode(usage); err != nil {
+	if err := json.NewEncoder(w).Encode(usage); err != nil {
 		panic(err)
 	}
 }
@@ -9,8 +9,8 @@ import (
 
 	"github.com/gorilla/context"
 	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
 	uuid "github.com/satori/go.uuid"
-	"github.com/tespo/buddha/db"
 	"github.com/tespo/buddha/util"
 	"github.com/tespo/satya/v2/scoping"
 	"github.com/tespo/satya/v2/types"
@@ -30,11 +30,7 @@ func GetSelfUser(w http.ResponseWriter, r *http.Request) {
 		util.ErrorResponder(w, http.StatusBadRequest, errors.New("No scopes"))
 		return
 	}
-	db, err := db.Open()
-	if err != nil {
-		panic(err)
-	}
-	defer db.Close()
+	db := requestDB(r)
 	user := types.User{
 		ID: uuid.FromStringOrNil(userID.(string)),
 	}
@@ -72,11 +68,7 @@ func PutSelfUser(w http.ResponseWriter, r *http.Request) {
 		util.ErrorResponder(w, http.StatusBadRequest, err)
 		return
 	}
-	db, err := db.Open()
-	if err != nil {
-		panic(err)
-	}
-	defer db.Close()
+	db := requestDB(r)
 	user.ID = uuid.FromStringOrNil(userID.(string))
 	user.AccountID = uuid.FromStringOrNil(accountID.(string))
 	if err := user.Update(db); err != nil {
@@ -93,12 +85,7 @@ func PutSelfUser(w http.ResponseWriter, r *http.Request) {
 func GetUsers(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	db, err := db.Open()
-	if err != nil {
-		panic(err)
-	}
-	defer db.Close()
-	db = util.SetDBPagination(db, r)
+	db := util.SetDBPagination(requestDB(r), r)
 
 	var users types.Users
 	if err := users.Get(db); err != nil {
@@ -121,11 +108,7 @@ func GetUserByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	db, err := db.Open()
-	if err != nil {
-		panic(err)
-	}
-	defer db.Close()
+	db := requestDB(r)
 
 	var user types.User
 	if err := user.GetByID(db, uuid.FromStringOrNil(id)); err != nil {
@@ -133,7 +116,7 @@ func GetUserByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err = json.NewEncoder(w).Encode(user); err != nil {
+	if err := json.NewEncoder(w).Encode(user); err != nil {
 		panic(err)
 	}
 }
@@ -152,11 +135,7 @@ func PostUsers(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	db, err := db.Open()
-	if err != nil {
-		panic(err)
-	}
-	defer db.Close()
+	db := requestDB(r)
 
 	if err := user.Create(db); err != nil {
 		util.ErrorResponder(w, http.StatusInternalServerError, err)
@@ -192,18 +171,14 @@ func PutUsersByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	db, err := db.Open()
-	if err != nil {
-		panic(err)
-	}
-	defer db.Close()
+	db := requestDB(r)
 
 	if err := user.Update(db); err != nil {
 		util.ErrorResponder(w, http.StatusInternalServerError, err)
 		return
 	}
 
-	if err = json.NewEncoder(w).Encode(user); err != nil {
+	if err := json.NewEncoder(w).Encode(user); err != nil {
 		panic(err)
 	}
 }
@@ -220,11 +195,7 @@ func DeleteUsersByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	db, err := db.Open()
-	if err != nil {
-		panic(err)
-	}
-	defer db.Close()
+	db := requestDB(r)
 
 	var user types.User
 	if err := user.Delete(db, uuid.FromStringOrNil(id)); err != nil {
@@ -232,7 +203,7 @@ func DeleteUsersByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err = json.NewEncoder(w).Encode(user); err != nil {
+	if err := json.NewEncoder(w).Encode(user); err != nil {
 		panic(err)
 	}
 }
@@ -249,11 +220,7 @@ func GetUsersByAccountID(w http.ResponseWriter, r *http.Request) {
 
 	var account types.Account
 
-	db, err := db.Open()
-	if err != nil {
-		panic(err)
-	}
-	defer db.Close()
+	db := requestDB(r)
 	if err := account.GetByID(db, uuid.FromStringOrNil(accountID)); err != nil {
 		util.ErrorResponder(w, http.StatusNoContent, err)
 		return
@@ -280,11 +247,7 @@ func GetAccountUsers(w http.ResponseWriter, r *http.Request) {
 		util.ErrorResponder(w, http.StatusBadRequest, errors.New("No scopes"))
 		return
 	}
-	db, err := db.Open()
-	if err != nil {
-		panic(err)
-	}
-	defer db.Close()
+	db := requestDB(r)
 	account := types.Account{
 		ID: uuid.FromStringOrNil(accountID.(string)),
 	}
@@ -318,11 +281,7 @@ func GetAccountUsersByUserID(w http.ResponseWriter, r *http.Request) {
 		util.ErrorResponder(w, http.StatusBadRequest, errors.New("No scopes"))
 		return
 	}
-	db, err := db.Open()
-	if err != nil {
-		panic(err)
-	}
-	defer db.Close()
+	db := requestDB(r)
 	account := types.Account{
 		ID: uuid.FromStringOrNil(accountID.(string)),
 	}
@@ -355,11 +314,7 @@ func CreateAccountUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	db, err := db.Open()
-	if err != nil {
-		panic(err)
-	}
-	defer db.Close()
+	db := requestDB(r)
 	account := types.Account{
 		ID: uuid.FromStringOrNil(accountID.(string)),
 	}
@@ -401,11 +356,7 @@ func PutAccountUsersByUserID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	db, err := db.Open()
-	if err != nil {
-		panic(err)
-	}
-	defer db.Close()
+	db := requestDB(r)
 	account := types.Account{
 		ID: uuid.FromStringOrNil(accountID.(string)),
 	}
@@ -440,11 +391,7 @@ func DeleteAccountUserByID(w http.ResponseWriter, r *http.Request) {
 		util.ErrorResponder(w, http.StatusBadRequest, errors.New("cannot delete yourself"))
 		return
 	}
-	db, err := db.Open()
-	if err != nil {
-		panic(err)
-	}
-	defer db.Close()
+	db := requestDB(r)
 
 	var user types.User
 	if err := user.GetByID(db, uuid.FromStringOrNil(requestedUserID.(string))); err != nil {
@@ -461,34 +408,31 @@ func DeleteAccountUserByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	userRegimens := types.Regimens{}
-	if err := userRegimens.GetByQuery(db, "user_id = ?", uuid.FromStringOrNil(userID)); err != nil {
-		util.ErrorResponder(w, http.StatusInternalServerError, err)
-		return
-	}
-
-	for _, regimen := range userRegimens {
-		userUsages := types.Usages{}
-		if err := userUsages.GetByQuery(db, "regimen_id = ?", uuid.FromStringOrNil(userID)); err != nil {
-			util.ErrorResponder(w, http.StatusInternalServerError, err)
-			return
+	err := transaction(db, func(tx *gorm.DB) error {
+		userRegimens := types.Regimens{}
+		if err := userRegimens.GetByQuery(tx, "user_id = ?", deleteUser.ID); err != nil {
+			return err
 		}
-		for _, usage := range userUsages {
-			usage.UserID = nil
-			if err := usage.Update(db); err != nil {
-				util.ErrorResponder(w, http.StatusInternalServerError, err)
-				return
+		for _, regimen := range userRegimens {
+			userUsages := types.Usages{}
+			if err := userUsages.GetByQuery(tx, "regimen_id = ?", regimen.ID); err != nil {
+				return err
+			}
+			for _, usage := range userUsages {
+				usage.UserID = nil
+				if err := usage.Update(tx); err != nil {
+					return err
+				}
+			}
+			regimen.UserID = nil
+			regimen.User = types.User{}
+			if err := regimen.Update(tx); err != nil {
+				return err
 			}
 		}
-		regimen.UserID = nil
-		regimen.User = types.User{}
-		if err := regimen.Update(db); err != nil {
-			util.ErrorResponder(w, http.StatusInternalServerError, err)
-			return
-		}
-	}
-
-	if err := deleteUser.Delete(db, deleteUser.ID); err != nil {
+		return deleteUser.Delete(tx, deleteUser.ID)
+	})
+	if err != nil {
 		util.ErrorResponder(w, http.StatusInternalServerError, err)
 		return
 	}
@@ -512,11 +456,7 @@ func PostUsersByAccountID(w http.ResponseWriter, r *http.Request) {
 		util.ErrorResponder(w, http.StatusBadRequest, err)
 		return
 	}
-	db, err := db.Open()
-	if err != nil {
-		panic(err)
-	}
-	defer db.Close()
+	db := requestDB(r)
 	user.AccountID = uuid.FromStringOrNil(accountID)
 	if err := user.Create(db); err != nil {
 		util.ErrorResponder(w, http.StatusInternalServerError, err)
@@ -557,11 +497,7 @@ func PutUsersByAccountIDAndUserID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	db, err := db.Open()
-	if err != nil {
-		panic(err)
-	}
-	defer db.Close()
+	db := requestDB(r)
 	user.ID = uuid.FromStringOrNil(userID)
 	user.AccountID = uuid.FromStringOrNil(accountID)
 	if err := user.Update(db); err != nil {
@@ -593,11 +529,7 @@ func PutUsersByExternalID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	db, err := db.Open()
-	if err != nil {
-		panic(err)
-	}
-	defer db.Close()
+	db := requestDB(r)
 
 	if queryErr := user.GetByQuery(db, "external_id = ?", externalID); queryErr != nil {
 		util.ErrorResponder(w, http.StatusBadRequest, errors.New("Error getting user by external ID"))
@@ -639,11 +571,7 @@ func DeleteUsersByAccountIDAndUserID(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var user types.User
-	db, err := db.Open()
-	if err != nil {
-		panic(err)
-	}
-	defer db.Close()
+	db := requestDB(r)
 	user.ID = uuid.FromStringOrNil(userID)
 	user.AccountID = uuid.FromStringOrNil(accountID)
 	if err := user.Delete(db, user.ID); err != nil {
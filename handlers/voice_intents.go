@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	uuid "github.com/satori/go.uuid"
+	"github.com/tespo/buddha/scheduler"
+	"github.com/tespo/buddha/util"
+	"github.com/tespo/buddha/voice"
+	"github.com/tespo/satya/v2/types"
+)
+
+//
+// voiceAnswer is what a voice assistant says back
+//
+type voiceAnswer struct {
+	Speech string
+}
+
+//
+// voiceIntentHandler answers a question the user asked a voice
+// assistant. Handlers do not know which assistant was asked
+//
+type voiceIntentHandler func(db *gorm.DB, user types.User, now time.Time) (voiceAnswer, error)
+
+//
+// voiceSorry is said when answering a question failed
+//
+const voiceSorry = "Sorry, I couldn't get that from Tespo right now."
+
+//
+// voiceQuestion registers the handler for an intent, apologising
+// when answering fails
+//
+func voiceQuestion(handler voiceIntentHandler) voice.Handler {
+	return func(user types.User, request voice.Request) (voice.Response, error) {
+		answer, err := handler(voiceDB(request), user, time.Now())
+		if err != nil {
+			util.CaptureException(request.RequestID, err)
+			answer = voiceAnswer{Speech: voiceSorry}
+		}
+		return voice.Response{Speech: answer.Speech}, nil
+	}
+}
+
+//
+// servingsRemainingIntent answers how many servings are left
+// in each of the user's regimens
+//
+func servingsRemainingIntent(db *gorm.DB, user types.User, now time.Time) (voiceAnswer, error) {
+	regimens := types.Regimens{}
+	if err := regimens.GetByQuery(db, "user_id = ?", user.ID); err != nil {
+		return voiceAnswer{}, err
+	}
+	if len(regimens) == 0 {
+		return voiceAnswer{Speech: "You don't have any pods set up yet."}, nil
+	}
+	if len(regimens) == 1 {
+		return voiceAnswer{Speech: fmt.Sprintf("You have %v left.", servings(regimens[0].LastReportedServingsRemaining))}, nil
+	}
+	parts := make([]string, len(regimens))
+	for i, regimen := range regimens {
+		parts[i] = fmt.Sprintf("%v of %v", servings(regimen.LastReportedServingsRemaining), regimenName(db, regimen))
+	}
+	return voiceAnswer{Speech: "You have " + spokenList(parts) + " left."}, nil
+}
+
+//
+// takenTodayIntent answers whether the user has dispensed
+// anything since midnight in their timezone
+//
+func takenTodayIntent(db *gorm.DB, user types.User, now time.Time) (voiceAnswer, error) {
+	location := scheduler.MetaLocation(time.UTC, user.Meta)
+	local := now.In(location)
+	midnight := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, location)
+	usages := types.Usages{}
+	if err := usages.GetByQuery(db, "user_id = ? AND created_at BETWEEN ? AND ?", user.ID, midnight, now); err != nil {
+		return voiceAnswer{}, err
+	}
+	if len(usages) == 0 {
+		return voiceAnswer{Speech: "No, you haven't taken your vitamins today."}, nil
+	}
+	last := usages[0].CreatedAt
+	for _, usage := range usages {
+		if usage.CreatedAt.After(last) {
+			last = usage.CreatedAt
+		}
+	}
+	return voiceAnswer{Speech: "Yes, you last took your vitamins at " + last.In(location).Format("3:04 PM") + "."}, nil
+}
+
+//
+// nextReminderIntent answers when the next of the
+// user's reminders goes off
+//
+func nextReminderIntent(db *gorm.DB, user types.User, now time.Time) (voiceAnswer, error) {
+	reminders := types.Reminders{}
+	if err := reminders.GetByQuery(db, "user_id = ?", user.ID); err != nil {
+		return voiceAnswer{}, err
+	}
+	if len(reminders) == 0 {
+		return voiceAnswer{Speech: "You don't have any reminders set."}, nil
+	}
+	times := []time.Time{}
+	for _, reminder := range reminders {
+		location := scheduler.MetaLocation(time.UTC, reminder.Meta, user.Meta)
+		times = append(times, scheduler.Occurrences(reminder.Minute, location, now, now.AddDate(0, 0, 1))...)
+	}
+	if len(times) == 0 {
+		return voiceAnswer{Speech: "You don't have any reminders coming up."}, nil
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+	next := times[0]
+	day := "today"
+	if next.Day() != now.In(next.Location()).Day() {
+		day = "tomorrow"
+	}
+	return voiceAnswer{Speech: "Your next reminder is at " + next.Format("3:04 PM") + " " + day + "."}, nil
+}
+
+//
+// regimenName is the name of the regimen's pod
+//
+func regimenName(db *gorm.DB, regimen types.Regimen) string {
+	pod := types.Pod{}
+	if regimen.PodID == nil || *regimen.PodID == uuid.Nil || pod.GetByID(db, *regimen.PodID) != nil || pod.Name == "" {
+		return "your pod"
+	}
+	return pod.Name
+}
+
+func servings(count uint) string {
+	if count == 1 {
+		return "1 serving"
+	}
+	return fmt.Sprintf("%v servings", count)
+}
+
+//
+// spokenList joins the parts as they would be said
+//
+func spokenList(parts []string) string {
+	if len(parts) < 2 {
+		return strings.Join(parts, "")
+	}
+	return strings.Join(parts[:len(parts)-1], ", ") + " and " + parts[len(parts)-1]
+}
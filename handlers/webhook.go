@@ -0,0 +1,456 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+
+	"github.com/gorilla/context"
+	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
+	uuid "github.com/satori/go.uuid"
+	"github.com/tespo/buddha/models"
+	"github.com/tespo/buddha/util"
+	"github.com/tespo/buddha/webhook"
+)
+
+//
+// webhooks delivers account events. Events
+// are not sent when it is not set
+//
+var webhooks *webhook.Dispatcher
+
+//
+// SetWebhooks injects the dispatcher built on
+// startup into the handlers
+//
+func SetWebhooks(dispatcher *webhook.Dispatcher) {
+	webhooks = dispatcher
+}
+
+//
+// emitWebhook sends the event to the endpoints subscribed to it
+//
+func emitWebhook(requestID string, accountID uuid.UUID, event string, data interface{}) {
+	if webhooks == nil {
+		return
+	}
+	if err := webhooks.Emit(accountID, event, data); err != nil {
+		util.CaptureException(requestID, err)
+	}
+}
+
+var errWebhookNotFound = errors.New("webhook cannot be found")
+
+//
+// webhookRequest is the body of a request registering
+// or changing a webhook endpoint
+//
+type webhookRequest struct {
+	AccountID   *uuid.UUID `json:"account_id"`
+	URL         string     `json:"url"`
+	Events      []string   `json:"events"`
+	Description string     `json:"description"`
+	Secret      string     `json:"secret"`
+	Active      *bool      `json:"active"`
+}
+
+//
+// webhookWithSecret is a new endpoint along with its
+// secret, which is only shown when it is registered
+//
+type webhookWithSecret struct {
+	models.WebhookEndpoint
+	Secret string `json:"secret"`
+}
+
+//
+// GetAccountWebhooks is the GET method for the account's webhooks
+//
+func GetAccountWebhooks(w http.ResponseWriter, r *http.Request) {
+	accountID, ok := webhookAccount(w, r)
+	if !ok {
+		return
+	}
+	listWebhooks(w, r, &accountID)
+}
+
+//
+// PostAccountWebhook is the POST method to register a webhook
+// endpoint for the account's events
+//
+func PostAccountWebhook(w http.ResponseWriter, r *http.Request) {
+	accountID, ok := webhookAccount(w, r)
+	if !ok {
+		return
+	}
+	createWebhook(w, r, &accountID)
+}
+
+//
+// GetAccountWebhookByID is the GET method for one of the account's webhooks
+//
+func GetAccountWebhookByID(w http.ResponseWriter, r *http.Request) {
+	accountID, ok := webhookAccount(w, r)
+	if !ok {
+		return
+	}
+	getWebhook(w, r, &accountID)
+}
+
+//
+// PutAccountWebhookByID is the PUT method for one of the account's webhooks
+//
+func PutAccountWebhookByID(w http.ResponseWriter, r *http.Request) {
+	accountID, ok := webhookAccount(w, r)
+	if !ok {
+		return
+	}
+	updateWebhook(w, r, &accountID)
+}
+
+//
+// DeleteAccountWebhookByID is the DELETE method for one of the account's webhooks
+//
+func DeleteAccountWebhookByID(w http.ResponseWriter, r *http.Request) {
+	accountID, ok := webhookAccount(w, r)
+	if !ok {
+		return
+	}
+	deleteWebhook(w, r, &accountID)
+}
+
+//
+// GetAccountWebhookDeliveries is the GET method for the
+// delivery log of one of the account's webhooks
+//
+func GetAccountWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	accountID, ok := webhookAccount(w, r)
+	if !ok {
+		return
+	}
+	listWebhookDeliveries(w, r, &accountID)
+}
+
+//
+// PostAccountWebhookReplay is the POST method to send a
+// delivery of one of the account's webhooks again
+//
+func PostAccountWebhookReplay(w http.ResponseWriter, r *http.Request) {
+	accountID, ok := webhookAccount(w, r)
+	if !ok {
+		return
+	}
+	replayWebhookDelivery(w, r, &accountID)
+}
+
+// Dev routes
+
+//
+// GetWebhooks is the GET method for every webhook, or the
+// webhooks of the account in the account_id query parameter
+//
+func GetWebhooks(w http.ResponseWriter, r *http.Request) {
+	if id := r.URL.Query().Get("account_id"); id != "" {
+		accountID := uuid.FromStringOrNil(id)
+		listWebhooks(w, r, &accountID)
+		return
+	}
+	listWebhooks(w, r, nil)
+}
+
+//
+// PostWebhook is the POST method to register a webhook endpoint. An
+// endpoint without an account_id receives the events of every account
+//
+func PostWebhook(w http.ResponseWriter, r *http.Request) {
+	createWebhook(w, r, nil)
+}
+
+//
+// GetWebhookByID is the GET method for a webhook
+//
+func GetWebhookByID(w http.ResponseWriter, r *http.Request) {
+	getWebhook(w, r, nil)
+}
+
+//
+// PutWebhookByID is the PUT method for a webhook
+//
+func PutWebhookByID(w http.ResponseWriter, r *http.Request) {
+	updateWebhook(w, r, nil)
+}
+
+//
+// DeleteWebhookByID is the DELETE method for a webhook
+//
+func DeleteWebhookByID(w http.ResponseWriter, r *http.Request) {
+	deleteWebhook(w, r, nil)
+}
+
+//
+// GetWebhookDeliveries is the GET method for the delivery log of a webhook
+//
+func GetWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	listWebhookDeliveries(w, r, nil)
+}
+
+//
+// PostWebhookReplay is the POST method to send a delivery of a webhook again
+//
+func PostWebhookReplay(w http.ResponseWriter, r *http.Request) {
+	replayWebhookDelivery(w, r, nil)
+}
+
+func webhookAccount(w http.ResponseWriter, r *http.Request) (uuid.UUID, bool) {
+	w.Header().Set("Content-Type", "application/json")
+	accountID, ok := context.GetOk(r, "account_id")
+	if !ok {
+		util.ErrorResponder(w, http.StatusInternalServerError, errors.New("Cannot process token claims"))
+		return uuid.Nil, false
+	}
+	return uuid.FromStringOrNil(accountID.(string)), true
+}
+
+func listWebhooks(w http.ResponseWriter, r *http.Request, accountID *uuid.UUID) {
+	w.Header().Set("Content-Type", "application/json")
+	db := requestDB(r)
+	endpoints := models.WebhookEndpoints{}
+	var err error
+	if accountID != nil {
+		err = endpoints.GetByQuery(util.SetDBPagination(db, r), "account_id = ?", *accountID)
+	} else {
+		err = util.SetDBPagination(db, r).Find(&endpoints).Error
+	}
+	if err != nil {
+		util.ErrorResponder(w, http.StatusInternalServerError, err)
+		return
+	}
+	util.PaginationResponder(w, r, endpoints)
+}
+
+func createWebhook(w http.ResponseWriter, r *http.Request, accountID *uuid.UUID) {
+	w.Header().Set("Content-Type", "application/json")
+	body := webhookRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		util.ErrorResponder(w, http.StatusBadRequest, err)
+		return
+	}
+	if accountID == nil {
+		accountID = body.AccountID
+	}
+	if err := validateWebhookRequest(body, accountID); err != nil {
+		util.ErrorResponder(w, http.StatusBadRequest, err)
+		return
+	}
+	endpoint := models.WebhookEndpoint{
+		AccountID:   accountID,
+		URL:         body.URL,
+		EventList:   body.Events,
+		Description: body.Description,
+		Secret:      body.Secret,
+		Active:      body.Active == nil || *body.Active,
+	}
+	if endpoint.Secret == "" {
+		secret, err := webhookSecret()
+		if err != nil {
+			util.ErrorResponder(w, http.StatusInternalServerError, err)
+			return
+		}
+		endpoint.Secret = secret
+	}
+	db := requestDB(r)
+	if err := endpoint.Create(db); err != nil {
+		util.ErrorResponder(w, http.StatusInternalServerError, err)
+		return
+	}
+	util.JSONResponder(w, webhookWithSecret{WebhookEndpoint: endpoint, Secret: endpoint.Secret})
+}
+
+func getWebhook(w http.ResponseWriter, r *http.Request, accountID *uuid.UUID) {
+	w.Header().Set("Content-Type", "application/json")
+	endpoint, err := findWebhook(requestDB(r), mux.Vars(r)["webhook_id"], accountID)
+	if err != nil {
+		webhookErrorResponder(w, err)
+		return
+	}
+	util.JSONResponder(w, endpoint)
+}
+
+func updateWebhook(w http.ResponseWriter, r *http.Request, accountID *uuid.UUID) {
+	w.Header().Set("Content-Type", "application/json")
+	body := webhookRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		util.ErrorResponder(w, http.StatusBadRequest, err)
+		return
+	}
+	db := requestDB(r)
+	endpoint, err := findWebhook(db, mux.Vars(r)["webhook_id"], accountID)
+	if err != nil {
+		webhookErrorResponder(w, err)
+		return
+	}
+	if body.URL != "" {
+		endpoint.URL = body.URL
+	}
+	if body.Events != nil {
+		endpoint.EventList = body.Events
+	}
+	if body.Description != "" {
+		endpoint.Description = body.Description
+	}
+	if body.Secret != "" {
+		endpoint.Secret = body.Secret
+	}
+	if body.Active != nil {
+		endpoint.Active = *body.Active
+	}
+	if err := validateWebhookRequest(webhookRequest{URL: endpoint.URL, Events: endpoint.EventList}, endpoint.AccountID); err != nil {
+		util.ErrorResponder(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := endpoint.Update(db); err != nil {
+		util.ErrorResponder(w, http.StatusInternalServerError, err)
+		return
+	}
+	util.JSONResponder(w, endpoint)
+}
+
+func deleteWebhook(w http.ResponseWriter, r *http.Request, accountID *uuid.UUID) {
+	w.Header().Set("Content-Type", "application/json")
+	db := requestDB(r)
+	endpoint, err := findWebhook(db, mux.Vars(r)["webhook_id"], accountID)
+	if err != nil {
+		webhookErrorResponder(w, err)
+		return
+	}
+	if err := endpoint.Delete(db); err != nil {
+		util.ErrorResponder(w, http.StatusInternalServerError, err)
+		return
+	}
+	util.JSONResponder(w, map[string]string{"status": "success"})
+}
+
+func listWebhookDeliveries(w http.ResponseWriter, r *http.Request, accountID *uuid.UUID) {
+	w.Header().Set("Content-Type", "application/json")
+	db := requestDB(r)
+	endpoint, err := findWebhook(db, mux.Vars(r)["webhook_id"], accountID)
+	if err != nil {
+		webhookErrorResponder(w, err)
+		return
+	}
+	query := util.SetDBPagination(db, r).Order("created_at desc")
+	if status := r.URL.Query().Get("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if event := r.URL.Query().Get("event"); event != "" {
+		query = query.Where("event = ?", event)
+	}
+	deliveries := models.WebhookDeliveries{}
+	if err := deliveries.GetByQuery(query, "endpoint_id = ?", endpoint.ID); err != nil {
+		util.ErrorResponder(w, http.StatusInternalServerError, err)
+		return
+	}
+	util.PaginationResponder(w, r, deliveries)
+}
+
+func replayWebhookDelivery(w http.ResponseWriter, r *http.Request, accountID *uuid.UUID) {
+	w.Header().Set("Content-Type", "application/json")
+	if webhooks == nil {
+		util.ErrorResponder(w, http.StatusServiceUnavailable, errors.New("webhooks are not being delivered"))
+		return
+	}
+	db := requestDB(r)
+	endpoint, err := findWebhook(db, mux.Vars(r)["webhook_id"], accountID)
+	if err != nil {
+		webhookErrorResponder(w, err)
+		return
+	}
+	delivery := models.WebhookDelivery{}
+	if err := delivery.GetOneByQuery(db, "id = ? AND endpoint_id = ?", uuid.FromStringOrNil(mux.Vars(r)["delivery_id"]), endpoint.ID); err != nil {
+		if err.Error() == "record not found" {
+			util.ErrorResponder(w, http.StatusNotFound, errors.New("delivery cannot be found"))
+			return
+		}
+		util.ErrorResponder(w, http.StatusInternalServerError, err)
+		return
+	}
+	replay, err := webhooks.Replay(delivery)
+	if err != nil {
+		util.ErrorResponder(w, http.StatusInternalServerError, err)
+		return
+	}
+	util.JSONResponder(w, replay)
+}
+
+//
+// findWebhook gets the endpoint, which must belong
+// to the account when an account is given
+//
+func findWebhook(db *gorm.DB, id string, accountID *uuid.UUID) (models.WebhookEndpoint, error) {
+	endpoint := models.WebhookEndpoint{}
+	var err error
+	if accountID != nil {
+		err = endpoint.GetOneByQuery(db, "id = ? AND account_id = ?", uuid.FromStringOrNil(id), *accountID)
+	} else {
+		err = endpoint.GetOneByQuery(db, "id = ?", uuid.FromStringOrNil(id))
+	}
+	if err != nil && err.Error() == "record not found" {
+		return endpoint, errWebhookNotFound
+	}
+	return endpoint, err
+}
+
+func webhookErrorResponder(w http.ResponseWriter, err error) {
+	if err == errWebhookNotFound {
+		util.ErrorResponder(w, http.StatusNotFound, err)
+		return
+	}
+	util.ErrorResponder(w, http.StatusInternalServerError, err)
+}
+
+//
+// validateWebhookRequest checks the url and events of an endpoint.
+// An account's endpoint must be https and resolve to public addresses
+//
+func validateWebhookRequest(body webhookRequest, accountID *uuid.UUID) error {
+	if accountID != nil {
+		if err := webhook.CheckAccountURL(body.URL); err != nil {
+			return err
+		}
+	} else {
+		target, err := url.Parse(body.URL)
+		if err != nil || (target.Scheme != "https" && target.Scheme != "http") || target.Host == "" {
+			return errors.New("url must be an absolute http or https url")
+		}
+	}
+	if len(body.Events) == 0 {
+		return errors.New("events must list at least one event")
+	}
+	for _, event := range body.Events {
+		if event == "*" {
+			continue
+		}
+		known := false
+		for _, name := range webhook.Events {
+			if event == name {
+				known = true
+			}
+		}
+		if !known {
+			return errors.New("Unknown event " + event)
+		}
+	}
+	return nil
+}
+
+func webhookSecret() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(secret), nil
+}
@@ -0,0 +1,244 @@
+package health
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+//
+// Statuses of a check and of the whole report. A report is
+// degraded when only optional components fail
+//
+const (
+	StatusOK       = "ok"
+	StatusError    = "error"
+	StatusDegraded = "degraded"
+)
+
+//
+// Check reports whether a dependency can be used,
+// giving up when the context ends
+//
+type Check func(ctx context.Context) error
+
+//
+// Result is the outcome of a check
+//
+type Result struct {
+	Status    string  `json:"status"`
+	LatencyMS float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+	Optional  bool    `json:"optional,omitempty"`
+}
+
+//
+// Report is the outcome of every check. It is ok only when
+// every component is, and an error when a required one fails
+//
+type Report struct {
+	Status     string            `json:"status"`
+	Components map[string]Result `json:"components"`
+}
+
+//
+// Config holds the settings of the readiness checks
+//
+type Config struct {
+	Timeout time.Duration
+}
+
+//
+// ConfigFromEnv builds the readiness config specific to the environment
+//
+func ConfigFromEnv() Config {
+	timeout, err := time.ParseDuration(os.Getenv("READYZ_TIMEOUT"))
+	if err != nil || timeout <= 0 {
+		timeout = 2 * time.Second
+	}
+	return Config{Timeout: timeout}
+}
+
+type named struct {
+	name     string
+	check    Check
+	optional bool
+}
+
+//
+// Checker runs the checks of the dependencies
+// an instance needs to serve requests
+//
+type Checker struct {
+	config Config
+	mutex  sync.Mutex
+	checks []named
+}
+
+//
+// New returns a checker giving each check up to the timeout
+//
+func New(config Config) *Checker {
+	return &Checker{config: config}
+}
+
+//
+// Add adds a check of a component the instance cannot serve without
+//
+func (c *Checker) Add(component string, check Check) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.checks = append(c.checks, named{name: component, check: check})
+}
+
+//
+// AddOptional adds a check of a component that is reported on
+// but does not make the instance unready. It suits dependencies
+// every instance shares, whose outage no instance can route around
+//
+func (c *Checker) AddOptional(component string, check Check) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.checks = append(c.checks, named{name: component, check: check, optional: true})
+}
+
+//
+// Run runs every check at once and reports on them
+//
+func (c *Checker) Run(ctx context.Context) Report {
+	c.mutex.Lock()
+	checks := append([]named(nil), c.checks...)
+	c.mutex.Unlock()
+	ctx, cancel := context.WithTimeout(ctx, c.config.Timeout)
+	defer cancel()
+	results := make([]Result, len(checks))
+	wait := sync.WaitGroup{}
+	for i := range checks {
+		wait.Add(1)
+		go func(i int) {
+			defer wait.Done()
+			results[i] = run(ctx, checks[i].check)
+		}(i)
+	}
+	wait.Wait()
+	report := Report{Status: StatusOK, Components: map[string]Result{}}
+	for i, check := range checks {
+		results[i].Optional = check.optional
+		report.Components[check.name] = results[i]
+		switch {
+		case results[i].Status == StatusOK:
+		case !check.optional:
+			report.Status = StatusError
+		case report.Status == StatusOK:
+			report.Status = StatusDegraded
+		}
+	}
+	return report
+}
+
+//
+// run runs the check, giving up when the context ends
+// even if the check does not
+//
+func run(ctx context.Context, check Check) Result {
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		done <- check(ctx)
+	}()
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = errors.New("timed out")
+	}
+	result := Result{
+		Status:    StatusOK,
+		LatencyMS: float64(time.Since(start).Nanoseconds()) / float64(time.Millisecond),
+	}
+	if err != nil {
+		result.Status = StatusError
+		result.Error = err.Error()
+	}
+	return result
+}
+
+//
+// SQL checks the connection pool can reach the database
+//
+func SQL(db *sql.DB) Check {
+	return func(ctx context.Context) error {
+		return db.PingContext(ctx)
+	}
+}
+
+//
+// HTTP checks the service at the URL answers. Any
+// answer below 500 means it can be reached
+//
+func HTTP(url string) Check {
+	client := &http.Client{}
+	return func(ctx context.Context) error {
+		if url == "" {
+			return errors.New("no URL is configured")
+		}
+		req, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := client.Do(req.WithContext(ctx))
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 500 {
+			return fmt.Errorf("answered %v", resp.StatusCode)
+		}
+		return nil
+	}
+}
+
+//
+// AWS checks credentials can be found for the AWS SDK, the
+// way the lambda and email calls look for them, and that the
+// settings they need are set
+//
+func AWS(settings ...string) Check {
+	return func(ctx context.Context) error {
+		missing := []string{}
+		for _, setting := range settings {
+			if os.Getenv(setting) == "" {
+				missing = append(missing, setting)
+			}
+		}
+		if len(missing) > 0 {
+			sort.Strings(missing)
+			return fmt.Errorf("missing settings %v", missing)
+		}
+		sess, err := session.NewSessionWithOptions(session.Options{
+			SharedConfigState: session.SharedConfigEnable,
+		})
+		if err != nil {
+			return err
+		}
+		if sess.Config.Credentials == nil {
+			return errors.New("no credentials are configured")
+		}
+		value, err := sess.Config.Credentials.Get()
+		if err != nil {
+			return err
+		}
+		if value.AccessKeyID == "" {
+			return errors.New("no credentials are configured")
+		}
+		return nil
+	}
+}
@@ -0,0 +1,111 @@
+package metrics
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+//
+// The metrics buddha exposes. Routes are labelled with
+// the name they are registered under
+//
+var (
+	HTTPRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "buddha_http_requests_total",
+		Help: "HTTP requests by route, method and status code.",
+	}, []string{"route", "method", "code"})
+	HTTPDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "buddha_http_request_duration_seconds",
+		Help: "Time taken to answer HTTP requests by route and method.",
+	}, []string{"route", "method"})
+	VijnanaRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "buddha_vijnana_requests_total",
+		Help: "Token checks sent to Vijnana by endpoint and result: valid, invalid or error.",
+	}, []string{"endpoint", "result"})
+	VijnanaDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "buddha_vijnana_request_duration_seconds",
+		Help: "Time taken by Vijnana to check tokens by endpoint.",
+	}, []string{"endpoint"})
+	LambdaInvocations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "buddha_lambda_invocations_total",
+		Help: "Lambda invocations by function and result: success, error or function_error.",
+	}, []string{"function", "result"})
+	LambdaDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "buddha_lambda_invocation_duration_seconds",
+		Help: "Time taken by lambda invocations by function.",
+	}, []string{"function"})
+	Dispenses = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "buddha_dispenses_total",
+		Help: "Dispenses reported by dispensers.",
+	})
+	Insertions = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "buddha_pod_insertions_total",
+		Help: "Pod insertions reported by dispensers.",
+	})
+)
+
+//
+// RegisterDBStats exposes the stats of the connection pool
+//
+func RegisterDBStats(db *sql.DB) {
+	Registry.MustRegister(collectors.NewDBStatsCollector(db, "buddha"))
+}
+
+//
+// statusRecorder remembers the status code a handler wrote
+//
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	if s.status == 0 {
+		s.status = status
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Write(data []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	return s.ResponseWriter.Write(data)
+}
+
+//
+// InstrumentRoute counts the requests of a route and how long they take
+//
+func InstrumentRoute(route string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w}
+		method := methodLabel(r.Method)
+		defer func() {
+			status := recorder.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+			HTTPDuration.WithLabelValues(route, method).Observe(time.Since(start).Seconds())
+			HTTPRequests.WithLabelValues(route, method, strconv.Itoa(status)).Inc()
+		}()
+		next.ServeHTTP(recorder, r)
+	})
+}
+
+//
+// methodLabel keeps the method label to the methods routes
+// use, so clients cannot grow the series with made up ones
+//
+func methodLabel(method string) string {
+	switch method {
+	case "GET", "POST", "PUT", "PATCH", "DELETE":
+		return method
+	}
+	return "other"
+}
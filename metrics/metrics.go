@@ -0,0 +1,27 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+//
+// Registry is the registry served on /metrics. Next to the
+// instruments it holds the Go runtime and process collectors
+//
+var Registry = prometheus.NewRegistry()
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		HTTPRequests,
+		HTTPDuration,
+		VijnanaRequests,
+		VijnanaDuration,
+		LambdaInvocations,
+		LambdaDuration,
+		Dispenses,
+		Insertions,
+	)
+}
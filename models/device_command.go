@@ -0,0 +1,147 @@
+package models
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+	uuid "github.com/satori/go.uuid"
+)
+
+//
+// Kinds of commands a dispenser runs
+//
+const (
+	CommandDispense = "dispense"
+	CommandLocate   = "locate"
+	CommandReboot   = "reboot"
+)
+
+//
+// Statuses a command goes through. A command only moves forward,
+// and completed and failed commands do not change again
+//
+const (
+	CommandQueued       = "queued"
+	CommandSent         = "sent"
+	CommandAcknowledged = "acknowledged"
+	CommandCompleted    = "completed"
+	CommandFailed       = "failed"
+)
+
+var commandOrder = map[string]int{
+	CommandQueued:       0,
+	CommandSent:         1,
+	CommandAcknowledged: 2,
+	CommandCompleted:    3,
+	CommandFailed:       3,
+}
+
+//
+// DeviceCommand is a command sent to a dispenser, tracked from
+// being queued until the dispenser reports it done
+//
+type DeviceCommand struct {
+	ID             uuid.UUID  `gorm:"type:char(36);primary_key" json:"id"`
+	DispenserID    uuid.UUID  `gorm:"type:char(36);index" json:"dispenser_id"`
+	AccountID      uuid.UUID  `gorm:"type:char(36);index" json:"account_id"`
+	UserID         *uuid.UUID `gorm:"type:char(36)" json:"user_id"`
+	Kind           string     `gorm:"type:varchar(32)" json:"kind"`
+	Status         string     `gorm:"type:varchar(32);index" json:"status"`
+	Source         string     `gorm:"type:varchar(32)" json:"source"`
+	UsageID        *uuid.UUID `gorm:"type:char(36)" json:"usage_id"`
+	Error          string     `gorm:"type:text" json:"error"`
+	SentAt         *time.Time `json:"sent_at"`
+	AcknowledgedAt *time.Time `json:"acknowledged_at"`
+	CompletedAt    *time.Time `json:"completed_at"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+}
+
+//
+// DeviceCommands is a list of commands
+//
+type DeviceCommands []DeviceCommand
+
+//
+// ValidCommandKind reports whether dispensers run the kind
+//
+func ValidCommandKind(kind string) bool {
+	return kind == CommandDispense || kind == CommandLocate || kind == CommandReboot
+}
+
+//
+// BeforeCreate assigns the id of a new command
+//
+func (c *DeviceCommand) BeforeCreate(scope *gorm.Scope) error {
+	if c.ID == uuid.Nil {
+		c.ID = uuid.NewV4()
+	}
+	return nil
+}
+
+//
+// GetOneByQuery gets the first command matching the query
+//
+func (c *DeviceCommand) GetOneByQuery(db *gorm.DB, query string, args ...interface{}) error {
+	return db.Where(query, args...).First(c).Error
+}
+
+//
+// GetByQuery gets the commands matching the query
+//
+func (c *DeviceCommands) GetByQuery(db *gorm.DB, query string, args ...interface{}) error {
+	return db.Where(query, args...).Find(c).Error
+}
+
+//
+// Create stores a new command
+//
+func (c *DeviceCommand) Create(db *gorm.DB) error {
+	return db.Create(c).Error
+}
+
+//
+// Update saves the command
+//
+func (c *DeviceCommand) Update(db *gorm.DB) error {
+	return db.Save(c).Error
+}
+
+//
+// Open reports whether the command may still change
+//
+func (c DeviceCommand) Open() bool {
+	return c.Status != CommandCompleted && c.Status != CommandFailed
+}
+
+//
+// Advance moves the command to the status, recording when it
+// happened. It reports false when the command is already past it
+//
+func (c *DeviceCommand) Advance(status string, at time.Time) bool {
+	order, ok := commandOrder[status]
+	if !ok || !c.Open() || order <= commandOrder[c.Status] {
+		return false
+	}
+	c.Status = status
+	switch status {
+	case CommandSent:
+		c.SentAt = &at
+	case CommandAcknowledged:
+		c.AcknowledgedAt = &at
+	case CommandCompleted, CommandFailed:
+		c.CompletedAt = &at
+	}
+	return true
+}
+
+//
+// Fail moves the command to failed with the reason
+//
+func (c *DeviceCommand) Fail(reason string, at time.Time) bool {
+	if !c.Advance(CommandFailed, at) {
+		return false
+	}
+	c.Error = reason
+	return true
+}
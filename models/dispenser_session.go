@@ -0,0 +1,90 @@
+package models
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+	uuid "github.com/satori/go.uuid"
+)
+
+//
+// Reasons a dispenser session ends
+//
+const (
+	SessionDisconnected = "disconnected"
+	SessionSilent       = "silent"
+)
+
+//
+// DispenserSession is a span of time a dispenser was online,
+// from the first sign of life until it disconnected or went
+// silent. LastSeenAt moves with every heartbeat. OpenDispenserID
+// is the dispenser while the session is open and null once it
+// ends, so the unique key allows one open session per dispenser
+//
+type DispenserSession struct {
+	ID              uuid.UUID  `gorm:"type:char(36);primary_key" json:"id"`
+	DispenserID     uuid.UUID  `gorm:"type:char(36);index" json:"dispenser_id"`
+	OpenDispenserID *uuid.UUID `gorm:"type:char(36);unique_index" json:"-"`
+	AccountID       uuid.UUID  `gorm:"type:char(36);index" json:"account_id"`
+	StartedAt       time.Time  `json:"started_at"`
+	LastSeenAt      time.Time  `gorm:"index" json:"last_seen_at"`
+	EndedAt         *time.Time `gorm:"index" json:"ended_at"`
+	EndReason       string     `gorm:"type:varchar(32)" json:"end_reason"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+}
+
+//
+// DispenserSessions is a list of sessions
+//
+type DispenserSessions []DispenserSession
+
+//
+// BeforeCreate assigns the id of a new session
+//
+func (s *DispenserSession) BeforeCreate(scope *gorm.Scope) error {
+	if s.ID == uuid.Nil {
+		s.ID = uuid.NewV4()
+	}
+	return nil
+}
+
+//
+// GetOneByQuery gets the first session matching the query
+//
+func (s *DispenserSession) GetOneByQuery(db *gorm.DB, query string, args ...interface{}) error {
+	return db.Where(query, args...).First(s).Error
+}
+
+//
+// GetByQuery gets the sessions matching the query
+//
+func (s *DispenserSessions) GetByQuery(db *gorm.DB, query string, args ...interface{}) error {
+	return db.Where(query, args...).Find(s).Error
+}
+
+//
+// Create stores a new session
+//
+func (s *DispenserSession) Create(db *gorm.DB) error {
+	return db.Create(s).Error
+}
+
+//
+// Update saves the session
+//
+func (s *DispenserSession) Update(db *gorm.DB) error {
+	return db.Save(s).Error
+}
+
+//
+// Duration is how long the session lasted, or has
+// lasted so far when it is still open
+//
+func (s DispenserSession) Duration(now time.Time) time.Duration {
+	if s.EndedAt != nil {
+		return s.EndedAt.Sub(s.StartedAt)
+	}
+	return now.Sub(s.StartedAt)
+}
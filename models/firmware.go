@@ -0,0 +1,191 @@
+package models
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+	uuid "github.com/satori/go.uuid"
+)
+
+//
+// FirmwareVersion is a version of firmware a dispenser part
+// ran, from the first report of it to the last. The version a
+// part runs now is its current one
+//
+type FirmwareVersion struct {
+	ID          uuid.UUID `gorm:"type:char(36);primary_key" json:"id"`
+	DispenserID uuid.UUID `gorm:"type:char(36);index" json:"dispenser_id"`
+	Part        string    `gorm:"type:varchar(32);index" json:"part"`
+	Version     string    `gorm:"type:varchar(64);index" json:"version"`
+	Current     bool      `gorm:"index" json:"current"`
+	FirstSeenAt time.Time `json:"first_seen_at"`
+	LastSeenAt  time.Time `json:"last_seen_at"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+//
+// FirmwareVersions is a list of firmware versions
+//
+type FirmwareVersions []FirmwareVersion
+
+//
+// BeforeCreate assigns the id of a new version
+//
+func (f *FirmwareVersion) BeforeCreate(scope *gorm.Scope) error {
+	if f.ID == uuid.Nil {
+		f.ID = uuid.NewV4()
+	}
+	return nil
+}
+
+//
+// GetOneByQuery gets the first version matching the query
+//
+func (f *FirmwareVersion) GetOneByQuery(db *gorm.DB, query string, args ...interface{}) error {
+	return db.Where(query, args...).First(f).Error
+}
+
+//
+// GetByQuery gets the versions matching the query
+//
+func (f *FirmwareVersions) GetByQuery(db *gorm.DB, query string, args ...interface{}) error {
+	return db.Where(query, args...).Find(f).Error
+}
+
+//
+// Create stores a new version
+//
+func (f *FirmwareVersion) Create(db *gorm.DB) error {
+	return db.Create(f).Error
+}
+
+//
+// Update saves the version
+//
+func (f *FirmwareVersion) Update(db *gorm.DB) error {
+	return db.Save(f).Error
+}
+
+//
+// FirmwareRollout offers a target version of a part's firmware to
+// a percentage of the dispensers in a cohort. Dispensers ask for
+// the rollouts they are in to decide whether to update. Every
+// dispenser is in the default cohort, and other cohorts hold only
+// their FirmwareCohortMembers
+//
+type FirmwareRollout struct {
+	ID            uuid.UUID  `gorm:"type:char(36);primary_key" json:"id"`
+	Part          string     `gorm:"type:varchar(32);index" json:"part"`
+	TargetVersion string     `gorm:"type:varchar(64)" json:"target_version"`
+	Percentage    uint       `json:"percentage"`
+	Cohort        string     `gorm:"type:varchar(64)" json:"cohort"`
+	Active        bool       `gorm:"index" json:"active"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+	DeletedAt     *time.Time `json:"-"`
+}
+
+//
+// FirmwareRollouts is a list of rollouts
+//
+type FirmwareRollouts []FirmwareRollout
+
+//
+// BeforeCreate assigns the id of a new rollout
+//
+func (f *FirmwareRollout) BeforeCreate(scope *gorm.Scope) error {
+	if f.ID == uuid.Nil {
+		f.ID = uuid.NewV4()
+	}
+	return nil
+}
+
+//
+// GetOneByQuery gets the first rollout matching the query
+//
+func (f *FirmwareRollout) GetOneByQuery(db *gorm.DB, query string, args ...interface{}) error {
+	return db.Where(query, args...).First(f).Error
+}
+
+//
+// GetByQuery gets the rollouts matching the query
+//
+func (f *FirmwareRollouts) GetByQuery(db *gorm.DB, query string, args ...interface{}) error {
+	return db.Where(query, args...).Find(f).Error
+}
+
+//
+// Create stores a new rollout
+//
+func (f *FirmwareRollout) Create(db *gorm.DB) error {
+	return db.Create(f).Error
+}
+
+//
+// Update saves the rollout
+//
+func (f *FirmwareRollout) Update(db *gorm.DB) error {
+	return db.Save(f).Error
+}
+
+//
+// Delete soft deletes the rollout
+//
+func (f *FirmwareRollout) Delete(db *gorm.DB) error {
+	return db.Delete(f).Error
+}
+
+//
+// FirmwareCohortMember puts a dispenser in a cohort, so the
+// rollouts of that cohort are offered to it
+//
+type FirmwareCohortMember struct {
+	ID          uuid.UUID `gorm:"type:char(36);primary_key" json:"id"`
+	Cohort      string    `gorm:"type:varchar(64);unique_index:idx_firmware_cohort_dispenser" json:"cohort"`
+	DispenserID uuid.UUID `gorm:"type:char(36);unique_index:idx_firmware_cohort_dispenser" json:"dispenser_id"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+//
+// FirmwareCohortMembers is a list of cohort members
+//
+type FirmwareCohortMembers []FirmwareCohortMember
+
+//
+// BeforeCreate assigns the id of a new cohort member
+//
+func (f *FirmwareCohortMember) BeforeCreate(scope *gorm.Scope) error {
+	if f.ID == uuid.Nil {
+		f.ID = uuid.NewV4()
+	}
+	return nil
+}
+
+//
+// GetOneByQuery gets the first cohort member matching the query
+//
+func (f *FirmwareCohortMember) GetOneByQuery(db *gorm.DB, query string, args ...interface{}) error {
+	return db.Where(query, args...).First(f).Error
+}
+
+//
+// GetByQuery gets the cohort members matching the query
+//
+func (f *FirmwareCohortMembers) GetByQuery(db *gorm.DB, query string, args ...interface{}) error {
+	return db.Where(query, args...).Find(f).Error
+}
+
+//
+// Create stores a new cohort member
+//
+func (f *FirmwareCohortMember) Create(db *gorm.DB) error {
+	return db.Create(f).Error
+}
+
+//
+// Delete takes the dispenser out of the cohort
+//
+func (f *FirmwareCohortMember) Delete(db *gorm.DB) error {
+	return db.Delete(f).Error
+}
@@ -0,0 +1,82 @@
+package models
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+	uuid "github.com/satori/go.uuid"
+)
+
+//
+// LambdaEvent records a dispenser event processed from
+// lambda under its idempotency key, along with the response
+// returned so a re-delivery can be answered with it
+//
+type LambdaEvent struct {
+	ID          uuid.UUID  `gorm:"type:char(36);primary_key" json:"id"`
+	Key         string     `gorm:"type:varchar(255);unique_index" json:"key"`
+	Route       string     `gorm:"type:varchar(255)" json:"route"`
+	StatusCode  int        `json:"status_code"`
+	Response    []byte     `gorm:"type:blob" json:"response"`
+	CompletedAt *time.Time `json:"completed_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+//
+// BeforeCreate assigns the id of a new event
+//
+func (e *LambdaEvent) BeforeCreate(scope *gorm.Scope) error {
+	if e.ID == uuid.Nil {
+		e.ID = uuid.NewV4()
+	}
+	return nil
+}
+
+//
+// GetOneByQuery gets the first event matching the query
+//
+func (e *LambdaEvent) GetOneByQuery(db *gorm.DB, query string, args ...interface{}) error {
+	return db.Where(query, args...).First(e).Error
+}
+
+//
+// Create stores a new event
+//
+func (e *LambdaEvent) Create(db *gorm.DB) error {
+	return db.Create(e).Error
+}
+
+//
+// Update saves the event
+//
+func (e *LambdaEvent) Update(db *gorm.DB) error {
+	return db.Save(e).Error
+}
+
+//
+// Complete stores the response of the event, keeping its key
+// from then on. Called in the transaction of the event's writes,
+// the key is kept exactly when the writes are
+//
+func (e *LambdaEvent) Complete(db *gorm.DB, statusCode int, response []byte) error {
+	now := time.Now()
+	e.StatusCode = statusCode
+	e.Response = response
+	e.CompletedAt = &now
+	return e.Update(db)
+}
+
+//
+// Delete removes the event so its key can be processed again
+//
+func (e *LambdaEvent) Delete(db *gorm.DB) error {
+	return db.Delete(e).Error
+}
+
+//
+// DeleteUnfinished removes the event unless it was completed
+//
+func (e *LambdaEvent) DeleteUnfinished(db *gorm.DB) error {
+	return db.Where("completed_at IS NULL").Delete(e).Error
+}
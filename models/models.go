@@ -0,0 +1,23 @@
+package models
+
+import "github.com/jinzhu/gorm"
+
+//
+// AutoMigrate creates or updates the tables for the
+// models owned by this service rather than satya
+//
+func AutoMigrate(db *gorm.DB) error {
+	return db.AutoMigrate(
+		&LambdaEvent{},
+		&ReminderNotification{},
+		&SchedulerWatermark{},
+		&VoiceLink{},
+		&DeviceCommand{},
+		&WebhookEndpoint{},
+		&WebhookDelivery{},
+		&DispenserSession{},
+		&FirmwareVersion{},
+		&FirmwareRollout{},
+		&FirmwareCohortMember{},
+	).Error
+}
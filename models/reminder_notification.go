@@ -0,0 +1,50 @@
+package models
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+	uuid "github.com/satori/go.uuid"
+)
+
+//
+// ReminderNotification records a notification sent for
+// one scheduled dose of a reminder. The key is unique so
+// a dose is only ever notified once per kind, even with
+// several instances running the scheduler
+//
+type ReminderNotification struct {
+	ID          uuid.UUID `gorm:"type:char(36);primary_key" json:"id"`
+	Key         string    `gorm:"type:varchar(255);unique_index" json:"key"`
+	ReminderID  uuid.UUID `gorm:"type:char(36);index" json:"reminder_id"`
+	UserID      uuid.UUID `gorm:"type:char(36)" json:"user_id"`
+	Kind        string    `gorm:"type:varchar(32)" json:"kind"`
+	ScheduledAt time.Time `json:"scheduled_at"`
+	Error       string    `gorm:"type:text" json:"error"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+//
+// BeforeCreate assigns the id of a new notification
+//
+func (n *ReminderNotification) BeforeCreate(scope *gorm.Scope) error {
+	if n.ID == uuid.Nil {
+		n.ID = uuid.NewV4()
+	}
+	return nil
+}
+
+//
+// Create stores a new notification
+//
+func (n *ReminderNotification) Create(db *gorm.DB) error {
+	return db.Create(n).Error
+}
+
+//
+// Update saves the notification
+//
+func (n *ReminderNotification) Update(db *gorm.DB) error {
+	return db.Save(n).Error
+}
@@ -0,0 +1,42 @@
+package models
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+//
+// SchedulerWatermark is the time a background job has run up
+// to, so a restarted instance carries on from there instead
+// of from the time it started
+//
+type SchedulerWatermark struct {
+	Name      string    `gorm:"type:varchar(64);primary_key" json:"name"`
+	RunAt     time.Time `json:"run_at"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+//
+// GetOneByQuery gets the first watermark matching the query
+//
+func (w *SchedulerWatermark) GetOneByQuery(db *gorm.DB, query string, args ...interface{}) error {
+	return db.Where(query, args...).First(w).Error
+}
+
+//
+// AdvanceWatermark moves the watermark of the job forward to
+// the time. A watermark already past it, written by another
+// instance, is left alone
+//
+func AdvanceWatermark(db *gorm.DB, name string, at time.Time) error {
+	watermark := SchedulerWatermark{}
+	if err := watermark.GetOneByQuery(db, "name = ?", name); err != nil {
+		if err.Error() != "record not found" {
+			return err
+		}
+		return db.Create(&SchedulerWatermark{Name: name, RunAt: at}).Error
+	}
+	return db.Model(&SchedulerWatermark{}).Where("name = ? AND run_at < ?", name, at).UpdateColumn("run_at", at).Error
+}
@@ -0,0 +1,62 @@
+package models
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+	uuid "github.com/satori/go.uuid"
+)
+
+//
+// VoiceLink records a user linking their account to a voice
+// assistant. A link is unlinked rather than removed when the
+// user disconnects, so the history of links is kept
+//
+type VoiceLink struct {
+	ID         uuid.UUID  `gorm:"type:char(36);primary_key" json:"id"`
+	Provider   string     `gorm:"type:varchar(32);unique_index:voice_link_provider_user" json:"provider"`
+	UserID     uuid.UUID  `gorm:"type:char(36);unique_index:voice_link_provider_user" json:"user_id"`
+	AccountID  uuid.UUID  `gorm:"type:char(36);index" json:"account_id"`
+	LinkedAt   time.Time  `json:"linked_at"`
+	UnlinkedAt *time.Time `json:"unlinked_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+}
+
+//
+// BeforeCreate assigns the id of a new link
+//
+func (l *VoiceLink) BeforeCreate(scope *gorm.Scope) error {
+	if l.ID == uuid.Nil {
+		l.ID = uuid.NewV4()
+	}
+	return nil
+}
+
+//
+// GetOneByQuery gets the first link matching the query
+//
+func (l *VoiceLink) GetOneByQuery(db *gorm.DB, query string, args ...interface{}) error {
+	return db.Where(query, args...).First(l).Error
+}
+
+//
+// Create stores a new link
+//
+func (l *VoiceLink) Create(db *gorm.DB) error {
+	return db.Create(l).Error
+}
+
+//
+// Update saves the link
+//
+func (l *VoiceLink) Update(db *gorm.DB) error {
+	return db.Save(l).Error
+}
+
+//
+// Linked reports whether the link is currently active
+//
+func (l VoiceLink) Linked() bool {
+	return l.ID != uuid.Nil && l.UnlinkedAt == nil
+}
@@ -0,0 +1,84 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	uuid "github.com/satori/go.uuid"
+)
+
+//
+// Statuses of a webhook delivery
+//
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+//
+// WebhookDelivery is one event sent to one endpoint, with
+// the outcome of its latest attempt. A replay is a new
+// delivery of the same event
+//
+type WebhookDelivery struct {
+	ID             uuid.UUID       `gorm:"type:char(36);primary_key" json:"id"`
+	EndpointID     uuid.UUID       `gorm:"type:char(36);index" json:"endpoint_id"`
+	AccountID      uuid.UUID       `gorm:"type:char(36);index" json:"account_id"`
+	EventID        uuid.UUID       `gorm:"type:char(36);index" json:"event_id"`
+	Event          string          `gorm:"type:varchar(64)" json:"event"`
+	Payload        json.RawMessage `gorm:"type:json" json:"payload"`
+	Status         string          `gorm:"type:varchar(32);index" json:"status"`
+	Attempts       int             `json:"attempts"`
+	NextAttemptAt  *time.Time      `gorm:"index" json:"next_attempt_at"`
+	LastStatusCode int             `json:"last_status_code"`
+	LastError      string          `gorm:"type:text" json:"last_error"`
+	DeliveredAt    *time.Time      `json:"delivered_at"`
+	ReplayOf       *uuid.UUID      `gorm:"type:char(36)" json:"replay_of"`
+	CreatedAt      time.Time       `json:"created_at"`
+	UpdatedAt      time.Time       `json:"updated_at"`
+}
+
+//
+// WebhookDeliveries is a list of deliveries
+//
+type WebhookDeliveries []WebhookDelivery
+
+//
+// BeforeCreate assigns the id of a new delivery
+//
+func (d *WebhookDelivery) BeforeCreate(scope *gorm.Scope) error {
+	if d.ID == uuid.Nil {
+		d.ID = uuid.NewV4()
+	}
+	return nil
+}
+
+//
+// GetOneByQuery gets the first delivery matching the query
+//
+func (d *WebhookDelivery) GetOneByQuery(db *gorm.DB, query string, args ...interface{}) error {
+	return db.Where(query, args...).First(d).Error
+}
+
+//
+// GetByQuery gets the deliveries matching the query
+//
+func (d *WebhookDeliveries) GetByQuery(db *gorm.DB, query string, args ...interface{}) error {
+	return db.Where(query, args...).Find(d).Error
+}
+
+//
+// Create stores a new delivery
+//
+func (d *WebhookDelivery) Create(db *gorm.DB) error {
+	return db.Create(d).Error
+}
+
+//
+// Update saves the delivery
+//
+func (d *WebhookDelivery) Update(db *gorm.DB) error {
+	return db.Save(d).Error
+}
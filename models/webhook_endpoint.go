@@ -0,0 +1,110 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	uuid "github.com/satori/go.uuid"
+)
+
+//
+// WebhookEndpoint is a url events are delivered to. An endpoint
+// without an account is a developer's and receives the events
+// of every account
+//
+type WebhookEndpoint struct {
+	ID          uuid.UUID  `gorm:"type:char(36);primary_key" json:"id"`
+	AccountID   *uuid.UUID `gorm:"type:char(36);index" json:"account_id"`
+	URL         string     `gorm:"type:varchar(2048)" json:"url"`
+	Secret      string     `gorm:"type:varchar(128)" json:"-"`
+	Events      string     `gorm:"type:text" json:"-"`
+	EventList   []string   `gorm:"-" json:"events"`
+	Description string     `gorm:"type:varchar(255)" json:"description"`
+	Active      bool       `json:"active"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	DeletedAt   *time.Time `json:"-"`
+}
+
+//
+// WebhookEndpoints is a list of endpoints
+//
+type WebhookEndpoints []WebhookEndpoint
+
+//
+// BeforeCreate assigns the id of a new endpoint
+//
+func (e *WebhookEndpoint) BeforeCreate(scope *gorm.Scope) error {
+	if e.ID == uuid.Nil {
+		e.ID = uuid.NewV4()
+	}
+	return nil
+}
+
+//
+// BeforeSave stores the event list
+//
+func (e *WebhookEndpoint) BeforeSave() error {
+	e.Events = strings.Join(e.EventList, ",")
+	return nil
+}
+
+//
+// AfterFind reads the event list
+//
+func (e *WebhookEndpoint) AfterFind() error {
+	e.EventList = []string{}
+	if e.Events != "" {
+		e.EventList = strings.Split(e.Events, ",")
+	}
+	return nil
+}
+
+//
+// GetOneByQuery gets the first endpoint matching the query
+//
+func (e *WebhookEndpoint) GetOneByQuery(db *gorm.DB, query string, args ...interface{}) error {
+	return db.Where(query, args...).First(e).Error
+}
+
+//
+// GetByQuery gets the endpoints matching the query
+//
+func (e *WebhookEndpoints) GetByQuery(db *gorm.DB, query string, args ...interface{}) error {
+	return db.Where(query, args...).Find(e).Error
+}
+
+//
+// Create stores a new endpoint
+//
+func (e *WebhookEndpoint) Create(db *gorm.DB) error {
+	return db.Create(e).Error
+}
+
+//
+// Update saves the endpoint
+//
+func (e *WebhookEndpoint) Update(db *gorm.DB) error {
+	return db.Save(e).Error
+}
+
+//
+// Delete soft deletes the endpoint
+//
+func (e *WebhookEndpoint) Delete(db *gorm.DB) error {
+	return db.Delete(e).Error
+}
+
+//
+// Subscribes reports whether the endpoint receives the event.
+// An endpoint listing "*" receives every event
+//
+func (e WebhookEndpoint) Subscribes(event string) bool {
+	for _, subscribed := range e.EventList {
+		if subscribed == event || subscribed == "*" {
+			return true
+		}
+	}
+	return false
+}
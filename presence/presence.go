@@ -0,0 +1,223 @@
+package presence
+
+import (
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	"github.com/jinzhu/gorm"
+	uuid "github.com/satori/go.uuid"
+	"github.com/tespo/buddha/models"
+)
+
+//
+// Config holds the timing of offline detection
+//
+type Config struct {
+	// OfflineAfter is how long a dispenser may be silent and still be online
+	OfflineAfter time.Duration
+	// Interval is how often silent dispensers are looked for
+	Interval time.Duration
+}
+
+//
+// ConfigFromEnv reads DISPENSER_OFFLINE_AFTER and
+// DISPENSER_OFFLINE_CHECK_INTERVAL
+//
+func ConfigFromEnv() Config {
+	return Config{
+		OfflineAfter: envDuration("DISPENSER_OFFLINE_AFTER", 5*time.Minute),
+		Interval:     envDuration("DISPENSER_OFFLINE_CHECK_INTERVAL", time.Minute),
+	}
+}
+
+//
+// Status is whether a dispenser is online and since when
+//
+type Status struct {
+	DispenserID  uuid.UUID  `json:"dispenser_id"`
+	Online       bool       `json:"online"`
+	LastSeenAt   *time.Time `json:"last_seen_at"`
+	OnlineSince  *time.Time `json:"online_since"`
+	OfflineSince *time.Time `json:"offline_since"`
+}
+
+//
+// Seen records a sign of life from the dispenser, extending its
+// open session or starting a new one. A session of another
+// account is ended first. When concurrent heartbeats both start
+// a session, the unique key lets one in and the other extends it
+//
+func Seen(db *gorm.DB, dispenserID, accountID uuid.UUID, at time.Time) (models.DispenserSession, error) {
+	session, err := seen(db, dispenserID, accountID, at)
+	if err != nil && strings.Contains(err.Error(), "Duplicate entry") {
+		return seen(db, dispenserID, accountID, at)
+	}
+	return session, err
+}
+
+func seen(db *gorm.DB, dispenserID, accountID uuid.UUID, at time.Time) (models.DispenserSession, error) {
+	session := models.DispenserSession{}
+	err := session.GetOneByQuery(db, "dispenser_id = ? AND ended_at IS NULL", dispenserID)
+	if err != nil && err.Error() != "record not found" {
+		return session, err
+	}
+	open := err == nil
+	if open && session.AccountID != accountID {
+		if err := end(db, &session, session.LastSeenAt, models.SessionDisconnected); err != nil {
+			return session, err
+		}
+		open = false
+	}
+	if !open {
+		session = models.DispenserSession{
+			DispenserID:     dispenserID,
+			OpenDispenserID: &dispenserID,
+			AccountID:       accountID,
+			StartedAt:       at,
+			LastSeenAt:      at,
+		}
+		return session, session.Create(db)
+	}
+	if at.After(session.LastSeenAt) {
+		session.LastSeenAt = at
+	}
+	return session, session.Update(db)
+}
+
+//
+// End closes the open session of the dispenser, if it has one
+//
+func End(db *gorm.DB, dispenserID uuid.UUID, at time.Time, reason string) error {
+	session := models.DispenserSession{}
+	if err := session.GetOneByQuery(db, "dispenser_id = ? AND ended_at IS NULL", dispenserID); err != nil {
+		if err.Error() == "record not found" {
+			return nil
+		}
+		return err
+	}
+	return end(db, &session, at, reason)
+}
+
+func end(db *gorm.DB, session *models.DispenserSession, at time.Time, reason string) error {
+	session.EndedAt = &at
+	session.EndReason = reason
+	session.OpenDispenserID = nil
+	return session.Update(db)
+}
+
+//
+// StatusOf reports the dispenser from its latest session. A
+// dispenser silent for longer than OfflineAfter is offline even
+// before the detector has ended its session
+//
+func StatusOf(db *gorm.DB, dispenserID uuid.UUID, config Config, now time.Time) (Status, error) {
+	status := Status{DispenserID: dispenserID}
+	session := models.DispenserSession{}
+	if err := session.GetOneByQuery(db.Order("started_at desc"), "dispenser_id = ?", dispenserID); err != nil {
+		if err.Error() == "record not found" {
+			return status, nil
+		}
+		return status, err
+	}
+	status.LastSeenAt = &session.LastSeenAt
+	switch {
+	case session.EndedAt != nil:
+		status.OfflineSince = session.EndedAt
+	case now.Sub(session.LastSeenAt) > config.OfflineAfter:
+		status.OfflineSince = &session.LastSeenAt
+	default:
+		status.Online = true
+		status.OnlineSince = &session.StartedAt
+	}
+	return status, nil
+}
+
+//
+// Detector ends the sessions of dispensers that have gone
+// silent, in the background
+//
+type Detector struct {
+	db      *gorm.DB
+	config  Config
+	started bool
+	stop    chan struct{}
+	done    chan struct{}
+	once    sync.Once
+}
+
+//
+// New returns a detector that has not been started
+//
+func New(db *gorm.DB, config Config) *Detector {
+	return &Detector{
+		db:     db,
+		config: config,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+}
+
+//
+// Start runs the detector until Stop is called
+//
+func (d *Detector) Start() {
+	d.started = true
+	go func() {
+		defer close(d.done)
+		ticker := time.NewTicker(d.config.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-d.stop:
+				return
+			case now := <-ticker.C:
+				if err := d.Run(now); err != nil {
+					log.Println("offline detector:", err)
+					sentry.CaptureException(err)
+				}
+			}
+		}
+	}()
+}
+
+//
+// Stop stops the detector, waiting for a run in progress
+//
+func (d *Detector) Stop() {
+	if !d.started {
+		return
+	}
+	d.once.Do(func() {
+		close(d.stop)
+	})
+	<-d.done
+}
+
+//
+// Run ends the open sessions not seen within OfflineAfter of
+// now. A session ends when its dispenser was last seen
+//
+func (d *Detector) Run(now time.Time) error {
+	sessions := models.DispenserSessions{}
+	if err := sessions.GetByQuery(d.db, "ended_at IS NULL AND last_seen_at < ?", now.Add(-d.config.OfflineAfter)); err != nil {
+		return err
+	}
+	for i := range sessions {
+		if err := end(d.db, &sessions[i], sessions[i].LastSeenAt, models.SessionSilent); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func envDuration(key string, fallback time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(key))
+	if err != nil || value <= 0 {
+		return fallback
+	}
+	return value
+}
@@ -0,0 +1,109 @@
+package refill
+
+import (
+	"math"
+	"os"
+	"strconv"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	"github.com/tespo/satya/v2/types"
+)
+
+//
+// Config holds the rules forecasts are made with
+//
+type Config struct {
+	// Lookback is how far back usages are counted for the velocity
+	Lookback time.Duration
+	// LowSupplyDays is the forecast under which supply is low
+	LowSupplyDays float64
+}
+
+//
+// Forecast is the estimated run-out of a regimen. DaysRemaining
+// and RunOutAt are nil while the regimen has no recent usage
+//
+type Forecast struct {
+	RegimenID         uuid.UUID  `json:"regimen_id"`
+	ServingsRemaining uint       `json:"servings_remaining"`
+	DailyUsage        float64    `json:"daily_usage"`
+	DaysRemaining     *float64   `json:"days_remaining"`
+	RunOutAt          *time.Time `json:"run_out_at"`
+	LowSupply         bool       `json:"low_supply"`
+}
+
+//
+// ConfigFromEnv reads REFILL_LOOKBACK and REFILL_LOW_SUPPLY_DAYS,
+// defaulting to 14 days of usage and 7 days of supply
+//
+func ConfigFromEnv() Config {
+	config := Config{
+		Lookback:      14 * 24 * time.Hour,
+		LowSupplyDays: 7,
+	}
+	if lookback, err := time.ParseDuration(os.Getenv("REFILL_LOOKBACK")); err == nil && lookback > 0 {
+		config.Lookback = lookback
+	}
+	if days, err := strconv.ParseFloat(os.Getenv("REFILL_LOW_SUPPLY_DAYS"), 64); err == nil {
+		config.LowSupplyDays = days
+	}
+	return config
+}
+
+//
+// Since returns the earliest usage a forecast made at now counts
+//
+func (c Config) Since(now time.Time) time.Time {
+	return now.Add(-c.Lookback)
+}
+
+//
+// Predict estimates when the regimen runs out from the number of
+// dispenses in the lookback, each of which is one serving. Regimens
+// younger than the lookback are measured over their age, but never
+// less than a day so a first dispense does not skew the velocity
+//
+func Predict(regimen types.Regimen, usages types.Usages, config Config, now time.Time) Forecast {
+	return predict(regimen, regimen.LastReportedServingsRemaining, usages, config, now)
+}
+
+//
+// Crossed reports whether a change from the previous servings
+// remaining moved the regimen into low supply
+//
+func Crossed(regimen types.Regimen, previousServings uint, usages types.Usages, config Config, now time.Time) (Forecast, bool) {
+	forecast := Predict(regimen, usages, config, now)
+	previous := predict(regimen, previousServings, usages, config, now)
+	return forecast, forecast.LowSupply && !previous.LowSupply
+}
+
+func predict(regimen types.Regimen, servings uint, usages types.Usages, config Config, now time.Time) Forecast {
+	forecast := Forecast{
+		RegimenID:         regimen.ID,
+		ServingsRemaining: servings,
+	}
+	since := config.Since(now)
+	if regimen.CreatedAt.After(since) {
+		since = regimen.CreatedAt
+	}
+	count := 0
+	for _, usage := range usages {
+		if usage.RegimenID == regimen.ID && usage.DeletedAt == nil && !usage.CreatedAt.Before(since) && !usage.CreatedAt.After(now) {
+			count++
+		}
+	}
+	days := math.Max(now.Sub(since).Hours()/24, 1)
+	if count == 0 {
+		forecast.LowSupply = servings == 0
+		return forecast
+	}
+	forecast.DailyUsage = math.Round(float64(count)/days*100) / 100
+	remaining := float64(servings) / (float64(count) / days)
+	runOut := now.Add(time.Duration(remaining * float64(24*time.Hour)))
+	remaining = math.Round(remaining*10) / 10
+	forecast.DaysRemaining = &remaining
+	forecast.RunOutAt = &runOut
+	forecast.LowSupply = remaining < config.LowSupplyDays
+	return forecast
+}
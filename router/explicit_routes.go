@@ -383,4 +383,94 @@ var ExplicitRoutes = []types.Route{
 		Pattern:     "/roles/{role_id}/{permission_id}",
 		HandlerFunc: handlers.AddPermissionToRoleByID,
 	},
+	{
+		Name:        "Get Webhooks",
+		Method:      "GET",
+		Pattern:     "/webhooks",
+		HandlerFunc: handlers.GetWebhooks,
+	},
+	{
+		Name:        "Post Webhook",
+		Method:      "POST",
+		Pattern:     "/webhooks",
+		HandlerFunc: handlers.PostWebhook,
+	},
+	{
+		Name:        "Get Webhook By ID",
+		Method:      "GET",
+		Pattern:     "/webhooks/{webhook_id}",
+		HandlerFunc: handlers.GetWebhookByID,
+	},
+	{
+		Name:        "Put Webhook By ID",
+		Method:      "PUT",
+		Pattern:     "/webhooks/{webhook_id}",
+		HandlerFunc: handlers.PutWebhookByID,
+	},
+	{
+		Name:        "Delete Webhook By ID",
+		Method:      "DELETE",
+		Pattern:     "/webhooks/{webhook_id}",
+		HandlerFunc: handlers.DeleteWebhookByID,
+	},
+	{
+		Name:        "Get Webhook Deliveries",
+		Method:      "GET",
+		Pattern:     "/webhooks/{webhook_id}/deliveries",
+		HandlerFunc: handlers.GetWebhookDeliveries,
+	},
+	{
+		Name:        "Replay Webhook Delivery",
+		Method:      "POST",
+		Pattern:     "/webhooks/{webhook_id}/deliveries/{delivery_id}/replay",
+		HandlerFunc: handlers.PostWebhookReplay,
+	},
+	{
+		Name:        "Get Firmware Fleet",
+		Method:      "GET",
+		Pattern:     "/firmware/fleet",
+		HandlerFunc: handlers.GetFirmwareFleet,
+	},
+	{
+		Name:        "Get Firmware Rollouts",
+		Method:      "GET",
+		Pattern:     "/firmware/rollouts",
+		HandlerFunc: handlers.GetFirmwareRollouts,
+	},
+	{
+		Name:        "Post Firmware Rollout",
+		Method:      "POST",
+		Pattern:     "/firmware/rollouts",
+		HandlerFunc: handlers.PostFirmwareRollout,
+	},
+	{
+		Name:        "Put Firmware Rollout By ID",
+		Method:      "PUT",
+		Pattern:     "/firmware/rollouts/{rollout_id}",
+		HandlerFunc: handlers.PutFirmwareRolloutByID,
+	},
+	{
+		Name:        "Delete Firmware Rollout By ID",
+		Method:      "DELETE",
+		Pattern:     "/firmware/rollouts/{rollout_id}",
+		HandlerFunc: handlers.DeleteFirmwareRolloutByID,
+	},
+	{
+		Name:        "Get Firmware Cohort Dispensers",
+		Method:      "GET",
+		Pattern:     "/firmware/cohorts/{cohort}/dispensers",
+		HandlerFunc: handlers.GetFirmwareCohortDispensers,
+	},
+	{
+		Name:        "Put Firmware Cohort Dispenser",
+		Method:      "PUT",
+		Pattern:     "/firmware/cohorts/{cohort}/dispensers/{serial}",
+		HandlerFunc: handlers.PutFirmwareCohortDispenser,
+	},
+	{
+		Name:        "Delete Firmware Cohort Dispenser",
+		Method:      "DELETE",
+		Pattern:     "/firmware/cohorts/{cohort}/dispensers/{serial}",
+		HandlerFunc: handlers.DeleteFirmwareCohortDispenser,
+	},
 }
@@ -113,6 +113,48 @@ var ImplicitRoutes = map[string]types.Route{
 		Pattern:     "/account/dispensers",
 		HandlerFunc: handlers.DeleteDispenser,
 	},
+	"account.delete.dispenser.by.id": {
+		Name:        "Delete Dispenser By ID",
+		Method:      "DELETE",
+		Pattern:     "/account/dispensers/{dispenser_id}",
+		HandlerFunc: handlers.DeleteDispenser,
+	},
+	"account.dispenser.status": {
+		Name:        "Get Dispenser Status",
+		Method:      "GET",
+		Pattern:     "/account/dispensers/{dispenser_id}/status",
+		HandlerFunc: handlers.GetDispenserStatus,
+	},
+	"account.dispenser.connections": {
+		Name:        "Get Dispenser Connection History",
+		Method:      "GET",
+		Pattern:     "/account/dispensers/{dispenser_id}/connections",
+		HandlerFunc: handlers.GetDispenserConnectionHistory,
+	},
+	"account.dispenser.firmware": {
+		Name:        "Get Dispenser Firmware",
+		Method:      "GET",
+		Pattern:     "/account/dispensers/{dispenser_id}/firmware",
+		HandlerFunc: handlers.GetDispenserFirmware,
+	},
+	"account.dispenser.commands": {
+		Name:        "Get Dispenser Commands",
+		Method:      "GET",
+		Pattern:     "/account/dispensers/{dispenser_id}/commands",
+		HandlerFunc: handlers.GetDispenserCommands,
+	},
+	"account.create.dispenser.command": {
+		Name:        "Post Dispenser Command",
+		Method:      "POST",
+		Pattern:     "/account/dispensers/{dispenser_id}/commands",
+		HandlerFunc: handlers.PostDispenserCommand,
+	},
+	"account.command": {
+		Name:        "Get Account Command By ID",
+		Method:      "GET",
+		Pattern:     "/account/commands/{command_id}",
+		HandlerFunc: handlers.GetAccountCommandByID,
+	},
 	"account.usages": {
 		Name:        "Get Account Usages",
 		Method:      "GET",
@@ -149,6 +191,24 @@ var ImplicitRoutes = map[string]types.Route{
 		Pattern:     "/user/usages/{usage_id}",
 		HandlerFunc: handlers.PutUserUsageByID,
 	},
+	"account.adherence": {
+		Name:        "Get Account Adherence",
+		Method:      "GET",
+		Pattern:     "/account/adherence",
+		HandlerFunc: handlers.GetAccountAdherence,
+	},
+	"user.adherence": {
+		Name:        "Get User Adherence",
+		Method:      "GET",
+		Pattern:     "/user/adherence",
+		HandlerFunc: handlers.GetUserAdherence,
+	},
+	"account.refills": {
+		Name:        "Get Account Refills",
+		Method:      "GET",
+		Pattern:     "/account/refills",
+		HandlerFunc: handlers.GetAccountRefills,
+	},
 	"account.regimens": {
 		Name:        "Get Regimens",
 		Method:      "GET",
@@ -245,4 +305,46 @@ var ImplicitRoutes = map[string]types.Route{
 		Pattern:     "/invitation/{invitation_id}/accept",
 		HandlerFunc: handlers.AcceptInvitation,
 	},
+	"account.webhooks": {
+		Name:        "Get Account Webhooks",
+		Method:      "GET",
+		Pattern:     "/account/webhooks",
+		HandlerFunc: handlers.GetAccountWebhooks,
+	},
+	"account.create.webhook": {
+		Name:        "Post Account Webhook",
+		Method:      "POST",
+		Pattern:     "/account/webhooks",
+		HandlerFunc: handlers.PostAccountWebhook,
+	},
+	"account.webhook": {
+		Name:        "Get Account Webhook By ID",
+		Method:      "GET",
+		Pattern:     "/account/webhooks/{webhook_id}",
+		HandlerFunc: handlers.GetAccountWebhookByID,
+	},
+	"account.update.webhook": {
+		Name:        "Put Account Webhook By ID",
+		Method:      "PUT",
+		Pattern:     "/account/webhooks/{webhook_id}",
+		HandlerFunc: handlers.PutAccountWebhookByID,
+	},
+	"account.delete.webhook": {
+		Name:        "Delete Account Webhook By ID",
+		Method:      "DELETE",
+		Pattern:     "/account/webhooks/{webhook_id}",
+		HandlerFunc: handlers.DeleteAccountWebhookByID,
+	},
+	"account.webhook.deliveries": {
+		Name:        "Get Account Webhook Deliveries",
+		Method:      "GET",
+		Pattern:     "/account/webhooks/{webhook_id}/deliveries",
+		HandlerFunc: handlers.GetAccountWebhookDeliveries,
+	},
+	"account.webhook.replay": {
+		Name:        "Replay Account Webhook Delivery",
+		Method:      "POST",
+		Pattern:     "/account/webhooks/{webhook_id}/deliveries/{delivery_id}/replay",
+		HandlerFunc: handlers.PostAccountWebhookReplay,
+	},
 }
@@ -14,13 +14,13 @@ var LambdaRoutes = []types.Route{
 		Name:        "Dispenser Dispensed",
 		Method:      "POST",
 		Pattern:     "/dispenser/dispensed",
-		HandlerFunc: handlers.DispenserDispensed,
+		HandlerFunc: handlers.IdempotentLambdaEvent("dispensed", handlers.DispenserDispensed),
 	},
 	{
 		Name:        "Dispenser Inserted",
 		Method:      "POST",
 		Pattern:     "/dispenser/inserted",
-		HandlerFunc: handlers.PodInserted,
+		HandlerFunc: handlers.IdempotentLambdaEvent("inserted", handlers.PodInserted),
 	},
 	{
 		Name:        "Dispenser Connected",
@@ -34,6 +34,24 @@ var LambdaRoutes = []types.Route{
 		Pattern:     "/dispenser/disconnected",
 		HandlerFunc: handlers.DispenserDisconnected,
 	},
+	{
+		Name:        "Dispenser Heartbeat",
+		Method:      "POST",
+		Pattern:     "/dispenser/heartbeat",
+		HandlerFunc: handlers.DispenserHeartbeat,
+	},
+	{
+		Name:        "Dispenser Firmware",
+		Method:      "POST",
+		Pattern:     "/dispenser/firmware",
+		HandlerFunc: handlers.DispenserFirmware,
+	},
+	{
+		Name:        "Dispenser Command Status",
+		Method:      "POST",
+		Pattern:     "/dispenser/command",
+		HandlerFunc: handlers.DeviceCommandStatus,
+	},
 	{
 		Name:        "Update User By External ID",
 		Method:      "PUT",
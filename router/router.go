@@ -2,29 +2,36 @@ package router
 
 import (
 	"net/http"
+	"os"
 
 	"github.com/gorilla/mux"
 	"github.com/tespo/buddha/auth"
 	"github.com/tespo/buddha/handlers"
+	"github.com/tespo/buddha/health"
+	"github.com/tespo/buddha/metrics"
 	"github.com/tespo/buddha/util"
 )
 
 //
-// CreateRouter builds the endpoints
+// CreateRouter builds the endpoints, guarding them
+// with the given authenticator. Voice command requests
+// are checked by the verifier of their provider, and
+// /readyz runs the checks of the readiness checker. /metrics
+// is left to MetricsRouter when METRICS_ADDR is set
 //
-func CreateRouter() *mux.Router {
+func CreateRouter(authenticator auth.Authenticator, voiceVerifiers map[string]auth.VoiceVerifier, readiness *health.Checker) *mux.Router {
 
 	router := mux.NewRouter().StrictSlash(true)
 
-	router.MethodNotAllowedHandler = http.HandlerFunc(handlers.MethodNotAllowedHandler)
-	router.NotFoundHandler = http.HandlerFunc(handlers.NotFoundHandler)
+	router.MethodNotAllowedHandler = instrument("Method Not Allowed", http.HandlerFunc(handlers.MethodNotAllowedHandler))
+	router.NotFoundHandler = instrument("Not Found", http.HandlerFunc(handlers.NotFoundHandler))
 
 	for _, route := range LambdaRoutes {
 		router.
 			Methods(route.Method).
 			Path(route.Pattern).
 			Name(route.Name).
-			Handler(auth.LambdaRouterAuthenticationWrapper(route.Pattern, route.Method, util.SentryWrapper(route.HandlerFunc)))
+			Handler(instrument(route.Name, auth.LambdaRouterAuthenticationWrapper(authenticator, route.Pattern, route.Method, util.SentryWrapper(route.HandlerFunc))))
 	}
 
 	for scope, route := range ImplicitRoutes {
@@ -32,7 +39,7 @@ func CreateRouter() *mux.Router {
 			Methods(route.Method).
 			Path(route.Pattern).
 			Name(route.Name).
-			Handler(auth.ImplicitRouterAuthenticationWrapper(scope, util.SentryWrapper(route.HandlerFunc)))
+			Handler(instrument(route.Name, auth.ImplicitRouterAuthenticationWrapper(authenticator, scope, util.SentryWrapper(route.HandlerFunc))))
 	}
 
 	for _, route := range ExplicitRoutes {
@@ -40,7 +47,7 @@ func CreateRouter() *mux.Router {
 			Methods(route.Method).
 			Path(route.Pattern).
 			Name(route.Name).
-			Handler(auth.ExplicitRouterAuthenticationWrapper(route.Pattern, route.Method, util.SentryWrapper(route.HandlerFunc)))
+			Handler(instrument(route.Name, auth.ExplicitRouterAuthenticationWrapper(authenticator, route.Pattern, route.Method, util.SentryWrapper(route.HandlerFunc))))
 	}
 
 	for provider, routes := range VoiceCommandRoutes {
@@ -49,11 +56,37 @@ func CreateRouter() *mux.Router {
 				Methods(route.Method).
 				Path(route.Pattern).
 				Name(route.Name).
-				Handler(auth.AuthenticateVoiceRequest(provider, util.SentryWrapper(route.HandlerFunc)))
+				Handler(instrument(route.Name, auth.AuthenticateVoiceRequest(authenticator, voiceVerifiers[provider], provider, util.SentryWrapper(route.HandlerFunc))))
 		}
 	}
 
+	if os.Getenv("METRICS_ADDR") == "" {
+		router.Methods("GET").Path("/metrics").Name("Metrics").Handler(handlers.Metrics(metrics.Registry))
+	}
+
+	router.Methods("GET").Path("/healthz").Name("Liveness").HandlerFunc(handlers.Liveness)
+
+	router.Methods("GET").Path("/readyz").Name("Readiness").Handler(handlers.Readiness(readiness))
+
 	router.Methods("GET").Path("/").Name("Status Check").HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(200); w.Write([]byte("ok")) })
 
 	return router
 }
+
+//
+// instrument logs the requests of a route with their
+// correlation ID, traces them and counts them in its metrics
+//
+func instrument(route string, next http.Handler) http.Handler {
+	return metrics.InstrumentRoute(route, util.RequestLogger(route, util.TraceRoute(route, next)))
+}
+
+//
+// MetricsRouter serves /metrics alone, for a listener
+// kept off the public one with METRICS_ADDR
+//
+func MetricsRouter() *mux.Router {
+	router := mux.NewRouter().StrictSlash(true)
+	router.Methods("GET").Path("/metrics").Name("Metrics").Handler(handlers.Metrics(metrics.Registry))
+	return router
+}
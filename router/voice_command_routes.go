@@ -6,8 +6,9 @@ import (
 )
 
 //
-// VoiceCommandRoutes are routes for Google Home
-// Alexa, and Siri
+// VoiceCommandRoutes are routes for Google Home,
+// Alexa, and plain webhooks such as Siri Shortcuts.
+// Each provider needs a codec in voice.Codecs
 //
 var VoiceCommandRoutes = map[string][]types.Route{
 	"google": {
@@ -15,7 +16,7 @@ var VoiceCommandRoutes = map[string][]types.Route{
 			Name:        "Fulfillment for google home voice commands",
 			Method:      "POST",
 			Pattern:     "/google/fulfillment",
-			HandlerFunc: handlers.GoogleFulfillment,
+			HandlerFunc: handlers.VoiceFulfillment("google"),
 		},
 	},
 	"alexa": {
@@ -23,7 +24,15 @@ var VoiceCommandRoutes = map[string][]types.Route{
 			Name:        "Fulfillment for alexa voice commands",
 			Method:      "POST",
 			Pattern:     "/alexa/fulfillment",
-			HandlerFunc: handlers.AlexaFulfillment,
+			HandlerFunc: handlers.VoiceFulfillment("alexa"),
+		},
+	},
+	"webhook": {
+		{
+			Name:        "Fulfillment for webhook voice commands",
+			Method:      "POST",
+			Pattern:     "/voice/fulfillment",
+			HandlerFunc: handlers.VoiceFulfillment("webhook"),
 		},
 	},
 }
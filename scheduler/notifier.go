@@ -0,0 +1,155 @@
+package scheduler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	"github.com/tespo/buddha/util"
+)
+
+const (
+	// KindDue is sent when a dose is due and not yet taken
+	KindDue = "due"
+	// KindMissed is sent when a dose was not taken in time
+	KindMissed = "missed"
+)
+
+//
+// Notification is a reminder for one scheduled dose
+//
+type Notification struct {
+	Kind        string    `json:"kind"`
+	ReminderID  uuid.UUID `json:"reminder_id"`
+	RegimenID   uuid.UUID `json:"regimen_id"`
+	UserID      uuid.UUID `json:"user_id"`
+	Email       string    `json:"email"`
+	FirstName   string    `json:"first_name"`
+	ScheduledAt time.Time `json:"scheduled_at"`
+	Timezone    string    `json:"timezone"`
+}
+
+//
+// Notifier delivers reminder notifications to users
+//
+type Notifier interface {
+	Notify(notification Notification) error
+}
+
+//
+// NotifierFromEnv builds the notifier named by REMINDER_NOTIFIER,
+// defaulting to logging to stdout
+//
+func NotifierFromEnv() (Notifier, error) {
+	switch notifier := os.Getenv("REMINDER_NOTIFIER"); notifier {
+	case "", "log":
+		path := os.Getenv("REMINDER_LOG_FILE")
+		if path == "" {
+			return NewLogNotifier(os.Stdout), nil
+		}
+		file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return nil, err
+		}
+		return NewLogNotifier(file), nil
+	case "email":
+		return EmailNotifier{}, nil
+	case "webhook":
+		url := os.Getenv("REMINDER_WEBHOOK_URL")
+		if url == "" {
+			return nil, fmt.Errorf("REMINDER_WEBHOOK_URL is required for the webhook notifier")
+		}
+		return NewWebhookNotifier(url, 10*time.Second), nil
+	default:
+		return nil, fmt.Errorf("Unknown reminder notifier %v", notifier)
+	}
+}
+
+//
+// LogNotifier writes each notification as a json line.
+// It is meant for local development
+//
+type LogNotifier struct {
+	mutex  sync.Mutex
+	writer io.Writer
+}
+
+//
+// NewLogNotifier returns a notifier writing to the writer
+//
+func NewLogNotifier(writer io.Writer) *LogNotifier {
+	return &LogNotifier{writer: writer}
+}
+
+//
+// Notify writes the notification
+//
+func (n *LogNotifier) Notify(notification Notification) error {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	return json.NewEncoder(n.writer).Encode(notification)
+}
+
+//
+// WebhookNotifier posts each notification as json to a url
+//
+type WebhookNotifier struct {
+	url    string
+	client *http.Client
+}
+
+//
+// NewWebhookNotifier returns a notifier posting to the url
+//
+func NewWebhookNotifier(url string, timeout time.Duration) *WebhookNotifier {
+	return &WebhookNotifier{url: url, client: &http.Client{Timeout: timeout}}
+}
+
+//
+// Notify posts the notification, failing on any non 2xx response
+//
+func (n *WebhookNotifier) Notify(notification Notification) error {
+	data, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+	resp, err := n.client.Post(n.url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("Reminder webhook responded %v", resp.Status)
+	}
+	return nil
+}
+
+//
+// EmailNotifier emails the user through the SES
+// template named by REMINDER_TEMPLATE_NAME
+//
+type EmailNotifier struct{}
+
+//
+// Notify emails the notification to the user
+//
+func (EmailNotifier) Notify(notification Notification) error {
+	if notification.Email == "" {
+		return fmt.Errorf("User %v has no email address", notification.UserID)
+	}
+	location, err := time.LoadLocation(notification.Timezone)
+	if err != nil {
+		location = time.UTC
+	}
+	return util.SendReminderEmail(notification.Email, map[string]string{
+		"name":        notification.FirstName,
+		"kind":        notification.Kind,
+		"scheduledAt": notification.ScheduledAt.In(location).Format("3:04 PM"),
+	})
+}
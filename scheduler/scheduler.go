@@ -0,0 +1,331 @@
+package scheduler
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	"github.com/jinzhu/gorm"
+	uuid "github.com/satori/go.uuid"
+	"github.com/tespo/buddha/models"
+	"github.com/tespo/satya/v2/types"
+)
+
+//
+// Config holds the timing of the scheduler
+//
+type Config struct {
+	// Interval is how often reminders are evaluated
+	Interval time.Duration
+	// Window is how early a usage still counts for a dose
+	Window time.Duration
+	// MissedAfter is how long after a dose it is reported missed
+	MissedAfter time.Duration
+	// CatchUp is how far back a restart goes to notify the
+	// doses that fell due while no scheduler was running
+	CatchUp time.Duration
+	// Location is used for reminders and users without a timezone
+	Location *time.Location
+}
+
+//
+// ConfigFromEnv reads REMINDER_INTERVAL, REMINDER_WINDOW,
+// REMINDER_MISSED_AFTER, REMINDER_CATCH_UP and REMINDER_TIMEZONE
+//
+func ConfigFromEnv() (Config, error) {
+	config := Config{
+		Interval:    envDuration("REMINDER_INTERVAL", time.Minute),
+		Window:      envDuration("REMINDER_WINDOW", time.Hour),
+		MissedAfter: envDuration("REMINDER_MISSED_AFTER", 2*time.Hour),
+		CatchUp:     envDuration("REMINDER_CATCH_UP", 6*time.Hour),
+		Location:    time.UTC,
+	}
+	if tz := os.Getenv("REMINDER_TIMEZONE"); tz != "" {
+		location, err := time.LoadLocation(tz)
+		if err != nil {
+			return config, err
+		}
+		config.Location = location
+	}
+	return config, nil
+}
+
+//
+// Scheduler evaluates reminder schedules in the background and
+// notifies users of doses that are due or were missed. Each run
+// covers the time since the previous one, so a dose is looked
+// at once no matter how long a run takes. The end of the last
+// run is kept in the database for the next instance to start from
+//
+type Scheduler struct {
+	db       *gorm.DB
+	notifier Notifier
+	config   Config
+	last     time.Time
+	started  bool
+	stop     chan struct{}
+	done     chan struct{}
+	once     sync.Once
+}
+
+//
+// New returns a scheduler that has not been started
+//
+func New(db *gorm.DB, notifier Notifier, config Config) *Scheduler {
+	return &Scheduler{
+		db:       db,
+		notifier: notifier,
+		config:   config,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+//
+// watermarkName names the scheduler's watermark
+//
+const watermarkName = "reminders"
+
+//
+// Start runs the scheduler until Stop is called. The first run
+// starts where the last run of any instance ended, going back
+// no further than the catch up
+//
+func (s *Scheduler) Start() {
+	s.started = true
+	s.last = s.Resume(time.Now())
+	go func() {
+		defer close(s.done)
+		ticker := time.NewTicker(s.config.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.stop:
+				return
+			case now := <-ticker.C:
+				if err := s.Run(s.last, now); err != nil {
+					log.Println("reminder scheduler:", err)
+					sentry.CaptureException(err)
+					continue
+				}
+				s.last = now
+				if err := models.AdvanceWatermark(s.db, watermarkName, now); err != nil {
+					sentry.CaptureException(err)
+				}
+			}
+		}
+	}()
+}
+
+//
+// Resume returns the time the first run starts from: the
+// watermark when there is one within the catch up, or now
+//
+func (s *Scheduler) Resume(now time.Time) time.Time {
+	watermark := models.SchedulerWatermark{}
+	if err := watermark.GetOneByQuery(s.db, "name = ?", watermarkName); err != nil || !watermark.RunAt.Before(now) {
+		return now
+	}
+	if earliest := now.Add(-s.config.CatchUp); watermark.RunAt.Before(earliest) {
+		return earliest
+	}
+	return watermark.RunAt
+}
+
+//
+// Stop stops the scheduler, waiting for a run in progress
+//
+func (s *Scheduler) Stop() {
+	if !s.started {
+		return
+	}
+	s.once.Do(func() {
+		close(s.stop)
+	})
+	<-s.done
+}
+
+//
+// Run notifies the doses falling due in (from, to] and the
+// doses whose missed deadline falls in the same span. Only the
+// reminders set to a minute that can fall in either are read
+//
+func (s *Scheduler) Run(from, to time.Time) error {
+	query := s.db
+	due := Minutes(from, to)
+	missed := Minutes(from.Add(-s.config.MissedAfter), to.Add(-s.config.MissedAfter))
+	if due != nil && missed != nil {
+		query = query.Where("minute IN (?)", append(due, missed...))
+	}
+	reminders := types.Reminders{}
+	if err := query.Find(&reminders).Error; err != nil {
+		return err
+	}
+	users := map[uuid.UUID]*types.User{}
+	for _, reminder := range reminders {
+		user, ok := users[reminder.UserID]
+		if !ok {
+			user = &types.User{}
+			if err := user.GetByID(s.db, reminder.UserID); err != nil {
+				user = nil
+			}
+			users[reminder.UserID] = user
+		}
+		if user == nil {
+			continue
+		}
+		location := s.location(reminder, *user)
+		for _, at := range Occurrences(reminder.Minute, location, from, to) {
+			if !at.Before(reminder.CreatedAt) {
+				s.check(reminder, *user, location, KindDue, at, at.Add(-s.config.Window), to)
+			}
+		}
+		missedFrom, missedTo := from.Add(-s.config.MissedAfter), to.Add(-s.config.MissedAfter)
+		for _, at := range Occurrences(reminder.Minute, location, missedFrom, missedTo) {
+			if !at.Before(reminder.CreatedAt) {
+				s.check(reminder, *user, location, KindMissed, at, at.Add(-s.config.Window), at.Add(s.config.MissedAfter))
+			}
+		}
+	}
+	return nil
+}
+
+//
+// check notifies the dose unless a usage between start and end
+// already covers it or another run has already claimed it
+//
+func (s *Scheduler) check(reminder types.Reminder, user types.User, location *time.Location, kind string, at, start, end time.Time) {
+	usages := types.Usages{}
+	if err := usages.GetByQuery(s.db, "regimen_id = ? AND created_at BETWEEN ? AND ?", reminder.RegimenID, start, end); err != nil {
+		sentry.CaptureException(err)
+		return
+	}
+	if len(usages) > 0 {
+		return
+	}
+	record := models.ReminderNotification{
+		Key:         fmt.Sprintf("%v|%v|%v", reminder.ID, kind, at.Unix()),
+		ReminderID:  reminder.ID,
+		UserID:      user.ID,
+		Kind:        kind,
+		ScheduledAt: at,
+	}
+	if err := record.Create(s.db); err != nil {
+		return
+	}
+	err := s.notifier.Notify(Notification{
+		Kind:        kind,
+		ReminderID:  reminder.ID,
+		RegimenID:   reminder.RegimenID,
+		UserID:      user.ID,
+		Email:       user.Email,
+		FirstName:   user.FirstName,
+		ScheduledAt: at,
+		Timezone:    location.String(),
+	})
+	if err != nil {
+		sentry.CaptureException(err)
+		record.Error = err.Error()
+		record.Update(s.db)
+	}
+}
+
+//
+// location is the timezone in the reminder's meta, then
+// the user's meta, falling back to the configured one
+//
+func (s *Scheduler) location(reminder types.Reminder, user types.User) *time.Location {
+	return MetaLocation(s.config.Location, reminder.Meta, user.Meta)
+}
+
+//
+// MetaLocation is the first valid timezone found in the metas,
+// or the fallback when none of them has one
+//
+func MetaLocation(fallback *time.Location, metas ...json.RawMessage) *time.Location {
+	for _, meta := range metas {
+		var values struct {
+			Timezone string `json:"timezone"`
+		}
+		if len(meta) == 0 || json.Unmarshal(meta, &values) != nil || values.Timezone == "" {
+			continue
+		}
+		if location, err := time.LoadLocation(values.Timezone); err == nil {
+			return location
+		}
+	}
+	return fallback
+}
+
+//
+// Occurrences lists the times in (from, to] a reminder for the
+// minute of the day goes off in the location. time.Date moves a
+// time skipped by daylight saving forward, and a time repeated
+// by it goes off once
+//
+func Occurrences(minute uint, location *time.Location, from, to time.Time) []time.Time {
+	times := []time.Time{}
+	start := from.In(location)
+	day := time.Date(start.Year(), start.Month(), start.Day()-1, 0, 0, 0, 0, location)
+	for !day.After(to) {
+		at := time.Date(day.Year(), day.Month(), day.Day(), int(minute/60), int(minute%60), 0, 0, location)
+		if at.After(from) && !at.After(to) {
+			times = append(times, at)
+		}
+		day = time.Date(day.Year(), day.Month(), day.Day()+1, 0, 0, 0, 0, location)
+	}
+	return times
+}
+
+//
+// UTC offsets range from -12:00 to +14:00, in steps of 15 minutes
+//
+const (
+	minOffset   = -12 * 60
+	maxOffset   = 14 * 60
+	offsetStep  = 15
+	minutesADay = 24 * 60
+)
+
+//
+// Minutes lists the minutes of the day a reminder can be set to and
+// go off in (from, to] in some timezone. It is nil when the span
+// takes in every minute of the day
+//
+func Minutes(from, to time.Time) []uint {
+	if to.Sub(from) >= 24*time.Hour {
+		return nil
+	}
+	seen := map[uint]bool{}
+	for at := from.UTC().Truncate(time.Minute); !at.After(to); at = at.Add(time.Minute) {
+		if !at.After(from) {
+			continue
+		}
+		minute := at.Hour()*60 + at.Minute()
+		for offset := minOffset; offset <= maxOffset; offset += offsetStep {
+			seen[uint((minute+offset+minutesADay)%minutesADay)] = true
+		}
+	}
+	if len(seen) == minutesADay {
+		return nil
+	}
+	minutes := make([]uint, 0, len(seen))
+	for minute := range seen {
+		minutes = append(minutes, minute)
+	}
+	sort.Slice(minutes, func(i, j int) bool { return minutes[i] < minutes[j] })
+	return minutes
+}
+
+func envDuration(key string, fallback time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(key))
+	if err != nil || value <= 0 {
+		return fallback
+	}
+	return value
+}
@@ -0,0 +1,182 @@
+package shutdown
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+//
+// Stopper is background work, such as a scheduler,
+// that stops when asked and returns once it has
+//
+type Stopper interface {
+	Stop()
+}
+
+//
+// Config holds the settings of the server and how long it
+// may take to drain on shutdown
+//
+type Config struct {
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	Timeout           time.Duration
+}
+
+//
+// ConfigFromEnv builds the server config specific to the environment
+//
+func ConfigFromEnv() Config {
+	return Config{
+		ReadHeaderTimeout: envDuration("HTTP_READ_HEADER_TIMEOUT", 5*time.Second),
+		ReadTimeout:       envDuration("HTTP_READ_TIMEOUT", 15*time.Second),
+		WriteTimeout:      envDuration("HTTP_WRITE_TIMEOUT", 30*time.Second),
+		IdleTimeout:       envDuration("HTTP_IDLE_TIMEOUT", 2*time.Minute),
+		Timeout:           envDuration("SHUTDOWN_TIMEOUT", 30*time.Second),
+	}
+}
+
+func envDuration(key string, fallback time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(key))
+	if err != nil || value <= 0 {
+		return fallback
+	}
+	return value
+}
+
+type registered struct {
+	name    string
+	stopper Stopper
+}
+
+type task struct {
+	name string
+	done chan struct{}
+}
+
+//
+// Group is the background work to finish on shutdown: workers
+// registered to be stopped, and goroutines started with Go
+//
+type Group struct {
+	mutex    sync.Mutex
+	stoppers []registered
+	tasks    map[*task]bool
+}
+
+//
+// Default is the group buddha shuts down on SIGTERM
+//
+var Default = NewGroup()
+
+//
+// NewGroup returns an empty group
+//
+func NewGroup() *Group {
+	return &Group{tasks: map[*task]bool{}}
+}
+
+//
+// Register adds a worker to stop on shutdown. Workers are
+// stopped in the reverse order they were registered in
+//
+func (g *Group) Register(name string, stopper Stopper) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.stoppers = append(g.stoppers, registered{name: name, stopper: stopper})
+}
+
+//
+// Go runs fn in the background. Shutdown waits for it
+// before stopping the workers
+//
+func (g *Group) Go(name string, fn func()) {
+	t := &task{name: name, done: make(chan struct{})}
+	g.mutex.Lock()
+	g.tasks[t] = true
+	g.mutex.Unlock()
+	go func() {
+		defer func() {
+			g.mutex.Lock()
+			delete(g.tasks, t)
+			g.mutex.Unlock()
+			close(t.done)
+		}()
+		fn()
+	}()
+}
+
+//
+// Shutdown waits for the goroutines started with Go, then stops
+// the workers. When the context ends first it returns an error
+// naming the work that had not finished
+//
+func (g *Group) Shutdown(ctx context.Context) error {
+	g.mutex.Lock()
+	tasks := make([]*task, 0, len(g.tasks))
+	for t := range g.tasks {
+		tasks = append(tasks, t)
+	}
+	stoppers := append([]registered(nil), g.stoppers...)
+	g.stoppers = nil
+	g.mutex.Unlock()
+
+	for _, t := range tasks {
+		select {
+		case <-t.done:
+		case <-ctx.Done():
+			return unfinished(g.pending(), stoppers)
+		}
+	}
+	for i := len(stoppers) - 1; i >= 0; i-- {
+		stopped := make(chan struct{})
+		go func(stopper Stopper) {
+			defer close(stopped)
+			stopper.Stop()
+		}(stoppers[i].stopper)
+		select {
+		case <-stopped:
+		case <-ctx.Done():
+			return unfinished(nil, stoppers[:i+1])
+		}
+	}
+	return nil
+}
+
+func (g *Group) pending() []string {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	names := []string{}
+	for t := range g.tasks {
+		names = append(names, t.name)
+	}
+	return names
+}
+
+func unfinished(tasks []string, stoppers []registered) error {
+	names := append([]string(nil), tasks...)
+	for i := len(stoppers) - 1; i >= 0; i-- {
+		names = append(names, stoppers[i].name)
+	}
+	return errors.New("shutdown deadline passed before finishing: " + strings.Join(names, ", "))
+}
+
+//
+// Register adds a worker to the default group
+//
+func Register(name string, stopper Stopper) {
+	Default.Register(name, stopper)
+}
+
+//
+// Go runs fn in the background in the default group
+//
+func Go(name string, fn func()) {
+	Default.Go(name, fn)
+}
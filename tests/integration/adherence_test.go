@@ -0,0 +1,116 @@
+package integration
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	"github.com/tespo/buddha/adherence"
+	"github.com/tespo/satya/v2/types"
+)
+
+func adherenceFixture(location *time.Location, from time.Time, days int, usageTimes ...time.Time) (types.Regimens, types.Reminders, types.Usages, adherence.Options) {
+	regimen := types.Regimen{ID: uuid.NewV4()}
+	reminders := types.Reminders{{
+		ID:        uuid.NewV4(),
+		RegimenID: regimen.ID,
+		Minute:    8 * 60,
+		CreatedAt: from.AddDate(0, 0, -1),
+	}}
+	usages := types.Usages{}
+	for _, at := range usageTimes {
+		usages = append(usages, types.Usage{RegimenID: regimen.ID, CreatedAt: at})
+	}
+	options := adherence.Options{
+		From:     from,
+		To:       from.AddDate(0, 0, days-1),
+		Location: location,
+		Window:   adherence.DefaultWindow,
+		Now:      from.AddDate(0, 0, days+1),
+	}
+	return types.Regimens{regimen}, reminders, usages, options
+}
+
+func TestAdherenceCountsTakenLateAndMissed(tests *testing.T) {
+	location, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		tests.Error(err)
+		return
+	}
+	from := time.Date(2019, 6, 1, 0, 0, 0, 0, location)
+	regimens, reminders, usages, options := adherenceFixture(location, from, 4,
+		time.Date(2019, 6, 1, 8, 10, 0, 0, location),
+		time.Date(2019, 6, 2, 10, 30, 0, 0, location),
+		time.Date(2019, 6, 4, 7, 30, 0, 0, location),
+		time.Date(2019, 6, 4, 7, 45, 0, 0, location),
+	)
+	report := adherence.Build(regimens, reminders, usages, options)
+	if report.Taken != 2 || report.Late != 1 || report.Missed != 1 {
+		tests.Errorf("Expected 2 taken, 1 late and 1 missed, got %v, %v and %v", report.Taken, report.Late, report.Missed)
+	}
+	if report.Adherence == nil || *report.Adherence != 75 {
+		tests.Errorf("Expected 75%% adherence, got %v", report.Adherence)
+	}
+	if report.LongestStreak != 2 || report.CurrentStreak != 1 {
+		tests.Errorf("Expected streaks of 2 and 1, got %v and %v", report.LongestStreak, report.CurrentStreak)
+	}
+	if len(report.Days) != 4 || report.Days[2].Missed != 1 {
+		tests.Errorf("Expected the third day to be missed, got %+v", report.Days)
+	}
+}
+
+func TestAdherenceFollowsDaylightSaving(tests *testing.T) {
+	location, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		tests.Error(err)
+		return
+	}
+	from := time.Date(2019, 3, 9, 0, 0, 0, 0, location)
+	regimens, reminders, usages, options := adherenceFixture(location, from, 3,
+		time.Date(2019, 3, 9, 8, 0, 0, 0, location),
+		time.Date(2019, 3, 10, 8, 0, 0, 0, location),
+		time.Date(2019, 3, 11, 8, 0, 0, 0, location),
+	)
+	report := adherence.Build(regimens, reminders, usages, options)
+	if report.Scheduled != 3 || report.Taken != 3 {
+		tests.Errorf("Expected 3 doses taken across the switch, got %v of %v", report.Taken, report.Scheduled)
+	}
+}
+
+func TestAdherenceLeavesUpcomingDosesPending(tests *testing.T) {
+	from := time.Date(2019, 6, 1, 0, 0, 0, 0, time.UTC)
+	regimens, reminders, usages, options := adherenceFixture(time.UTC, from, 1)
+	options.Now = time.Date(2019, 6, 1, 7, 0, 0, 0, time.UTC)
+	report := adherence.Build(regimens, reminders, usages, options)
+	if report.Pending != 1 || report.Missed != 0 || report.Adherence != nil {
+		tests.Errorf("Expected the dose to be pending, got %+v", report.Summary)
+	}
+}
+
+func TestGetUserAdherence(tests *testing.T) {
+	if testing.Short() {
+		tests.Skip()
+	}
+	response, err := http.Get(os.Getenv("TESTING_URL") + "/user/adherence?from=2019-06-01&to=2019-06-30&tz=America/New_York")
+	if err != nil {
+		tests.Error(err)
+		return
+	}
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		tests.Error(err)
+		return
+	}
+	report := adherence.Report{}
+	if err := json.Unmarshal(body, &report); err != nil {
+		tests.Error(err)
+		return
+	}
+	if len(report.Days) != 30 || report.Timezone != "America/New_York" {
+		tests.Errorf("Returned the wrong range: %v days in %v", len(report.Days), report.Timezone)
+	}
+}
@@ -0,0 +1,143 @@
+package integration
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/tespo/buddha/auth"
+	"github.com/tespo/buddha/util"
+)
+
+func testVerifier(tests *testing.T) *util.TokenVerifier {
+	verifier, err := util.NewTokenVerifier(util.TokenConfig{HMACSecret: tokenSecret})
+	if err != nil {
+		tests.Fatal(err)
+	}
+	return verifier
+}
+
+func TestVijnanaValidationIsCached(tests *testing.T) {
+	var calls int32
+	vijnana := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		if r.Header.Get("Authorization") != "Bearer cached-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer vijnana.Close()
+	os.Setenv("VIJNANA_URL", vijnana.URL)
+
+	authenticator := auth.NewVijnanaAuthenticator(testVerifier(tests), 5*time.Second, time.Minute, 10*time.Second)
+	handler := auth.ExplicitRouterAuthenticationWrapper(authenticator, "/cached", "GET", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			request := httptest.NewRequest("GET", "/cached", nil)
+			request.Header.Set("Authorization", "Bearer cached-token")
+			recorder := httptest.NewRecorder()
+			handler(recorder, request)
+			if recorder.Code != http.StatusOK {
+				tests.Error(errors.New("Valid token was rejected"))
+			}
+		}()
+	}
+	wg.Wait()
+	if atomic.LoadInt32(&calls) != 1 {
+		tests.Errorf("Expected one call to vijnana, got %v", calls)
+	}
+
+	for i := 0; i < 3; i++ {
+		request := httptest.NewRequest("GET", "/cached", nil)
+		request.Header.Set("Authorization", "Bearer rejected-token")
+		recorder := httptest.NewRecorder()
+		handler(recorder, request)
+		if recorder.Code != http.StatusUnauthorized {
+			tests.Error(errors.New("Invalid token was accepted"))
+		}
+	}
+	if atomic.LoadInt32(&calls) != 2 {
+		tests.Errorf("Expected rejected token to be cached, got %v calls", calls)
+	}
+}
+
+func TestVijnanaErrorsAreNotCached(tests *testing.T) {
+	var calls int32
+	vijnana := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer vijnana.Close()
+	os.Setenv("VIJNANA_URL", vijnana.URL)
+
+	authenticator := auth.NewVijnanaAuthenticator(testVerifier(tests), 5*time.Second, time.Minute, time.Minute)
+	handler := auth.ExplicitRouterAuthenticationWrapper(authenticator, "/outage", "GET", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	for _, expected := range []int{http.StatusInternalServerError, http.StatusOK} {
+		request := httptest.NewRequest("GET", "/outage", nil)
+		request.Header.Set("Authorization", "Bearer outage-token")
+		recorder := httptest.NewRecorder()
+		handler(recorder, request)
+		if recorder.Code != expected {
+			tests.Errorf("Expected %v, got %v", expected, recorder.Code)
+		}
+	}
+	if atomic.LoadInt32(&calls) != 2 {
+		tests.Errorf("Expected the token to be checked again after the outage, got %v calls", calls)
+	}
+}
+
+func TestImplicitWrapperChecksScope(tests *testing.T) {
+	claims := map[string]interface{}{}
+	for key, value := range testClaims {
+		claims[key] = value
+	}
+	claims["scope_permissions"] = []interface{}{"account.info"}
+	authenticator := auth.NewStaticAuthenticator(map[string]map[string]interface{}{
+		"scoped-token": claims,
+		"empty-token": map[string]interface{}{
+			"account_id":        claims["account_id"],
+			"user_id":           claims["user_id"],
+			"owner":             false,
+			"scoped_fields":     []interface{}{},
+			"scope_permissions": []interface{}{},
+		},
+	})
+	next := func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}
+	cases := []struct {
+		token string
+		scope string
+		code  int
+	}{
+		{"scoped-token", "account.info", http.StatusOK},
+		{"scoped-token", "account.users", http.StatusUnauthorized},
+		{"empty-token", "account.info", http.StatusUnauthorized},
+		{"unknown-token", "account.info", http.StatusUnauthorized},
+	}
+	for _, c := range cases {
+		request := httptest.NewRequest("GET", "/scoped", nil)
+		request.Header.Set("Authorization", "Bearer "+c.token)
+		recorder := httptest.NewRecorder()
+		auth.ImplicitRouterAuthenticationWrapper(authenticator, c.scope, next)(recorder, request)
+		if recorder.Code != c.code {
+			tests.Errorf("Expected %v for %v on %v, got %v", c.code, c.token, c.scope, recorder.Code)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package integration
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	"github.com/tespo/buddha/models"
+	"github.com/tespo/satya/v2/types"
+)
+
+func TestDeviceCommandOnlyMovesForward(tests *testing.T) {
+	now := time.Now()
+	command := models.DeviceCommand{Status: models.CommandQueued}
+	if !command.Advance(models.CommandSent, now) || command.SentAt == nil {
+		tests.Fatalf("Expected a queued command to be sent, got %+v", command)
+	}
+	if !command.Advance(models.CommandCompleted, now) || command.CompletedAt == nil {
+		tests.Fatalf("Expected a sent command to complete, got %+v", command)
+	}
+	if command.Advance(models.CommandAcknowledged, now) {
+		tests.Errorf("Expected a completed command not to go back to acknowledged")
+	}
+	if command.Fail("late", now) || command.Status != models.CommandCompleted {
+		tests.Errorf("Expected a completed command not to fail, got %+v", command)
+	}
+}
+
+func TestPostDispenserCommandRejectsUnknownKinds(tests *testing.T) {
+	if testing.Short() {
+		tests.Skip()
+	}
+	body, _ := json.Marshal(map[string]string{"kind": "explode"})
+	response, err := http.Post(os.Getenv("TESTING_URL")+"/account/dispensers/kitchen/commands", "application/json", bytes.NewReader(body))
+	if err != nil {
+		tests.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		tests.Fatal(err)
+	}
+	if response.StatusCode != http.StatusBadRequest {
+		tests.Errorf("Expected 400 for an unknown kind, got %v: %s", response.StatusCode, data)
+	}
+}
+
+func TestPostDispenserCommandAnswersBadGatewayWhenNotSent(tests *testing.T) {
+	if testing.Short() {
+		tests.Skip()
+	}
+	accountID := uuid.FromStringOrNil("d8e4c5dc-9767-41bd-b802-060e80d83867")
+	testDispenser := types.Dispenser{Serial: "command-" + randomString(8), Name: "Command " + randomString(4)}
+	if err := testDispenser.Create(testDB); err != nil {
+		tests.Fatal(err)
+	}
+	defer testDispenser.Delete(testDB, testDispenser.ID)
+	connection := types.Connection{DispenserID: testDispenser.ID, AccountID: accountID, ConnectedAt: time.Now()}
+	if err := connection.Create(testDB); err != nil {
+		tests.Fatal(err)
+	}
+	defer connection.Delete(testDB, connection.ID)
+
+	body, _ := json.Marshal(map[string]string{"kind": models.CommandLocate})
+	response, err := http.Post(os.Getenv("TESTING_URL")+"/account/dispensers/"+testDispenser.ID.String()+"/commands", "application/json", bytes.NewReader(body))
+	if err != nil {
+		tests.Fatal(err)
+	}
+	defer response.Body.Close()
+	command := models.DeviceCommand{}
+	if err := json.NewDecoder(response.Body).Decode(&command); err != nil {
+		tests.Fatal(err)
+	}
+	defer testDB.Delete(&models.DeviceCommand{}, "id = ?", command.ID)
+	switch command.Status {
+	case models.CommandSent:
+		if response.StatusCode != http.StatusOK {
+			tests.Errorf("Expected a sent command to answer 200, got %v", response.StatusCode)
+		}
+	case models.CommandFailed:
+		if response.StatusCode != http.StatusBadGateway || command.Error == "" {
+			tests.Errorf("Expected a command that was not sent to answer 502 with the error, got %v: %+v", response.StatusCode, command)
+		}
+	default:
+		tests.Errorf("Expected the command to be sent or failed, got %+v", command)
+	}
+}
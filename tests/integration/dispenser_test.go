@@ -7,10 +7,13 @@ import (
 	"io/ioutil"
 	"math/rand"
 	"net/http"
+	"net/url"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
+	uuid "github.com/satori/go.uuid"
 	"github.com/tespo/satya/v2/types"
 )
 
@@ -158,6 +161,62 @@ func TestDeleteDispensersByID(tests *testing.T) {
 	}
 }
 
+func TestAccountWithSeveralDispensers(tests *testing.T) {
+	if testing.Short() {
+		tests.Skip()
+	}
+	accountID := uuid.FromStringOrNil("d8e4c5dc-9767-41bd-b802-060e80d83867")
+	kitchen := types.Dispenser{Serial: "kitchen-" + randomString(8), Name: "Kitchen " + randomString(4)}
+	bathroom := types.Dispenser{Serial: "bathroom-" + randomString(8), Name: "Bathroom " + randomString(4)}
+	for _, testDispenser := range []*types.Dispenser{&kitchen, &bathroom} {
+		if err := testDispenser.Create(testDB); err != nil {
+			tests.Error(err)
+			return
+		}
+		connection := types.Connection{DispenserID: testDispenser.ID, AccountID: accountID, ConnectedAt: time.Now()}
+		if err := connection.Create(testDB); err != nil {
+			tests.Error(err)
+			return
+		}
+		defer connection.Delete(testDB, connection.ID)
+		defer testDispenser.Delete(testDB, testDispenser.ID)
+	}
+
+	response, err := http.Get(os.Getenv("TESTING_URL") + "/account/dispensers/" + url.PathEscape(strings.ToLower(bathroom.Name)))
+	if err != nil {
+		tests.Error(err)
+		return
+	}
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		tests.Error(err)
+		return
+	}
+	testDispenser := types.Dispenser{}
+	if err := json.Unmarshal(body, &testDispenser); err != nil {
+		tests.Error(err)
+		return
+	}
+	if testDispenser.ID != bathroom.ID {
+		tests.Error(errors.New("Returned the wrong dispenser for the name"))
+	}
+
+	request, err := http.NewRequest("DELETE", os.Getenv("TESTING_URL")+"/account/dispensers", nil)
+	if err != nil {
+		tests.Error(err)
+		return
+	}
+	response, err = http.DefaultClient.Do(request)
+	if err != nil {
+		tests.Error(err)
+		return
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusBadRequest {
+		tests.Errorf("Expected deleting without choosing a dispenser to fail, got %v", response.StatusCode)
+	}
+}
+
 func randomString(len int) string {
 	rand.Seed(time.Now().UTC().UnixNano())
 	bytes := make([]byte, len)
@@ -0,0 +1,98 @@
+package integration
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	uuid "github.com/satori/go.uuid"
+	"github.com/tespo/buddha/firmware"
+	"github.com/tespo/buddha/models"
+	"github.com/tespo/satya/v2/types"
+)
+
+func TestFirmwareFromPayloadKeepsParts(tests *testing.T) {
+	versions := firmware.FromPayload(types.PayloadDispenser{
+		PcbFirmwareVersion:        "pcb-1",
+		ControllerFirmwareVersion: "controller-1",
+	})
+	if versions[firmware.PartPCB] != "pcb-1" || versions[firmware.PartController] != "controller-1" {
+		tests.Errorf("Expected each part to keep its own version, got %v", versions)
+	}
+	if _, ok := versions[firmware.PartWifi]; ok {
+		tests.Errorf("Expected a part without a version to be skipped, got %v", versions)
+	}
+	meta, err := firmware.Meta(json.RawMessage(`{"nickname": "kitchen", "pcb": {"version": "pcb-0"}}`), versions)
+	if err != nil {
+		tests.Fatal(err)
+	}
+	fields := map[string]interface{}{}
+	if err := json.Unmarshal(meta, &fields); err != nil {
+		tests.Fatal(err)
+	}
+	if fields["nickname"] != "kitchen" || fields["pcb"].(map[string]interface{})["version"] != "pcb-1" {
+		tests.Errorf("Expected the versions to be set without losing the rest of the meta, got %s", meta)
+	}
+}
+
+func TestFirmwareRolloutsStage(tests *testing.T) {
+	rollout := models.FirmwareRollout{ID: uuid.NewV4(), Part: firmware.PartWifi, TargetVersion: "2.0", Percentage: 25, Cohort: "wifi-2"}
+	cohorts := map[string]bool{firmware.DefaultCohort: true, "wifi-2": true, "wifi-1.5": true}
+	included := 0
+	for i := 0; i < 1000; i++ {
+		serial := fmt.Sprintf("TS%05d", i)
+		updates := firmware.Updates(models.FirmwareRollouts{rollout}, serial, cohorts, firmware.Versions{firmware.PartWifi: "1.0"})
+		if len(updates) == 1 {
+			included++
+			if firmware.Bucket(rollout.Cohort, serial) >= 25 {
+				tests.Fatalf("Expected only dispensers below the percentage to update, got %v", serial)
+			}
+		}
+	}
+	if included < 180 || included > 320 {
+		tests.Errorf("Expected about a quarter of the dispensers to update, got %v of 1000", included)
+	}
+	rollout.Percentage = 100
+	if updates := firmware.Updates(models.FirmwareRollouts{rollout}, "TS00001", cohorts, firmware.Versions{firmware.PartWifi: "2.0"}); len(updates) != 0 {
+		tests.Errorf("Expected no update for a part already on the target, got %v", updates)
+	}
+	older := models.FirmwareRollout{ID: uuid.NewV4(), Part: firmware.PartWifi, TargetVersion: "1.5", Percentage: 100, Cohort: "wifi-1.5"}
+	rollout.Percentage = 0
+	updates := firmware.Updates(models.FirmwareRollouts{rollout, older}, "TS00001", cohorts, firmware.Versions{firmware.PartWifi: "1.0"})
+	if len(updates) != 1 || updates[0].Version != "1.5" {
+		tests.Errorf("Expected an older rollout to apply outside the newest one, got %v", updates)
+	}
+}
+
+func TestFirmwareRolloutsSkipDispensersOutsideTheCohort(tests *testing.T) {
+	rollout := models.FirmwareRollout{ID: uuid.NewV4(), Part: firmware.PartPCB, TargetVersion: "3.0", Percentage: 100, Cohort: "beta"}
+	current := firmware.Versions{firmware.PartPCB: "2.0"}
+	if updates := firmware.Updates(models.FirmwareRollouts{rollout}, "TS00001", map[string]bool{firmware.DefaultCohort: true}, current); len(updates) != 0 {
+		tests.Errorf("Expected no update for a dispenser outside the cohort, got %v", updates)
+	}
+	if updates := firmware.Updates(models.FirmwareRollouts{rollout}, "TS00001", map[string]bool{firmware.DefaultCohort: true, "beta": true}, current); len(updates) != 1 {
+		tests.Errorf("Expected an update for a dispenser in the cohort, got %v", updates)
+	}
+}
+
+func TestFirmwareCohortsComeFromMembership(tests *testing.T) {
+	if testing.Short() {
+		tests.Skip()
+	}
+	inside, outside := uuid.NewV4(), uuid.NewV4()
+	cohort := "beta-" + randomString(6)
+	member := models.FirmwareCohortMember{Cohort: cohort, DispenserID: inside}
+	if err := member.Create(testDB); err != nil {
+		tests.Fatal(err)
+	}
+	defer member.Delete(testDB)
+	for dispenserID, expected := range map[uuid.UUID]bool{inside: true, outside: false} {
+		cohorts, err := firmware.Cohorts(testDB, dispenserID)
+		if err != nil {
+			tests.Fatal(err)
+		}
+		if !cohorts[firmware.DefaultCohort] || cohorts[cohort] != expected {
+			tests.Errorf("Expected dispenser %v to be in the default cohort and in %v: %v, got %v", dispenserID, cohort, expected, cohorts)
+		}
+	}
+}
@@ -0,0 +1,365 @@
+package integration
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	"github.com/tespo/satya/v2/types"
+)
+
+type googleQueryResponse struct {
+	RequestID string `json:"requestId"`
+	Payload   struct {
+		Devices map[string]struct {
+			Status    string `json:"status"`
+			ErrorCode string `json:"errorCode"`
+			Online    bool   `json:"online"`
+		} `json:"devices"`
+	} `json:"payload"`
+}
+
+func TestGoogleQueryReportsConnections(tests *testing.T) {
+	if testing.Short() {
+		tests.Skip()
+	}
+	accountID := uuid.FromStringOrNil("d8e4c5dc-9767-41bd-b802-060e80d83867")
+	online := types.Dispenser{Serial: "online-" + randomString(8), Name: "Online " + randomString(4)}
+	offline := types.Dispenser{Serial: "offline-" + randomString(8), Name: "Offline " + randomString(4)}
+	for _, testDispenser := range []*types.Dispenser{&online, &offline} {
+		if err := testDispenser.Create(testDB); err != nil {
+			tests.Error(err)
+			return
+		}
+		connection := types.Connection{DispenserID: testDispenser.ID, AccountID: accountID, ConnectedAt: time.Now()}
+		if err := connection.Create(testDB); err != nil {
+			tests.Error(err)
+			return
+		}
+		defer connection.Delete(testDB, connection.ID)
+		defer testDispenser.Delete(testDB, testDispenser.ID)
+		if testDispenser == &offline {
+			connection.Delete(testDB, connection.ID)
+		}
+	}
+
+	unknown := uuid.NewV4().String()
+	body, err := json.Marshal(map[string]interface{}{
+		"requestId": "query-request",
+		"inputs": []interface{}{
+			map[string]interface{}{
+				"intent": "action.devices.QUERY",
+				"payload": map[string]interface{}{
+					"devices": []interface{}{
+						map[string]string{"id": online.ID.String()},
+						map[string]string{"id": offline.ID.String()},
+						map[string]string{"id": unknown},
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		tests.Error(err)
+		return
+	}
+	response, err := http.Post(os.Getenv("TESTING_URL")+"/google/fulfillment", "application/json", bytes.NewReader(body))
+	if err != nil {
+		tests.Error(err)
+		return
+	}
+	defer response.Body.Close()
+	query := googleQueryResponse{}
+	if err := json.NewDecoder(response.Body).Decode(&query); err != nil {
+		tests.Error(err)
+		return
+	}
+	if query.RequestID != "query-request" {
+		tests.Errorf("Expected the request id to be echoed, got %v", query.RequestID)
+	}
+	if device := query.Payload.Devices[online.ID.String()]; device.Status != "SUCCESS" || !device.Online {
+		tests.Errorf("Expected the connected dispenser to be online, got %+v", device)
+	}
+	if device := query.Payload.Devices[offline.ID.String()]; device.Status != "OFFLINE" || device.Online {
+		tests.Errorf("Expected the disconnected dispenser to be offline, got %+v", device)
+	}
+	if device := query.Payload.Devices[unknown]; device.ErrorCode != "deviceNotFound" {
+		tests.Errorf("Expected an unknown device to not be found, got %+v", device)
+	}
+}
+
+func postGoogleIntent(intent string, payload map[string]interface{}) (*http.Response, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"requestId": intent,
+		"inputs":    []interface{}{map[string]interface{}{"intent": intent, "payload": payload}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return http.Post(os.Getenv("TESTING_URL")+"/google/fulfillment", "application/json", bytes.NewReader(body))
+}
+
+type googleSyncResponse struct {
+	RequestID string `json:"requestId"`
+	Payload   struct {
+		AgentUserID string `json:"agentUserId"`
+		Devices     []struct {
+			ID     string   `json:"id"`
+			Type   string   `json:"type"`
+			Traits []string `json:"traits"`
+			Name   struct {
+				DefaultNames []string `json:"defaultNames"`
+				Name         string   `json:"name"`
+				Nicknames    []string `json:"nicknames"`
+			} `json:"name"`
+		} `json:"devices"`
+	} `json:"payload"`
+}
+
+func TestGoogleSyncListsDispensers(tests *testing.T) {
+	if testing.Short() {
+		tests.Skip()
+	}
+	accountID := uuid.FromStringOrNil("d8e4c5dc-9767-41bd-b802-060e80d83867")
+	named := types.Dispenser{Serial: "sync-" + randomString(8), Name: "Kitchen " + randomString(4)}
+	unnamed := types.Dispenser{Serial: "sync-" + randomString(8)}
+	for _, testDispenser := range []*types.Dispenser{&named, &unnamed} {
+		if err := testDispenser.Create(testDB); err != nil {
+			tests.Error(err)
+			return
+		}
+		defer testDispenser.Delete(testDB, testDispenser.ID)
+		connection := types.Connection{DispenserID: testDispenser.ID, AccountID: accountID, ConnectedAt: time.Now()}
+		if err := connection.Create(testDB); err != nil {
+			tests.Error(err)
+			return
+		}
+		defer connection.Delete(testDB, connection.ID)
+	}
+
+	response, err := postGoogleIntent("action.devices.SYNC", nil)
+	if err != nil {
+		tests.Error(err)
+		return
+	}
+	defer response.Body.Close()
+	sync := googleSyncResponse{}
+	if err := json.NewDecoder(response.Body).Decode(&sync); err != nil {
+		tests.Error(err)
+		return
+	}
+	if sync.RequestID != "action.devices.SYNC" {
+		tests.Errorf("Expected the request id to be echoed, got %v", sync.RequestID)
+	}
+	if sync.Payload.AgentUserID != testClaims["user_id"] {
+		tests.Errorf("Expected the agent user id to be the user, got %v", sync.Payload.AgentUserID)
+	}
+	expected := map[string][]string{
+		named.ID.String():   {named.Name, named.Name + " dispenser"},
+		unnamed.ID.String(): {"Dispenser"},
+	}
+	for _, device := range sync.Payload.Devices {
+		nicknames, ok := expected[device.ID]
+		if !ok {
+			continue
+		}
+		delete(expected, device.ID)
+		if device.Type != "action.devices.types.SWITCH" || len(device.Traits) != 2 || device.Traits[0] != "action.devices.traits.OnOff" || device.Traits[1] != "action.devices.traits.Dispense" {
+			tests.Errorf("Expected an OnOff switch that dispenses, got %+v", device)
+		}
+		if device.Name.Name != nicknames[0] || len(device.Name.DefaultNames) != 1 || device.Name.DefaultNames[0] != "Tespo Connect Dispenser" {
+			tests.Errorf("Expected the dispenser name %q, got %+v", nicknames[0], device.Name)
+		}
+		if len(device.Name.Nicknames) != len(nicknames) {
+			tests.Errorf("Expected the nicknames %v, got %v", nicknames, device.Name.Nicknames)
+			continue
+		}
+		for i := range nicknames {
+			if device.Name.Nicknames[i] != nicknames[i] {
+				tests.Errorf("Expected the nicknames %v, got %v", nicknames, device.Name.Nicknames)
+			}
+		}
+	}
+	if len(expected) != 0 {
+		tests.Errorf("Expected every connected dispenser to be synced, missing %v", expected)
+	}
+}
+
+func TestGoogleExecuteFailsAfterDisconnect(tests *testing.T) {
+	if testing.Short() {
+		tests.Skip()
+	}
+	// SYNC links the test user to Google again for the other tests
+	defer func() {
+		if response, err := postGoogleIntent("action.devices.SYNC", nil); err == nil {
+			response.Body.Close()
+		}
+	}()
+	execute := map[string]interface{}{
+		"commands": []interface{}{
+			map[string]interface{}{
+				"devices":   []interface{}{map[string]string{"id": uuid.NewV4().String()}},
+				"execution": []interface{}{map[string]interface{}{"command": "action.devices.commands.OnOff", "params": map[string]bool{"on": true}}},
+			},
+		},
+	}
+	for _, step := range []struct {
+		intent  string
+		payload map[string]interface{}
+		code    int
+	}{
+		{"action.devices.SYNC", nil, http.StatusOK},
+		{"action.devices.EXECUTE", execute, http.StatusOK},
+		{"action.devices.DISCONNECT", nil, http.StatusOK},
+		{"action.devices.EXECUTE", execute, http.StatusUnauthorized},
+		{"action.devices.QUERY", nil, http.StatusUnauthorized},
+	} {
+		response, err := postGoogleIntent(step.intent, step.payload)
+		if err != nil {
+			tests.Fatal(err)
+		}
+		response.Body.Close()
+		if response.StatusCode != step.code {
+			tests.Errorf("Expected %v to answer %v, got %v", step.intent, step.code, response.StatusCode)
+		}
+	}
+}
+
+func postAlexaDirective(directive map[string]interface{}) (map[string]interface{}, error) {
+	body, err := json.Marshal(map[string]interface{}{"directive": directive})
+	if err != nil {
+		return nil, err
+	}
+	response, err := http.Post(os.Getenv("TESTING_URL")+"/alexa/fulfillment", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	event := map[string]interface{}{}
+	return event, json.NewDecoder(response.Body).Decode(&event)
+}
+
+func TestAlexaDiscoversDispensers(tests *testing.T) {
+	if testing.Short() {
+		tests.Skip()
+	}
+	accountID := uuid.FromStringOrNil("d8e4c5dc-9767-41bd-b802-060e80d83867")
+	testDispenser := types.Dispenser{Serial: "alexa-" + randomString(8), Name: "Alexa " + randomString(4)}
+	if err := testDispenser.Create(testDB); err != nil {
+		tests.Error(err)
+		return
+	}
+	defer testDispenser.Delete(testDB, testDispenser.ID)
+	connection := types.Connection{DispenserID: testDispenser.ID, AccountID: accountID, ConnectedAt: time.Now()}
+	if err := connection.Create(testDB); err != nil {
+		tests.Error(err)
+		return
+	}
+	defer connection.Delete(testDB, connection.ID)
+
+	discovery, err := postAlexaDirective(map[string]interface{}{
+		"header":  map[string]string{"namespace": "Alexa.Discovery", "name": "Discover", "messageId": "discover", "payloadVersion": "3"},
+		"payload": map[string]interface{}{"scope": map[string]string{"type": "BearerToken", "token": testToken}},
+	})
+	if err != nil {
+		tests.Error(err)
+		return
+	}
+	data, _ := json.Marshal(discovery)
+	if !bytes.Contains(data, []byte(testDispenser.Serial)) || !bytes.Contains(data, []byte(testDispenser.ID.String())) {
+		tests.Errorf("Expected the dispenser to be discovered, got %s", data)
+	}
+
+	report, err := postAlexaDirective(map[string]interface{}{
+		"header": map[string]string{"namespace": "Alexa", "name": "ReportState", "messageId": "report", "correlationToken": "token", "payloadVersion": "3"},
+		"endpoint": map[string]interface{}{
+			"endpointId": "unknown",
+			"scope":      map[string]string{"type": "BearerToken", "token": testToken},
+			"cookie":     map[string]string{"dispenser_id": uuid.NewV4().String()},
+		},
+	})
+	if err != nil {
+		tests.Error(err)
+		return
+	}
+	event, _ := report["event"].(map[string]interface{})
+	header, _ := event["header"].(map[string]interface{})
+	payload, _ := event["payload"].(map[string]interface{})
+	if header["name"] != "ErrorResponse" || payload["type"] != "NO_SUCH_ENDPOINT" || header["correlationToken"] != "token" {
+		tests.Errorf("Expected a NO_SUCH_ENDPOINT error response, got %v", report)
+	}
+}
+
+func TestVoiceIntentsAnswerOnBothAssistants(tests *testing.T) {
+	if testing.Short() {
+		tests.Skip()
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"version": "1.0",
+		"session": map[string]interface{}{"user": map[string]string{"accessToken": testToken}},
+		"request": map[string]interface{}{"type": "IntentRequest", "intent": map[string]string{"name": "NextReminder"}},
+	})
+	if err != nil {
+		tests.Error(err)
+		return
+	}
+	response, err := http.Post(os.Getenv("TESTING_URL")+"/alexa/fulfillment", "application/json", bytes.NewReader(body))
+	if err != nil {
+		tests.Error(err)
+		return
+	}
+	alexa := struct {
+		Response struct {
+			OutputSpeech struct {
+				Text string `json:"text"`
+			} `json:"outputSpeech"`
+		} `json:"response"`
+	}{}
+	err = json.NewDecoder(response.Body).Decode(&alexa)
+	response.Body.Close()
+	if err != nil {
+		tests.Error(err)
+		return
+	}
+
+	body, err = json.Marshal(map[string]interface{}{
+		"requestId": "intent-request",
+		"inputs":    []interface{}{map[string]string{"intent": "NextReminder"}},
+	})
+	if err != nil {
+		tests.Error(err)
+		return
+	}
+	response, err = http.Post(os.Getenv("TESTING_URL")+"/google/fulfillment", "application/json", bytes.NewReader(body))
+	if err != nil {
+		tests.Error(err)
+		return
+	}
+	google := struct {
+		FinalResponse struct {
+			RichResponse struct {
+				Items []struct {
+					SimpleResponse struct {
+						TextToSpeech string `json:"textToSpeech"`
+					} `json:"simpleResponse"`
+				} `json:"items"`
+			} `json:"richResponse"`
+		} `json:"finalResponse"`
+	}{}
+	err = json.NewDecoder(response.Body).Decode(&google)
+	response.Body.Close()
+	if err != nil {
+		tests.Error(err)
+		return
+	}
+	if alexa.Response.OutputSpeech.Text == "" || len(google.FinalResponse.RichResponse.Items) != 1 {
+		tests.Fatalf("Expected both assistants to answer, got %+v and %+v", alexa, google)
+	}
+	if alexa.Response.OutputSpeech.Text != google.FinalResponse.RichResponse.Items[0].SimpleResponse.TextToSpeech {
+		tests.Errorf("Expected the same answer from both assistants, got %q and %q", alexa.Response.OutputSpeech.Text, google.FinalResponse.RichResponse.Items[0].SimpleResponse.TextToSpeech)
+	}
+}
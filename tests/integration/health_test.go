@@ -0,0 +1,81 @@
+package integration
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/tespo/buddha/handlers"
+	"github.com/tespo/buddha/health"
+)
+
+func TestReadinessReportsEachComponent(tests *testing.T) {
+	vijnana := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer vijnana.Close()
+	checker := health.New(health.Config{Timeout: 50 * time.Millisecond})
+	checker.Add("vijnana", health.HTTP(vijnana.URL))
+	checker.Add("mysql", func(ctx context.Context) error {
+		return errors.New("connection refused")
+	})
+	checker.Add("aws", func(ctx context.Context) error {
+		<-ctx.Done()
+		return nil
+	})
+	recorder := httptest.NewRecorder()
+	handlers.Readiness(checker).ServeHTTP(recorder, httptest.NewRequest("GET", "/readyz", nil))
+	if recorder.Code != http.StatusServiceUnavailable {
+		tests.Errorf("Expected a failing dependency to make the instance unready, got %v", recorder.Code)
+	}
+	report := health.Report{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &report); err != nil {
+		tests.Fatal(err)
+	}
+	if report.Status != health.StatusError || report.Components["vijnana"].Status != health.StatusOK {
+		tests.Errorf("Expected a reachable Vijnana in a failing report, got %+v", report)
+	}
+	if mysql := report.Components["mysql"]; mysql.Status != health.StatusError || mysql.Error != "connection refused" {
+		tests.Errorf("Expected the MySQL error, got %+v", mysql)
+	}
+	if aws := report.Components["aws"]; aws.Error != "timed out" || aws.LatencyMS < 50 {
+		tests.Errorf("Expected a slow check to time out, got %+v", aws)
+	}
+}
+
+func TestReadinessAndLivenessPass(tests *testing.T) {
+	checker := health.New(health.Config{Timeout: time.Second})
+	checker.Add("mysql", func(ctx context.Context) error { return nil })
+	recorder := httptest.NewRecorder()
+	handlers.Readiness(checker).ServeHTTP(recorder, httptest.NewRequest("GET", "/readyz", nil))
+	if recorder.Code != http.StatusOK {
+		tests.Errorf("Expected a ready instance to answer 200, got %v: %s", recorder.Code, recorder.Body)
+	}
+	recorder = httptest.NewRecorder()
+	handlers.Liveness(recorder, httptest.NewRequest("GET", "/healthz", nil))
+	if recorder.Code != http.StatusOK {
+		tests.Errorf("Expected liveness to answer 200, got %v", recorder.Code)
+	}
+}
+
+func TestOptionalComponentsDoNotFailReadiness(tests *testing.T) {
+	checker := health.New(health.Config{Timeout: time.Second})
+	checker.Add("mysql", func(ctx context.Context) error { return nil })
+	checker.AddOptional("vijnana", func(ctx context.Context) error { return errors.New("connection refused") })
+	recorder := httptest.NewRecorder()
+	handlers.Readiness(checker).ServeHTTP(recorder, httptest.NewRequest("GET", "/readyz", nil))
+	if recorder.Code != http.StatusOK {
+		tests.Errorf("Expected a failing optional component to leave the instance ready, got %v", recorder.Code)
+	}
+	report := health.Report{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &report); err != nil {
+		tests.Fatal(err)
+	}
+	if vijnana := report.Components["vijnana"]; report.Status != health.StatusDegraded || vijnana.Status != health.StatusError || !vijnana.Optional {
+		tests.Errorf("Expected a degraded report with the optional error, got %+v", report)
+	}
+}
@@ -0,0 +1,132 @@
+package integration
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/jinzhu/gorm"
+	uuid "github.com/satori/go.uuid"
+	"github.com/tespo/buddha/db"
+	"github.com/tespo/buddha/handlers"
+	"github.com/tespo/buddha/models"
+	"github.com/tespo/buddha/util"
+)
+
+func TestLambdaEventIsProcessedOnce(tests *testing.T) {
+	if testing.Short() {
+		tests.Skip()
+	}
+	calls := 0
+	handler := handlers.IdempotentLambdaEvent("integration", func(w http.ResponseWriter, r *http.Request, event *models.LambdaEvent) {
+		calls++
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"call":` + strconv.Itoa(calls) + `}`))
+	})
+	body := `{"message_id":"` + uuid.NewV4().String() + `","payload":{"dispenser":{"serial":"integration"}}}`
+	responses := []string{}
+	for i := 0; i < 3; i++ {
+		recorder := httptest.NewRecorder()
+		handler(recorder, httptest.NewRequest("POST", "/dispenser/dispensed", strings.NewReader(body)))
+		if recorder.Code != http.StatusOK {
+			tests.Errorf("Expected 200, got %v", recorder.Code)
+			return
+		}
+		responses = append(responses, recorder.Body.String())
+	}
+	if calls != 1 {
+		tests.Errorf("Expected the event to be processed once, got %v", calls)
+	}
+	for _, response := range responses {
+		if response != responses[0] {
+			tests.Errorf("Replay returned %v instead of %v", response, responses[0])
+		}
+	}
+}
+
+func TestFailedLambdaEventCanBeRetried(tests *testing.T) {
+	if testing.Short() {
+		tests.Skip()
+	}
+	calls := 0
+	handler := handlers.IdempotentLambdaEvent("integration", func(w http.ResponseWriter, r *http.Request, event *models.LambdaEvent) {
+		calls++
+		if calls == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	key := uuid.NewV4().String()
+	for i := 0; i < 2; i++ {
+		request := httptest.NewRequest("POST", "/dispenser/inserted", strings.NewReader("{}"))
+		request.Header.Set("Idempotency-Key", key)
+		handler(httptest.NewRecorder(), request)
+	}
+	if calls != 2 {
+		tests.Errorf("Expected a failed event to be processed again, got %v calls", calls)
+	}
+}
+
+func TestCommittedLambdaEventKeepsItsKey(tests *testing.T) {
+	if testing.Short() {
+		tests.Skip()
+	}
+	calls := 0
+	handler := handlers.IdempotentLambdaEvent("integration", func(w http.ResponseWriter, r *http.Request, event *models.LambdaEvent) {
+		calls++
+		err := db.Transaction(testDB, func(tx *gorm.DB) error {
+			return event.Complete(tx, http.StatusOK, []byte(`{"status":"success"}`))
+		})
+		if err != nil {
+			tests.Fatal(err)
+		}
+		// The instance dies between the commit and the response
+		panic("crashed after commit")
+	})
+	key := uuid.NewV4().String()
+	for i := 0; i < 2; i++ {
+		request := httptest.NewRequest("POST", "/dispenser/dispensed", strings.NewReader("{}"))
+		request.Header.Set("Idempotency-Key", key)
+		recorder := httptest.NewRecorder()
+		func() {
+			defer func() { recover() }()
+			handler(recorder, request)
+		}()
+		if i == 1 && (recorder.Code != http.StatusOK || recorder.Header().Get("Idempotent-Replayed") != "true") {
+			tests.Errorf("Expected the committed response to be replayed, got %v", recorder.Code)
+		}
+	}
+	if calls != 1 {
+		tests.Errorf("Expected an event committed before a crash not to be processed again, got %v calls", calls)
+	}
+}
+
+func TestRolledBackLambdaEventCanBeRetried(tests *testing.T) {
+	if testing.Short() {
+		tests.Skip()
+	}
+	calls := 0
+	handler := handlers.IdempotentLambdaEvent("integration", func(w http.ResponseWriter, r *http.Request, event *models.LambdaEvent) {
+		calls++
+		err := db.Transaction(testDB, func(tx *gorm.DB) error {
+			if err := event.Complete(tx, http.StatusOK, []byte(`{"status":"success"}`)); err != nil {
+				return err
+			}
+			return errors.New("write failed")
+		})
+		util.ErrorResponder(w, http.StatusInternalServerError, err)
+	})
+	key := uuid.NewV4().String()
+	for i := 0; i < 2; i++ {
+		request := httptest.NewRequest("POST", "/dispenser/dispensed", strings.NewReader("{}"))
+		request.Header.Set("Idempotency-Key", key)
+		handler(httptest.NewRecorder(), request)
+	}
+	if calls != 2 {
+		tests.Errorf("Expected an event whose writes rolled back to be processed again, got %v calls", calls)
+	}
+}
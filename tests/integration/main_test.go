@@ -5,44 +5,56 @@ import (
 	"net/http"
 	"os"
 	"testing"
+	"time"
 
-	"github.com/gorilla/context"
-	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
+	"github.com/tespo/buddha/auth"
+	"github.com/tespo/buddha/db"
+	"github.com/tespo/buddha/handlers"
+	"github.com/tespo/buddha/health"
+	"github.com/tespo/buddha/models"
 	"github.com/tespo/buddha/router"
 )
 
-func CreateRouter() *mux.Router {
+const testToken = "integration-testing-token"
 
-	testRouter := mux.NewRouter().StrictSlash(true)
+var testDB *gorm.DB
 
-	for _, route := range router.ExplicitRoutes {
-		testRouter.
-			Methods(route.Method).
-			Path(route.Pattern).
-			Name(route.Name).
-			Handler(testAccountRouteWrapper(route.HandlerFunc))
-	}
-
-	for _, route := range router.ImplicitRoutes {
-		testRouter.
-			Methods(route.Method).
-			Path(route.Pattern).
-			Name(route.Name).
-			Handler(testAccountRouteWrapper(route.HandlerFunc))
-	}
-
-	return testRouter
+var testClaims = map[string]interface{}{
+	"account_id":        "d8e4c5dc-9767-41bd-b802-060e80d83867",
+	"user_id":           "8c8aa229-3959-4a40-bbe6-67c2eeace5cb",
+	"owner":             true,
+	"scoped_fields":     []interface{}{"account.*"},
+	"scope_permissions": []interface{}{"*"},
+	"permissions": []interface{}{
+		map[string]interface{}{"slug": "*", "actions": []interface{}{"*"}},
+	},
 }
 
-func testAccountRouteWrapper(next http.HandlerFunc) http.HandlerFunc {
-	return func(writer http.ResponseWriter, request *http.Request) {
+func CreateRouter() http.Handler {
+	authenticator := auth.NewStaticAuthenticator(map[string]map[string]interface{}{
+		testToken: testClaims,
+	})
+	readiness := health.New(health.Config{Timeout: time.Second})
+	readiness.Add("mysql", health.SQL(testDB.DB()))
+	return testTokenWrapper(router.CreateRouter(authenticator, map[string]auth.VoiceVerifier{
+		"alexa":   auth.SkipVoiceVerification,
+		"google":  auth.SkipVoiceVerification,
+		"webhook": auth.SkipVoiceVerification,
+	}, readiness))
+}
 
-		context.Set(request, "account_id", "d8e4c5dc-9767-41bd-b802-060e80d83867")
-		context.Set(request, "user_id", "8c8aa229-3959-4a40-bbe6-67c2eeace5cb")
-		context.Set(request, "scoped_fields", []string{"user.*"})
-		context.Set(request, "scoped_fields", []string{"account.*"})
-		next(writer, request)
-	}
+//
+// testTokenWrapper authenticates requests that
+// do not carry a token of their own as the test user
+//
+func testTokenWrapper(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if request.Header.Get("Authorization") == "" {
+			request.Header.Set("Authorization", "Bearer "+testToken)
+		}
+		next.ServeHTTP(writer, request)
+	})
 }
 
 func TestMain(m *testing.M) {
@@ -54,6 +66,15 @@ func TestMain(m *testing.M) {
 	flag.Parse()
 
 	if !testing.Short() {
+		conn, err := db.Connect(db.ConfigFromEnv())
+		if err != nil {
+			panic(err)
+		}
+		testDB = conn
+		if err := models.AutoMigrate(conn); err != nil {
+			panic(err)
+		}
+		handlers.SetDB(conn)
 		srv := &http.Server{
 			Handler: CreateRouter(),
 			Addr:    ":5555",
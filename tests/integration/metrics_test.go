@@ -0,0 +1,72 @@
+package integration
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/tespo/buddha/handlers"
+	"github.com/tespo/buddha/metrics"
+)
+
+func TestMetricsExposition(tests *testing.T) {
+	metrics.Dispenses.Inc()
+	recorder := httptest.NewRecorder()
+	handlers.Metrics(metrics.Registry).ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	body := recorder.Body.String()
+	lines := []string{
+		"# TYPE buddha_dispenses_total counter",
+		"# TYPE go_goroutines gauge",
+	}
+	if runtime.GOOS == "linux" {
+		lines = append(lines, "# TYPE process_resident_memory_bytes gauge")
+	}
+	for _, line := range lines {
+		if !strings.Contains(body, line+"\n") {
+			tests.Errorf("Expected the exposition to contain %v, got:\n%s", line, body)
+		}
+	}
+}
+
+func TestRoutesAreInstrumented(tests *testing.T) {
+	handler := metrics.InstrumentRoute("Test Teapot", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/teapot", nil))
+	if count := testutil.ToFloat64(metrics.HTTPRequests.WithLabelValues("Test Teapot", "POST", "418")); count != 1 {
+		tests.Errorf("Expected the request to be counted, got %v", count)
+	}
+	os.Setenv("METRICS_TOKEN", "scraper")
+	defer os.Unsetenv("METRICS_TOKEN")
+	recorder := httptest.NewRecorder()
+	handlers.Metrics(metrics.Registry).ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	if recorder.Code != http.StatusUnauthorized {
+		tests.Errorf("Expected a scrape without the token to be rejected, got %v", recorder.Code)
+	}
+	request := httptest.NewRequest("GET", "/metrics", nil)
+	request.Header.Set("Authorization", "Bearer scraper")
+	recorder = httptest.NewRecorder()
+	handlers.Metrics(metrics.Registry).ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusOK || !strings.Contains(recorder.Body.String(), `buddha_http_requests_total{code="418",method="POST",route="Test Teapot"} 1`) {
+		tests.Errorf("Expected /metrics to report the request, got %v:\n%s", recorder.Code, recorder.Body.String())
+	}
+}
+
+func TestUnknownMethodsShareALabel(tests *testing.T) {
+	handler := metrics.InstrumentRoute("Test Methods", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}))
+	for _, method := range []string{"PROPFIND", "BREW", "DELETE"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(method, "/methods", nil))
+	}
+	if count := testutil.ToFloat64(metrics.HTTPRequests.WithLabelValues("Test Methods", "other", "405")); count != 2 {
+		tests.Errorf("Expected unknown methods to be counted as other, got %v", count)
+	}
+	if count := testutil.ToFloat64(metrics.HTTPRequests.WithLabelValues("Test Methods", "DELETE", "405")); count != 1 {
+		tests.Errorf("Expected DELETE to keep its label, got %v", count)
+	}
+}
@@ -0,0 +1,124 @@
+package integration
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	"github.com/tespo/buddha/models"
+	"github.com/tespo/buddha/presence"
+	"github.com/tespo/satya/v2/types"
+)
+
+func TestDispenserSessionDuration(tests *testing.T) {
+	start := time.Date(2019, 6, 15, 12, 0, 0, 0, time.UTC)
+	session := models.DispenserSession{StartedAt: start}
+	if duration := session.Duration(start.Add(time.Hour)); duration != time.Hour {
+		tests.Errorf("Expected an open session to last until now, got %v", duration)
+	}
+	ended := start.Add(10 * time.Minute)
+	session.EndedAt = &ended
+	if duration := session.Duration(start.Add(time.Hour)); duration != 10*time.Minute {
+		tests.Errorf("Expected an ended session to last until it ended, got %v", duration)
+	}
+}
+
+func TestOfflineDetectorEndsSilentSessions(tests *testing.T) {
+	if testing.Short() {
+		tests.Skip()
+	}
+	config := presence.Config{OfflineAfter: 5 * time.Minute, Interval: time.Minute}
+	dispenserID, accountID := uuid.NewV4(), uuid.NewV4()
+	seen := time.Now().Add(-time.Hour)
+	if _, err := presence.Seen(testDB, dispenserID, accountID, seen); err != nil {
+		tests.Fatal(err)
+	}
+	if status, err := presence.StatusOf(testDB, dispenserID, config, seen.Add(time.Minute)); err != nil || !status.Online {
+		tests.Fatalf("Expected the dispenser to be online after a heartbeat, got %+v, %v", status, err)
+	}
+	if err := presence.New(testDB, config).Run(time.Now()); err != nil {
+		tests.Fatal(err)
+	}
+	status, err := presence.StatusOf(testDB, dispenserID, config, time.Now())
+	if err != nil {
+		tests.Fatal(err)
+	}
+	if status.Online || status.OfflineSince == nil || status.OfflineSince.Sub(seen) > time.Second || seen.Sub(*status.OfflineSince) > time.Second {
+		tests.Errorf("Expected the dispenser to be offline since it was last seen, got %+v", status)
+	}
+	session, err := presence.Seen(testDB, dispenserID, accountID, time.Now())
+	if err != nil {
+		tests.Fatal(err)
+	}
+	if session.StartedAt.Before(seen.Add(time.Minute)) {
+		tests.Errorf("Expected a heartbeat after going offline to start a new session, got %+v", session)
+	}
+}
+
+func TestConcurrentHeartbeatsOpenOneSession(tests *testing.T) {
+	if testing.Short() {
+		tests.Skip()
+	}
+	dispenserID, accountID := uuid.NewV4(), uuid.NewV4()
+	errs := make(chan error, 10)
+	for i := 0; i < cap(errs); i++ {
+		go func() {
+			_, err := presence.Seen(testDB, dispenserID, accountID, time.Now())
+			errs <- err
+		}()
+	}
+	for i := 0; i < cap(errs); i++ {
+		if err := <-errs; err != nil {
+			tests.Error(err)
+		}
+	}
+	sessions := models.DispenserSessions{}
+	if err := sessions.GetByQuery(testDB, "dispenser_id = ? AND ended_at IS NULL", dispenserID); err != nil {
+		tests.Fatal(err)
+	}
+	if len(sessions) != 1 {
+		tests.Errorf("Expected one open session, got %v", len(sessions))
+	}
+}
+
+func TestHeartbeatMustComeFromTheConnectedAccount(tests *testing.T) {
+	if testing.Short() {
+		tests.Skip()
+	}
+	accountID := uuid.FromStringOrNil("d8e4c5dc-9767-41bd-b802-060e80d83867")
+	testDispenser := types.Dispenser{Serial: "heartbeat-" + randomString(8), Name: "Heartbeat " + randomString(4)}
+	if err := testDispenser.Create(testDB); err != nil {
+		tests.Fatal(err)
+	}
+	defer testDispenser.Delete(testDB, testDispenser.ID)
+	connection := types.Connection{DispenserID: testDispenser.ID, AccountID: accountID, ConnectedAt: time.Now()}
+	if err := connection.Create(testDB); err != nil {
+		tests.Fatal(err)
+	}
+	defer connection.Delete(testDB, connection.ID)
+
+	for _, c := range []struct {
+		accountID uuid.UUID
+		code      int
+	}{
+		{uuid.NewV4(), http.StatusForbidden},
+		{accountID, http.StatusOK},
+	} {
+		body, _ := json.Marshal(types.LambdaMessage{Payload: types.Payload{
+			Customer:  types.PayloadCustomer{ID: c.accountID.String()},
+			Dispenser: types.PayloadDispenser{Serial: testDispenser.Serial},
+		}})
+		response, err := http.Post(os.Getenv("TESTING_URL")+"/dispenser/heartbeat", "application/json", bytes.NewReader(body))
+		if err != nil {
+			tests.Fatal(err)
+		}
+		response.Body.Close()
+		if response.StatusCode != c.code {
+			tests.Errorf("Expected a heartbeat for account %v to answer %v, got %v", c.accountID, c.code, response.StatusCode)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package integration
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gorilla/context"
+	uuid "github.com/satori/go.uuid"
+	"github.com/tespo/buddha/handlers"
+	"github.com/tespo/buddha/refill"
+	"github.com/tespo/satya/v2/types"
+)
+
+var refillConfig = refill.Config{Lookback: 14 * 24 * time.Hour, LowSupplyDays: 7}
+
+func dailyUsages(regimenID uuid.UUID, now time.Time, days, perDay int) types.Usages {
+	usages := types.Usages{}
+	for day := 0; day < days; day++ {
+		for i := 0; i < perDay; i++ {
+			usages = append(usages, types.Usage{RegimenID: regimenID, CreatedAt: now.AddDate(0, 0, -day).Add(-time.Duration(i+1) * time.Hour)})
+		}
+	}
+	return usages
+}
+
+func TestPredictRunOut(tests *testing.T) {
+	now := time.Date(2019, 6, 15, 12, 0, 0, 0, time.UTC)
+	regimen := types.Regimen{ID: uuid.NewV4(), LastReportedServingsRemaining: 20, CreatedAt: now.AddDate(0, -1, 0)}
+	forecast := refill.Predict(regimen, dailyUsages(regimen.ID, now, 14, 2), refillConfig, now)
+	if forecast.DailyUsage != 2 {
+		tests.Errorf("Expected 2 servings a day, got %v", forecast.DailyUsage)
+	}
+	if forecast.DaysRemaining == nil || *forecast.DaysRemaining != 10 {
+		tests.Errorf("Expected 10 days remaining, got %v", forecast.DaysRemaining)
+	}
+	if forecast.RunOutAt == nil || !forecast.RunOutAt.Equal(now.AddDate(0, 0, 10)) {
+		tests.Errorf("Expected to run out in 10 days, got %v", forecast.RunOutAt)
+	}
+	if forecast.LowSupply {
+		tests.Errorf("Did not expect 10 days of supply to be low")
+	}
+}
+
+func TestPredictWithoutUsage(tests *testing.T) {
+	now := time.Now()
+	regimen := types.Regimen{ID: uuid.NewV4(), LastReportedServingsRemaining: 20}
+	forecast := refill.Predict(regimen, types.Usages{}, refillConfig, now)
+	if forecast.DaysRemaining != nil || forecast.LowSupply {
+		tests.Errorf("Expected no forecast without usage, got %+v", forecast)
+	}
+}
+
+func TestLowSupplyCrossing(tests *testing.T) {
+	now := time.Date(2019, 6, 15, 12, 0, 0, 0, time.UTC)
+	regimen := types.Regimen{ID: uuid.NewV4(), LastReportedServingsRemaining: 13, CreatedAt: now.AddDate(0, -1, 0)}
+	usages := dailyUsages(regimen.ID, now, 14, 2)
+	if _, crossed := refill.Crossed(regimen, 14, usages, refillConfig, now); !crossed {
+		tests.Errorf("Expected dropping to 6.5 days of supply to cross the threshold")
+	}
+	regimen.LastReportedServingsRemaining = 12
+	if _, crossed := refill.Crossed(regimen, 13, usages, refillConfig, now); crossed {
+		tests.Errorf("Expected a regimen already low on supply not to cross again")
+	}
+}
+
+func TestGetAccountRefills(tests *testing.T) {
+	if testing.Short() {
+		tests.Skip()
+	}
+	response, err := http.Get(os.Getenv("TESTING_URL") + "/account/refills")
+	if err != nil {
+		tests.Error(err)
+		return
+	}
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		tests.Error(err)
+		return
+	}
+	refills := []refill.Forecast{}
+	if err := json.Unmarshal(body, &refills); err != nil {
+		tests.Error(err)
+	}
+}
+
+func TestForecastHiddenByScope(tests *testing.T) {
+	if testing.Short() {
+		tests.Skip()
+	}
+	accountID := uuid.NewV4()
+	regimen := types.Regimen{AccountID: accountID, LastReportedServingsRemaining: 20}
+	if err := regimen.Create(testDB); err != nil {
+		tests.Error(err)
+		return
+	}
+	defer regimen.Delete(testDB, regimen.ID)
+	cases := []struct {
+		scopedFields []string
+		forecast     bool
+	}{
+		{[]string{"regimen.*"}, true},
+		{[]string{"regimen.id"}, false},
+	}
+	for _, c := range cases {
+		request := httptest.NewRequest("GET", "/account/regimens", nil)
+		context.Set(request, "account_id", accountID.String())
+		context.Set(request, "scoped_fields", c.scopedFields)
+		recorder := httptest.NewRecorder()
+		handlers.GetAccountRegimens(recorder, request)
+		context.Clear(request)
+		response := types.PaginatedResponse{}
+		if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+			tests.Error(err)
+			return
+		}
+		regimens, ok := response.Data.([]interface{})
+		if !ok || len(regimens) != 1 {
+			tests.Errorf("Expected the regimen, got %v", recorder.Body)
+			continue
+		}
+		if _, ok := regimens[0].(map[string]interface{})["forecast"]; ok != c.forecast {
+			tests.Errorf("Expected forecast %v for scopes %v, got %v", c.forecast, c.scopedFields, regimens[0])
+		}
+	}
+}
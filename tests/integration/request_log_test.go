@@ -0,0 +1,59 @@
+package integration
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/gorilla/context"
+	"github.com/tespo/buddha/util"
+)
+
+func TestRequestLoggerPropagatesID(tests *testing.T) {
+	out := &bytes.Buffer{}
+	util.SetRequestLog(out)
+	defer util.SetRequestLog(os.Stdout)
+	seen := ""
+	handler := util.RequestLogger("Test Teapot", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = r.Header.Get(util.RequestIDHeader)
+		context.Set(r, "user_id", "user-1")
+		context.Set(r, "account_id", "account-1")
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	request := httptest.NewRequest("POST", "/teapot", nil)
+	request.Header.Set(util.RequestIDHeader, "dispense-42")
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	if seen != "dispense-42" || recorder.Header().Get(util.RequestIDHeader) != "dispense-42" {
+		tests.Errorf("Expected the caller's request ID to be kept, got %q and %q", seen, recorder.Header().Get(util.RequestIDHeader))
+	}
+	line := util.RequestLine{}
+	if err := json.Unmarshal(out.Bytes(), &line); err != nil {
+		tests.Fatalf("Expected one JSON log line, got %q: %v", out.String(), err)
+	}
+	if line.RequestID != "dispense-42" || line.Route != "Test Teapot" || line.Method != "POST" || line.Status != http.StatusTeapot {
+		tests.Errorf("Expected the line to describe the request, got %+v", line)
+	}
+	if line.UserID != "user-1" || line.AccountID != "account-1" {
+		tests.Errorf("Expected the line to carry the token's user and account, got %+v", line)
+	}
+}
+
+func TestRequestLoggerAssignsID(tests *testing.T) {
+	util.SetRequestLog(nil)
+	defer util.SetRequestLog(os.Stdout)
+	seen := ""
+	handler := util.RequestLogger("Test OK", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = util.RequestID(r)
+	}))
+	request := httptest.NewRequest("GET", "/ok", nil)
+	request.Header.Set(util.RequestIDHeader, "not a sane\nid")
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	if seen == "" || seen == "not a sane\nid" || recorder.Header().Get(util.RequestIDHeader) != seen {
+		tests.Errorf("Expected a new request ID in place of an unusable one, got %q", seen)
+	}
+}
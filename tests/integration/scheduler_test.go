@@ -0,0 +1,145 @@
+package integration
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	"github.com/tespo/buddha/models"
+	"github.com/tespo/buddha/scheduler"
+)
+
+func TestOccurrencesAcrossDaylightSaving(tests *testing.T) {
+	location, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		tests.Error(err)
+		return
+	}
+	from := time.Date(2019, 3, 9, 0, 0, 0, 0, location)
+	times := scheduler.Occurrences(8*60, location, from, from.AddDate(0, 0, 3))
+	if len(times) != 3 {
+		tests.Errorf("Expected 3 reminders, got %v", times)
+		return
+	}
+	for _, at := range times {
+		if at.Hour() != 8 || at.Minute() != 0 {
+			tests.Errorf("Expected the reminder at 8:00 local time, got %v", at)
+		}
+	}
+	if times[1].Sub(times[0]) != 23*time.Hour || times[2].Sub(times[1]) != 24*time.Hour {
+		tests.Errorf("Expected the day of the switch to be an hour shorter, got %v", times)
+	}
+}
+
+func TestOccurrencesInSkippedHour(tests *testing.T) {
+	location, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		tests.Error(err)
+		return
+	}
+	from := time.Date(2019, 3, 10, 0, 0, 0, 0, location)
+	times := scheduler.Occurrences(2*60+30, location, from, from.Add(12*time.Hour))
+	if len(times) != 1 {
+		tests.Errorf("Expected a reminder in the skipped hour to go off once, got %v", times)
+	}
+}
+
+func TestOccurrencesOnlyInSpan(tests *testing.T) {
+	from := time.Date(2019, 6, 1, 7, 59, 0, 0, time.UTC)
+	if times := scheduler.Occurrences(8*60, time.UTC, from, from.Add(time.Minute)); len(times) != 1 {
+		tests.Errorf("Expected the reminder in the span, got %v", times)
+	}
+	if times := scheduler.Occurrences(8*60, time.UTC, from.Add(time.Minute), from.Add(2*time.Minute)); len(times) != 0 {
+		tests.Errorf("Expected a span starting at the reminder to exclude it, got %v", times)
+	}
+}
+
+func TestMinutesInSpan(tests *testing.T) {
+	from := time.Date(2019, 6, 1, 7, 59, 0, 0, time.UTC)
+	minutes := scheduler.Minutes(from, from.Add(time.Minute))
+	found := map[uint]bool{}
+	for _, minute := range minutes {
+		found[minute] = true
+	}
+	if !found[8*60] || !found[13*60+30] {
+		tests.Errorf("Expected 8:00 in UTC and 13:30 at +05:30, got %v", minutes)
+	}
+	if found[7*60+59] || found[8*60+1] {
+		tests.Errorf("Expected the minutes outside the span to be left out, got %v", minutes)
+	}
+	if minutes := scheduler.Minutes(from, from.Add(24*time.Hour)); minutes != nil {
+		tests.Errorf("Expected a whole day to select every reminder, got %v", minutes)
+	}
+}
+
+func TestSchedulerResumesFromWatermark(tests *testing.T) {
+	if testing.Short() {
+		tests.Skip()
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	testDB.Delete(&models.SchedulerWatermark{}, "name = ?", "reminders")
+	config := scheduler.Config{Interval: time.Minute, Window: time.Hour, MissedAfter: 2 * time.Hour, CatchUp: 6 * time.Hour, Location: time.UTC}
+	reminders := scheduler.New(testDB, scheduler.NewLogNotifier(&bytes.Buffer{}), config)
+	if err := models.AdvanceWatermark(testDB, "reminders", now.Add(-time.Hour)); err != nil {
+		tests.Error(err)
+		return
+	}
+	if err := models.AdvanceWatermark(testDB, "reminders", now.Add(-2*time.Hour)); err != nil {
+		tests.Error(err)
+		return
+	}
+	if last := reminders.Resume(now); !last.Equal(now.Add(-time.Hour)) {
+		tests.Errorf("Expected to resume an hour back, got %v", last)
+	}
+	if last := reminders.Resume(now.Add(12 * time.Hour)); !last.Equal(now.Add(6 * time.Hour)) {
+		tests.Errorf("Expected to go back no further than the catch up, got %v", last)
+	}
+}
+
+func TestLogNotifier(tests *testing.T) {
+	var buffer bytes.Buffer
+	notification := scheduler.Notification{Kind: scheduler.KindDue, ReminderID: uuid.NewV4()}
+	if err := scheduler.NewLogNotifier(&buffer).Notify(notification); err != nil {
+		tests.Error(err)
+		return
+	}
+	logged := scheduler.Notification{}
+	if err := json.Unmarshal(buffer.Bytes(), &logged); err != nil {
+		tests.Error(err)
+		return
+	}
+	if logged.ReminderID != notification.ReminderID {
+		tests.Errorf("Logged the wrong notification: %v", buffer.String())
+	}
+}
+
+func TestWebhookNotifier(tests *testing.T) {
+	received := make(chan scheduler.Notification, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		notification := scheduler.Notification{}
+		json.NewDecoder(r.Body).Decode(&notification)
+		received <- notification
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+	notification := scheduler.Notification{Kind: scheduler.KindMissed, ReminderID: uuid.NewV4()}
+	if err := scheduler.NewWebhookNotifier(server.URL, time.Second).Notify(notification); err != nil {
+		tests.Error(err)
+		return
+	}
+	if got := <-received; got.ReminderID != notification.ReminderID || got.Kind != scheduler.KindMissed {
+		tests.Errorf("Webhook received the wrong notification: %+v", got)
+	}
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer failing.Close()
+	if err := scheduler.NewWebhookNotifier(failing.URL, time.Second).Notify(notification); err == nil {
+		tests.Errorf("Expected a failed webhook to return an error")
+	}
+}
@@ -0,0 +1,72 @@
+package integration
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tespo/buddha/shutdown"
+)
+
+type recordingStopper struct {
+	name   string
+	mutex  *sync.Mutex
+	events *[]string
+	block  chan struct{}
+}
+
+func (s recordingStopper) Stop() {
+	if s.block != nil {
+		<-s.block
+	}
+	s.mutex.Lock()
+	*s.events = append(*s.events, "stop "+s.name)
+	s.mutex.Unlock()
+}
+
+func TestShutdownDrainsThenStopsInReverse(tests *testing.T) {
+	mutex := &sync.Mutex{}
+	events := []string{}
+	group := shutdown.NewGroup()
+	group.Register("scheduler", recordingStopper{name: "scheduler", mutex: mutex, events: &events})
+	group.Register("webhooks", recordingStopper{name: "webhooks", mutex: mutex, events: &events})
+	release := make(chan struct{})
+	group.Go("low supply lambda", func() {
+		<-release
+		mutex.Lock()
+		events = append(events, "lambda sent")
+		mutex.Unlock()
+	})
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		close(release)
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := group.Shutdown(ctx); err != nil {
+		tests.Fatal(err)
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	if strings.Join(events, ", ") != "lambda sent, stop webhooks, stop scheduler" {
+		tests.Errorf("Expected background work to finish before workers stop in reverse, got %v", events)
+	}
+}
+
+func TestShutdownGivesUpAtTheDeadline(tests *testing.T) {
+	mutex := &sync.Mutex{}
+	events := []string{}
+	group := shutdown.NewGroup()
+	block := make(chan struct{})
+	defer close(block)
+	group.Register("scheduler", recordingStopper{name: "scheduler", mutex: mutex, events: &events})
+	group.Register("webhooks", recordingStopper{name: "webhooks", mutex: mutex, events: &events, block: block})
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err := group.Shutdown(ctx)
+	if err == nil || !strings.Contains(err.Error(), "webhooks, scheduler") {
+		tests.Errorf("Expected the deadline error to name the workers left, got %v", err)
+	}
+}
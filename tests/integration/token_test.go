@@ -0,0 +1,286 @@
+package integration
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/tespo/buddha/util"
+)
+
+var tokenSecret = []byte("integration-testing-secret")
+
+func signedTestToken(claims jwt.MapClaims) (string, error) {
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(tokenSecret)
+}
+
+func TestParseValidToken(tests *testing.T) {
+	verifier, err := util.NewTokenVerifier(util.TokenConfig{HMACSecret: tokenSecret, Issuer: "vijnana"})
+	if err != nil {
+		tests.Error(err)
+		return
+	}
+	tokenString, err := signedTestToken(jwt.MapClaims{
+		"user_id": "8c8aa229-3959-4a40-bbe6-67c2eeace5cb",
+		"iss":     "vijnana",
+		"exp":     time.Now().Add(time.Hour).Unix(),
+	})
+	if err != nil {
+		tests.Error(err)
+		return
+	}
+	claims, err := verifier.Parse(tokenString)
+	if err != nil {
+		tests.Error(err)
+		return
+	}
+	if claims["user_id"] != "8c8aa229-3959-4a40-bbe6-67c2eeace5cb" {
+		tests.Error(errors.New("Returned the wrong claims"))
+	}
+}
+
+func TestParseRejectsForgedToken(tests *testing.T) {
+	verifier, err := util.NewTokenVerifier(util.TokenConfig{HMACSecret: tokenSecret})
+	if err != nil {
+		tests.Error(err)
+		return
+	}
+	forged, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"owner": true,
+		"exp":   time.Now().Add(time.Hour).Unix(),
+	}).SignedString([]byte("not-the-secret"))
+	if err != nil {
+		tests.Error(err)
+		return
+	}
+	if _, err := verifier.Parse(forged); err == nil {
+		tests.Error(errors.New("Accepted a token with an invalid signature"))
+	}
+}
+
+func TestParseRejectsExpiredToken(tests *testing.T) {
+	verifier, err := util.NewTokenVerifier(util.TokenConfig{HMACSecret: tokenSecret})
+	if err != nil {
+		tests.Error(err)
+		return
+	}
+	tokenString, err := signedTestToken(jwt.MapClaims{"exp": time.Now().Add(-time.Hour).Unix()})
+	if err != nil {
+		tests.Error(err)
+		return
+	}
+	if _, err := verifier.Parse(tokenString); err == nil {
+		tests.Error(errors.New("Accepted an expired token"))
+	}
+}
+
+func TestParseRejectsWrongAudience(tests *testing.T) {
+	verifier, err := util.NewTokenVerifier(util.TokenConfig{HMACSecret: tokenSecret, Audience: "buddha"})
+	if err != nil {
+		tests.Error(err)
+		return
+	}
+	tokenString, err := signedTestToken(jwt.MapClaims{
+		"aud": []string{"someone-else"},
+		"exp": time.Now().Add(time.Hour).Unix(),
+	})
+	if err != nil {
+		tests.Error(err)
+		return
+	}
+	if _, err := verifier.Parse(tokenString); err == nil {
+		tests.Error(errors.New("Accepted a token for another audience"))
+	}
+}
+
+func TestParseTokenWithJWKS(tests *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		tests.Error(err)
+		return
+	}
+	jwks, err := json.Marshal(map[string]interface{}{
+		"keys": []map[string]string{{
+			"kid": "integration",
+			"kty": "RSA",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}},
+	})
+	if err != nil {
+		tests.Error(err)
+		return
+	}
+	file, err := ioutil.TempFile("", "jwks")
+	if err != nil {
+		tests.Error(err)
+		return
+	}
+	defer os.Remove(file.Name())
+	if _, err := file.Write(jwks); err != nil {
+		tests.Error(err)
+		return
+	}
+	file.Close()
+
+	verifier, err := util.NewTokenVerifier(util.TokenConfig{JWKSURL: file.Name(), JWKSRefresh: time.Hour})
+	if err != nil {
+		tests.Error(err)
+		return
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{"exp": time.Now().Add(time.Hour).Unix()})
+	token.Header["kid"] = "integration"
+	tokenString, err := token.SignedString(key)
+	if err != nil {
+		tests.Error(err)
+		return
+	}
+	if _, err := verifier.Parse(tokenString); err != nil {
+		tests.Error(err)
+	}
+}
+
+func rsaSignedTestToken(key *rsa.PrivateKey, kid string) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{"exp": time.Now().Add(time.Hour).Unix()})
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	return token.SignedString(key)
+}
+
+func TestParseTokenWithSeveralKeyFiles(tests *testing.T) {
+	dir, err := ioutil.TempDir("", "keys")
+	if err != nil {
+		tests.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	keys := make([]*rsa.PrivateKey, 3)
+	files := make([]string, 2)
+	for i := range keys {
+		if keys[i], err = rsa.GenerateKey(rand.Reader, 2048); err != nil {
+			tests.Fatal(err)
+		}
+		if i == len(files) {
+			continue
+		}
+		der, err := x509.MarshalPKIXPublicKey(&keys[i].PublicKey)
+		if err != nil {
+			tests.Fatal(err)
+		}
+		files[i] = filepath.Join(dir, []string{"first.pem", "second.pem"}[i])
+		if err := ioutil.WriteFile(files[i], pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0600); err != nil {
+			tests.Fatal(err)
+		}
+	}
+	verifier, err := util.NewTokenVerifier(util.TokenConfig{PublicKeyFiles: []string{"vijnana-1=" + files[0], files[1]}})
+	if err != nil {
+		tests.Fatal(err)
+	}
+	cases := []struct {
+		key   *rsa.PrivateKey
+		kid   string
+		valid bool
+	}{
+		{keys[0], "vijnana-1", true},
+		{keys[1], "second", true},
+		{keys[1], "", true},
+		{keys[0], "rotated-out", true},
+		{keys[1], "vijnana-1", false},
+		{keys[2], "", false},
+	}
+	for _, c := range cases {
+		tokenString, err := rsaSignedTestToken(c.key, c.kid)
+		if err != nil {
+			tests.Fatal(err)
+		}
+		if _, err := verifier.Parse(tokenString); (err == nil) != c.valid {
+			tests.Errorf("Expected a token with kid %q to be valid: %v, got %v", c.kid, c.valid, err)
+		}
+	}
+}
+
+func TestJWKSSkipsUnsupportedKeys(tests *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		tests.Fatal(err)
+	}
+	jwks := `{"keys": [
+		{"kid": "edwards", "kty": "OKP", "crv": "Ed25519", "x": "11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo"},
+		{"kid": "koblitz", "kty": "EC", "crv": "secp256k1", "x": "AA", "y": "AA"},
+		{"kid": "integration", "kty": "RSA", "use": "sig", "n": "` + base64.RawURLEncoding.EncodeToString(key.N.Bytes()) + `", "e": "AQAB"}
+	]}`
+	file, err := ioutil.TempFile("", "jwks")
+	if err != nil {
+		tests.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString(jwks)
+	file.Close()
+	verifier, err := util.NewTokenVerifier(util.TokenConfig{JWKSURL: file.Name(), JWKSRefresh: time.Hour})
+	if err != nil {
+		tests.Fatal(err)
+	}
+	tokenString, err := rsaSignedTestToken(key, "integration")
+	if err != nil {
+		tests.Fatal(err)
+	}
+	if _, err := verifier.Parse(tokenString); err != nil {
+		tests.Errorf("Expected the RSA key to be used despite the unsupported ones, got %v", err)
+	}
+}
+
+func TestJWKSLookupsDoNotWaitForARefresh(tests *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		tests.Fatal(err)
+	}
+	jwks := `{"keys": [{"kid": "integration", "kty": "RSA", "n": "` + base64.RawURLEncoding.EncodeToString(key.N.Bytes()) + `", "e": "AQAB"}]}`
+	var requests int32
+	refreshing := make(chan struct{})
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) == 2 {
+			close(refreshing)
+			<-release
+		}
+		w.Write([]byte(jwks))
+	}))
+	defer server.Close()
+	defer close(release)
+
+	set := util.NewJWKS(server.URL, time.Millisecond)
+	if _, err := set.Key("integration"); err != nil {
+		tests.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	go set.Key("integration")
+	<-refreshing
+	found := make(chan error, 1)
+	go func() {
+		_, err := set.Key("integration")
+		found <- err
+	}()
+	select {
+	case err := <-found:
+		if err != nil {
+			tests.Error(err)
+		}
+	case <-time.After(time.Second):
+		tests.Error("Expected the key to be found while the key set is fetched again")
+	}
+}
@@ -0,0 +1,116 @@
+package integration
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/tespo/buddha/tracing"
+	"github.com/tespo/buddha/util"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+//
+// testProvider sets a provider exporting to memory and
+// returns a func that turns tracing off again
+//
+func testProvider() (*tracing.Provider, *tracetest.InMemoryExporter, func()) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := tracing.NewProvider(exporter, tracing.Config{Service: "buddha-test", Interval: time.Minute, QueueSize: 10})
+	tracing.SetProvider(provider)
+	return provider, exporter, func() {
+		tracing.SetProvider(nil)
+		provider.Stop()
+	}
+}
+
+func TestTraceRouteContinuesTraceparent(tests *testing.T) {
+	provider, exporter, stop := testProvider()
+	defer stop()
+	incoming := "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"
+	forwarded := ""
+	handler := util.TraceRoute("Test Inserted", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded = r.Header.Get(tracing.TraceparentHeader)
+		_, query := tracing.Tracer().Start(util.RequestContext(r), "db select pods", trace.WithSpanKind(trace.SpanKindClient))
+		tracing.SetError(query, errors.New("connection refused"))
+		query.End()
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	request := httptest.NewRequest("POST", "/dispenser/inserted", nil)
+	request.Header.Set(tracing.TraceparentHeader, incoming)
+	handler.ServeHTTP(httptest.NewRecorder(), request)
+	provider.ForceFlush(request.Context())
+	spans := exporter.GetSpans()
+	if len(spans) != 2 {
+		tests.Fatalf("Expected the route and query spans, got %v", len(spans))
+	}
+	query, route := spans[0], spans[1]
+	if route.SpanContext.TraceID().String() != "0af7651916cd43dd8448eb211c80319c" || route.Parent.SpanID().String() != "b7ad6b7169203331" {
+		tests.Errorf("Expected the route span to continue the caller's trace, got %v/%v", route.SpanContext.TraceID(), route.Parent.SpanID())
+	}
+	if expected := "00-" + route.SpanContext.TraceID().String() + "-" + route.SpanContext.SpanID().String() + "-01"; forwarded != expected {
+		tests.Errorf("Expected the request's traceparent to be the route span, got %v", forwarded)
+	}
+	if query.SpanContext.TraceID() != route.SpanContext.TraceID() || query.Parent.SpanID() != route.SpanContext.SpanID() || query.Status.Description != "connection refused" {
+		tests.Errorf("Expected the query span to be a failed child of the route span, got %+v", query)
+	}
+	status := false
+	for _, attr := range route.Attributes {
+		if attr == attribute.Int("http.status_code", http.StatusInternalServerError) {
+			status = true
+		}
+	}
+	if !status || route.Status.Code != codes.Error {
+		tests.Errorf("Expected the route span to record the failed status, got %+v", route)
+	}
+}
+
+func TestTraceRouteKeepsTheCallersSampledFlag(tests *testing.T) {
+	provider, exporter, stop := testProvider()
+	defer stop()
+	forwarded := ""
+	handler := util.TraceRoute("Test Unsampled", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded = r.Header.Get(tracing.TraceparentHeader)
+	}))
+	request := httptest.NewRequest("GET", "/unsampled", nil)
+	request.Header.Set(tracing.TraceparentHeader, "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-00")
+	handler.ServeHTTP(httptest.NewRecorder(), request)
+	provider.ForceFlush(request.Context())
+	if spans := exporter.GetSpans(); len(spans) != 0 {
+		tests.Errorf("Expected no spans for a trace the caller did not sample, got %v", len(spans))
+	}
+	if len(forwarded) != 55 || forwarded[:36] != "00-0af7651916cd43dd8448eb211c80319c-" || forwarded[52:] != "-00" {
+		tests.Errorf("Expected the trace to be passed on unsampled, got %v", forwarded)
+	}
+}
+
+func TestTracingOffStartsNoSpans(tests *testing.T) {
+	tracing.SetProvider(nil)
+	request := httptest.NewRequest("GET", "/off", nil)
+	forwarded := "unset"
+	util.TraceRoute("Test Off", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded = r.Header.Get(tracing.TraceparentHeader)
+	})).ServeHTTP(httptest.NewRecorder(), request)
+	if forwarded != "" || tracing.Traceparent(util.RequestContext(request)) != "" {
+		tests.Errorf("Expected no span while tracing is off, got %q", forwarded)
+	}
+}
+
+func TestTracingExporterFromConfig(tests *testing.T) {
+	for _, config := range []tracing.Config{
+		{Exporter: "zipkin"},
+		{Exporter: "otlp", Protocol: "http/json"},
+	} {
+		if _, err := tracing.ExporterFromConfig(config); err == nil {
+			tests.Errorf("Expected %+v to be rejected", config)
+		}
+	}
+	if exporter, err := tracing.ExporterFromConfig(tracing.Config{Exporter: "off"}); exporter != nil || err != nil {
+		tests.Errorf("Expected no exporter while tracing is off, got %v %v", exporter, err)
+	}
+}
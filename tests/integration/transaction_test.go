@@ -0,0 +1,450 @@
+package integration
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gorilla/context"
+	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
+	uuid "github.com/satori/go.uuid"
+	"github.com/tespo/buddha/db"
+	"github.com/tespo/buddha/handlers"
+	"github.com/tespo/satya/v2/types"
+)
+
+var errInjected = errors.New("injected failure")
+
+//
+// failWrite makes the nth write of the given kind (create,
+// update or delete) fail until the returned func is called
+//
+func failWrite(conn *gorm.DB, kind string, n int) func() {
+	processors := map[string]func() *gorm.CallbackProcessor{
+		"create": func() *gorm.CallbackProcessor { return conn.Callback().Create() },
+		"update": func() *gorm.CallbackProcessor { return conn.Callback().Update() },
+		"delete": func() *gorm.CallbackProcessor { return conn.Callback().Delete() },
+	}
+	count := 0
+	processors[kind]().After("gorm:"+kind).Register("integration:fail_write", func(scope *gorm.Scope) {
+		count++
+		if count == n {
+			scope.Err(errInjected)
+		}
+	})
+	return func() {
+		processors[kind]().Remove("integration:fail_write")
+	}
+}
+
+//
+// callWithClaims calls the handler with the route's url vars
+// and the claims of a token set on the request
+//
+func callWithClaims(handler http.HandlerFunc, method string, vars map[string]string, body []byte, claims map[string]interface{}) *httptest.ResponseRecorder {
+	request := mux.SetURLVars(httptest.NewRequest(method, "/", bytes.NewReader(body)), vars)
+	for key, value := range claims {
+		context.Set(request, key, value)
+	}
+	defer context.Clear(request)
+	recorder := httptest.NewRecorder()
+	handler(recorder, request)
+	return recorder
+}
+
+func TestTransactionRollsBackOnError(tests *testing.T) {
+	if testing.Short() {
+		tests.Skip()
+	}
+	first := types.Dispenser{Serial: "transaction-" + randomString(8), Name: "Transaction testing dispenser"}
+	second := types.Dispenser{Serial: "transaction-" + randomString(8), Name: "Transaction testing dispenser"}
+	restore := failWrite(testDB, "create", 2)
+	err := db.Transaction(testDB, func(tx *gorm.DB) error {
+		if err := first.Create(tx); err != nil {
+			return err
+		}
+		return second.Create(tx)
+	})
+	restore()
+	if err != errInjected {
+		tests.Errorf("Expected the injected failure, got %v", err)
+	}
+	for _, serial := range []string{first.Serial, second.Serial} {
+		dispenser := types.Dispenser{}
+		if err := dispenser.GetOneByQuery(testDB, "serial = ?", serial); err == nil {
+			tests.Errorf("Dispenser %v was not rolled back", serial)
+		}
+	}
+}
+
+func TestTransactionRollsBackOnPanic(tests *testing.T) {
+	if testing.Short() {
+		tests.Skip()
+	}
+	dispenser := types.Dispenser{Serial: "transaction-" + randomString(8), Name: "Transaction testing dispenser"}
+	func() {
+		defer func() {
+			if recover() == nil {
+				tests.Error(errors.New("Panic was not raised again"))
+			}
+		}()
+		db.Transaction(testDB, func(tx *gorm.DB) error {
+			if err := dispenser.Create(tx); err != nil {
+				return err
+			}
+			panic("injected panic")
+		})
+	}()
+	if err := dispenser.GetOneByQuery(testDB, "serial = ?", dispenser.Serial); err == nil {
+		tests.Error(errors.New("Dispenser was not rolled back"))
+	}
+}
+
+func TestDispenserDisconnectedRollsBack(tests *testing.T) {
+	if testing.Short() {
+		tests.Skip()
+	}
+	accountID := uuid.FromStringOrNil("d8e4c5dc-9767-41bd-b802-060e80d83867")
+	testDispenser := types.Dispenser{Serial: "transaction-" + randomString(8), Name: "Transaction testing dispenser"}
+	if err := testDispenser.Create(testDB); err != nil {
+		tests.Error(err)
+		return
+	}
+	connection := types.Connection{
+		DispenserID: testDispenser.ID,
+		AccountID:   accountID,
+		ConnectedAt: time.Now(),
+	}
+	if err := connection.Create(testDB); err != nil {
+		tests.Error(err)
+		return
+	}
+	data, err := json.Marshal(types.LambdaMessage{
+		Payload: types.Payload{
+			Customer:  types.PayloadCustomer{ID: accountID.String()},
+			Dispenser: types.PayloadDispenser{Serial: testDispenser.Serial},
+		},
+	})
+	if err != nil {
+		tests.Error(err)
+		return
+	}
+
+	restore := failWrite(testDB, "delete", 1)
+	response, err := http.Post(os.Getenv("TESTING_URL")+"/dispenser/disconnected", "application/json", bytes.NewBuffer(data))
+	restore()
+	if err != nil {
+		tests.Error(err)
+		return
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusInternalServerError {
+		tests.Errorf("Expected the injected failure to fail the request, got %v", response.StatusCode)
+	}
+	stored := types.Connection{}
+	if err := stored.GetByID(testDB, connection.ID); err != nil {
+		tests.Error(err)
+		return
+	}
+	if stored.DisconnectedAt != nil {
+		tests.Error(errors.New("Connection was updated but not deleted"))
+	}
+	connection.Delete(testDB, connection.ID)
+	testDispenser.Delete(testDB, testDispenser.ID)
+}
+
+func TestDispenserDispensedRollsBack(tests *testing.T) {
+	if testing.Short() {
+		tests.Skip()
+	}
+	accountID := uuid.FromStringOrNil("d8e4c5dc-9767-41bd-b802-060e80d83867")
+	testDispenser := types.Dispenser{Serial: "transaction-" + randomString(8), Name: "Transaction testing dispenser"}
+	if err := testDispenser.Create(testDB); err != nil {
+		tests.Error(err)
+		return
+	}
+	defer testDispenser.Delete(testDB, testDispenser.ID)
+	connection := types.Connection{
+		DispenserID: testDispenser.ID,
+		AccountID:   accountID,
+		ConnectedAt: time.Now(),
+	}
+	if err := connection.Create(testDB); err != nil {
+		tests.Error(err)
+		return
+	}
+	defer connection.Delete(testDB, connection.ID)
+	regimen := types.Regimen{AccountID: accountID, LastReportedServingsRemaining: 30}
+	if err := regimen.Create(testDB); err != nil {
+		tests.Error(err)
+		return
+	}
+	defer regimen.Delete(testDB, regimen.ID)
+	insertion := types.Insertion{RegimenID: regimen.ID, DispenserID: testDispenser.ID}
+	if err := insertion.Create(testDB); err != nil {
+		tests.Error(err)
+		return
+	}
+	defer insertion.Delete(testDB, insertion.ID)
+	data, err := json.Marshal(types.LambdaMessage{
+		Payload: types.Payload{
+			Customer:  types.PayloadCustomer{ID: accountID.String()},
+			Dispenser: types.PayloadDispenser{Serial: testDispenser.Serial},
+			Pod:       types.PayloadPod{ServingsRemaining: 29},
+		},
+	})
+	if err != nil {
+		tests.Error(err)
+		return
+	}
+
+	// The regimen update is the step after the usage insert
+	restore := failWrite(testDB, "update", 1)
+	response, err := http.Post(os.Getenv("TESTING_URL")+"/dispenser/dispensed", "application/json", bytes.NewBuffer(data))
+	restore()
+	if err != nil {
+		tests.Error(err)
+		return
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusInternalServerError {
+		tests.Errorf("Expected the injected failure to fail the request, got %v", response.StatusCode)
+	}
+	usages := types.Usages{}
+	if err := usages.GetByQuery(testDB, "regimen_id = ?", regimen.ID); err != nil {
+		tests.Error(err)
+		return
+	}
+	if len(usages) != 0 {
+		tests.Error(errors.New("Usage was inserted but the regimen was not updated"))
+	}
+	stored := types.Regimen{}
+	if err := stored.GetByID(testDB, regimen.ID); err != nil {
+		tests.Error(err)
+		return
+	}
+	if stored.LastReportedServingsRemaining != 30 {
+		tests.Errorf("Expected the servings to be left alone, got %v", stored.LastReportedServingsRemaining)
+	}
+}
+
+func TestAcceptInvitationRollsBack(tests *testing.T) {
+	if testing.Short() {
+		tests.Skip()
+	}
+	from := types.Account{ID: uuid.NewV4(), Name: "Transaction testing account"}
+	to := types.Account{ID: uuid.NewV4(), Name: "Transaction testing account"}
+	for _, account := range []*types.Account{&from, &to} {
+		if err := account.Create(testDB); err != nil {
+			tests.Fatal(err)
+		}
+		defer account.Delete(testDB, account.ID)
+	}
+	testUser := types.User{
+		ID:        uuid.NewV4(),
+		AccountID: from.ID,
+		FirstName: "Transaction",
+		LastName:  "testing user",
+		Email:     "transaction-" + randomString(8) + "@example.com",
+	}
+	if err := testUser.Create(testDB); err != nil {
+		tests.Fatal(err)
+	}
+	defer testUser.Delete(testDB, testUser.ID)
+	regimen := types.Regimen{AccountID: from.ID, UserID: &testUser.ID}
+	if err := regimen.Create(testDB); err != nil {
+		tests.Fatal(err)
+	}
+	defer regimen.Delete(testDB, regimen.ID)
+	invitation := types.Invitation{AccountID: to.ID, Email: testUser.Email, ExpiresAt: time.Now().Add(time.Hour)}
+	if err := invitation.Create(testDB); err != nil {
+		tests.Fatal(err)
+	}
+	defer invitation.Delete(testDB, invitation.ID)
+
+	// The user moved and their regimen deleted, the invitation fails to be deleted
+	restore := failWrite(testDB, "delete", 1)
+	recorder := callWithClaims(handlers.AcceptInvitation, "POST", map[string]string{"invitation_id": invitation.ID.String()}, nil, map[string]interface{}{
+		"user_id":    testUser.ID.String(),
+		"account_id": from.ID.String(),
+		"owner":      false,
+	})
+	restore()
+	if recorder.Code != http.StatusInternalServerError {
+		tests.Errorf("Expected the injected failure to fail the request, got %v", recorder.Code)
+	}
+	stored := types.User{}
+	if err := stored.GetByID(testDB, testUser.ID); err != nil || stored.AccountID != from.ID {
+		tests.Errorf("Expected the user to stay on their account, got %v %v", stored.AccountID, err)
+	}
+	storedRegimen := types.Regimen{}
+	if err := storedRegimen.GetByID(testDB, regimen.ID); err != nil {
+		tests.Errorf("Expected the user's regimen to be kept, got %v", err)
+	}
+	storedInvitation := types.Invitation{}
+	if err := storedInvitation.GetByID(testDB, invitation.ID); err != nil {
+		tests.Errorf("Expected the invitation to be kept, got %v", err)
+	}
+}
+
+func TestDeleteAccountUserByIDRollsBack(tests *testing.T) {
+	if testing.Short() {
+		tests.Skip()
+	}
+	account := types.Account{ID: uuid.NewV4(), Name: "Transaction testing account"}
+	if err := account.Create(testDB); err != nil {
+		tests.Fatal(err)
+	}
+	defer account.Delete(testDB, account.ID)
+	owner := types.User{ID: uuid.NewV4(), AccountID: account.ID, FirstName: "Transaction", LastName: "testing owner", Owner: true}
+	member := types.User{ID: uuid.NewV4(), AccountID: account.ID, FirstName: "Transaction", LastName: "testing member"}
+	for _, testUser := range []*types.User{&owner, &member} {
+		if err := testUser.Create(testDB); err != nil {
+			tests.Fatal(err)
+		}
+		defer testUser.Delete(testDB, testUser.ID)
+	}
+	regimen := types.Regimen{AccountID: account.ID, UserID: &member.ID}
+	if err := regimen.Create(testDB); err != nil {
+		tests.Fatal(err)
+	}
+	defer regimen.Delete(testDB, regimen.ID)
+	testUsage := types.Usage{RegimenID: regimen.ID, UserID: &member.ID}
+	if err := testUsage.Create(testDB); err != nil {
+		tests.Fatal(err)
+	}
+	defer testUsage.Delete(testDB, testUsage.ID)
+
+	// The usage and regimen are unassigned, the user fails to be deleted
+	restore := failWrite(testDB, "delete", 1)
+	recorder := callWithClaims(handlers.DeleteAccountUserByID, "DELETE", map[string]string{"user_id": member.ID.String()}, nil, map[string]interface{}{
+		"user_id":    owner.ID.String(),
+		"account_id": account.ID.String(),
+	})
+	restore()
+	if recorder.Code != http.StatusInternalServerError {
+		tests.Errorf("Expected the injected failure to fail the request, got %v", recorder.Code)
+	}
+	stored := types.User{}
+	if err := stored.GetByID(testDB, member.ID); err != nil {
+		tests.Errorf("Expected the user to be kept, got %v", err)
+	}
+	storedRegimen := types.Regimen{}
+	if err := storedRegimen.GetByID(testDB, regimen.ID); err != nil || storedRegimen.UserID == nil || *storedRegimen.UserID != member.ID {
+		tests.Errorf("Expected the regimen to stay assigned to the user, got %v %v", storedRegimen.UserID, err)
+	}
+	storedUsage := types.Usage{}
+	if err := storedUsage.GetByID(testDB, testUsage.ID); err != nil || storedUsage.UserID == nil || *storedUsage.UserID != member.ID {
+		tests.Errorf("Expected the usage to stay assigned to the user, got %v %v", storedUsage.UserID, err)
+	}
+}
+
+func TestPutAccountRegimensByIDMergeRollsBack(tests *testing.T) {
+	if testing.Short() {
+		tests.Skip()
+	}
+	accountID := uuid.NewV4()
+	podID := uuid.NewV4()
+	existing := types.Regimen{AccountID: accountID, PodID: &podID, LastReportedServingsRemaining: 30}
+	current := types.Regimen{AccountID: accountID, LastReportedServingsRemaining: 12}
+	for _, regimen := range []*types.Regimen{&existing, &current} {
+		if err := regimen.Create(testDB); err != nil {
+			tests.Fatal(err)
+		}
+		defer regimen.Delete(testDB, regimen.ID)
+	}
+	testUsage := types.Usage{RegimenID: current.ID}
+	if err := testUsage.Create(testDB); err != nil {
+		tests.Fatal(err)
+	}
+	defer testUsage.Delete(testDB, testUsage.ID)
+	body, err := json.Marshal(types.Regimen{ID: current.ID, AccountID: accountID, PodID: &podID})
+	if err != nil {
+		tests.Fatal(err)
+	}
+
+	// The usages are moved and the servings merged, the regimen fails to be deleted
+	restore := failWrite(testDB, "delete", 1)
+	recorder := callWithClaims(handlers.PutAccountRegimensByID, "PUT", map[string]string{"regimen_id": current.ID.String()}, body, map[string]interface{}{
+		"account_id":    accountID.String(),
+		"scoped_fields": []string{"regimen.*"},
+	})
+	restore()
+	if recorder.Code != http.StatusInternalServerError {
+		tests.Errorf("Expected the injected failure to fail the request, got %v", recorder.Code)
+	}
+	stored := types.Regimen{}
+	if err := stored.GetByID(testDB, current.ID); err != nil {
+		tests.Errorf("Expected the merged regimen to be kept, got %v", err)
+	}
+	if err := stored.GetByID(testDB, existing.ID); err != nil || stored.LastReportedServingsRemaining != 30 {
+		tests.Errorf("Expected the servings of the pod's regimen to be left alone, got %v %v", stored.LastReportedServingsRemaining, err)
+	}
+	storedUsage := types.Usage{}
+	if err := storedUsage.GetByID(testDB, testUsage.ID); err != nil || storedUsage.RegimenID != current.ID {
+		tests.Errorf("Expected the usage to stay on its regimen, got %v %v", storedUsage.RegimenID, err)
+	}
+}
+
+func TestPodInsertedRollsBack(tests *testing.T) {
+	if testing.Short() {
+		tests.Skip()
+	}
+	account := types.Account{ID: uuid.NewV4(), Name: "Transaction testing account"}
+	if err := account.Create(testDB); err != nil {
+		tests.Fatal(err)
+	}
+	defer account.Delete(testDB, account.ID)
+	testDispenser := types.Dispenser{Serial: "transaction-" + randomString(8), Name: "Transaction testing dispenser"}
+	if err := testDispenser.Create(testDB); err != nil {
+		tests.Fatal(err)
+	}
+	defer testDispenser.Delete(testDB, testDispenser.ID)
+	connection := types.Connection{DispenserID: testDispenser.ID, AccountID: account.ID, ConnectedAt: time.Now()}
+	if err := connection.Create(testDB); err != nil {
+		tests.Fatal(err)
+	}
+	defer connection.Delete(testDB, connection.ID)
+	data, err := json.Marshal(types.LambdaMessage{
+		Payload: types.Payload{
+			Customer:  types.PayloadCustomer{ID: account.ID.String()},
+			Dispenser: types.PayloadDispenser{Serial: testDispenser.Serial},
+			Pod:       types.PayloadPod{ServingsRemaining: 30},
+		},
+	})
+	if err != nil {
+		tests.Fatal(err)
+	}
+
+	// The regimen is created, the insertion fails to be
+	restore := failWrite(testDB, "create", 2)
+	response, err := http.Post(os.Getenv("TESTING_URL")+"/dispenser/inserted", "application/json", bytes.NewBuffer(data))
+	restore()
+	if err != nil {
+		tests.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusInternalServerError {
+		tests.Errorf("Expected the injected failure to fail the request, got %v", response.StatusCode)
+	}
+	regimens := types.Regimens{}
+	if err := regimens.GetByQuery(testDB, "account_id = ?", account.ID); err != nil {
+		tests.Fatal(err)
+	}
+	if len(regimens) != 0 {
+		tests.Errorf("Expected the new regimen to be rolled back, got %v", len(regimens))
+	}
+	insertions := types.Insertions{}
+	if err := insertions.GetByQuery(testDB, "dispenser_id = ?", testDispenser.ID); err != nil {
+		tests.Fatal(err)
+	}
+	if len(insertions) != 0 {
+		tests.Errorf("Expected no insertion, got %v", len(insertions))
+	}
+}
@@ -0,0 +1,161 @@
+package integration
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/tespo/buddha/voice"
+	"github.com/tespo/satya/v2/types"
+)
+
+func TestGoogleCodecDecodesExecute(tests *testing.T) {
+	body := []byte(`{"requestId": "1", "inputs": [{"intent": "action.devices.EXECUTE", "payload": {"commands": [
+		{"devices": [{"id": "a"}, {"id": "b"}], "execution": [{"command": "action.devices.commands.OnOff", "params": {"on": true}}]},
+		{"devices": [{"id": "c"}], "execution": [{"command": "action.devices.commands.BrightnessAbsolute"}]}
+	]}}]}`)
+	request, err := voice.GoogleCodec{}.Decode(body)
+	if err != nil {
+		tests.Fatal(err)
+	}
+	if request.Intent != voice.IntentExecute || len(request.Commands) != 2 {
+		tests.Fatalf("Expected two execute commands, got %+v", request)
+	}
+	if command := request.Commands[0]; command.Name != voice.CommandDispense || len(command.Devices) != 2 {
+		tests.Errorf("Expected turning on to dispense on both devices, got %+v", command)
+	}
+	if command := request.Commands[1]; command.Name == voice.CommandDispense || command.Name == voice.CommandStop {
+		tests.Errorf("Expected an unsupported command to keep its name, got %+v", command)
+	}
+}
+
+func TestAlexaCodecTargetsCookieDispenser(tests *testing.T) {
+	body := []byte(`{"directive": {"header": {"namespace": "Alexa.PowerController", "name": "TurnOn", "messageId": "1", "correlationToken": "token"},
+		"endpoint": {"endpointId": "endpoint", "cookie": {"dispenser_id": "dispenser"}, "scope": {"token": "user-token"}}}}`)
+	codec := voice.AlexaCodec{}
+	request, err := codec.Decode(body)
+	if err != nil {
+		tests.Fatal(err)
+	}
+	if request.Intent != voice.IntentExecute || len(request.Commands) != 1 || request.Commands[0].Devices[0] != "dispenser" {
+		tests.Fatalf("Expected a dispense on the cookie's dispenser, got %+v", request)
+	}
+	if token := codec.Token(nil, body); token != "user-token" {
+		tests.Errorf("Expected the endpoint scope token, got %v", token)
+	}
+	data, err := json.Marshal(codec.Encode(request, voice.Response{States: []voice.DeviceState{{ID: "dispenser", Err: voice.ErrDeviceOffline}}}))
+	if err != nil {
+		tests.Fatal(err)
+	}
+	response := struct {
+		Event struct {
+			Header struct {
+				Name             string `json:"name"`
+				CorrelationToken string `json:"correlationToken"`
+			} `json:"header"`
+			Payload struct {
+				Type string `json:"type"`
+			} `json:"payload"`
+		} `json:"event"`
+	}{}
+	if err := json.Unmarshal(data, &response); err != nil {
+		tests.Fatal(err)
+	}
+	if response.Event.Header.Name != "ErrorResponse" || response.Event.Payload.Type != "ENDPOINT_UNREACHABLE" || response.Event.Header.CorrelationToken != "token" {
+		tests.Errorf("Expected an ENDPOINT_UNREACHABLE error response, got %s", data)
+	}
+}
+
+func TestWebhookCodecDispenses(tests *testing.T) {
+	request, err := voice.WebhookCodec{}.Decode([]byte(`{"id": "1", "intent": "Dispense"}`))
+	if err != nil {
+		tests.Fatal(err)
+	}
+	if request.Intent != voice.IntentExecute || len(request.Commands) != 1 || len(request.Commands[0].Devices) != 1 {
+		tests.Fatalf("Expected a dispense on the only dispenser, got %+v", request)
+	}
+	data, err := json.Marshal(voice.WebhookCodec{}.Encode(request, voice.Response{States: []voice.DeviceState{{ID: "", Err: voice.ErrDeviceNotFound}}}))
+	if err != nil {
+		tests.Fatal(err)
+	}
+	response := struct {
+		ID     string `json:"id"`
+		States []struct {
+			Error string `json:"error"`
+		} `json:"states"`
+	}{}
+	if err := json.Unmarshal(data, &response); err != nil {
+		tests.Fatal(err)
+	}
+	if response.ID != "1" || len(response.States) != 1 || response.States[0].Error != voice.ErrDeviceNotFound.Error() {
+		tests.Errorf("Expected the device error in the response, got %s", data)
+	}
+}
+
+func TestRegistryRejectsUnknownIntents(tests *testing.T) {
+	registry := voice.NewRegistry(map[string]voice.Handler{
+		voice.IntentNextReminder: func(user types.User, request voice.Request) (voice.Response, error) {
+			return voice.Response{Speech: "Soon."}, nil
+		},
+	})
+	if response, err := registry.Dispatch(types.User{}, voice.Request{Intent: voice.IntentNextReminder}); err != nil || response.Speech != "Soon." {
+		tests.Errorf("Expected the registered handler to answer, got %+v, %v", response, err)
+	}
+	if _, err := registry.Dispatch(types.User{}, voice.Request{Intent: "Unknown"}); err != voice.ErrUnknownIntent {
+		tests.Errorf("Expected ErrUnknownIntent, got %v", err)
+	}
+}
+
+func TestGoogleCodecNestsExecuteStates(tests *testing.T) {
+	codec := voice.GoogleCodec{}
+	states := []voice.DeviceState{{ID: "kitchen", Online: true, On: true}, {ID: "office", Err: voice.ErrDeviceOffline}}
+	data, err := json.Marshal(codec.Encode(voice.Request{ID: "1", Intent: voice.IntentExecute}, voice.Response{States: states}))
+	if err != nil {
+		tests.Fatal(err)
+	}
+	expected := `{"requestId":"1","payload":{"commands":[{"ids":["kitchen"],"status":"SUCCESS","states":{"online":true,"on":true}},{"ids":["office"],"status":"OFFLINE","errorCode":"deviceOffline"}]}}`
+	if string(data) != expected {
+		tests.Errorf("Expected the EXECUTE states nested beside the ids, got %s", data)
+	}
+	data, err = json.Marshal(codec.Encode(voice.Request{ID: "2", Intent: voice.IntentQuery}, voice.Response{States: states}))
+	if err != nil {
+		tests.Fatal(err)
+	}
+	expected = `{"requestId":"2","payload":{"devices":{"kitchen":{"online":true,"on":true,"status":"SUCCESS"},"office":{"online":false,"on":false,"status":"OFFLINE","errorCode":"deviceOffline"}}}}`
+	if string(data) != expected {
+		tests.Errorf("Expected the QUERY states keyed by device, got %s", data)
+	}
+}
+
+func TestGoogleCodecReportsServingsRemaining(tests *testing.T) {
+	codec := voice.GoogleCodec{}
+	servings := uint(12)
+	states := []voice.DeviceState{{ID: "kitchen", Online: true, ServingsRemaining: &servings}, {ID: "office", Online: true}}
+	data, err := json.Marshal(codec.Encode(voice.Request{ID: "1", Intent: voice.IntentQuery}, voice.Response{States: states}))
+	if err != nil {
+		tests.Fatal(err)
+	}
+	expected := `{"requestId":"1","payload":{"devices":{"kitchen":{"online":true,"on":false,"dispenseItems":[{"itemName":"servings","amountRemaining":{"amount":12,"unit":"NO_UNITS"}}],"status":"SUCCESS"},"office":{"online":true,"on":false,"status":"SUCCESS"}}}}`
+	if string(data) != expected {
+		tests.Errorf("Expected the servings remaining as a dispense item, got %s", data)
+	}
+	data, err = json.Marshal(codec.Encode(voice.Request{ID: "2", Intent: voice.IntentDiscover}, voice.Response{UserID: "user", Devices: []voice.Device{{ID: "kitchen", Name: "Kitchen"}}}))
+	if err != nil {
+		tests.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"traits":["action.devices.traits.OnOff","action.devices.traits.Dispense"]`) ||
+		!strings.Contains(string(data), `"supportedDispenseItems":[{"item_name":"servings"`) {
+		tests.Errorf("Expected SYNC to declare the Dispense trait, got %s", data)
+	}
+}
+
+func TestGoogleCodecDecodesDispense(tests *testing.T) {
+	body := []byte(`{"requestId":"1","inputs":[{"intent":"action.devices.EXECUTE","payload":{"commands":[{"devices":[{"id":"kitchen"}],"execution":[{"command":"action.devices.commands.Dispense","params":{"item":"servings","amount":1,"unit":"NO_UNITS"}}]}]}}]}`)
+	request, err := voice.GoogleCodec{}.Decode(body)
+	if err != nil {
+		tests.Fatal(err)
+	}
+	if len(request.Commands) != 1 || request.Commands[0].Name != voice.CommandDispense {
+		tests.Errorf("Expected a dispense command, got %+v", request.Commands)
+	}
+}
@@ -0,0 +1,174 @@
+package integration
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/tespo/buddha/auth"
+)
+
+const alexaTestCertURL = "https://s3.amazonaws.com/echo.api/echo-api-cert.pem"
+
+//
+// alexaFixture creates a root and a signing certificate for
+// echo-api.amazon.com and writes the chain to a file
+//
+func alexaFixture(tests *testing.T) (*auth.AlexaVerifier, *rsa.PrivateKey, func()) {
+	rootKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		tests.Fatal(err)
+	}
+	root := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test Root"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	rootDER, err := x509.CreateCertificate(rand.Reader, root, root, &rootKey.PublicKey, rootKey)
+	if err != nil {
+		tests.Fatal(err)
+	}
+	root, _ = x509.ParseCertificate(rootDER)
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		tests.Fatal(err)
+	}
+	leaf := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "echo-api.amazon.com"},
+		DNSNames:     []string{"echo-api.amazon.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	leafDER, err := x509.CreateCertificate(rand.Reader, leaf, root, &key.PublicKey, rootKey)
+	if err != nil {
+		tests.Fatal(err)
+	}
+	file, err := ioutil.TempFile("", "alexa-chain")
+	if err != nil {
+		tests.Fatal(err)
+	}
+	pem.Encode(file, &pem.Block{Type: "CERTIFICATE", Bytes: leafDER})
+	pem.Encode(file, &pem.Block{Type: "CERTIFICATE", Bytes: rootDER})
+	file.Close()
+	roots := x509.NewCertPool()
+	roots.AddCert(root)
+	verifier := auth.NewAlexaVerifier(auth.NewFixtureCertificateStore(file.Name()), roots)
+	return verifier, key, func() { os.Remove(file.Name()) }
+}
+
+func signedAlexaRequest(tests *testing.T, key *rsa.PrivateKey, certURL string, body []byte) *http.Request {
+	sum := sha256.Sum256(body)
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, sum[:])
+	if err != nil {
+		tests.Fatal(err)
+	}
+	request, err := http.NewRequest("POST", "/alexa/fulfillment", bytes.NewReader(body))
+	if err != nil {
+		tests.Fatal(err)
+	}
+	request.Header.Set("SignatureCertChainUrl", certURL)
+	request.Header.Set("Signature-256", base64.StdEncoding.EncodeToString(signature))
+	return request
+}
+
+func alexaBody(timestamp time.Time) []byte {
+	return []byte(`{"request":{"type":"IntentRequest","timestamp":"` + timestamp.UTC().Format(time.RFC3339) + `"}}`)
+}
+
+func TestAlexaVerifierAcceptsSignedRequests(tests *testing.T) {
+	verifier, key, cleanup := alexaFixture(tests)
+	defer cleanup()
+	body := alexaBody(time.Now())
+	if err := verifier.Verify(signedAlexaRequest(tests, key, alexaTestCertURL, body), body); err != nil {
+		tests.Error(err)
+	}
+}
+
+func TestAlexaVerifierAcceptsSignedDirectives(tests *testing.T) {
+	verifier, key, cleanup := alexaFixture(tests)
+	defer cleanup()
+	body := []byte(`{"directive":{"header":{"namespace":"Alexa.Discovery","name":"Discover","payloadVersion":"3","messageId":"1"},"payload":{"scope":{"type":"BearerToken","token":"token"}}}}`)
+	if err := verifier.Verify(signedAlexaRequest(tests, key, alexaTestCertURL, body), body); err != nil {
+		tests.Errorf("Expected a signed directive without a timestamp to be accepted, got %v", err)
+	}
+	tampered := []byte(`{"directive":{"header":{"namespace":"Alexa.PowerController","name":"TurnOn","payloadVersion":"3","messageId":"1"}}}`)
+	if err := verifier.Verify(signedAlexaRequest(tests, key, alexaTestCertURL, body), tampered); err == nil {
+		tests.Error("Expected a directive that does not match the signature to be rejected")
+	}
+}
+
+func TestAlexaVerifierRejectsSpoofedRequests(tests *testing.T) {
+	verifier, key, cleanup := alexaFixture(tests)
+	defer cleanup()
+	body := alexaBody(time.Now())
+	tampered := alexaBody(time.Now().Add(time.Second))
+	if err := verifier.Verify(signedAlexaRequest(tests, key, alexaTestCertURL, body), tampered); err == nil {
+		tests.Error("Expected a body that does not match the signature to be rejected")
+	}
+	for _, certURL := range []string{
+		"http://s3.amazonaws.com/echo.api/echo-api-cert.pem",
+		"https://s3.amazonaws.com/EcHo.aPi/echo-api-cert.pem",
+		"https://s3.amazonaws.com/echo.api/../invalid.pem",
+		"https://s3.amazonaws.com:563/echo.api/echo-api-cert.pem",
+		"https://notamazon.com/echo.api/echo-api-cert.pem",
+	} {
+		if err := verifier.Verify(signedAlexaRequest(tests, key, certURL, body), body); err == nil {
+			tests.Errorf("Expected the certificate url %v to be rejected", certURL)
+		}
+	}
+	stale := alexaBody(time.Now().Add(-5 * time.Minute))
+	if err := verifier.Verify(signedAlexaRequest(tests, key, alexaTestCertURL, stale), stale); err == nil {
+		tests.Error("Expected a stale request to be rejected")
+	}
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		tests.Fatal(err)
+	}
+	if err := verifier.Verify(signedAlexaRequest(tests, other, alexaTestCertURL, body), body); err == nil {
+		tests.Error("Expected a request signed with another key to be rejected")
+	}
+}
+
+func TestGoogleVerifierLetsSmartHomeIntentsThrough(tests *testing.T) {
+	file, err := ioutil.TempFile("", "google-jwks")
+	if err != nil {
+		tests.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString(`{"keys":[]}`)
+	file.Close()
+	verifier, err := auth.NewGoogleVerifier("tespo-project", file.Name())
+	if err != nil {
+		tests.Fatal(err)
+	}
+	query := []byte(`{"requestId":"1","inputs":[{"intent":"action.devices.QUERY","payload":{"devices":[{"id":"dispenser"}]}}]}`)
+	request := httptest.NewRequest("POST", "/google/fulfillment", bytes.NewReader(query))
+	request.Header.Set("Authorization", "Bearer token")
+	if err := verifier.Verify(request, query); err != nil {
+		tests.Errorf("Expected an unsigned QUERY to be left to the bearer token, got %v", err)
+	}
+	conversation := []byte(`{"inputs":[{"intent":"actions.intent.MAIN"}]}`)
+	request = httptest.NewRequest("POST", "/google/fulfillment", bytes.NewReader(conversation))
+	if err := verifier.Verify(request, conversation); err == nil {
+		tests.Error("Expected an unsigned conversational request to be rejected")
+	}
+}
@@ -0,0 +1,229 @@
+package integration
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	"github.com/tespo/buddha/models"
+	"github.com/tespo/buddha/webhook"
+)
+
+func TestWebhookSignatureVerifies(tests *testing.T) {
+	now := time.Now()
+	body := []byte(`{"event": "usage.created"}`)
+	header := webhook.Sign("secret", now, body)
+	if err := webhook.Verify("secret", header, body, 5*time.Minute, now); err != nil {
+		tests.Errorf("Expected the signature to verify, got %v", err)
+	}
+	if err := webhook.Verify("other", header, body, 5*time.Minute, now); err == nil {
+		tests.Errorf("Expected a signature with another secret to be rejected")
+	}
+	if err := webhook.Verify("secret", header, []byte(`{}`), 5*time.Minute, now); err == nil {
+		tests.Errorf("Expected a signature of another body to be rejected")
+	}
+	if err := webhook.Verify("secret", header, body, 5*time.Minute, now.Add(time.Hour)); err == nil {
+		tests.Errorf("Expected an old signature to be rejected")
+	}
+}
+
+func TestWebhookEndpointSubscribes(tests *testing.T) {
+	endpoint := models.WebhookEndpoint{EventList: []string{webhook.EventUsageCreated}}
+	if !endpoint.Subscribes(webhook.EventUsageCreated) || endpoint.Subscribes(webhook.EventPodInserted) {
+		tests.Errorf("Expected the endpoint to only subscribe to usage.created")
+	}
+	endpoint.EventList = []string{"*"}
+	if !endpoint.Subscribes(webhook.EventPodInserted) {
+		tests.Errorf("Expected * to subscribe to every event")
+	}
+}
+
+func TestWebhookDeliveryRetriesAndReplays(tests *testing.T) {
+	if testing.Short() {
+		tests.Skip()
+	}
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if err := webhook.Verify("integration-secret", r.Header.Get(webhook.SignatureHeader), body, time.Minute, time.Now()); err != nil {
+			tests.Errorf("Expected a signed delivery, got %v", err)
+		}
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	// A developer endpoint, as the test server listens on loopback
+	accountID := uuid.NewV4()
+	endpoint := models.WebhookEndpoint{
+		URL:       server.URL,
+		Secret:    "integration-secret",
+		EventList: []string{webhook.EventUsageCreated},
+		Active:    true,
+	}
+	if err := endpoint.Create(testDB); err != nil {
+		tests.Fatal(err)
+	}
+	dispatcher := webhook.New(testDB, webhook.Config{Backoff: time.Minute, MaxAttempts: 3, Timeout: time.Second})
+	if err := dispatcher.Emit(accountID, webhook.EventUsageCreated, map[string]string{"usage_id": "1"}); err != nil {
+		tests.Fatal(err)
+	}
+	dispatcher.Stop()
+
+	delivery := models.WebhookDelivery{}
+	if err := delivery.GetOneByQuery(testDB, "endpoint_id = ?", endpoint.ID); err != nil {
+		tests.Fatal(err)
+	}
+	if delivery.Status != models.DeliveryPending || delivery.Attempts != 1 || delivery.LastStatusCode != http.StatusServiceUnavailable {
+		tests.Fatalf("Expected the failed delivery to be retried, got %+v", delivery)
+	}
+	if err := dispatcher.Retry(time.Now().Add(time.Hour)); err != nil {
+		tests.Fatal(err)
+	}
+	if err := delivery.GetOneByQuery(testDB, "id = ?", delivery.ID); err != nil {
+		tests.Fatal(err)
+	}
+	if delivery.Status != models.DeliveryDelivered || delivery.Attempts != 2 {
+		tests.Fatalf("Expected the retry to deliver, got %+v", delivery)
+	}
+	replay, err := dispatcher.Replay(delivery)
+	if err != nil {
+		tests.Fatal(err)
+	}
+	if replay.Status != models.DeliveryDelivered || replay.EventID != delivery.EventID || replay.ReplayOf == nil {
+		tests.Errorf("Expected the replay to deliver the same event, got %+v", replay)
+	}
+}
+
+func TestWebhookBackoffIsCapped(tests *testing.T) {
+	config := webhook.Config{Backoff: time.Minute, MaxBackoff: time.Hour}
+	for attempts, expected := range map[int]time.Duration{
+		1:    time.Minute,
+		2:    2 * time.Minute,
+		6:    32 * time.Minute,
+		7:    time.Hour,
+		40:   time.Hour,
+		1000: time.Hour,
+	} {
+		if delay := config.Delay(attempts); delay != expected {
+			tests.Errorf("Expected a delay of %v after %v attempts, got %v", expected, attempts, delay)
+		}
+	}
+}
+
+func TestWebhookAccountURLMustBePublic(tests *testing.T) {
+	for _, url := range []string{
+		"http://93.184.216.34/hooks",
+		"https://127.0.0.1/hooks",
+		"https://localhost/hooks",
+		"https://10.1.2.3/hooks",
+		"https://172.16.0.1/hooks",
+		"https://192.168.1.1/hooks",
+		"https://169.254.169.254/latest/meta-data",
+		"https://[::1]/hooks",
+		"https://[fd00::1]/hooks",
+	} {
+		if err := webhook.CheckAccountURL(url); err == nil {
+			tests.Errorf("Expected %v to be rejected", url)
+		}
+	}
+	if err := webhook.CheckAccountURL("https://93.184.216.34/hooks"); err != nil {
+		tests.Errorf("Expected a public https url to be accepted, got %v", err)
+	}
+	if webhook.PublicIP(net.ParseIP("fe80::1")) || !webhook.PublicIP(net.ParseIP("2606:2800:220:1::1")) {
+		tests.Errorf("Expected link-local addresses to be private and global ones public")
+	}
+}
+
+func TestWebhookAccountEndpointRefusesPrivateAddress(tests *testing.T) {
+	if testing.Short() {
+		tests.Skip()
+	}
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+	}))
+	defer server.Close()
+
+	// Stored directly, as registration would refuse the url
+	accountID := uuid.NewV4()
+	endpoint := models.WebhookEndpoint{
+		AccountID: &accountID,
+		URL:       server.URL,
+		Secret:    "integration-secret",
+		EventList: []string{webhook.EventUsageCreated},
+		Active:    true,
+	}
+	if err := endpoint.Create(testDB); err != nil {
+		tests.Fatal(err)
+	}
+	dispatcher := webhook.New(testDB, webhook.Config{Backoff: time.Minute, MaxAttempts: 3, Timeout: time.Second})
+	if err := dispatcher.Emit(accountID, webhook.EventUsageCreated, map[string]string{"usage_id": "1"}); err != nil {
+		tests.Fatal(err)
+	}
+	dispatcher.Stop()
+
+	delivery := models.WebhookDelivery{}
+	if err := delivery.GetOneByQuery(testDB, "endpoint_id = ?", endpoint.ID); err != nil {
+		tests.Fatal(err)
+	}
+	if atomic.LoadInt32(&calls) != 0 || !strings.Contains(delivery.LastError, "private address") {
+		tests.Errorf("Expected the delivery to a loopback address to be refused, got %v calls and %+v", calls, delivery)
+	}
+}
+
+func TestWebhookRetryClaimsEachDeliveryOnce(tests *testing.T) {
+	if testing.Short() {
+		tests.Skip()
+	}
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+	}))
+	defer server.Close()
+
+	endpoint := models.WebhookEndpoint{
+		URL:       server.URL,
+		Secret:    "integration-secret",
+		EventList: []string{webhook.EventUsageCreated},
+		Active:    false,
+	}
+	if err := endpoint.Create(testDB); err != nil {
+		tests.Fatal(err)
+	}
+	due := time.Now().Add(-time.Minute)
+	delivery := models.WebhookDelivery{
+		EndpointID:    endpoint.ID,
+		EventID:       uuid.NewV4(),
+		Event:         webhook.EventUsageCreated,
+		Payload:       []byte(`{}`),
+		Status:        models.DeliveryPending,
+		NextAttemptAt: &due,
+	}
+	if err := delivery.Create(testDB); err != nil {
+		tests.Fatal(err)
+	}
+	config := webhook.Config{Interval: time.Minute, Backoff: time.Minute, MaxAttempts: 3, Timeout: time.Second}
+	wait := sync.WaitGroup{}
+	for i := 0; i < 4; i++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			if err := webhook.New(testDB, config).Retry(time.Now()); err != nil {
+				tests.Error(err)
+			}
+		}()
+	}
+	wait.Wait()
+	if atomic.LoadInt32(&calls) != 1 {
+		tests.Errorf("Expected instances retrying at once to send the delivery once, got %v", calls)
+	}
+}
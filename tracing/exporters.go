@@ -0,0 +1,37 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"os"
+
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+//
+// ExporterFromConfig builds the exporter named by the config,
+// otlp or stdout. It returns nil when tracing is off. The otlp
+// exporter sends over OTLP/HTTP, or gRPC when the protocol is
+// grpc, and reads its endpoint, headers and timeout from the
+// OTEL_EXPORTER_OTLP_* variables
+//
+func ExporterFromConfig(config Config) (sdktrace.SpanExporter, error) {
+	switch config.Exporter {
+	case "", "off":
+		return nil, nil
+	case "otlp":
+		switch config.Protocol {
+		case "", "http/protobuf":
+			return otlptracehttp.New(context.Background())
+		case "grpc":
+			return otlptracegrpc.New(context.Background())
+		}
+		return nil, errors.New("OTEL_EXPORTER_OTLP_PROTOCOL must be http/protobuf or grpc")
+	case "stdout":
+		return stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	}
+	return nil, errors.New("TRACE_EXPORTER must be otlp, stdout or off")
+}
@@ -0,0 +1,87 @@
+package tracing
+
+import (
+	"context"
+
+	"github.com/jinzhu/gorm"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	contextKey = "tracing:context"
+	queryKey   = "tracing:query_span"
+)
+
+//
+// WithContext returns the db with the span of the context
+// set as the parent of the spans of its queries
+//
+func WithContext(db *gorm.DB, ctx context.Context) *gorm.DB {
+	if ctx == nil {
+		return db
+	}
+	return db.Set(contextKey, ctx)
+}
+
+//
+// Context returns the context set with WithContext,
+// or the background context
+//
+func Context(db *gorm.DB) context.Context {
+	if db != nil {
+		if ctx, ok := db.Get(contextKey); ok {
+			return ctx.(context.Context)
+		}
+	}
+	return context.Background()
+}
+
+//
+// RegisterGorm adds callbacks to the db that
+// trace every create, query, update and delete
+//
+func RegisterGorm(db *gorm.DB) {
+	callback := db.Callback()
+	callback.Create().Before("gorm:create").Register("tracing:before_create", startQuery("create"))
+	callback.Create().After("gorm:create").Register("tracing:after_create", finishQuery)
+	callback.Query().Before("gorm:query").Register("tracing:before_query", startQuery("select"))
+	callback.Query().After("gorm:query").Register("tracing:after_query", finishQuery)
+	callback.Update().Before("gorm:update").Register("tracing:before_update", startQuery("update"))
+	callback.Update().After("gorm:update").Register("tracing:after_update", finishQuery)
+	callback.Delete().Before("gorm:delete").Register("tracing:before_delete", startQuery("delete"))
+	callback.Delete().After("gorm:delete").Register("tracing:after_delete", finishQuery)
+	callback.RowQuery().Before("gorm:row_query").Register("tracing:before_row_query", startQuery("select"))
+	callback.RowQuery().After("gorm:row_query").Register("tracing:after_row_query", finishQuery)
+}
+
+func startQuery(operation string) func(scope *gorm.Scope) {
+	return func(scope *gorm.Scope) {
+		ctx := context.Background()
+		if value, ok := scope.Get(contextKey); ok {
+			ctx = value.(context.Context)
+		}
+		_, span := Tracer().Start(ctx, "db "+operation+" "+scope.TableName(),
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(
+				attribute.String("db.system", "mysql"),
+				attribute.String("db.operation", operation),
+				attribute.String("db.sql.table", scope.TableName()),
+			),
+		)
+		scope.InstanceSet(queryKey, span)
+	}
+}
+
+func finishQuery(scope *gorm.Scope) {
+	value, ok := scope.InstanceGet(queryKey)
+	if !ok {
+		return
+	}
+	span := value.(trace.Span)
+	span.SetAttributes(attribute.String("db.statement", scope.SQL))
+	if err := scope.DB().Error; err != nil && !gorm.IsRecordNotFoundError(err) {
+		SetError(span, err)
+	}
+	span.End()
+}
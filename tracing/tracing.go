@@ -0,0 +1,161 @@
+package tracing
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+//
+// TraceparentHeader carries the trace context between
+// services, in the W3C Trace Context format
+//
+const TraceparentHeader = "traceparent"
+
+//
+// propagator reads and writes the traceparent header,
+// keeping the caller's sampled flag
+//
+var propagator = propagation.TraceContext{}
+
+//
+// Tracer is the tracer spans are started with. Its spans
+// do nothing until a provider is set with SetProvider
+//
+func Tracer() trace.Tracer {
+	return otel.Tracer("github.com/tespo/buddha/tracing")
+}
+
+//
+// Extract returns the context with the span of the
+// header's traceparent as the parent of its spans
+//
+func Extract(ctx context.Context, header http.Header) context.Context {
+	return propagator.Extract(ctx, propagation.HeaderCarrier(header))
+}
+
+//
+// Inject sets the header's traceparent to the span of the context
+//
+func Inject(ctx context.Context, header http.Header) {
+	propagator.Inject(ctx, propagation.HeaderCarrier(header))
+}
+
+//
+// Traceparent is the header that makes the span of the context
+// the parent of the spans of another service. It is empty when
+// the context has no span
+//
+func Traceparent(ctx context.Context) string {
+	carrier := propagation.MapCarrier{}
+	propagator.Inject(ctx, carrier)
+	return carrier.Get(TraceparentHeader)
+}
+
+//
+// SetError marks the span failed with the error
+//
+func SetError(span trace.Span, err error) {
+	if err == nil {
+		return
+	}
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+//
+// Config holds the settings for exporting spans
+//
+type Config struct {
+	Exporter  string
+	Protocol  string
+	Service   string
+	Interval  time.Duration
+	QueueSize int
+}
+
+//
+// ConfigFromEnv builds the tracing config specific to the environment
+//
+func ConfigFromEnv() Config {
+	service := os.Getenv("OTEL_SERVICE_NAME")
+	if service == "" {
+		service = "buddha"
+	}
+	queueSize, err := strconv.Atoi(os.Getenv("TRACE_QUEUE_SIZE"))
+	if err != nil || queueSize <= 0 {
+		queueSize = 2048
+	}
+	return Config{
+		Exporter:  os.Getenv("TRACE_EXPORTER"),
+		Protocol:  os.Getenv("OTEL_EXPORTER_OTLP_PROTOCOL"),
+		Service:   service,
+		Interval:  envDuration("TRACE_EXPORT_INTERVAL", 5*time.Second),
+		QueueSize: queueSize,
+	}
+}
+
+func envDuration(key string, fallback time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(key))
+	if err != nil || value <= 0 {
+		return fallback
+	}
+	return value
+}
+
+//
+// Provider exports finished spans in batches. Spans
+// finished while the queue is full are dropped
+//
+type Provider struct {
+	*sdktrace.TracerProvider
+}
+
+//
+// NewProvider returns a provider exporting to the exporter.
+// It samples the traces it starts, and a trace continued from
+// a traceparent only when the caller sampled it
+//
+func NewProvider(exporter sdktrace.SpanExporter, config Config) *Provider {
+	return &Provider{sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter,
+			sdktrace.WithBatchTimeout(config.Interval),
+			sdktrace.WithMaxQueueSize(config.QueueSize),
+		),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", config.Service))),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.AlwaysSample())),
+	)}
+}
+
+//
+// Stop exports the spans left and stops the provider
+//
+func (p *Provider) Stop() {
+	if err := p.Shutdown(context.Background()); err != nil {
+		log.Printf("cannot stop tracing: %v", err)
+	}
+}
+
+//
+// SetProvider makes the provider the one spans are started
+// with. Nil turns tracing off
+//
+func SetProvider(provider *Provider) {
+	if provider == nil {
+		otel.SetTracerProvider(noop.NewTracerProvider())
+		return
+	}
+	otel.SetTracerProvider(provider)
+}
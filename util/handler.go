@@ -15,13 +15,28 @@ func ErrorResponder(w http.ResponseWriter, statusCode int, err error) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(statusCode)
 	if statusCode >= 500 {
-		sentry.CaptureException(err)
+		CaptureException(w.Header().Get(RequestIDHeader), err)
 	}
 	if err = json.NewEncoder(w).Encode(map[string]string{"error": err.Error()}); err != nil {
 		panic(err)
 	}
 }
 
+//
+// CaptureException sends the error to sentry, tagged
+// with the ID of the request it happened in
+//
+func CaptureException(requestID string, err error) {
+	if requestID == "" {
+		sentry.CaptureException(err)
+		return
+	}
+	sentry.WithScope(func(scope *sentry.Scope) {
+		scope.SetTag("request_id", requestID)
+		sentry.CaptureException(err)
+	})
+}
+
 //
 // JSONResponder responds to the passed in http.ResponsWriter with
 // a json object of the passed in interface
@@ -1,17 +1,26 @@
 package util
 
 import (
+	"context"
 	"os"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ses"
+	"github.com/tespo/buddha/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 //
 // SendInviteEmail sends an invite email when a user wishes to invite another user to their account
 //
-func SendInviteEmail(address, name, inviteCode string) error {
+func SendInviteEmail(ctx context.Context, address, name, inviteCode string) error {
+	_, span := tracing.Tracer().Start(ctx, "ses SendTemplatedEmail",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("email.template", os.Getenv("INVITATION_TEMPLATE_NAME"))),
+	)
+	defer span.End()
 
 	// Create a new session in the us-east-1 region.
 	// Replace us-east-1 with the AWS Region you're using for Amazon SES.
@@ -19,6 +28,7 @@ func SendInviteEmail(address, name, inviteCode string) error {
 		Region: aws.String("us-east-1")},
 	)
 	if err != nil {
+		tracing.SetError(span, err)
 		return err
 	}
 	// Create an SES session.
@@ -42,6 +52,7 @@ func SendInviteEmail(address, name, inviteCode string) error {
 
 	_, err = svc.SendTemplatedEmail(&sendTemplateInput)
 	if err != nil {
+		tracing.SetError(span, err)
 		return err
 	}
 	return nil
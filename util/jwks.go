@@ -0,0 +1,274 @@
+package util
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+//
+// minJWKSRefresh limits how often an unknown kid
+// can force the key set to be fetched again
+//
+const minJWKSRefresh = time.Minute
+
+//
+// JWKS is a cached JSON Web Key Set loaded from an http(s)
+// endpoint or a local file. The set is refreshed after the
+// refresh interval or when a token uses an unknown key id.
+// One fetch runs at a time, outside the lock, and lookups
+// keep using the current keys while it runs
+//
+type JWKS struct {
+	source    string
+	refresh   time.Duration
+	client    *http.Client
+	mutex     sync.Mutex
+	keys      map[string]interface{}
+	fetchedAt time.Time
+	fetching  *jwksFetch
+}
+
+type jwksFetch struct {
+	done chan struct{}
+	err  error
+}
+
+type jsonWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+//
+// NewJWKS returns a key set for the given url or file path
+//
+func NewJWKS(source string, refresh time.Duration) *JWKS {
+	return &JWKS{
+		source:  source,
+		refresh: refresh,
+		client:  &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+//
+// Key returns the public key for the key id, fetching
+// the key set again when it is stale or the id is unknown
+//
+func (j *JWKS) Key(kid string) (interface{}, error) {
+	if loaded, age := j.state(); !loaded || age > j.refresh {
+		if err := j.update(); err != nil {
+			if loaded, _ := j.state(); !loaded {
+				return nil, err
+			}
+		}
+	}
+	if key, ok := j.lookup(kid); ok {
+		return key, nil
+	}
+	if _, age := j.state(); age > minJWKSRefresh {
+		if err := j.update(); err != nil {
+			return nil, err
+		}
+		if key, ok := j.lookup(kid); ok {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("Unknown key id %v", kid)
+}
+
+//
+// state returns whether a key set was loaded and
+// how long ago the last fetch started
+//
+func (j *JWKS) state() (bool, time.Duration) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	return j.keys != nil, time.Since(j.fetchedAt)
+}
+
+//
+// update fetches the key set and swaps it in. A caller finding
+// a fetch in flight waits for it only when no keys are loaded
+// yet, and otherwise goes on with the current keys
+//
+func (j *JWKS) update() error {
+	j.mutex.Lock()
+	if fetching := j.fetching; fetching != nil {
+		loaded := j.keys != nil
+		j.mutex.Unlock()
+		if loaded {
+			return nil
+		}
+		<-fetching.done
+		return fetching.err
+	}
+	fetching := &jwksFetch{done: make(chan struct{})}
+	j.fetching = fetching
+	j.fetchedAt = time.Now()
+	j.mutex.Unlock()
+
+	keys, err := j.fetch()
+
+	j.mutex.Lock()
+	if err == nil {
+		j.keys = keys
+	}
+	j.fetching = nil
+	j.mutex.Unlock()
+	fetching.err = err
+	close(fetching.done)
+	return err
+}
+
+func (j *JWKS) lookup(kid string) (interface{}, bool) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	if kid == "" && len(j.keys) == 1 {
+		for _, key := range j.keys {
+			return key, true
+		}
+	}
+	key, ok := j.keys[kid]
+	return key, ok
+}
+
+//
+// fetch reads and parses the key set
+//
+func (j *JWKS) fetch() (map[string]interface{}, error) {
+	data, err := j.read()
+	if err != nil {
+		return nil, err
+	}
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+	keys := map[string]interface{}{}
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if _, unsupported := err.(unsupportedKeyError); unsupported {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		keys[jwk.Kid] = key
+	}
+	return keys, nil
+}
+
+func (j *JWKS) read() ([]byte, error) {
+	if !strings.HasPrefix(j.source, "http://") && !strings.HasPrefix(j.source, "https://") {
+		return ioutil.ReadFile(strings.TrimPrefix(j.source, "file://"))
+	}
+	resp, err := j.client.Get(j.source)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Cannot fetch key set: %v", resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+func (k jsonWebKey) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBase64URLInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBase64URLInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, unsupportedKeyError("Unsupported curve " + k.Crv)
+		}
+		x, err := decodeBase64URLInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBase64URLInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, unsupportedKeyError("Unsupported key type " + k.Kty)
+}
+
+//
+// unsupportedKeyError is a key of a type or curve tokens cannot
+// be verified with here. Such keys are left out of the set
+//
+type unsupportedKeyError string
+
+func (e unsupportedKeyError) Error() string {
+	return string(e)
+}
+
+func decodeBase64URLInt(value string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(value, "="))
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(data), nil
+}
+
+func parsePublicKeyPEM(data []byte) (interface{}, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("Cannot decode public key")
+	}
+	if block.Type == "CERTIFICATE" {
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return cert.PublicKey, nil
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		if key, err := x509.ParsePKCS1PublicKey(block.Bytes); err == nil {
+			return key, nil
+		}
+		return nil, err
+	}
+	return key, nil
+}
@@ -1,18 +1,37 @@
 package util
 
 import (
+	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/lambda"
-	"github.com/tespo/satya/v2/types"
+	"github.com/tespo/buddha/metrics"
+	"github.com/tespo/buddha/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 //
-// TriggerLambda will trigger a lambda function
+// InvokeLambda invokes a lambda function with any payload
+// that marshals to json, in a span that is a child of the
+// context's span. The request ID and the span's traceparent are
+// passed to the function as custom client context
 //
-func TriggerLambda(payload types.Payload, name string) (*lambda.InvokeOutput, error) {
+func InvokeLambda(ctx context.Context, requestID, name string, payload interface{}) (*lambda.InvokeOutput, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "lambda "+name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("faas.invoked_name", name),
+			attribute.String("faas.invoked_provider", "aws"),
+		),
+	)
+	defer span.End()
+
 	sess := session.Must(session.NewSessionWithOptions(session.Options{
 		SharedConfigState: session.SharedConfigEnable,
 	}))
@@ -24,10 +43,36 @@ func TriggerLambda(payload types.Payload, name string) (*lambda.InvokeOutput, er
 		return nil, err
 	}
 
-	result, err := client.Invoke(&lambda.InvokeInput{FunctionName: aws.String(name), Payload: data})
+	input := &lambda.InvokeInput{FunctionName: aws.String(name), Payload: data}
+	custom := map[string]string{}
+	if requestID != "" {
+		custom["request_id"] = requestID
+	}
+	if traceparent := tracing.Traceparent(ctx); traceparent != "" {
+		custom[tracing.TraceparentHeader] = traceparent
+	}
+	if len(custom) > 0 {
+		clientContext, err := json.Marshal(map[string]interface{}{"custom": custom})
+		if err != nil {
+			return nil, err
+		}
+		input.ClientContext = aws.String(base64.StdEncoding.EncodeToString(clientContext))
+	}
+
+	start := time.Now()
+	result, err := client.Invoke(input)
+	metrics.LambdaDuration.WithLabelValues(name).Observe(time.Since(start).Seconds())
 	if err != nil {
+		metrics.LambdaInvocations.WithLabelValues(name, "error").Inc()
+		tracing.SetError(span, err)
 		return nil, err
 	}
+	if result.FunctionError != nil {
+		metrics.LambdaInvocations.WithLabelValues(name, "function_error").Inc()
+		tracing.SetError(span, errors.New(*result.FunctionError))
+	} else {
+		metrics.LambdaInvocations.WithLabelValues(name, "success").Inc()
+	}
 
 	return result, nil
 }
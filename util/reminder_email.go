@@ -0,0 +1,38 @@
+package util
+
+import (
+	"encoding/json"
+	"os"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ses"
+)
+
+//
+// SendReminderEmail sends a reminder email using the
+// REMINDER_TEMPLATE_NAME template with the given data
+//
+func SendReminderEmail(address string, data map[string]string) error {
+	sess, err := session.NewSession(&aws.Config{
+		Region: aws.String("us-east-1")},
+	)
+	if err != nil {
+		return err
+	}
+	svc := ses.New(sess)
+
+	templateData, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	sendTemplateInput := ses.SendTemplatedEmailInput{}
+	sendTemplateInput.Destination = &ses.Destination{ToAddresses: []*string{&address}}
+	sendTemplateInput.Source = aws.String(os.Getenv("TESPO_EMAIL"))
+	sendTemplateInput.Template = aws.String(os.Getenv("REMINDER_TEMPLATE_NAME"))
+	sendTemplateInput.TemplateData = aws.String(string(templateData))
+	sendTemplateInput.SourceArn = aws.String(os.Getenv("SOURCE_ARN"))
+
+	_, err = svc.SendTemplatedEmail(&sendTemplateInput)
+	return err
+}
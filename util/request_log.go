@@ -0,0 +1,140 @@
+package util
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/gorilla/context"
+	uuid "github.com/satori/go.uuid"
+)
+
+//
+// RequestIDHeader carries the correlation ID of a request
+// to and from buddha
+//
+const RequestIDHeader = "X-Request-ID"
+
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+var requestLog = struct {
+	mutex sync.Mutex
+	out   io.Writer
+}{out: os.Stdout}
+
+//
+// SetRequestLog sets where request log lines are written.
+// Nil turns request logging off
+//
+func SetRequestLog(out io.Writer) {
+	requestLog.mutex.Lock()
+	requestLog.out = out
+	requestLog.mutex.Unlock()
+}
+
+//
+// RequestLine is the log line written for each request
+//
+type RequestLine struct {
+	Time      time.Time `json:"time"`
+	RequestID string    `json:"request_id"`
+	Route     string    `json:"route"`
+	Method    string    `json:"method"`
+	Path      string    `json:"path"`
+	Status    int       `json:"status"`
+	LatencyMS float64   `json:"latency_ms"`
+	UserID    string    `json:"user_id,omitempty"`
+	AccountID string    `json:"account_id,omitempty"`
+}
+
+//
+// RequestID returns the correlation ID RequestLogger gave the request
+//
+func RequestID(r *http.Request) string {
+	if id, ok := context.GetOk(r, "request_id"); ok {
+		return id.(string)
+	}
+	return ""
+}
+
+//
+// RequestLogger gives the request a correlation ID, keeping the
+// X-Request-ID the caller sent when it is sane, and writes one
+// JSON line once the request is answered. The ID is set on the
+// request header, so it is forwarded to Vijnana, and on the response.
+// The request's context values are cleared once it is logged
+//
+func RequestLogger(route string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID.MatchString(id) {
+			id = uuid.NewV4().String()
+		}
+		r.Header.Set(RequestIDHeader, id)
+		w.Header().Set(RequestIDHeader, id)
+		context.Set(r, "request_id", id)
+		recorder := &statusRecorder{ResponseWriter: w}
+		defer func() {
+			line := RequestLine{
+				Time:      start.UTC(),
+				RequestID: id,
+				Route:     route,
+				Method:    r.Method,
+				Path:      r.URL.Path,
+				Status:    recorder.status,
+				LatencyMS: float64(time.Since(start).Nanoseconds()) / float64(time.Millisecond),
+			}
+			if line.Status == 0 {
+				line.Status = http.StatusOK
+			}
+			if userID, ok := context.GetOk(r, "user_id"); ok {
+				line.UserID, _ = userID.(string)
+			}
+			if accountID, ok := context.GetOk(r, "account_id"); ok {
+				line.AccountID, _ = accountID.(string)
+			}
+			writeRequestLine(line)
+			context.Clear(r)
+		}()
+		next.ServeHTTP(recorder, r)
+	})
+}
+
+func writeRequestLine(line RequestLine) {
+	data, err := json.Marshal(line)
+	if err != nil {
+		return
+	}
+	requestLog.mutex.Lock()
+	defer requestLog.mutex.Unlock()
+	if requestLog.out != nil {
+		requestLog.out.Write(append(data, '\n'))
+	}
+}
+
+//
+// statusRecorder remembers the status code a handler wrote
+//
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	if s.status == 0 {
+		s.status = status
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Write(data []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	return s.ResponseWriter.Write(data)
+}
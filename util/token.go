@@ -2,21 +2,233 @@ package util
 
 import (
 	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/dgrijalva/jwt-go"
 )
 
 //
-// ParseToken parses a jwt token and returns the claims
+// TokenConfig holds the keys and expected claims
+// used to verify jwt tokens
 //
-func ParseToken(tokenString string) (map[string]interface{}, error) {
-	token, _ := jwt.Parse(tokenString, nil)
-	if token == nil {
+type TokenConfig struct {
+	HMACSecret     []byte
+	PublicKeyFiles []string
+	JWKSURL        string
+	JWKSRefresh    time.Duration
+	Issuer         string
+	Audience       string
+	Leeway         time.Duration
+}
+
+//
+// TokenVerifier checks token signatures against the configured
+// keys and validates the registered claims
+//
+type TokenVerifier struct {
+	config     TokenConfig
+	publicKeys []publicKeyFile
+	jwks       *JWKS
+	methods    []string
+}
+
+//
+// publicKeyFile is a key read from JWT_PUBLIC_KEY_FILES along
+// with its key id: the id given as kid=path, or else the name
+// of the file without its extension
+//
+type publicKeyFile struct {
+	kid string
+	key interface{}
+}
+
+var errNoTokenKey = errors.New("No key found for token")
+
+//
+// TokenConfigFromEnv builds the token config specific to the environment
+//
+func TokenConfigFromEnv() TokenConfig {
+	config := TokenConfig{
+		HMACSecret:  []byte(os.Getenv("JWT_HMAC_SECRET")),
+		JWKSURL:     os.Getenv("JWT_JWKS_URL"),
+		JWKSRefresh: time.Hour,
+		Issuer:      os.Getenv("JWT_ISSUER"),
+		Audience:    os.Getenv("JWT_AUDIENCE"),
+		Leeway:      30 * time.Second,
+	}
+	if files := os.Getenv("JWT_PUBLIC_KEY_FILES"); files != "" {
+		config.PublicKeyFiles = strings.Split(files, ",")
+	}
+	if refresh, err := time.ParseDuration(os.Getenv("JWT_JWKS_REFRESH")); err == nil {
+		config.JWKSRefresh = refresh
+	}
+	if leeway, err := time.ParseDuration(os.Getenv("JWT_LEEWAY")); err == nil {
+		config.Leeway = leeway
+	}
+	return config
+}
+
+//
+// NewTokenVerifier loads the configured keys and
+// returns a verifier for them
+//
+func NewTokenVerifier(config TokenConfig) (*TokenVerifier, error) {
+	verifier := &TokenVerifier{config: config}
+	if len(config.HMACSecret) > 0 {
+		verifier.methods = append(verifier.methods, "HS256", "HS384", "HS512")
+	}
+	for _, file := range config.PublicKeyFiles {
+		file = strings.TrimSpace(file)
+		kid := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+		if i := strings.Index(file, "="); i >= 0 {
+			kid, file = file[:i], file[i+1:]
+		}
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		key, err := parsePublicKeyPEM(data)
+		if err != nil {
+			return nil, err
+		}
+		verifier.publicKeys = append(verifier.publicKeys, publicKeyFile{kid: kid, key: key})
+	}
+	if config.JWKSURL != "" {
+		verifier.jwks = NewJWKS(config.JWKSURL, config.JWKSRefresh)
+	}
+	if len(verifier.publicKeys) > 0 || verifier.jwks != nil {
+		verifier.methods = append(verifier.methods, "RS256", "RS384", "RS512", "ES256", "ES384", "ES512")
+	}
+	if len(verifier.methods) == 0 {
+		return nil, errors.New("No token verification keys configured")
+	}
+	return verifier, nil
+}
+
+//
+// Parse verifies the token signature and registered claims and
+// returns the claims. A token whose kid matches none of the key
+// files is checked against each of them in turn
+//
+func (v *TokenVerifier) Parse(tokenString string) (map[string]interface{}, error) {
+	parser := jwt.Parser{ValidMethods: v.methods, SkipClaimsValidation: true}
+	unmatched := false
+	token, err := parser.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		key, err := v.key(token)
+		unmatched = err == errNoTokenKey
+		return key, err
+	})
+	if unmatched {
+		for _, file := range v.publicKeys {
+			key := file.key
+			token, err = parser.Parse(tokenString, func(*jwt.Token) (interface{}, error) {
+				return key, nil
+			})
+			if err == nil {
+				break
+			}
+		}
+	}
+	if err != nil || token == nil || !token.Valid {
 		return nil, errors.New("Cannot parse token")
 	}
 	claims, ok := token.Claims.(jwt.MapClaims)
 	if !ok {
 		return nil, errors.New("Cannot parse token claims")
 	}
+	if err := v.validateClaims(claims); err != nil {
+		return nil, err
+	}
 	return claims, nil
 }
+
+func (v *TokenVerifier) key(token *jwt.Token) (interface{}, error) {
+	switch token.Method.(type) {
+	case *jwt.SigningMethodHMAC:
+		if len(v.config.HMACSecret) == 0 {
+			return nil, errors.New("Unexpected signing method")
+		}
+		return v.config.HMACSecret, nil
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodECDSA:
+		kid, _ := token.Header["kid"].(string)
+		if v.jwks != nil {
+			if key, err := v.jwks.Key(kid); err == nil {
+				return key, nil
+			}
+		}
+		for _, file := range v.publicKeys {
+			if file.kid == kid {
+				return file.key, nil
+			}
+		}
+		if len(v.publicKeys) == 1 {
+			return v.publicKeys[0].key, nil
+		}
+		return nil, errNoTokenKey
+	}
+	return nil, errors.New("Unexpected signing method")
+}
+
+func (v *TokenVerifier) validateClaims(claims jwt.MapClaims) error {
+	now := time.Now()
+	exp, ok := numericClaim(claims, "exp")
+	if !ok || now.After(time.Unix(exp, 0).Add(v.config.Leeway)) {
+		return errors.New("Token is expired")
+	}
+	if nbf, ok := numericClaim(claims, "nbf"); ok && now.Add(v.config.Leeway).Before(time.Unix(nbf, 0)) {
+		return errors.New("Token is not valid yet")
+	}
+	if v.config.Issuer != "" {
+		if iss, _ := claims["iss"].(string); iss != v.config.Issuer {
+			return errors.New("Token has an invalid issuer")
+		}
+	}
+	if v.config.Audience != "" && !hasAudience(claims["aud"], v.config.Audience) {
+		return errors.New("Token has an invalid audience")
+	}
+	return nil
+}
+
+func numericClaim(claims jwt.MapClaims, name string) (int64, bool) {
+	value, ok := claims[name].(float64)
+	if !ok {
+		return 0, false
+	}
+	return int64(value), true
+}
+
+//
+// TokenExpiry reads the exp claim of a token without verifying
+// it, so it must only be used to shorten how long a result
+// about that exact token string is trusted
+//
+func TokenExpiry(tokenString string) (time.Time, bool) {
+	parser := jwt.Parser{}
+	claims := jwt.MapClaims{}
+	if _, _, err := parser.ParseUnverified(tokenString, claims); err != nil {
+		return time.Time{}, false
+	}
+	exp, ok := numericClaim(claims, "exp")
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(exp, 0), true
+}
+
+func hasAudience(aud interface{}, expected string) bool {
+	switch aud := aud.(type) {
+	case string:
+		return aud == expected
+	case []interface{}:
+		for _, value := range aud {
+			if value == expected {
+				return true
+			}
+		}
+	}
+	return false
+}
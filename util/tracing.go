@@ -0,0 +1,57 @@
+package util
+
+import (
+	gocontext "context"
+	"net/http"
+
+	"github.com/gorilla/context"
+	"github.com/tespo/buddha/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+//
+// RequestContext returns the context holding the span TraceRoute
+// started for the request, or the request's own context
+//
+func RequestContext(r *http.Request) gocontext.Context {
+	if ctx, ok := context.GetOk(r, "trace_context"); ok {
+		return ctx.(gocontext.Context)
+	}
+	return r.Context()
+}
+
+//
+// TraceRoute runs the request in a span named after its route,
+// continuing the trace of the caller's traceparent. The request's
+// traceparent is set to the span, so Vijnana continues it too
+//
+func TraceRoute(route string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := tracing.Tracer().Start(tracing.Extract(r.Context(), r.Header), route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.route", route),
+				attribute.String("http.method", r.Method),
+				attribute.String("http.target", r.URL.Path),
+				attribute.String("request_id", RequestID(r)),
+			),
+		)
+		tracing.Inject(ctx, r.Header)
+		context.Set(r, "trace_context", ctx)
+		recorder := &statusRecorder{ResponseWriter: w}
+		defer func() {
+			status := recorder.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+			span.SetAttributes(attribute.Int("http.status_code", status))
+			if status >= 500 {
+				span.SetStatus(codes.Error, http.StatusText(status))
+			}
+			span.End()
+		}()
+		next.ServeHTTP(recorder, r)
+	})
+}
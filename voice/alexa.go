@@ -0,0 +1,344 @@
+package voice
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	"github.com/tespo/satya/v2/types"
+)
+
+//
+// AlexaCodec handles Alexa smart home directives, where each
+// dispenser is an endpoint that dispenses when turned on, and
+// skill intent requests for questions. The endpoint cookie
+// carries the dispenser ID given out at discovery
+//
+type AlexaCodec struct{}
+
+type alexaScope struct {
+	Token string `json:"token"`
+}
+
+type alexaRequest struct {
+	Session struct {
+		User struct {
+			AccessToken string `json:"accessToken"`
+		} `json:"user"`
+	} `json:"session"`
+	Context struct {
+		System struct {
+			User struct {
+				AccessToken string `json:"accessToken"`
+			} `json:"user"`
+		} `json:"System"`
+	} `json:"context"`
+	Request struct {
+		Type      string `json:"type"`
+		RequestID string `json:"requestId"`
+		Intent    struct {
+			Name string `json:"name"`
+		} `json:"intent"`
+	} `json:"request"`
+	Directive struct {
+		Header   alexaHeader `json:"header"`
+		Endpoint struct {
+			EndpointID string            `json:"endpointId"`
+			Cookie     map[string]string `json:"cookie"`
+			Scope      alexaScope        `json:"scope"`
+		} `json:"endpoint"`
+		Payload struct {
+			Scope alexaScope `json:"scope"`
+		} `json:"payload"`
+	} `json:"directive"`
+}
+
+type alexaHeader struct {
+	Namespace        string `json:"namespace"`
+	Name             string `json:"name"`
+	MessageID        string `json:"messageId"`
+	CorrelationToken string `json:"correlationToken,omitempty"`
+	PayloadVersion   string `json:"payloadVersion"`
+}
+
+type alexaEndpoint struct {
+	EndpointID string            `json:"endpointId"`
+	Cookie     map[string]string `json:"cookie,omitempty"`
+}
+
+type alexaProperty struct {
+	Namespace                 string      `json:"namespace"`
+	Name                      string      `json:"name"`
+	Value                     interface{} `json:"value"`
+	TimeOfSample              time.Time   `json:"timeOfSample"`
+	UncertaintyInMilliseconds int         `json:"uncertaintyInMilliseconds"`
+}
+
+type alexaContext struct {
+	Properties []alexaProperty `json:"properties"`
+}
+
+type alexaEvent struct {
+	Header   alexaHeader    `json:"header"`
+	Endpoint *alexaEndpoint `json:"endpoint,omitempty"`
+	Payload  interface{}    `json:"payload"`
+}
+
+type alexaResponse struct {
+	Context *alexaContext `json:"context,omitempty"`
+	Event   alexaEvent    `json:"event"`
+}
+
+//
+// Decode reads a skill request or a directive
+//
+func (AlexaCodec) Decode(body []byte) (Request, error) {
+	alexa := alexaRequest{}
+	if err := json.Unmarshal(body, &alexa); err != nil {
+		return Request{}, err
+	}
+	if alexa.Request.Type != "" {
+		request := Request{
+			ID:     alexa.Request.RequestID,
+			Intent: alexa.Request.Intent.Name,
+			Echo:   map[string]interface{}{"skill": true},
+		}
+		if alexa.Request.Type != "IntentRequest" {
+			request.Intent = alexa.Request.Type
+		}
+		return request, nil
+	}
+	directive := alexa.Directive
+	if directive.Header.Name == "" {
+		return Request{}, errors.New("request has no directive")
+	}
+	request := Request{
+		ID:     directive.Header.MessageID,
+		Intent: directive.Header.Namespace + "." + directive.Header.Name,
+		Echo: map[string]interface{}{
+			"correlationToken": directive.Header.CorrelationToken,
+		},
+	}
+	device := directive.Endpoint.Cookie["dispenser_id"]
+	if device == "" {
+		device = directive.Endpoint.EndpointID
+	}
+	if directive.Endpoint.EndpointID != "" {
+		request.Echo["endpoint"] = alexaEndpoint{
+			EndpointID: directive.Endpoint.EndpointID,
+			Cookie:     directive.Endpoint.Cookie,
+		}
+	}
+	switch directive.Header.Name {
+	case "Discover":
+		request.Intent = IntentDiscover
+	case "ReportState":
+		request.Intent = IntentQuery
+		request.Devices = []string{device}
+	case "TurnOn":
+		request.Intent = IntentExecute
+		request.Commands = []Command{{Name: CommandDispense, Devices: []string{device}}}
+	case "TurnOff":
+		request.Intent = IntentExecute
+		request.Commands = []Command{{Name: CommandStop, Devices: []string{device}}}
+	}
+	return request, nil
+}
+
+//
+// Encode writes the response for the request's intent. A
+// device that failed is answered with an ErrorResponse
+//
+func (codec AlexaCodec) Encode(request Request, response Response) interface{} {
+	switch request.Intent {
+	case IntentDiscover:
+		return alexaDiscoverResponse(response.Devices)
+	case IntentQuery, IntentExecute:
+		if len(response.States) == 0 {
+			return codec.EncodeError(request, ErrDeviceNotFound)
+		}
+		state := response.States[0]
+		if state.Err != nil {
+			return codec.EncodeError(request, state.Err)
+		}
+		name := "Response"
+		if request.Intent == IntentQuery {
+			name = "StateReport"
+		}
+		powerState := "OFF"
+		if state.On {
+			powerState = "ON"
+		}
+		now := time.Now()
+		alexa := newAlexaResponse(request, "Alexa", name)
+		alexa.Context = &alexaContext{
+			Properties: []alexaProperty{
+				{Namespace: "Alexa.PowerController", Name: "powerState", Value: powerState, TimeOfSample: now},
+				{Namespace: "Alexa.EndpointHealth", Name: "connectivity", Value: map[string]string{"value": "OK"}, TimeOfSample: now},
+			},
+		}
+		return alexa
+	}
+	return alexaSpeech(response.Speech)
+}
+
+//
+// EncodeError answers skill requests with an apology and
+// directives with an ErrorResponse
+//
+func (AlexaCodec) EncodeError(request Request, err error) interface{} {
+	if request.Echo["skill"] == true {
+		if err == ErrUnknownIntent {
+			return alexaSpeech("Sorry, I can't help with that yet.")
+		}
+		if err == ErrNotLinked {
+			return alexaSpeech("Please link your Tespo account in the Alexa app first.")
+		}
+		return alexaSpeech("Sorry, something went wrong.")
+	}
+	errorType := "INTERNAL_ERROR"
+	switch err {
+	case ErrDeviceNotFound:
+		errorType = "NO_SUCH_ENDPOINT"
+	case ErrDeviceOffline:
+		errorType = "ENDPOINT_UNREACHABLE"
+	case ErrNotSupported, ErrUnknownIntent:
+		errorType = "INVALID_DIRECTIVE"
+	case ErrNotLinked:
+		errorType = "INVALID_AUTHORIZATION_CREDENTIAL"
+	}
+	response := newAlexaResponse(request, "Alexa", "ErrorResponse")
+	response.Event.Payload = map[string]string{
+		"type":    errorType,
+		"message": err.Error(),
+	}
+	return response
+}
+
+//
+// Token reads the skill's access token or the directive's scope
+//
+func (AlexaCodec) Token(r *http.Request, body []byte) string {
+	alexa := alexaRequest{}
+	if json.Unmarshal(body, &alexa) != nil {
+		return ""
+	}
+	for _, token := range []string{
+		alexa.Session.User.AccessToken,
+		alexa.Context.System.User.AccessToken,
+		alexa.Directive.Endpoint.Scope.Token,
+		alexa.Directive.Payload.Scope.Token,
+	} {
+		if token != "" {
+			return token
+		}
+	}
+	return ""
+}
+
+//
+// newAlexaResponse starts the response event to the directive,
+// echoing its correlation token and endpoint
+//
+func newAlexaResponse(request Request, namespace, name string) alexaResponse {
+	correlationToken, _ := request.Echo["correlationToken"].(string)
+	response := alexaResponse{
+		Event: alexaEvent{
+			Header: alexaHeader{
+				Namespace:        namespace,
+				Name:             name,
+				MessageID:        uuid.NewV4().String(),
+				CorrelationToken: correlationToken,
+				PayloadVersion:   "3",
+			},
+			Payload: map[string]interface{}{},
+		},
+	}
+	if endpoint, ok := request.Echo["endpoint"].(alexaEndpoint); ok {
+		response.Event.Endpoint = &endpoint
+	}
+	return response
+}
+
+//
+// alexaSpeech says the text and ends the session
+//
+func alexaSpeech(text string) map[string]interface{} {
+	return map[string]interface{}{
+		"version": "1.0",
+		"response": map[string]interface{}{
+			"outputSpeech": map[string]string{
+				"type": "PlainText",
+				"text": text,
+			},
+			"shouldEndSession": true,
+		},
+	}
+}
+
+//
+// alexaDiscoverResponse lists the devices as endpoints
+//
+func alexaDiscoverResponse(devices []Device) types.AlexaResponse {
+	endpoints := make([]types.AlexaDiscoverResponseEventPayloadEndpoint, len(devices))
+	for i, device := range devices {
+		endpoints[i] = types.AlexaDiscoverResponseEventPayloadEndpoint{
+			EndpointID:        device.ID,
+			FriendlyName:      device.Name,
+			Description:       "Tespo Connect Dispenser",
+			ManufacturerName:  "Tespo",
+			DisplayCategories: []string{"OTHER"},
+			Cookie: map[string]interface{}{
+				"dispenser_id": device.ID,
+			},
+			Capabilities: []types.AlexaEndpointCapabilities{
+				{
+					Type:      "AlexaInterface",
+					Interface: "Alexa",
+					Version:   "3",
+				},
+				{
+					Type:      "AlexaInterface",
+					Interface: "Alexa.PowerController",
+					Version:   "3",
+					Properties: types.AlexaCapabilityProperites{
+						Retrievable: true,
+						Supported:   []map[string]string{{"name": "powerState"}},
+					},
+				},
+				{
+					Type:      "AlexaInterface",
+					Interface: "Alexa.EndpointHealth",
+					Version:   "3",
+					Properties: types.AlexaCapabilityProperites{
+						Retrievable: true,
+						Supported:   []map[string]string{{"name": "connectivity"}},
+					},
+				},
+			},
+			AdditionalAttributes: types.AlexaAdditionalAttributes{
+				Manufacturer:     "Tespo",
+				Model:            "Tespo Connect",
+				SerialNumber:     device.Serial,
+				FirmwareVersion:  device.Firmware,
+				SoftwareVersion:  device.Firmware,
+				CustomIdentifier: device.ID,
+			},
+		}
+	}
+	return types.AlexaResponse{
+		Event: types.AlexaDiscoverResponseEvent{
+			Header: types.AlexaHeader{
+				MessageID:      uuid.NewV4().String(),
+				Namespace:      "Alexa.Discovery",
+				Name:           "Discover.Response",
+				PayloadVersion: "3",
+			},
+			Payload: types.AlexaDiscoverResponseEventPayload{
+				Endpoints: endpoints,
+			},
+		},
+	}
+}
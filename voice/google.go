@@ -0,0 +1,299 @@
+package voice
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/tespo/satya/v2/types"
+)
+
+//
+// GoogleCodec handles Google smart home intents, where each
+// dispenser is a switch that dispenses when turned on and
+// reports the servings it has left through the Dispense
+// trait, and conversation intents for questions
+//
+type GoogleCodec struct{}
+
+var googleIntents = map[string]string{
+	"action.devices.SYNC":       IntentDiscover,
+	"action.devices.QUERY":      IntentQuery,
+	"action.devices.EXECUTE":    IntentExecute,
+	"action.devices.DISCONNECT": IntentDisconnect,
+}
+
+type googleRequest struct {
+	RequestID string `json:"requestId"`
+	Inputs    []struct {
+		Intent  string `json:"intent"`
+		Payload struct {
+			Devices  []googleDevice `json:"devices"`
+			Commands []struct {
+				Devices   []googleDevice `json:"devices"`
+				Execution []struct {
+					Command string `json:"command"`
+					Params  struct {
+						On bool `json:"on"`
+					} `json:"params"`
+				} `json:"execution"`
+			} `json:"commands"`
+		} `json:"payload"`
+	} `json:"inputs"`
+}
+
+type googleDevice struct {
+	ID string `json:"id"`
+}
+
+//
+// Decode reads the intent of the first input along with
+// the devices of every input
+//
+func (GoogleCodec) Decode(body []byte) (Request, error) {
+	google := googleRequest{}
+	if err := json.Unmarshal(body, &google); err != nil {
+		return Request{}, err
+	}
+	if len(google.Inputs) == 0 {
+		return Request{}, errors.New("request has no inputs")
+	}
+	request := Request{ID: google.RequestID, Intent: google.Inputs[0].Intent}
+	if intent, ok := googleIntents[request.Intent]; ok {
+		request.Intent = intent
+	}
+	for _, input := range google.Inputs {
+		for _, device := range input.Payload.Devices {
+			request.Devices = append(request.Devices, device.ID)
+		}
+		for _, command := range input.Payload.Commands {
+			devices := make([]string, len(command.Devices))
+			for i, device := range command.Devices {
+				devices[i] = device.ID
+			}
+			for _, execution := range command.Execution {
+				name := execution.Command
+				switch name {
+				case "action.devices.commands.OnOff":
+					name = CommandStop
+					if execution.Params.On {
+						name = CommandDispense
+					}
+				case "action.devices.commands.Dispense":
+					name = CommandDispense
+				}
+				request.Commands = append(request.Commands, Command{Name: name, Devices: devices})
+			}
+		}
+	}
+	return request, nil
+}
+
+type googleResponse struct {
+	RequestID string      `json:"requestId"`
+	Payload   interface{} `json:"payload"`
+}
+
+//
+// googleState is the OnOff state of a device
+//
+type googleState struct {
+	Online bool `json:"online"`
+	On     bool `json:"on"`
+}
+
+//
+// googleServings is the item a dispenser dispenses, counted
+// in servings rather than a unit of measure
+//
+const (
+	googleServings     = "servings"
+	googleServingsUnit = "NO_UNITS"
+)
+
+//
+// googleSyncDevice is a dispenser in SYNC responses
+//
+type googleSyncDevice struct {
+	ID              string                     `json:"id"`
+	Type            string                     `json:"type"`
+	Traits          []string                   `json:"traits"`
+	Name            types.GoogleHomeDeviceName `json:"name"`
+	WillReportState bool                       `json:"willReportState"`
+	Attributes      googleAttributes           `json:"attributes"`
+}
+
+type googleSyncPayload struct {
+	AgentUserID string             `json:"agentUserId"`
+	Devices     []googleSyncDevice `json:"devices"`
+}
+
+//
+// googleAttributes declares the servings a dispenser
+// can dispense for the Dispense trait
+//
+type googleAttributes struct {
+	SupportedDispenseItems []googleDispenseItemSpec `json:"supportedDispenseItems"`
+}
+
+type googleDispenseItemSpec struct {
+	ItemName         string           `json:"item_name"`
+	ItemNameSynonyms []googleSynonyms `json:"item_name_synonyms"`
+	SupportedUnits   []string         `json:"supported_units"`
+	DefaultPortion   googleAmount     `json:"default_portion"`
+}
+
+type googleSynonyms struct {
+	Lang     string   `json:"lang"`
+	Synonyms []string `json:"synonyms"`
+}
+
+type googleAmount struct {
+	Amount uint   `json:"amount"`
+	Unit   string `json:"unit"`
+}
+
+//
+// googleDispenseItem is the Dispense trait state of an item
+//
+type googleDispenseItem struct {
+	ItemName        string       `json:"itemName"`
+	AmountRemaining googleAmount `json:"amountRemaining"`
+}
+
+//
+// googleQueryState is the state of one device in QUERY
+// responses. Failed devices carry one of Google's error codes,
+// and the servings remaining are left out when no pod is known
+//
+type googleQueryState struct {
+	googleState
+	DispenseItems []googleDispenseItem `json:"dispenseItems,omitempty"`
+	Status        string               `json:"status"`
+	ErrorCode     string               `json:"errorCode,omitempty"`
+}
+
+//
+// googleCommandResult is the outcome of a command on a device
+// in EXECUTE responses. Only successful commands carry states
+//
+type googleCommandResult struct {
+	IDs       []string     `json:"ids"`
+	Status    string       `json:"status"`
+	ErrorCode string       `json:"errorCode,omitempty"`
+	States    *googleState `json:"states,omitempty"`
+}
+
+//
+// Encode writes the response for the request's intent
+//
+func (GoogleCodec) Encode(request Request, response Response) interface{} {
+	switch request.Intent {
+	case IntentDiscover:
+		devices := make([]googleSyncDevice, len(response.Devices))
+		for i, device := range response.Devices {
+			nicknames := []string{device.Name}
+			if !strings.HasSuffix(strings.ToLower(device.Name), "dispenser") {
+				nicknames = append(nicknames, device.Name+" dispenser")
+			}
+			devices[i] = googleSyncDevice{
+				ID:     device.ID,
+				Type:   "action.devices.types.SWITCH",
+				Traits: []string{"action.devices.traits.OnOff", "action.devices.traits.Dispense"},
+				Name: types.GoogleHomeDeviceName{
+					DefaultNames: []string{"Tespo Connect Dispenser"},
+					Name:         device.Name,
+					Nicknames:    nicknames,
+				},
+				WillReportState: false,
+				Attributes: googleAttributes{
+					SupportedDispenseItems: []googleDispenseItemSpec{{
+						ItemName:         googleServings,
+						ItemNameSynonyms: []googleSynonyms{{Lang: "en", Synonyms: []string{"servings", "vitamins", "pods"}}},
+						SupportedUnits:   []string{googleServingsUnit},
+						DefaultPortion:   googleAmount{Amount: 1, Unit: googleServingsUnit},
+					}},
+				},
+			}
+		}
+		return googleResponse{
+			RequestID: request.ID,
+			Payload:   googleSyncPayload{AgentUserID: response.UserID, Devices: devices},
+		}
+	case IntentQuery:
+		devices := map[string]googleQueryState{}
+		for _, state := range response.States {
+			status, errorCode := googleStatus(state.Err)
+			device := googleQueryState{
+				googleState: googleState{Online: state.Online, On: state.On},
+				Status:      status,
+				ErrorCode:   errorCode,
+			}
+			if state.ServingsRemaining != nil {
+				device.DispenseItems = []googleDispenseItem{{
+					ItemName:        googleServings,
+					AmountRemaining: googleAmount{Amount: *state.ServingsRemaining, Unit: googleServingsUnit},
+				}}
+			}
+			devices[state.ID] = device
+		}
+		return googleResponse{RequestID: request.ID, Payload: map[string]interface{}{"devices": devices}}
+	case IntentExecute:
+		commands := make([]googleCommandResult, len(response.States))
+		for i, state := range response.States {
+			status, errorCode := googleStatus(state.Err)
+			commands[i] = googleCommandResult{IDs: []string{state.ID}, Status: status, ErrorCode: errorCode}
+			if state.Err == nil {
+				commands[i].States = &googleState{Online: state.Online, On: state.On}
+			}
+		}
+		return googleResponse{RequestID: request.ID, Payload: map[string]interface{}{"commands": commands}}
+	case IntentDisconnect:
+		return map[string]interface{}{}
+	}
+	return map[string]interface{}{
+		"expectUserResponse": false,
+		"finalResponse": map[string]interface{}{
+			"richResponse": map[string]interface{}{
+				"items": []interface{}{
+					map[string]interface{}{
+						"simpleResponse": map[string]string{"textToSpeech": response.Speech},
+					},
+				},
+			},
+		},
+	}
+}
+
+//
+// googleStatus maps the error of a device to Google's
+// status and error code
+//
+func googleStatus(err error) (string, string) {
+	switch err {
+	case nil:
+		return "SUCCESS", ""
+	case ErrDeviceOffline:
+		return "OFFLINE", "deviceOffline"
+	case ErrDeviceNotFound:
+		return "ERROR", "deviceNotFound"
+	case ErrNotSupported:
+		return "ERROR", "functionNotSupported"
+	}
+	return "ERROR", "transientError"
+}
+
+//
+// EncodeError leaves failures to http errors
+//
+func (GoogleCodec) EncodeError(request Request, err error) interface{} {
+	return nil
+}
+
+//
+// Token reads the bearer token Google sends
+//
+func (GoogleCodec) Token(r *http.Request, body []byte) string {
+	return bearerToken(r)
+}
@@ -0,0 +1,53 @@
+package voice
+
+import (
+	"sync"
+
+	"github.com/tespo/satya/v2/types"
+)
+
+//
+// Handler handles an intent for the user
+//
+type Handler func(user types.User, request Request) (Response, error)
+
+//
+// Registry holds the handler of each intent
+//
+type Registry struct {
+	mutex    sync.RWMutex
+	handlers map[string]Handler
+}
+
+//
+// NewRegistry returns a registry with the handlers
+//
+func NewRegistry(handlers map[string]Handler) *Registry {
+	registry := &Registry{handlers: map[string]Handler{}}
+	for intent, handler := range handlers {
+		registry.Handle(intent, handler)
+	}
+	return registry
+}
+
+//
+// Handle sets the handler of the intent
+//
+func (r *Registry) Handle(intent string, handler Handler) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.handlers[intent] = handler
+}
+
+//
+// Dispatch runs the handler of the request's intent
+//
+func (r *Registry) Dispatch(user types.User, request Request) (Response, error) {
+	r.mutex.RLock()
+	handler, ok := r.handlers[request.Intent]
+	r.mutex.RUnlock()
+	if !ok {
+		return Response{}, ErrUnknownIntent
+	}
+	return handler(user, request)
+}
@@ -0,0 +1,144 @@
+package voice
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+//
+// Intents every codec decodes requests into. Questions keep
+// the intent name the assistant was configured with
+//
+const (
+	// IntentDiscover lists the user's devices
+	IntentDiscover = "Discover"
+	// IntentQuery reports the state of devices
+	IntentQuery = "Query"
+	// IntentExecute runs commands on devices
+	IntentExecute = "Execute"
+	// IntentDisconnect unlinks the user from the assistant
+	IntentDisconnect = "Disconnect"
+	// IntentServingsRemaining asks how many servings are left
+	IntentServingsRemaining = "ServingsRemaining"
+	// IntentTakenToday asks whether the user took their vitamins today
+	IntentTakenToday = "TakenToday"
+	// IntentNextReminder asks when the next reminder goes off
+	IntentNextReminder = "NextReminder"
+)
+
+//
+// Commands a device can run
+//
+const (
+	// CommandDispense dispenses a pod
+	CommandDispense = "Dispense"
+	// CommandStop turns a dispenser off, which it already is
+	CommandStop = "Stop"
+)
+
+var (
+	// ErrUnknownIntent is returned for intents without a handler
+	ErrUnknownIntent = errors.New("Unknown intent")
+	// ErrDeviceNotFound is a device the user does not have
+	ErrDeviceNotFound = errors.New("Device not found")
+	// ErrDeviceOffline is a device that is not connected
+	ErrDeviceOffline = errors.New("Device is offline")
+	// ErrNotSupported is a command the device cannot run
+	ErrNotSupported = errors.New("Command not supported")
+	// ErrTransient is a failure that may pass when tried again
+	ErrTransient = errors.New("Something went wrong, try again")
+	// ErrNotLinked is a user who unlinked the assistant
+	ErrNotLinked = errors.New("Account is not linked")
+)
+
+//
+// Request is a request from any assistant
+//
+type Request struct {
+	ID       string    `json:"id"`
+	Provider string    `json:"-"`
+	Intent   string    `json:"intent"`
+	Devices  []string  `json:"devices,omitempty"`
+	Commands []Command `json:"commands,omitempty"`
+	// Echo holds what the codec has to copy back into the response
+	Echo map[string]interface{} `json:"-"`
+	// RequestID is the correlation ID of the HTTP request
+	RequestID string `json:"-"`
+	// Context carries the trace of the HTTP request
+	Context context.Context `json:"-"`
+}
+
+//
+// Command is something to do on each of the devices
+//
+type Command struct {
+	Name    string   `json:"name"`
+	Devices []string `json:"devices"`
+}
+
+//
+// Response answers a request for any assistant
+//
+type Response struct {
+	UserID  string        `json:"user_id,omitempty"`
+	Speech  string        `json:"speech,omitempty"`
+	Devices []Device      `json:"devices,omitempty"`
+	States  []DeviceState `json:"states,omitempty"`
+}
+
+//
+// Device is a dispenser as assistants list it
+//
+type Device struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Serial   string `json:"serial"`
+	Firmware string `json:"firmware"`
+}
+
+//
+// DeviceState is the state of a device after a query or a
+// command. Err is one of the device errors when it failed
+//
+type DeviceState struct {
+	ID                string `json:"id"`
+	Online            bool   `json:"online"`
+	On                bool   `json:"on"`
+	ServingsRemaining *uint  `json:"servings_remaining,omitempty"`
+	Err               error  `json:"-"`
+}
+
+//
+// Codec translates between an assistant's requests and
+// responses and the common ones. Adding an assistant only
+// takes a codec registered in Codecs
+//
+type Codec interface {
+	// Decode reads a request body
+	Decode(body []byte) (Request, error)
+	// Encode writes the response to the request
+	Encode(request Request, response Response) interface{}
+	// EncodeError writes a failure the assistant has its own
+	// response for, or returns nil to answer with an http error
+	EncodeError(request Request, err error) interface{}
+	// Token finds the user's token in the request
+	Token(r *http.Request, body []byte) string
+}
+
+//
+// Codecs are the assistants by provider name
+//
+var Codecs = map[string]Codec{
+	"alexa":   AlexaCodec{},
+	"google":  GoogleCodec{},
+	"webhook": WebhookCodec{},
+}
+
+//
+// bearerToken reads the token from the Authorization header
+//
+func bearerToken(r *http.Request) string {
+	return strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+}
@@ -0,0 +1,85 @@
+package voice
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+//
+// WebhookCodec handles plain json requests, for assistants
+// such as Siri Shortcuts that can call any url. A request
+// names the intent and the devices it is about:
+//
+//	{"id": "1", "intent": "Dispense", "devices": ["kitchen"]}
+//
+// Devices can be given by ID or name, or left out when the
+// account has a single dispenser
+//
+type WebhookCodec struct{}
+
+//
+// Decode reads the request, where the Dispense and Stop
+// intents run the command on the devices
+//
+func (WebhookCodec) Decode(body []byte) (Request, error) {
+	request := Request{}
+	if err := json.Unmarshal(body, &request); err != nil {
+		return Request{}, err
+	}
+	if request.Intent == CommandDispense || request.Intent == CommandStop {
+		devices := request.Devices
+		if len(devices) == 0 {
+			devices = []string{""}
+		}
+		request.Commands = []Command{{Name: request.Intent, Devices: devices}}
+		request.Intent = IntentExecute
+		request.Devices = nil
+	}
+	return request, nil
+}
+
+type webhookState struct {
+	DeviceState
+	Error string `json:"error,omitempty"`
+}
+
+type webhookResponse struct {
+	ID      string         `json:"id"`
+	Speech  string         `json:"speech,omitempty"`
+	Devices []Device       `json:"devices,omitempty"`
+	States  []webhookState `json:"states,omitempty"`
+}
+
+//
+// Encode writes the response as json, with the
+// error message of each device that failed
+//
+func (WebhookCodec) Encode(request Request, response Response) interface{} {
+	webhook := webhookResponse{
+		ID:      request.ID,
+		Speech:  response.Speech,
+		Devices: response.Devices,
+	}
+	for _, state := range response.States {
+		encoded := webhookState{DeviceState: state}
+		if state.Err != nil {
+			encoded.Error = state.Err.Error()
+		}
+		webhook.States = append(webhook.States, encoded)
+	}
+	return webhook
+}
+
+//
+// EncodeError leaves failures to http errors
+//
+func (WebhookCodec) EncodeError(request Request, err error) interface{} {
+	return nil
+}
+
+//
+// Token reads the bearer token of the request
+//
+func (WebhookCodec) Token(r *http.Request, body []byte) string {
+	return bearerToken(r)
+}
@@ -0,0 +1,108 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+var errPrivateAddress = errors.New("webhook url must not point at a private address")
+
+//
+// privateNetworks are the ranges, besides loopback and link-local
+// addresses, an account endpoint may not point at
+//
+var privateNetworks = parseNetworks(
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	"198.18.0.0/15",
+	"fc00::/7",
+)
+
+func parseNetworks(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks[i] = network
+	}
+	return networks
+}
+
+//
+// PublicIP reports whether the address can be reached from the
+// internet: it is not loopback, link-local, unspecified or private
+//
+func PublicIP(ip net.IP) bool {
+	if ip == nil || ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsUnspecified() {
+		return false
+	}
+	for _, network := range privateNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+//
+// CheckAccountURL checks the url of an account endpoint is https
+// and that its host only resolves to public addresses
+//
+func CheckAccountURL(raw string) error {
+	target, err := url.Parse(raw)
+	if err != nil || target.Scheme != "https" || target.Hostname() == "" {
+		return errors.New("url must be an absolute https url")
+	}
+	ips, err := net.LookupIP(target.Hostname())
+	if err != nil {
+		return errors.New("url host cannot be resolved")
+	}
+	for _, ip := range ips {
+		if !PublicIP(ip) {
+			return errPrivateAddress
+		}
+	}
+	return nil
+}
+
+//
+// publicClient returns a client that only connects to public
+// addresses. The address is checked once it is resolved, so
+// a host that later resolves somewhere private, or a redirect
+// to one, is refused as well
+//
+func publicClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: func(network, address string, conn syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if !PublicIP(net.ParseIP(host)) {
+				return errPrivateAddress
+			}
+			return nil
+		},
+	}
+	// A proxy would be the address dialed, so the check would never
+	// see the endpoint's. Account endpoints are always dialed directly
+	transport := &http.Transport{
+		Proxy: nil,
+		DialContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+			return dialer.DialContext(ctx, network, address)
+		},
+		TLSHandshakeTimeout: timeout,
+	}
+	return &http.Client{Timeout: timeout, Transport: transport}
+}
@@ -0,0 +1,433 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	"github.com/jinzhu/gorm"
+	uuid "github.com/satori/go.uuid"
+	"github.com/tespo/buddha/models"
+)
+
+//
+// Events sent to webhook endpoints
+//
+const (
+	EventUsageCreated          = "usage.created"
+	EventPodInserted           = "pod.inserted"
+	EventDispenserConnected    = "dispenser.connected"
+	EventDispenserDisconnected = "dispenser.disconnected"
+	EventInvitationAccepted    = "invitation.accepted"
+	EventLowSupply             = "regimen.low_supply"
+)
+
+//
+// Events lists every event an endpoint can subscribe to
+//
+var Events = []string{
+	EventUsageCreated,
+	EventPodInserted,
+	EventDispenserConnected,
+	EventDispenserDisconnected,
+	EventInvitationAccepted,
+	EventLowSupply,
+}
+
+//
+// SignatureHeader carries the signature of a delivery
+//
+const SignatureHeader = "Webhook-Signature"
+
+var errBadSignature = errors.New("webhook signature does not match")
+
+//
+// Config holds the delivery settings of the dispatcher
+//
+type Config struct {
+	// Interval is how often failed deliveries are retried
+	Interval time.Duration
+	// Backoff is the wait before the first retry, doubling after each
+	Backoff time.Duration
+	// MaxBackoff bounds the wait between retries
+	MaxBackoff time.Duration
+	// MaxAttempts is how many times a delivery is tried before it fails
+	MaxAttempts int
+	// Timeout bounds each attempt
+	Timeout time.Duration
+}
+
+//
+// defaultMaxBackoff bounds the wait between retries
+// when no MaxBackoff is configured
+//
+const defaultMaxBackoff = 24 * time.Hour
+
+//
+// ConfigFromEnv reads WEBHOOK_RETRY_INTERVAL, WEBHOOK_BACKOFF,
+// WEBHOOK_MAX_BACKOFF, WEBHOOK_MAX_ATTEMPTS and WEBHOOK_TIMEOUT
+//
+func ConfigFromEnv() Config {
+	config := Config{
+		Interval:    envDuration("WEBHOOK_RETRY_INTERVAL", 30*time.Second),
+		Backoff:     envDuration("WEBHOOK_BACKOFF", time.Minute),
+		MaxBackoff:  envDuration("WEBHOOK_MAX_BACKOFF", defaultMaxBackoff),
+		MaxAttempts: 8,
+		Timeout:     envDuration("WEBHOOK_TIMEOUT", 10*time.Second),
+	}
+	if attempts, err := strconv.Atoi(os.Getenv("WEBHOOK_MAX_ATTEMPTS")); err == nil && attempts > 0 {
+		config.MaxAttempts = attempts
+	}
+	return config
+}
+
+//
+// Delay is the wait before retrying a delivery after its attempts:
+// the backoff, doubled after each attempt but the first, up to the
+// max backoff. Doubling stops at the max, so it never overflows
+//
+func (c Config) Delay(attempts int) time.Duration {
+	max := c.MaxBackoff
+	if max <= 0 {
+		max = defaultMaxBackoff
+	}
+	delay := c.Backoff
+	for i := 1; i < attempts; i++ {
+		if delay > max/2 {
+			return max
+		}
+		delay *= 2
+	}
+	if delay > max {
+		return max
+	}
+	return delay
+}
+
+//
+// Event is the body of every delivery
+//
+type Event struct {
+	ID        uuid.UUID   `json:"id"`
+	Event     string      `json:"event"`
+	AccountID uuid.UUID   `json:"account_id"`
+	CreatedAt time.Time   `json:"created_at"`
+	Data      interface{} `json:"data"`
+}
+
+//
+// Dispatcher delivers events to the endpoints subscribed to them.
+// Each delivery is tried right away and then retried in the
+// background with exponential backoff until it succeeds or runs
+// out of attempts
+//
+type Dispatcher struct {
+	db       *gorm.DB
+	client   *http.Client
+	public   *http.Client
+	config   Config
+	started  bool
+	stop     chan struct{}
+	done     chan struct{}
+	once     sync.Once
+	inflight sync.WaitGroup
+}
+
+//
+// New returns a dispatcher whose retries have not been started
+//
+func New(db *gorm.DB, config Config) *Dispatcher {
+	return &Dispatcher{
+		db:     db,
+		client: &http.Client{Timeout: config.Timeout},
+		public: publicClient(config.Timeout),
+		config: config,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+}
+
+//
+// Start retries failed deliveries until Stop is called
+//
+func (d *Dispatcher) Start() {
+	d.started = true
+	go func() {
+		defer close(d.done)
+		ticker := time.NewTicker(d.config.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-d.stop:
+				return
+			case now := <-ticker.C:
+				if err := d.Retry(now); err != nil {
+					log.Println("webhook dispatcher:", err)
+					sentry.CaptureException(err)
+				}
+			}
+		}
+	}()
+}
+
+//
+// Stop stops retrying, waiting for the deliveries in progress
+//
+func (d *Dispatcher) Stop() {
+	if d.started {
+		d.once.Do(func() {
+			close(d.stop)
+		})
+		<-d.done
+	}
+	d.inflight.Wait()
+}
+
+//
+// Emit records a delivery of the event to every endpoint of the
+// account, and every developer endpoint, that subscribes to it.
+// The deliveries are sent in the background
+//
+func (d *Dispatcher) Emit(accountID uuid.UUID, event string, data interface{}) error {
+	endpoints := models.WebhookEndpoints{}
+	if err := endpoints.GetByQuery(d.db, "active = ? AND (account_id = ? OR account_id IS NULL)", true, accountID); err != nil {
+		return err
+	}
+	body := Event{
+		ID:        uuid.NewV4(),
+		Event:     event,
+		AccountID: accountID,
+		CreatedAt: time.Now(),
+		Data:      data,
+	}
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	for _, endpoint := range endpoints {
+		if !endpoint.Subscribes(event) {
+			continue
+		}
+		next := body.CreatedAt.Add(d.config.Backoff)
+		delivery := models.WebhookDelivery{
+			EndpointID:    endpoint.ID,
+			AccountID:     accountID,
+			EventID:       body.ID,
+			Event:         event,
+			Payload:       payload,
+			Status:        models.DeliveryPending,
+			NextAttemptAt: &next,
+		}
+		if err := delivery.Create(d.db); err != nil {
+			return err
+		}
+		d.inflight.Add(1)
+		go func() {
+			defer d.inflight.Done()
+			if err := d.Attempt(&delivery); err != nil {
+				sentry.CaptureException(err)
+			}
+		}()
+	}
+	return nil
+}
+
+//
+// Retry attempts the pending deliveries that are due. Each delivery
+// is claimed before it is attempted, by moving its next attempt past
+// the attempt's timeout, so instances retrying at the same time never
+// send it twice. A claim left by an instance that died runs out and
+// the delivery is retried again. A delivery whose outcome cannot be
+// recorded is logged and the rest are still attempted
+//
+func (d *Dispatcher) Retry(now time.Time) error {
+	deliveries := models.WebhookDeliveries{}
+	if err := deliveries.GetByQuery(d.db.Order("next_attempt_at"), "status = ? AND next_attempt_at <= ?", models.DeliveryPending, now); err != nil {
+		return err
+	}
+	for i := range deliveries {
+		claimed, err := d.claim(&deliveries[i], now)
+		if err != nil {
+			return err
+		}
+		if !claimed {
+			continue
+		}
+		if err := d.Attempt(&deliveries[i]); err != nil {
+			log.Println("webhook retry:", err)
+			sentry.CaptureException(err)
+		}
+	}
+	return nil
+}
+
+//
+// claim leases the delivery to this instance if it
+// is still pending and due, reporting whether it was
+//
+func (d *Dispatcher) claim(delivery *models.WebhookDelivery, now time.Time) (bool, error) {
+	lease := time.Now().Add(d.config.Timeout + d.config.Interval)
+	result := d.db.Model(&models.WebhookDelivery{}).
+		Where("id = ? AND status = ? AND next_attempt_at <= ?", delivery.ID, models.DeliveryPending, now).
+		UpdateColumn("next_attempt_at", lease)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	delivery.NextAttemptAt = &lease
+	return result.RowsAffected == 1, nil
+}
+
+//
+// Replay sends the event of a delivery to its endpoint again,
+// as a new delivery. The replay is attempted before returning
+//
+func (d *Dispatcher) Replay(delivery models.WebhookDelivery) (models.WebhookDelivery, error) {
+	next := time.Now().Add(d.config.Backoff)
+	replay := models.WebhookDelivery{
+		EndpointID:    delivery.EndpointID,
+		AccountID:     delivery.AccountID,
+		EventID:       delivery.EventID,
+		Event:         delivery.Event,
+		Payload:       delivery.Payload,
+		Status:        models.DeliveryPending,
+		NextAttemptAt: &next,
+		ReplayOf:      &delivery.ID,
+	}
+	if err := replay.Create(d.db); err != nil {
+		return replay, err
+	}
+	return replay, d.Attempt(&replay)
+}
+
+//
+// Attempt posts the delivery to its endpoint and records the
+// outcome. A failed attempt is scheduled again until the
+// delivery runs out of attempts. The error is only set when
+// the outcome could not be recorded
+//
+func (d *Dispatcher) Attempt(delivery *models.WebhookDelivery) error {
+	endpoint := models.WebhookEndpoint{}
+	if err := endpoint.GetOneByQuery(d.db, "id = ?", delivery.EndpointID); err != nil {
+		if err.Error() != "record not found" {
+			return err
+		}
+		delivery.Status = models.DeliveryFailed
+		delivery.NextAttemptAt = nil
+		delivery.LastError = "endpoint was removed"
+		return delivery.Update(d.db)
+	}
+	now := time.Now()
+	delivery.Attempts++
+	delivery.LastStatusCode, delivery.LastError = 0, ""
+	status, err := d.post(endpoint, *delivery, now)
+	delivery.LastStatusCode = status
+	switch {
+	case err == nil:
+		delivery.Status = models.DeliveryDelivered
+		delivery.DeliveredAt = &now
+		delivery.NextAttemptAt = nil
+	case delivery.Attempts >= d.config.MaxAttempts || !endpoint.Active:
+		delivery.Status = models.DeliveryFailed
+		delivery.LastError = err.Error()
+		delivery.NextAttemptAt = nil
+	default:
+		delivery.LastError = err.Error()
+		next := now.Add(d.config.Delay(delivery.Attempts))
+		delivery.NextAttemptAt = &next
+	}
+	return delivery.Update(d.db)
+}
+
+func (d *Dispatcher) post(endpoint models.WebhookEndpoint, delivery models.WebhookDelivery, now time.Time) (int, error) {
+	request, err := http.NewRequest("POST", endpoint.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Webhook-Event", delivery.Event)
+	request.Header.Set("Webhook-Event-ID", delivery.EventID.String())
+	request.Header.Set("Webhook-Delivery-ID", delivery.ID.String())
+	request.Header.Set(SignatureHeader, Sign(endpoint.Secret, now, delivery.Payload))
+	client := d.client
+	if endpoint.AccountID != nil {
+		client = d.public
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(response.Body, 1<<16))
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return response.StatusCode, fmt.Errorf("Webhook endpoint responded %v", response.Status)
+	}
+	return response.StatusCode, nil
+}
+
+//
+// Sign returns the signature header of a body sent at the time:
+// the unix timestamp and the hex HMAC-SHA256 of the timestamp,
+// a dot and the body, keyed with the endpoint's secret
+//
+//	t=1561000000,v1=5257a869...
+//
+func Sign(secret string, at time.Time, body []byte) string {
+	timestamp := strconv.FormatInt(at.Unix(), 10)
+	return "t=" + timestamp + ",v1=" + signature(secret, timestamp, body)
+}
+
+//
+// Verify checks a signature header against the body, rejecting
+// signatures older than the tolerance. Receivers do the same
+//
+func Verify(secret, header string, body []byte, tolerance time.Duration, now time.Time) error {
+	var timestamp, signed string
+	for _, part := range strings.Split(header, ",") {
+		switch {
+		case strings.HasPrefix(part, "t="):
+			timestamp = strings.TrimPrefix(part, "t=")
+		case strings.HasPrefix(part, "v1="):
+			signed = strings.TrimPrefix(part, "v1=")
+		}
+	}
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || signed == "" {
+		return errBadSignature
+	}
+	if age := now.Sub(time.Unix(seconds, 0)); age > tolerance || age < -tolerance {
+		return errors.New("webhook signature is too old")
+	}
+	if !hmac.Equal([]byte(signed), []byte(signature(secret, timestamp, body))) {
+		return errBadSignature
+	}
+	return nil
+}
+
+func signature(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func envDuration(key string, fallback time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(key))
+	if err != nil || value <= 0 {
+		return fallback
+	}
+	return value
+}